@@ -0,0 +1,95 @@
+package osxkeychain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AccessControlFlags are the SecAccessControlCreateFlags of an item,
+// which require the user to authenticate before the item can be used.
+// A single constraint can be used on its own; several constraints
+// must be combined with exactly one of AccessControlOr and
+// AccessControlAnd.
+type AccessControlFlags uint32
+
+// Constraints.
+const (
+	// AccessControlUserPresence requires Touch ID or the device
+	// password.
+	AccessControlUserPresence AccessControlFlags = 1 << 0
+	// AccessControlBiometryAny requires Touch ID with any enrolled
+	// finger, even ones enrolled after the item was added.
+	AccessControlBiometryAny AccessControlFlags = 1 << 1
+	// AccessControlBiometryCurrentSet requires Touch ID with one of
+	// the fingers enrolled when the item was added.
+	AccessControlBiometryCurrentSet AccessControlFlags = 1 << 3
+	// AccessControlDevicePasscode requires the device password.
+	AccessControlDevicePasscode AccessControlFlags = 1 << 4
+)
+
+// Conjunctions.
+const (
+	AccessControlOr  AccessControlFlags = 1 << 14
+	AccessControlAnd AccessControlFlags = 1 << 15
+)
+
+const (
+	accessControlConstraints = AccessControlUserPresence | AccessControlBiometryAny |
+		AccessControlBiometryCurrentSet | AccessControlDevicePasscode
+	accessControlConjunctions = AccessControlOr | AccessControlAnd
+)
+
+func countFlags(flags AccessControlFlags) int {
+	n := 0
+	for ; flags != 0; flags &= flags - 1 {
+		n++
+	}
+	return n
+}
+
+// CheckValidity returns an error if the flags are a combination the
+// Security framework rejects. Otherwise, it returns nil.
+func (flags AccessControlFlags) CheckValidity() error {
+	if unknown := flags &^ (accessControlConstraints | accessControlConjunctions); unknown != 0 {
+		return fmt.Errorf("AccessControl has unknown flags %#x", uint32(unknown))
+	}
+	constraints := countFlags(flags & accessControlConstraints)
+	conjunctions := countFlags(flags & accessControlConjunctions)
+	switch {
+	case constraints == 0:
+		return errors.New("AccessControl has no constraints")
+	case conjunctions > 1:
+		return errors.New("AccessControl has both AccessControlOr and AccessControlAnd")
+	case constraints > 1 && conjunctions == 0:
+		return errors.New("AccessControl has several constraints but no conjunction")
+	case constraints == 1 && conjunctions == 1:
+		return errors.New("AccessControl has a conjunction but only one constraint")
+	case flags&AccessControlBiometryAny != 0 && flags&AccessControlBiometryCurrentSet != 0:
+		return errors.New("AccessControl has both AccessControlBiometryAny and AccessControlBiometryCurrentSet")
+	}
+	return nil
+}
+
+// Accessible is the kSecAttrAccessible level of an item, which says
+// when it can be read.
+type Accessible int
+
+const (
+	// AccessibleDefault leaves the level to the Security framework,
+	// which uses AccessibleWhenUnlocked.
+	AccessibleDefault Accessible = iota
+	AccessibleWhenUnlocked
+	AccessibleAfterFirstUnlock
+	AccessibleWhenPasscodeSetThisDeviceOnly
+	AccessibleWhenUnlockedThisDeviceOnly
+	AccessibleAfterFirstUnlockThisDeviceOnly
+)
+
+// CheckValidity returns an error if accessible is not one of the
+// levels above. Otherwise, it returns nil.
+func (accessible Accessible) CheckValidity() error {
+	if accessible < AccessibleDefault || accessible > AccessibleAfterFirstUnlockThisDeviceOnly {
+		return fmt.Errorf("Accessible has unknown value %d", int(accessible))
+	}
+	return nil
+}
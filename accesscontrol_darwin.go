@@ -0,0 +1,56 @@
+//go:build darwin && !ios && cgo
+// +build darwin,!ios,cgo
+
+package osxkeychain
+
+/*
+#include <stdlib.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+*/
+import "C"
+
+var secAttrAccessible = C.CFTypeRef(C.kSecAttrAccessible)
+var secAttrAccessControl = C.CFTypeRef(C.kSecAttrAccessControl)
+var secUseOperationPrompt = C.CFTypeRef(C.kSecUseOperationPrompt)
+
+var accessibleValues = map[Accessible]C.CFTypeRef{
+	AccessibleWhenUnlocked:                   C.CFTypeRef(C.kSecAttrAccessibleWhenUnlocked),
+	AccessibleAfterFirstUnlock:               C.CFTypeRef(C.kSecAttrAccessibleAfterFirstUnlock),
+	AccessibleWhenPasscodeSetThisDeviceOnly:  C.CFTypeRef(C.kSecAttrAccessibleWhenPasscodeSetThisDeviceOnly),
+	AccessibleWhenUnlockedThisDeviceOnly:     C.CFTypeRef(C.kSecAttrAccessibleWhenUnlockedThisDeviceOnly),
+	AccessibleAfterFirstUnlockThisDeviceOnly: C.CFTypeRef(C.kSecAttrAccessibleAfterFirstUnlockThisDeviceOnly),
+}
+
+// cfErrorToError converts a CFError from the Security framework, which
+// carries an OSStatus as its code, and releases it.
+func cfErrorToError(cfError C.CFErrorRef) error {
+	defer C.CFRelease(C.CFTypeRef(cfError))
+	return keychainError(C.CFErrorGetCode(cfError))
+}
+
+// addAccessControl adds the access control and accessibility
+// attributes of the item with the given attributes to query. The
+// returned function releases what was added.
+func addAccessControl(query map[C.CFTypeRef]C.CFTypeRef, attributes *GenericPasswordAttributes) (release func(), err error) {
+	release = func() {}
+	if attributes.AccessControl == 0 {
+		if attributes.Accessible != AccessibleDefault {
+			query[secAttrAccessible] = accessibleValues[attributes.Accessible]
+		}
+		return
+	}
+
+	protection := accessibleValues[attributes.Accessible]
+	if protection == nil {
+		protection = accessibleValues[AccessibleWhenUnlocked]
+	}
+	var cfError C.CFErrorRef
+	accessControl := C.SecAccessControlCreateWithFlags(nil, protection, C.SecAccessControlCreateFlags(attributes.AccessControl), &cfError)
+	if accessControl == nil {
+		return nil, cfErrorToError(cfError)
+	}
+	query[secAttrAccessControl] = C.CFTypeRef(accessControl)
+	release = func() { C.CFRelease(C.CFTypeRef(accessControl)) }
+	return
+}
@@ -0,0 +1,115 @@
+package osxkeychain_test
+
+import (
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+func TestAccessControlFlagsCheckValidity(t *testing.T) {
+	valid := []osxkeychain.AccessControlFlags{
+		osxkeychain.AccessControlUserPresence,
+		osxkeychain.AccessControlBiometryAny,
+		osxkeychain.AccessControlBiometryCurrentSet,
+		osxkeychain.AccessControlDevicePasscode,
+		osxkeychain.AccessControlBiometryAny | osxkeychain.AccessControlDevicePasscode | osxkeychain.AccessControlOr,
+		osxkeychain.AccessControlBiometryCurrentSet | osxkeychain.AccessControlDevicePasscode | osxkeychain.AccessControlAnd,
+	}
+	for _, flags := range valid {
+		if err := flags.CheckValidity(); err != nil {
+			t.Errorf("%#x: %v", uint32(flags), err)
+		}
+	}
+
+	invalid := []struct {
+		flags    osxkeychain.AccessControlFlags
+		expected string
+	}{
+		{0, "AccessControl has no constraints"},
+		{osxkeychain.AccessControlOr, "AccessControl has no constraints"},
+		{osxkeychain.AccessControlUserPresence | 1<<2, "AccessControl has unknown flags 0x4"},
+		{osxkeychain.AccessControlBiometryAny | osxkeychain.AccessControlDevicePasscode,
+			"AccessControl has several constraints but no conjunction"},
+		{osxkeychain.AccessControlUserPresence | osxkeychain.AccessControlAnd,
+			"AccessControl has a conjunction but only one constraint"},
+		{osxkeychain.AccessControlBiometryAny | osxkeychain.AccessControlDevicePasscode | osxkeychain.AccessControlOr | osxkeychain.AccessControlAnd,
+			"AccessControl has both AccessControlOr and AccessControlAnd"},
+		{osxkeychain.AccessControlBiometryAny | osxkeychain.AccessControlBiometryCurrentSet | osxkeychain.AccessControlOr,
+			"AccessControl has both AccessControlBiometryAny and AccessControlBiometryCurrentSet"},
+	}
+	for _, test := range invalid {
+		err := test.flags.CheckValidity()
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%#x: Expected \"%s\", got %v", uint32(test.flags), test.expected, err)
+		}
+	}
+}
+
+func TestAccessControlAttributes(t *testing.T) {
+	attributes := osxkeychain.GenericPasswordAttributes{
+		ServiceName:     "osxkeychain_test",
+		AccountName:     "test",
+		AccessControl:   osxkeychain.AccessControlUserPresence,
+		Accessible:      osxkeychain.AccessibleWhenUnlockedThisDeviceOnly,
+		OperationPrompt: "read the test password",
+	}
+	if err := attributes.CheckValidity(); err != nil {
+		t.Error(err)
+	}
+
+	withApps := attributes
+	withApps.TrustedApplications = []string{"/usr/bin/security"}
+	expected := "AccessControl and Accessible can't be combined with TrustedApplications"
+	if err := withApps.CheckValidity(); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+
+	badFlags := attributes
+	badFlags.AccessControl |= osxkeychain.AccessControlDevicePasscode
+	expected = "AccessControl has several constraints but no conjunction"
+	if err := badFlags.CheckValidity(); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+
+	badAccessible := attributes
+	badAccessible.Accessible = 42
+	expected = "Accessible has unknown value 42"
+	if err := badAccessible.CheckValidity(); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+
+	badPrompt := attributes
+	badPrompt.OperationPrompt = "\xff"
+	expected = "OperationPrompt is not a valid UTF-8 string"
+	if err := badPrompt.CheckValidity(); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
+
+func TestDataProtectionAttributes(t *testing.T) {
+	attributes := osxkeychain.GenericPasswordAttributes{
+		ServiceName: "osxkeychain_test",
+		AccountName: "test",
+		AccessGroup: "ABCDE12345.com.example.shared",
+	}
+	if err := attributes.CheckValidity(); err != nil {
+		t.Error(err)
+	}
+
+	expected := "UseDataProtectionKeychain and AccessGroup can't be combined with TrustedApplications"
+	for _, withApps := range []osxkeychain.GenericPasswordAttributes{
+		{AccessGroup: "ABCDE12345.com.example.shared", TrustedApplications: []string{"/usr/bin/security"}},
+		{UseDataProtectionKeychain: true, TrustedApplications: []string{"/usr/bin/security"}},
+	} {
+		if err := withApps.CheckValidity(); err == nil || err.Error() != expected {
+			t.Errorf("Expected \"%s\", got %v", expected, err)
+		}
+	}
+
+	badGroup := attributes
+	badGroup.AccessGroup = "\xff"
+	expected = "AccessGroup is not a valid UTF-8 string"
+	if err := badGroup.CheckValidity(); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
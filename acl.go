@@ -0,0 +1,306 @@
+package osxkeychain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ACLAuthorization is an operation an ACL entry authorizes. The values
+// are those of the kSecACLAuthorization* constants.
+type ACLAuthorization string
+
+const (
+	ACLAuthorizationAny                ACLAuthorization = "ACLAuthorizationAny"
+	ACLAuthorizationLogin              ACLAuthorization = "ACLAuthorizationLogin"
+	ACLAuthorizationGenKey             ACLAuthorization = "ACLAuthorizationGenKey"
+	ACLAuthorizationDelete             ACLAuthorization = "ACLAuthorizationDelete"
+	ACLAuthorizationExportWrapped      ACLAuthorization = "ACLAuthorizationExportWrapped"
+	ACLAuthorizationExportClear        ACLAuthorization = "ACLAuthorizationExportClear"
+	ACLAuthorizationImportWrapped      ACLAuthorization = "ACLAuthorizationImportWrapped"
+	ACLAuthorizationImportClear        ACLAuthorization = "ACLAuthorizationImportClear"
+	ACLAuthorizationSign               ACLAuthorization = "ACLAuthorizationSign"
+	ACLAuthorizationEncrypt            ACLAuthorization = "ACLAuthorizationEncrypt"
+	ACLAuthorizationDecrypt            ACLAuthorization = "ACLAuthorizationDecrypt"
+	ACLAuthorizationMAC                ACLAuthorization = "ACLAuthorizationMAC"
+	ACLAuthorizationDerive             ACLAuthorization = "ACLAuthorizationDerive"
+	ACLAuthorizationKeychainCreate     ACLAuthorization = "ACLAuthorizationKeychainCreate"
+	ACLAuthorizationKeychainDelete     ACLAuthorization = "ACLAuthorizationKeychainDelete"
+	ACLAuthorizationKeychainItemRead   ACLAuthorization = "ACLAuthorizationKeychainItemRead"
+	ACLAuthorizationKeychainItemInsert ACLAuthorization = "ACLAuthorizationKeychainItemInsert"
+	ACLAuthorizationKeychainItemModify ACLAuthorization = "ACLAuthorizationKeychainItemModify"
+	ACLAuthorizationKeychainItemDelete ACLAuthorization = "ACLAuthorizationKeychainItemDelete"
+	ACLAuthorizationChangeACL          ACLAuthorization = "ACLAuthorizationChangeACL"
+	ACLAuthorizationChangeOwner        ACLAuthorization = "ACLAuthorizationChangeOwner"
+	ACLAuthorizationPartitionID        ACLAuthorization = "ACLAuthorizationPartitionID"
+	ACLAuthorizationIntegrity          ACLAuthorization = "ACLAuthorizationIntegrity"
+)
+
+// PromptSelector holds the CSSM_ACL_KEYCHAIN_PROMPT_* flags of an ACL
+// entry, which control when the user is asked to confirm access.
+type PromptSelector uint16
+
+const (
+	PromptRequirePassphrase PromptSelector = 0x0001
+	PromptUnsigned          PromptSelector = 0x0010
+	PromptUnsignedAct       PromptSelector = 0x0020
+	PromptInvalid           PromptSelector = 0x0040
+	PromptInvalidAct        PromptSelector = 0x0080
+)
+
+// ACLEntry is an entry in the access control list of a keychain item:
+// it lets the listed applications perform the listed operations
+// without asking the user.
+type ACLEntry struct {
+	Authorizations []ACLAuthorization
+
+	// AnyApplication is true if every application may perform the
+	// operations, in which case TrustedApplications is empty.
+	AnyApplication bool
+	// TrustedApplications is the list of application paths that may
+	// perform the operations.
+	TrustedApplications []string
+
+	// Description is shown to the user when they are asked to
+	// confirm access; for most items it is the item's label.
+	Description    string
+	PromptSelector PromptSelector
+}
+
+// ACL is the access control list of a keychain item.
+type ACL []ACLEntry
+
+// ACLStore is implemented by Stores whose items have access control
+// lists, such as DefaultKeychain.
+type ACLStore interface {
+	// GetGenericPasswordACL returns the ACL of the generic password
+	// with the given attributes.
+	GetGenericPasswordACL(attributes *GenericPasswordAttributes) (ACL, error)
+	// SetGenericPasswordACL replaces the ACL of the generic password
+	// with the given attributes. The user may be asked to allow it.
+	SetGenericPasswordACL(attributes *GenericPasswordAttributes, acl ACL) error
+}
+
+func (entry *ACLEntry) authorizes(authorization ACLAuthorization) bool {
+	for _, a := range entry.Authorizations {
+		if a == authorization || a == ACLAuthorizationAny {
+			return true
+		}
+	}
+	return false
+}
+
+func (entry *ACLEntry) copy() ACLEntry {
+	c := *entry
+	c.Authorizations = append([]ACLAuthorization(nil), entry.Authorizations...)
+	c.TrustedApplications = append([]string(nil), entry.TrustedApplications...)
+	return c
+}
+
+func (acl ACL) copy() ACL {
+	c := make(ACL, len(acl))
+	for i := range acl {
+		c[i] = acl[i].copy()
+	}
+	return c
+}
+
+// WithTrustedApplication returns a copy of acl in which the given
+// application may read the item's contents, by adding it to the
+// entries authorizing ACLAuthorizationDecrypt. It returns an error if
+// there is no such entry to add it to.
+func (acl ACL) WithTrustedApplication(path string) (ACL, error) {
+	if path == "" {
+		return nil, errors.New("application path is empty")
+	}
+	c := acl.copy()
+	found := false
+	for i := range c {
+		entry := &c[i]
+		if !entry.authorizes(ACLAuthorizationDecrypt) {
+			continue
+		}
+		found = true
+		if entry.AnyApplication {
+			continue
+		}
+		present := false
+		for _, app := range entry.TrustedApplications {
+			if app == path {
+				present = true
+				break
+			}
+		}
+		if !present {
+			entry.TrustedApplications = append(entry.TrustedApplications, path)
+		}
+	}
+	if !found {
+		return nil, errors.New("ACL has no entry authorizing " + string(ACLAuthorizationDecrypt))
+	}
+	return c, nil
+}
+
+// WithoutTrustedApplication returns a copy of acl in which the given
+// application is removed from every entry's TrustedApplications.
+// Entries that allow any application are left alone.
+func (acl ACL) WithoutTrustedApplication(path string) ACL {
+	c := acl.copy()
+	for i := range c {
+		entry := &c[i]
+		var apps []string
+		for _, app := range entry.TrustedApplications {
+			if app != path {
+				apps = append(apps, app)
+			}
+		}
+		entry.TrustedApplications = apps
+	}
+	return c
+}
+
+// AddTrustedApplication lets the given application read the generic
+// password with the given attributes without prompting, by updating
+// its ACL in place rather than recreating the item.
+func AddTrustedApplication(store ACLStore, attributes *GenericPasswordAttributes, path string) error {
+	acl, err := store.GetGenericPasswordACL(attributes)
+	if err != nil {
+		return err
+	}
+	acl, err = acl.WithTrustedApplication(path)
+	if err != nil {
+		return err
+	}
+	return store.SetGenericPasswordACL(attributes, acl)
+}
+
+// RemoveTrustedApplication removes the given application from the ACL
+// of the generic password with the given attributes.
+func RemoveTrustedApplication(store ACLStore, attributes *GenericPasswordAttributes, path string) error {
+	acl, err := store.GetGenericPasswordACL(attributes)
+	if err != nil {
+		return err
+	}
+	return store.SetGenericPasswordACL(attributes, acl.WithoutTrustedApplication(path))
+}
+
+// ACLChange describes an ACL entry that differs between two ACLs.
+// Old is nil for an added entry and New is nil for a removed one.
+// Entries are matched by their set of authorizations.
+type ACLChange struct {
+	Old, New *ACLEntry
+
+	AddedApplications   []string
+	RemovedApplications []string
+}
+
+func authorizationsKey(authorizations []ACLAuthorization) string {
+	s := make([]string, len(authorizations))
+	for i, a := range authorizations {
+		s[i] = string(a)
+	}
+	sort.Strings(s)
+	return strings.Join(s, " ")
+}
+
+// stringsDiff returns the strings in b but not a, and those in a but
+// not b.
+func stringsDiff(a, b []string) (added, removed []string) {
+	inA := make(map[string]bool)
+	for _, s := range a {
+		inA[s] = true
+	}
+	inB := make(map[string]bool)
+	for _, s := range b {
+		inB[s] = true
+		if !inA[s] {
+			added = append(added, s)
+		}
+	}
+	for _, s := range a {
+		if !inB[s] {
+			removed = append(removed, s)
+		}
+	}
+	return
+}
+
+// DiffACL returns the entries that were added, removed or changed
+// going from old to new, in the order they appear in old and then
+// new.
+func DiffACL(old, new ACL) []ACLChange {
+	unmatched := make(map[string][]int)
+	for i := range new {
+		key := authorizationsKey(new[i].Authorizations)
+		unmatched[key] = append(unmatched[key], i)
+	}
+	matched := make([]bool, len(new))
+
+	var changes []ACLChange
+	for i := range old {
+		o := &old[i]
+		key := authorizationsKey(o.Authorizations)
+		if len(unmatched[key]) == 0 {
+			changes = append(changes, ACLChange{Old: o, RemovedApplications: o.TrustedApplications})
+			continue
+		}
+		j := unmatched[key][0]
+		unmatched[key] = unmatched[key][1:]
+		matched[j] = true
+
+		n := &new[j]
+		added, removed := stringsDiff(o.TrustedApplications, n.TrustedApplications)
+		if len(added) > 0 || len(removed) > 0 || o.AnyApplication != n.AnyApplication ||
+			o.Description != n.Description || o.PromptSelector != n.PromptSelector {
+			changes = append(changes, ACLChange{
+				Old:                 o,
+				New:                 n,
+				AddedApplications:   added,
+				RemovedApplications: removed,
+			})
+		}
+	}
+	for j := range new {
+		if !matched[j] {
+			changes = append(changes, ACLChange{New: &new[j], AddedApplications: new[j].TrustedApplications})
+		}
+	}
+	return changes
+}
+
+// String describes the change on a single line, for audit logs.
+func (c ACLChange) String() string {
+	var kind string
+	var entry *ACLEntry
+	switch {
+	case c.Old == nil:
+		kind, entry = "added", c.New
+	case c.New == nil:
+		kind, entry = "removed", c.Old
+	default:
+		kind, entry = "changed", c.New
+	}
+
+	s := fmt.Sprintf("%s entry [%s]", kind, authorizationsKey(entry.Authorizations))
+	if c.Old != nil && c.New != nil {
+		if c.Old.AnyApplication != c.New.AnyApplication {
+			s += fmt.Sprintf(" any application: %t -> %t", c.Old.AnyApplication, c.New.AnyApplication)
+		}
+		if c.Old.Description != c.New.Description {
+			s += fmt.Sprintf(" description: %q -> %q", c.Old.Description, c.New.Description)
+		}
+		if c.Old.PromptSelector != c.New.PromptSelector {
+			s += fmt.Sprintf(" prompt selector: %#04x -> %#04x", uint16(c.Old.PromptSelector), uint16(c.New.PromptSelector))
+		}
+	} else if entry.AnyApplication {
+		s += " any application"
+	}
+	for _, app := range c.AddedApplications {
+		s += " +" + app
+	}
+	for _, app := range c.RemovedApplications {
+		s += " -" + app
+	}
+	return s
+}
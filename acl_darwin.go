@@ -0,0 +1,363 @@
+//go:build darwin && !ios && cgo
+// +build darwin,!ios,cgo
+
+package osxkeychain
+
+/*
+#include <stdlib.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+*/
+import "C"
+
+import (
+	"bytes"
+	"fmt"
+	"unsafe"
+)
+
+var aclAuthorizationTags = map[C.CSSM_ACL_AUTHORIZATION_TAG]ACLAuthorization{
+	C.CSSM_ACL_AUTHORIZATION_ANY:            ACLAuthorizationAny,
+	C.CSSM_ACL_AUTHORIZATION_LOGIN:          ACLAuthorizationLogin,
+	C.CSSM_ACL_AUTHORIZATION_GENKEY:         ACLAuthorizationGenKey,
+	C.CSSM_ACL_AUTHORIZATION_DELETE:         ACLAuthorizationDelete,
+	C.CSSM_ACL_AUTHORIZATION_EXPORT_WRAPPED: ACLAuthorizationExportWrapped,
+	C.CSSM_ACL_AUTHORIZATION_EXPORT_CLEAR:   ACLAuthorizationExportClear,
+	C.CSSM_ACL_AUTHORIZATION_IMPORT_WRAPPED: ACLAuthorizationImportWrapped,
+	C.CSSM_ACL_AUTHORIZATION_IMPORT_CLEAR:   ACLAuthorizationImportClear,
+	C.CSSM_ACL_AUTHORIZATION_SIGN:           ACLAuthorizationSign,
+	C.CSSM_ACL_AUTHORIZATION_ENCRYPT:        ACLAuthorizationEncrypt,
+	C.CSSM_ACL_AUTHORIZATION_DECRYPT:        ACLAuthorizationDecrypt,
+	C.CSSM_ACL_AUTHORIZATION_MAC:            ACLAuthorizationMAC,
+	C.CSSM_ACL_AUTHORIZATION_DERIVE:         ACLAuthorizationDerive,
+	C.CSSM_ACL_AUTHORIZATION_DBS_CREATE:     ACLAuthorizationKeychainCreate,
+	C.CSSM_ACL_AUTHORIZATION_DBS_DELETE:     ACLAuthorizationKeychainDelete,
+	C.CSSM_ACL_AUTHORIZATION_DB_READ:        ACLAuthorizationKeychainItemRead,
+	C.CSSM_ACL_AUTHORIZATION_DB_INSERT:      ACLAuthorizationKeychainItemInsert,
+	C.CSSM_ACL_AUTHORIZATION_DB_MODIFY:      ACLAuthorizationKeychainItemModify,
+	C.CSSM_ACL_AUTHORIZATION_DB_DELETE:      ACLAuthorizationKeychainItemDelete,
+	C.CSSM_ACL_AUTHORIZATION_CHANGE_ACL:     ACLAuthorizationChangeACL,
+	C.CSSM_ACL_AUTHORIZATION_CHANGE_OWNER:   ACLAuthorizationChangeOwner,
+	C.CSSM_ACL_AUTHORIZATION_PARTITION_ID:   ACLAuthorizationPartitionID,
+	C.CSSM_ACL_AUTHORIZATION_INTEGRITY:      ACLAuthorizationIntegrity,
+}
+
+func aclAuthorizationToTag(authorization ACLAuthorization) (C.CSSM_ACL_AUTHORIZATION_TAG, error) {
+	for tag, a := range aclAuthorizationTags {
+		if a == authorization {
+			return tag, nil
+		}
+	}
+	var tag C.CSSM_ACL_AUTHORIZATION_TAG
+	if _, err := fmt.Sscanf(string(authorization), "ACLAuthorizationTag(%d)", &tag); err != nil {
+		return 0, fmt.Errorf("unknown ACL authorization %s", authorization)
+	}
+	return tag, nil
+}
+
+func aclAuthorizationFromTag(tag C.CSSM_ACL_AUTHORIZATION_TAG) ACLAuthorization {
+	if a, ok := aclAuthorizationTags[tag]; ok {
+		return a
+	}
+	// Keep unknown tags, so that setting an ACL that was read back
+	// doesn't lose them.
+	return ACLAuthorization(fmt.Sprintf("ACLAuthorizationTag(%d)", tag))
+}
+
+// GetGenericPasswordACL returns the access control list of the
+// generic password with the given attributes in the default keychain.
+func GetGenericPasswordACL(attributes *GenericPasswordAttributes) (acl ACL, err error) {
+	keychainExecutor.do(func() {
+		acl, err = getGenericPasswordACL(attributes)
+	})
+	return
+}
+
+func getGenericPasswordACL(attributes *GenericPasswordAttributes) (ACL, error) {
+	itemRef, err := findGenericPasswordItem(attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer C.CFRelease(C.CFTypeRef(itemRef))
+
+	var access C.SecAccessRef
+	errCode := C.SecKeychainItemCopyAccess(itemRef, &access)
+	if err := newKeychainError(errCode); err != nil {
+		return nil, err
+	}
+	defer C.CFRelease(C.CFTypeRef(access))
+
+	var aclList C.CFArrayRef
+	errCode = C.SecAccessCopyACLList(access, &aclList)
+	if err := newKeychainError(errCode); err != nil {
+		return nil, err
+	}
+	defer C.CFRelease(C.CFTypeRef(aclList))
+
+	var acl ACL
+	for _, aclRef := range _CFArrayToArray(aclList) {
+		entry, err := aclEntryFromSecACL(C.SecACLRef(aclRef))
+		if err != nil {
+			return nil, err
+		}
+		acl = append(acl, entry)
+	}
+	return acl, nil
+}
+
+func aclEntryFromSecACL(aclRef C.SecACLRef) (entry ACLEntry, err error) {
+	var applicationList C.CFArrayRef
+	var description C.CFStringRef
+	var promptSelector C.CSSM_ACL_KEYCHAIN_PROMPT_SELECTOR
+	errCode := C.SecACLCopySimpleContents(aclRef, &applicationList, &description, &promptSelector)
+	if err = newKeychainError(errCode); err != nil {
+		return
+	}
+
+	if description != nil {
+		defer C.CFRelease(C.CFTypeRef(description))
+		entry.Description = _CFStringToUTF8String(description)
+	}
+	entry.PromptSelector = PromptSelector(promptSelector.flags)
+
+	if applicationList == nil {
+		entry.AnyApplication = true
+	} else {
+		defer C.CFRelease(C.CFTypeRef(applicationList))
+		for _, app := range _CFArrayToArray(applicationList) {
+			path, err := trustedApplicationPath(C.SecTrustedApplicationRef(app))
+			if err != nil {
+				return entry, err
+			}
+			entry.TrustedApplications = append(entry.TrustedApplications, path)
+		}
+	}
+
+	var tags [64]C.CSSM_ACL_AUTHORIZATION_TAG
+	tagCount := C.uint32(len(tags))
+	errCode = C.SecACLGetAuthorizations(aclRef, &tags[0], &tagCount)
+	if err = newKeychainError(errCode); err != nil {
+		return
+	}
+	for _, tag := range tags[:tagCount] {
+		entry.Authorizations = append(entry.Authorizations, aclAuthorizationFromTag(tag))
+	}
+	return
+}
+
+// trustedApplicationPath returns the path of a trusted application,
+// or whatever else its data holds, such as a group:// name.
+func trustedApplicationPath(app C.SecTrustedApplicationRef) (string, error) {
+	var data C.CFDataRef
+	errCode := C.SecTrustedApplicationCopyData(app, &data)
+	if err := newKeychainError(errCode); err != nil {
+		return "", err
+	}
+	defer C.CFRelease(C.CFTypeRef(data))
+	path := C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(data)), C.int(C.CFDataGetLength(data)))
+	// The path is usually NUL-terminated.
+	return string(bytes.TrimRight(path, "\x00")), nil
+}
+
+// copyTrustedApplications returns the trusted applications of the
+// entries of aclList by their paths, as aclEntryFromSecACL reads
+// them. The caller must release the returned references.
+func copyTrustedApplications(aclList C.CFArrayRef) (map[string]C.CFTypeRef, error) {
+	apps := make(map[string]C.CFTypeRef)
+	for _, aclRef := range _CFArrayToArray(aclList) {
+		var applicationList C.CFArrayRef
+		var description C.CFStringRef
+		var promptSelector C.CSSM_ACL_KEYCHAIN_PROMPT_SELECTOR
+		errCode := C.SecACLCopySimpleContents(C.SecACLRef(aclRef), &applicationList, &description, &promptSelector)
+		if err := newKeychainError(errCode); err != nil {
+			releaseTrustedApplications(apps)
+			return nil, err
+		}
+		if description != nil {
+			C.CFRelease(C.CFTypeRef(description))
+		}
+		if applicationList == nil {
+			continue
+		}
+		for _, app := range _CFArrayToArray(applicationList) {
+			path, err := trustedApplicationPath(C.SecTrustedApplicationRef(app))
+			if err != nil {
+				C.CFRelease(C.CFTypeRef(applicationList))
+				releaseTrustedApplications(apps)
+				return nil, err
+			}
+			if _, ok := apps[path]; !ok {
+				apps[path] = C.CFRetain(app)
+			}
+		}
+		C.CFRelease(C.CFTypeRef(applicationList))
+	}
+	return apps, nil
+}
+
+func releaseTrustedApplications(apps map[string]C.CFTypeRef) {
+	for _, app := range apps {
+		C.CFRelease(app)
+	}
+}
+
+// SetGenericPasswordACL replaces the access control list of the
+// generic password with the given attributes in the default keychain.
+// Trusted applications the item already has are kept as they are, so
+// their code requirements aren't recomputed; only new paths are
+// looked up on disk. The user is asked to allow the change unless
+// the calling application may change the item's ACL.
+func SetGenericPasswordACL(attributes *GenericPasswordAttributes, acl ACL) (err error) {
+	keychainExecutor.do(func() {
+		err = setGenericPasswordACL(attributes, acl, nil)
+	})
+	return
+}
+
+// SetGenericPasswordACLWithPassword is like SetGenericPasswordACL, but
+// authorizes the change with the password of the default keychain
+// instead of asking the user. Changing the partition list of an item
+// requires this.
+func SetGenericPasswordACLWithPassword(attributes *GenericPasswordAttributes, acl ACL, keychainPassword []byte) (err error) {
+	if keychainPassword == nil {
+		keychainPassword = []byte{}
+	}
+	keychainExecutor.do(func() {
+		err = setGenericPasswordACL(attributes, acl, keychainPassword)
+	})
+	return
+}
+
+// setGenericPasswordACL sets the ACL of an item, authorized by
+// keychainPassword if it is non-nil.
+func setGenericPasswordACL(attributes *GenericPasswordAttributes, acl ACL, keychainPassword []byte) error {
+	if err := check32Bit("keychainPassword", keychainPassword); err != nil {
+		return err
+	}
+
+	itemRef, err := findGenericPasswordItem(attributes)
+	if err != nil {
+		return err
+	}
+	defer C.CFRelease(C.CFTypeRef(itemRef))
+
+	// Start from the item's current access, so that its owner
+	// is kept, and replace all of its entries.
+	var access C.SecAccessRef
+	errCode := C.SecKeychainItemCopyAccess(itemRef, &access)
+	if err := newKeychainError(errCode); err != nil {
+		return err
+	}
+	defer C.CFRelease(C.CFTypeRef(access))
+
+	var aclList C.CFArrayRef
+	errCode = C.SecAccessCopyACLList(access, &aclList)
+	if err := newKeychainError(errCode); err != nil {
+		return err
+	}
+	defer C.CFRelease(C.CFTypeRef(aclList))
+
+	// Keep the existing trusted applications, which may not be paths
+	// or may have moved, rather than creating them again.
+	apps, err := copyTrustedApplications(aclList)
+	if err != nil {
+		return err
+	}
+	defer releaseTrustedApplications(apps)
+
+	for _, aclRef := range _CFArrayToArray(aclList) {
+		errCode := C.SecACLRemove(C.SecACLRef(aclRef))
+		if err := newKeychainError(errCode); err != nil {
+			return err
+		}
+	}
+
+	for i := range acl {
+		if err := addSecACL(access, &acl[i], apps); err != nil {
+			return err
+		}
+	}
+
+	if keychainPassword == nil {
+		errCode = C.SecKeychainItemSetAccess(itemRef, access)
+		return newKeychainError(errCode)
+	}
+
+	var password unsafe.Pointer
+	if len(keychainPassword) > 0 {
+		password = unsafe.Pointer(&keychainPassword[0])
+	}
+	errCode = C.SecKeychainItemSetAccessWithPassword(itemRef, access, C.UInt32(len(keychainPassword)), password)
+	return newKeychainError(errCode)
+}
+
+// addSecACL adds entry to access, using the trusted applications in
+// apps for the paths it holds.
+func addSecACL(access C.SecAccessRef, entry *ACLEntry, apps map[string]C.CFTypeRef) error {
+	var tags []C.CSSM_ACL_AUTHORIZATION_TAG
+	for _, authorization := range entry.Authorizations {
+		tag, err := aclAuthorizationToTag(authorization)
+		if err != nil {
+			return err
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return fmt.Errorf("ACL entry %q has no authorizations", entry.Description)
+	}
+
+	var applicationList C.CFArrayRef
+	if !entry.AnyApplication {
+		var appRefs []C.CFTypeRef
+		for _, path := range entry.TrustedApplications {
+			if appRef, ok := apps[path]; ok {
+				appRefs = append(appRefs, appRef)
+				continue
+			}
+			if err := check32BitUTF8("TrustedApplications", path); err != nil {
+				return err
+			}
+			appRef, err := createTrustedApplication(path)
+			if err != nil {
+				return err
+			}
+			defer C.CFRelease(appRef)
+			appRefs = append(appRefs, appRef)
+		}
+		applicationList = arrayToCFArray(appRefs)
+		defer C.CFRelease(C.CFTypeRef(applicationList))
+	}
+
+	description, err := _UTF8StringToCFString(entry.Description)
+	if err != nil {
+		return err
+	}
+	defer C.CFRelease(C.CFTypeRef(description))
+
+	promptSelector := C.CSSM_ACL_KEYCHAIN_PROMPT_SELECTOR{
+		version: C.CSSM_ACL_KEYCHAIN_PROMPT_CURRENT_VERSION,
+		flags:   C.uint16(entry.PromptSelector),
+	}
+
+	var aclRef C.SecACLRef
+	errCode := C.SecACLCreateFromSimpleContents(access, applicationList, description, &promptSelector, &aclRef)
+	if err := newKeychainError(errCode); err != nil {
+		return err
+	}
+	defer C.CFRelease(C.CFTypeRef(aclRef))
+
+	errCode = C.SecACLSetAuthorizations(aclRef, &tags[0], C.uint32(len(tags)))
+	return newKeychainError(errCode)
+}
+
+func (defaultKeychain) GetGenericPasswordACL(attributes *GenericPasswordAttributes) (ACL, error) {
+	return GetGenericPasswordACL(attributes)
+}
+
+func (defaultKeychain) SetGenericPasswordACL(attributes *GenericPasswordAttributes, acl ACL) error {
+	return SetGenericPasswordACL(attributes, acl)
+}
+
+func (defaultKeychain) SetGenericPasswordACLWithPassword(attributes *GenericPasswordAttributes, acl ACL, keychainPassword []byte) error {
+	return SetGenericPasswordACLWithPassword(attributes, acl, keychainPassword)
+}
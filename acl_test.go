@@ -0,0 +1,176 @@
+package osxkeychain_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// fakeACLStore keeps the ACLs of items in memory.
+type fakeACLStore struct {
+	acls             map[string]osxkeychain.ACL
+	keychainPassword string
+	sets             int
+}
+
+func (s *fakeACLStore) GetGenericPasswordACL(attributes *osxkeychain.GenericPasswordAttributes) (osxkeychain.ACL, error) {
+	acl, ok := s.acls[attributes.ServiceName+"/"+attributes.AccountName]
+	if !ok {
+		return nil, osxkeychain.ErrItemNotFound
+	}
+	return acl, nil
+}
+
+func (s *fakeACLStore) SetGenericPasswordACL(attributes *osxkeychain.GenericPasswordAttributes, acl osxkeychain.ACL) error {
+	key := attributes.ServiceName + "/" + attributes.AccountName
+	if _, ok := s.acls[key]; !ok {
+		return osxkeychain.ErrItemNotFound
+	}
+	s.acls[key] = acl
+	s.sets++
+	return nil
+}
+
+func (s *fakeACLStore) SetGenericPasswordACLWithPassword(attributes *osxkeychain.GenericPasswordAttributes, acl osxkeychain.ACL, keychainPassword []byte) error {
+	if string(keychainPassword) != s.keychainPassword {
+		return osxkeychain.ErrAuthFailed
+	}
+	return s.SetGenericPasswordACL(attributes, acl)
+}
+
+// newTestACL returns an ACL like the one the keychain gives a new
+// generic password.
+func newTestACL() osxkeychain.ACL {
+	return osxkeychain.ACL{
+		{
+			Authorizations: []osxkeychain.ACLAuthorization{osxkeychain.ACLAuthorizationChangeACL},
+			AnyApplication: true,
+			Description:    "service",
+			PromptSelector: osxkeychain.PromptRequirePassphrase,
+		},
+		{
+			Authorizations: []osxkeychain.ACLAuthorization{
+				osxkeychain.ACLAuthorizationDecrypt,
+				osxkeychain.ACLAuthorizationExportClear,
+			},
+			TrustedApplications: []string{"/usr/local/bin/app"},
+			Description:         "service",
+		},
+		{
+			Authorizations: []osxkeychain.ACLAuthorization{osxkeychain.ACLAuthorizationEncrypt},
+			AnyApplication: true,
+			Description:    "service",
+		},
+	}
+}
+
+func TestACLWithTrustedApplication(t *testing.T) {
+	acl := newTestACL()
+
+	updated, err := acl.WithTrustedApplication("/Applications/Mail.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/usr/local/bin/app", "/Applications/Mail.app"}
+	if !reflect.DeepEqual(updated[1].TrustedApplications, expected) {
+		t.Errorf("Expected %q, got %q", expected, updated[1].TrustedApplications)
+	}
+	if len(updated[0].TrustedApplications) != 0 || len(updated[2].TrustedApplications) != 0 {
+		t.Errorf("Expected other entries to be left alone, got %+v", updated)
+	}
+
+	// The original is not modified.
+	if !reflect.DeepEqual(acl, newTestACL()) {
+		t.Errorf("Expected original ACL to be left alone, got %+v", acl)
+	}
+
+	// Adding again is a no-op.
+	again, err := updated.WithTrustedApplication("/Applications/Mail.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, updated) {
+		t.Errorf("Expected %+v, got %+v", updated, again)
+	}
+
+	removed := updated.WithoutTrustedApplication("/usr/local/bin/app")
+	expected = []string{"/Applications/Mail.app"}
+	if !reflect.DeepEqual(removed[1].TrustedApplications, expected) {
+		t.Errorf("Expected %q, got %q", expected, removed[1].TrustedApplications)
+	}
+
+	if _, err := acl[2:].WithTrustedApplication("/Applications/Mail.app"); err == nil {
+		t.Error("Expected error for ACL without a decrypt entry")
+	}
+}
+
+func TestAddAndRemoveTrustedApplication(t *testing.T) {
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "service", AccountName: "account"}
+	store := &fakeACLStore{acls: map[string]osxkeychain.ACL{"service/account": newTestACL()}}
+
+	if err := osxkeychain.AddTrustedApplication(store, attributes, "/Applications/Mail.app"); err != nil {
+		t.Fatal(err)
+	}
+	acl, _ := store.GetGenericPasswordACL(attributes)
+	expected := []string{"/usr/local/bin/app", "/Applications/Mail.app"}
+	if !reflect.DeepEqual(acl[1].TrustedApplications, expected) {
+		t.Errorf("Expected %q, got %q", expected, acl[1].TrustedApplications)
+	}
+
+	if err := osxkeychain.RemoveTrustedApplication(store, attributes, "/usr/local/bin/app"); err != nil {
+		t.Fatal(err)
+	}
+	acl, _ = store.GetGenericPasswordACL(attributes)
+	expected = []string{"/Applications/Mail.app"}
+	if !reflect.DeepEqual(acl[1].TrustedApplications, expected) {
+		t.Errorf("Expected %q, got %q", expected, acl[1].TrustedApplications)
+	}
+	if store.sets != 2 {
+		t.Errorf("Expected 2 updates, got %d", store.sets)
+	}
+
+	missing := &osxkeychain.GenericPasswordAttributes{ServiceName: "service", AccountName: "missing"}
+	if err := osxkeychain.AddTrustedApplication(store, missing, "/Applications/Mail.app"); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("Expected ErrItemNotFound, got %v", err)
+	}
+}
+
+func TestDiffACL(t *testing.T) {
+	old := newTestACL()
+	new := newTestACL()
+	new[0].PromptSelector = 0
+	new[1].TrustedApplications = []string{"/Applications/Mail.app"}
+	new[1].Description = "renamed"
+	new = append(new[:2], osxkeychain.ACLEntry{
+		Authorizations:      []osxkeychain.ACLAuthorization{osxkeychain.ACLAuthorizationSign},
+		TrustedApplications: []string{"/usr/bin/ssh"},
+	})
+
+	if changes := osxkeychain.DiffACL(old, newTestACL()); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v", changes)
+	}
+
+	changes := osxkeychain.DiffACL(old, new)
+	var lines []string
+	for _, change := range changes {
+		lines = append(lines, change.String())
+	}
+	expected := []string{
+		"changed entry [ACLAuthorizationChangeACL] prompt selector: 0x0001 -> 0x0000",
+		`changed entry [ACLAuthorizationDecrypt ACLAuthorizationExportClear] description: "service" -> "renamed" +/Applications/Mail.app -/usr/local/bin/app`,
+		"removed entry [ACLAuthorizationEncrypt] any application",
+		"added entry [ACLAuthorizationSign] +/usr/bin/ssh",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected\n%q\ngot\n%q", expected, lines)
+	}
+
+	if changes[1].Old != &old[1] || changes[1].New != &new[1] {
+		t.Errorf("Expected change to point at the entries, got %+v", changes[1])
+	}
+	if !reflect.DeepEqual(changes[1].AddedApplications, []string{"/Applications/Mail.app"}) ||
+		!reflect.DeepEqual(changes[1].RemovedApplications, []string{"/usr/local/bin/app"}) {
+		t.Errorf("Unexpected application changes %+v", changes[1])
+	}
+}
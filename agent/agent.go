@@ -0,0 +1,303 @@
+//go:build unix
+
+// Package agent serves an osxkeychain.Store over a Unix socket, as
+// ssh-agent serves keys, so that a store that is slow or prompts to
+// unlock, such as a KDBX file, is unlocked once and then shared by
+// many short-lived commands through a Client.
+package agent
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// ErrDenied is returned by Client methods when the agent's Allowlist
+// doesn't allow the calling program to do what it asked.
+var ErrDenied = errors.New("agent: denied by the agent's allowlist")
+
+// SocketEnv is the environment variable holding the path of the
+// agent's socket, as SSH_AUTH_SOCK does for ssh-agent.
+const SocketEnv = "OSXKEYCHAIN_AGENT_SOCK"
+
+// Peer is the process on the other end of a connection, as the
+// kernel reports it when it connects.
+type Peer struct {
+	UID uint32
+	PID int
+	// Executable is the path of the program the process runs, or
+	// empty if it can't be found. Since a process can exit and its
+	// PID be reused, it identifies a program only as far as
+	// TrustedApplications does.
+	Executable string
+}
+
+// Rule allows programs to use the agent.
+type Rule struct {
+	// Executable is the absolute path of the program, as in
+	// TrustedApplications. If empty, any program matches.
+	Executable string
+	// ServiceNames are the services whose items the program can
+	// use. If empty, it can use any.
+	ServiceNames []string
+	// ReadOnly restricts the program to finding passwords and
+	// listing account names.
+	ReadOnly bool
+}
+
+func (r *Rule) allows(peer *Peer, op byte, serviceName string) bool {
+	if r.Executable != "" && r.Executable != peer.Executable {
+		return false
+	}
+	if r.ReadOnly && op != opFind && op != opGetAllAccountNames {
+		return false
+	}
+	if len(r.ServiceNames) == 0 {
+		return true
+	}
+	for _, name := range r.ServiceNames {
+		if name == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+// Server serves a Store to Clients. Only processes of the same user,
+// or of root, can connect; if Allowlist isn't empty, they can only do
+// what one of its rules allows.
+//
+// The store is opened when first needed, and locked, by dropping it
+// and calling its Close method if it's an io.Closer, such as
+// kdbx.FileStore, when a Client asks or once it has been idle for
+// IdleTimeout; it's opened again for the next request.
+type Server struct {
+	// Open returns the unlocked store to serve, for example by asking
+	// for the password of a KDBX file.
+	Open        func() (osxkeychain.Store, error)
+	IdleTimeout time.Duration
+	Allowlist   []Rule
+
+	mu    sync.Mutex
+	store osxkeychain.Store
+	idle  *time.Timer
+}
+
+// Listen listens on a Unix socket at path, which only the current user
+// can connect to, removing any socket left there by an agent that is
+// no longer running.
+func Listen(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, errors.New("agent: an agent is already listening on " + path)
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	// Create the socket with the right permissions rather than
+	// changing them once others could have connected.
+	mask := syscall.Umask(0077)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(mask)
+	return l, err
+}
+
+// Serve accepts connections on l, which must be a Unix socket, and
+// serves each in its own goroutine. It returns when l is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Lock drops the store, so that it's opened again for the next
+// request, and returns the error of its Close method, if it has one.
+func (s *Server) Lock() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lock()
+}
+
+func (s *Server) lock() error {
+	store := s.store
+	s.store = nil
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	if closer, ok := store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	peer, err := connPeer(conn)
+	if err != nil || (peer.UID != uint32(os.Getuid()) && peer.UID != 0) {
+		return
+	}
+	version, err := readHello(conn)
+	if err != nil {
+		return
+	}
+	if version > protocolVersion {
+		version = protocolVersion
+	}
+	if err := writeHello(conn, version); err != nil || version == 0 {
+		return
+	}
+	for {
+		op, fields, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		status, response := s.handle(peer, op, fields)
+		if err := writeMessage(conn, status, response...); err != nil {
+			return
+		}
+	}
+}
+
+func connPeer(conn net.Conn) (*Peer, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("agent: connection isn't on a Unix socket")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var peer *Peer
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		peer, credErr = peerCredentials(fd)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	peer.Executable, _ = executablePath(peer.PID)
+	return peer, nil
+}
+
+// handle carries out a request, returning the status and fields of
+// the response.
+func (s *Server) handle(peer *Peer, op byte, fields [][]byte) (byte, [][]byte) {
+	wantFields := map[byte]int{
+		opFind:               2,
+		opAdd:                3,
+		opFindAndRemove:      2,
+		opRemoveAndAdd:       3,
+		opUpdate:             3,
+		opGetAllAccountNames: 1,
+		opLock:               0,
+	}
+	if n, ok := wantFields[op]; !ok || len(fields) != n {
+		return statusError, [][]byte{[]byte(errMalformedMessage.Error())}
+	}
+	if op == opLock {
+		if err := s.Lock(); err != nil {
+			return errorResponse(err)
+		}
+		return statusOK, nil
+	}
+	if !s.allows(peer, op, string(fields[0])) {
+		return statusDenied, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store == nil {
+		if s.Open == nil {
+			return statusError, [][]byte{[]byte("Open is nil")}
+		}
+		store, err := s.Open()
+		if err != nil {
+			return errorResponse(err)
+		}
+		s.store = store
+	}
+	if s.IdleTimeout > 0 {
+		if s.idle != nil {
+			s.idle.Stop()
+		}
+		var idle *time.Timer
+		idle = time.AfterFunc(s.IdleTimeout, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			// A timer that fired while a request held mu is stale.
+			if s.idle == idle {
+				s.lock()
+			}
+		})
+		s.idle = idle
+	}
+
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: string(fields[0])}
+	if len(fields) > 1 {
+		attributes.AccountName = string(fields[1])
+	}
+	if len(fields) > 2 {
+		attributes.Password = fields[2]
+	}
+	var err error
+	var response [][]byte
+	switch op {
+	case opFind:
+		var password []byte
+		password, err = s.store.FindGenericPassword(attributes)
+		response = [][]byte{password}
+	case opAdd:
+		err = s.store.AddGenericPassword(attributes)
+	case opFindAndRemove:
+		err = s.store.FindAndRemoveGenericPassword(attributes)
+	case opRemoveAndAdd:
+		err = s.store.RemoveAndAddGenericPassword(attributes)
+	case opUpdate:
+		err = s.store.UpdateGenericPassword(attributes)
+	case opGetAllAccountNames:
+		var accountNames []string
+		accountNames, err = s.store.GetAllAccountNames(attributes.ServiceName)
+		for _, accountName := range accountNames {
+			response = append(response, []byte(accountName))
+		}
+	}
+	if err != nil {
+		return errorResponse(err)
+	}
+	return statusOK, response
+}
+
+func (s *Server) allows(peer *Peer, op byte, serviceName string) bool {
+	if len(s.Allowlist) == 0 {
+		return true
+	}
+	for i := range s.Allowlist {
+		if s.Allowlist[i].allows(peer, op, serviceName) {
+			return true
+		}
+	}
+	return false
+}
+
+func errorResponse(err error) (byte, [][]byte) {
+	switch {
+	case errors.Is(err, osxkeychain.ErrItemNotFound):
+		return statusItemNotFound, nil
+	case errors.Is(err, osxkeychain.ErrDuplicateItem):
+		return statusDuplicateItem, nil
+	}
+	return statusError, [][]byte{[]byte(err.Error())}
+}
@@ -0,0 +1,296 @@
+//go:build unix
+
+package agent_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/agent"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+// startServer serves s on a socket in a temporary directory, and
+// returns a Client for it.
+func startServer(t *testing.T, s *agent.Server) *agent.Client {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := agent.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { l.Close() })
+	return &agent.Client{SocketPath: path}
+}
+
+// countingOpen returns an Open function that opens store, and a
+// function that returns how many times it was called.
+func countingOpen(store osxkeychain.Store) (open func() (osxkeychain.Store, error), opens func() int) {
+	var mu sync.Mutex
+	n := 0
+	open = func() (osxkeychain.Store, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		return store, nil
+	}
+	opens = func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return n
+	}
+	return open, opens
+}
+
+func TestClient(t *testing.T) {
+	open, _ := countingOpen(&storetest.MemStore{})
+	storetest.TestStore(t, startServer(t, &agent.Server{Open: open}))
+}
+
+func TestBackend(t *testing.T) {
+	open, _ := countingOpen(&storetest.MemStore{})
+	client := startServer(t, &agent.Server{Open: open})
+	store, err := osxkeychain.Open("agent://" + client.SocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.TestStore(t, store)
+
+	t.Setenv(agent.SocketEnv, client.SocketPath)
+	if store, err = osxkeychain.Open("agent:"); err != nil {
+		t.Fatal(err)
+	}
+	storetest.TestStore(t, store)
+
+	expected := "agent: URL agent://host/agent.sock isn't of the form agent:///path/to/socket"
+	if _, err := osxkeychain.Open("agent://host/agent.sock"); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, _ = filepath.EvalSymlinks(self)
+	store := &storetest.MemStore{}
+	for _, serviceName := range []string{"allowed", "read only", "other"} {
+		store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: serviceName, AccountName: "acct", Password: []byte("pw")})
+	}
+	open, _ := countingOpen(store)
+	client := startServer(t, &agent.Server{
+		Open: open,
+		Allowlist: []agent.Rule{
+			{Executable: self, ServiceNames: []string{"allowed"}},
+			{Executable: self, ServiceNames: []string{"read only"}, ReadOnly: true},
+			{Executable: "/usr/bin/other", ServiceNames: []string{"other"}},
+		},
+	})
+
+	find := func(a *osxkeychain.GenericPasswordAttributes) error {
+		_, err := client.FindGenericPassword(a)
+		return err
+	}
+	list := func(a *osxkeychain.GenericPasswordAttributes) error {
+		_, err := client.GetAllAccountNames(a.ServiceName)
+		return err
+	}
+	tests := []struct {
+		serviceName string
+		call        func(a *osxkeychain.GenericPasswordAttributes) error
+		expected    error
+	}{
+		{"allowed", find, nil},
+		{"allowed", client.UpdateGenericPassword, nil},
+		{"read only", find, nil},
+		{"read only", list, nil},
+		{"read only", client.UpdateGenericPassword, agent.ErrDenied},
+		{"read only", client.FindAndRemoveGenericPassword, agent.ErrDenied},
+		{"other", find, agent.ErrDenied},
+		{"unlisted", client.AddGenericPassword, agent.ErrDenied},
+	}
+	for _, test := range tests {
+		attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: test.serviceName, AccountName: "acct", Password: []byte("new")}
+		if err := test.call(attributes); err != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.serviceName, test.expected, err)
+		}
+	}
+	// Anyone who can connect can lock the agent.
+	if err := client.Lock(); err != nil {
+		t.Error(err)
+	}
+}
+
+// closingStore counts how many times it's closed.
+type closingStore struct {
+	storetest.MemStore
+	mu     sync.Mutex
+	closes int
+}
+
+func (s *closingStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closes++
+	return nil
+}
+
+func (s *closingStore) closed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closes
+}
+
+func TestLocking(t *testing.T) {
+	store := &closingStore{}
+	open, opens := countingOpen(store)
+	client := startServer(t, &agent.Server{Open: open, IdleTimeout: 100 * time.Millisecond})
+	find := func() {
+		t.Helper()
+		if _, err := client.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct"}); err != osxkeychain.ErrItemNotFound {
+			t.Fatalf("Expected ErrItemNotFound, got %v", err)
+		}
+	}
+
+	find()
+	find()
+	if opens() != 1 {
+		t.Errorf("Expected the store to be opened once, got %d", opens())
+	}
+	if err := client.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	find()
+	if opens() != 2 || store.closed() != 1 {
+		t.Errorf("Expected Lock to close the store and make the agent open it again, got %d closes and %d opens", store.closed(), opens())
+	}
+	time.Sleep(300 * time.Millisecond)
+	find()
+	if opens() != 3 || store.closed() != 2 {
+		t.Errorf("Expected the idle store to be closed and locked, got %d closes and %d opens", store.closed(), opens())
+	}
+
+	failing := startServer(t, &agent.Server{Open: func() (osxkeychain.Store, error) {
+		return nil, errors.New("wrong password")
+	}})
+	if _, err := failing.GetAllAccountNames("svc"); err == nil || err.Error() != "wrong password" {
+		t.Errorf("Expected \"wrong password\", got %v", err)
+	}
+}
+
+// wrappingStore wraps the errors of its Store, as stores built on
+// others do.
+type wrappingStore struct {
+	osxkeychain.Store
+}
+
+func (s wrappingStore) AddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := s.Store.AddGenericPassword(attributes); err != nil {
+		return fmt.Errorf("wrapped: %w", err)
+	}
+	return nil
+}
+
+func (s wrappingStore) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	password, err := s.Store.FindGenericPassword(attributes)
+	if err != nil {
+		return nil, fmt.Errorf("wrapped: %w", err)
+	}
+	return password, nil
+}
+
+func TestWrappedErrors(t *testing.T) {
+	open, _ := countingOpen(wrappingStore{&storetest.MemStore{}})
+	client := startServer(t, &agent.Server{Open: open})
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct"}
+	if _, err := client.FindGenericPassword(attributes); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("Expected ErrItemNotFound, got %v", err)
+	}
+	if err := client.AddGenericPassword(attributes); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.AddGenericPassword(attributes); err != osxkeychain.ErrDuplicateItem {
+		t.Errorf("Expected ErrDuplicateItem, got %v", err)
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	open, _ := countingOpen(&storetest.MemStore{})
+	client := startServer(t, &agent.Server{Open: open})
+	exchange := func(send string, receive int) (string, error) {
+		t.Helper()
+		conn, err := net.Dial("unix", client.SocketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := io.WriteString(conn, send); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, receive)
+		n, err := io.ReadFull(conn, reply)
+		return string(reply[:n]), err
+	}
+
+	tests := []struct {
+		name     string
+		send     string
+		expected string
+		hangsUp  bool
+	}{
+		{"newer client", "OKAG\x00\x07", "OKAG\x00\x01", false},
+		{"unsupported version", "OKAG\x00\x00", "OKAG\x00\x00", true},
+		{"not the protocol", "SSH-2.0-OpenSSH\r\n", "", true},
+		{"unknown operation", "OKAG\x00\x01\x00\x00\x00\x01\x63", "OKAG\x00\x01\x00\x00\x00\x1d\x04\x00\x00\x00\x18agent: malformed message", false},
+		{"wrong field count", "OKAG\x00\x01\x00\x00\x00\x01\x01", "OKAG\x00\x01\x00\x00\x00\x1d\x04\x00\x00\x00\x18agent: malformed message", false},
+		{"truncated field", "OKAG\x00\x01\x00\x00\x00\x03\x01\x00\x00", "OKAG\x00\x01", true},
+	}
+	for _, test := range tests {
+		// When the agent should hang up, ask for one more byte than
+		// expected, to see that reading it fails.
+		n := len(test.expected)
+		if test.hangsUp {
+			n++
+		}
+		reply, err := exchange(test.send, n)
+		if reply != test.expected || test.hangsUp != (err != nil) {
+			t.Errorf("%s: expected %q, got %q, %v", test.name, test.expected, reply, err)
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := agent.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm()&0077 != 0 {
+		t.Errorf("Expected only the user to have access, got %v, %v", info.Mode(), err)
+	}
+	expected := "agent: an agent is already listening on " + path
+	if _, err := agent.Listen(path); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+
+	// A socket left by an agent that died is replaced.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if l, err = agent.Listen(path); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	if _, err := (&agent.Client{SocketPath: path}).GetAllAccountNames("svc"); err == nil {
+		t.Error("Expected an error with no agent listening")
+	}
+}
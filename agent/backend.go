@@ -0,0 +1,26 @@
+//go:build unix
+
+package agent
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// The agent backend opens a Client with the URL "agent:", whose
+// socket is the one in SocketEnv, or "agent:///path/to/socket".
+func init() {
+	osxkeychain.Register("agent", &osxkeychain.Backend{Open: openClient})
+}
+
+func openClient(u *url.URL, options *osxkeychain.Options) (osxkeychain.Store, error) {
+	if err := options.Err(); err != nil {
+		return nil, err
+	}
+	if u.Opaque != "" || u.Host != "" {
+		return nil, errors.New("agent: URL " + u.Redacted() + " isn't of the form agent:///path/to/socket")
+	}
+	return &Client{SocketPath: u.Path}, nil
+}
@@ -0,0 +1,142 @@
+//go:build unix
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// Client is an osxkeychain.Store whose items are kept by an agent.
+// Each method makes a new connection to the agent's socket, so a
+// Client can be used by many goroutines. Only the names and password
+// of items are sent; other attributes are ignored.
+type Client struct {
+	// SocketPath is the path of the agent's socket. If empty, the one
+	// in SocketEnv is used.
+	SocketPath string
+}
+
+// AddGenericPassword implements osxkeychain.Store.
+func (c *Client) AddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	_, err := c.call(opAdd, []byte(attributes.ServiceName), []byte(attributes.AccountName), attributes.Password)
+	return err
+}
+
+// FindGenericPassword implements osxkeychain.Store.
+func (c *Client) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	if err := attributes.CheckValidity(); err != nil {
+		return nil, err
+	}
+	fields, err := c.call(opFind, []byte(attributes.ServiceName), []byte(attributes.AccountName))
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 1 {
+		return nil, errMalformedMessage
+	}
+	return fields[0], nil
+}
+
+// FindAndRemoveGenericPassword implements osxkeychain.Store.
+func (c *Client) FindAndRemoveGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	_, err := c.call(opFindAndRemove, []byte(attributes.ServiceName), []byte(attributes.AccountName))
+	return err
+}
+
+// RemoveAndAddGenericPassword implements osxkeychain.Store.
+func (c *Client) RemoveAndAddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	_, err := c.call(opRemoveAndAdd, []byte(attributes.ServiceName), []byte(attributes.AccountName), attributes.Password)
+	return err
+}
+
+// UpdateGenericPassword implements osxkeychain.Store.
+func (c *Client) UpdateGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	_, err := c.call(opUpdate, []byte(attributes.ServiceName), []byte(attributes.AccountName), attributes.Password)
+	return err
+}
+
+// GetAllAccountNames implements osxkeychain.Store.
+func (c *Client) GetAllAccountNames(serviceName string) ([]string, error) {
+	fields, err := c.call(opGetAllAccountNames, []byte(serviceName))
+	if err != nil {
+		return nil, err
+	}
+	accountNames := []string{}
+	for _, field := range fields {
+		accountNames = append(accountNames, string(field))
+	}
+	return accountNames, nil
+}
+
+// Lock asks the agent to lock its store, as Server.Lock does. Any
+// program that can connect to the agent can lock it.
+func (c *Client) Lock() error {
+	_, err := c.call(opLock)
+	return err
+}
+
+// call sends a request to the agent, returning the fields of its
+// response.
+func (c *Client) call(op byte, fields ...[]byte) ([][]byte, error) {
+	path := c.SocketPath
+	if path == "" {
+		if path = os.Getenv(SocketEnv); path == "" {
+			return nil, errors.New("agent: " + SocketEnv + " isn't set")
+		}
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := writeHello(conn, protocolVersion); err != nil {
+		return nil, err
+	}
+	version, err := readHello(conn)
+	if err != nil {
+		return nil, err
+	}
+	if version != protocolVersion {
+		return nil, fmt.Errorf("agent: agent doesn't speak protocol version %d", protocolVersion)
+	}
+	if err := writeMessage(conn, op, fields...); err != nil {
+		return nil, err
+	}
+	status, response, err := readMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case statusOK:
+		return response, nil
+	case statusItemNotFound:
+		return nil, osxkeychain.ErrItemNotFound
+	case statusDuplicateItem:
+		return nil, osxkeychain.ErrDuplicateItem
+	case statusDenied:
+		return nil, ErrDenied
+	case statusError:
+		if len(response) == 1 {
+			return nil, errors.New(string(response[0]))
+		}
+	}
+	return nil, errMalformedMessage
+}
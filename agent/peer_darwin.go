@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredentials returns the credentials of the process on the other
+// end of a Unix socket, with LOCAL_PEERCRED and LOCAL_PEERPID.
+func peerCredentials(fd uintptr) (*Peer, error) {
+	cred, err := unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	if err != nil {
+		return nil, err
+	}
+	pid, err := unix.GetsockoptInt(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+	if err != nil {
+		return nil, err
+	}
+	return &Peer{UID: cred.Uid, PID: pid}, nil
+}
+
+// executablePath returns the path a process was started from, which
+// kern.procargs2 returns after the number of arguments.
+func executablePath(pid int) (string, error) {
+	args, err := unix.SysctlRaw("kern.procargs2", pid)
+	if err != nil {
+		return "", err
+	}
+	if len(args) < 4 {
+		return "", errors.New("agent: kern.procargs2 is truncated")
+	}
+	path := args[4:]
+	if i := bytes.IndexByte(path, 0); i >= 0 {
+		path = path[:i]
+	}
+	return string(path), nil
+}
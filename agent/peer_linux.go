@@ -0,0 +1,22 @@
+package agent
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredentials returns the credentials of the process on the other
+// end of a Unix socket, with SO_PEERCRED.
+func peerCredentials(fd uintptr) (*Peer, error) {
+	cred, err := unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return nil, err
+	}
+	return &Peer{UID: cred.Uid, PID: int(cred.Pid)}, nil
+}
+
+func executablePath(pid int) (string, error) {
+	return os.Readlink("/proc/" + strconv.Itoa(pid) + "/exe")
+}
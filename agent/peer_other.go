@@ -0,0 +1,13 @@
+//go:build unix && !linux && !darwin
+
+package agent
+
+import "errors"
+
+func peerCredentials(fd uintptr) (*Peer, error) {
+	return nil, errors.New("agent: peer credentials aren't supported on this platform")
+}
+
+func executablePath(pid int) (string, error) {
+	return "", errors.New("agent: executable paths aren't supported on this platform")
+}
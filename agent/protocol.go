@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The protocol starts with each side sending a hello: protocolMagic
+// followed by a big-endian uint16 version. The client sends the
+// highest version it speaks, and the agent replies with the version
+// they'll use, or 0 if it speaks none the client does, and hangs up.
+//
+// Then the client sends requests and the agent replies to each, one at
+// a time. Every message is a big-endian uint32 length followed by that
+// many bytes: a kind byte, an operation for requests and a status for
+// responses, then any number of fields, each a big-endian uint32
+// length followed by that many bytes.
+const (
+	protocolMagic   = "OKAG"
+	protocolVersion = 1
+
+	// maxMessageLength bounds what a peer can make the other side
+	// allocate.
+	maxMessageLength = 64 << 20
+)
+
+// Operations of version 1, and the fields of their requests and
+// responses.
+const (
+	// opFind: service name, account name -> password.
+	opFind = 1 + iota
+	// opAdd: service name, account name, password.
+	opAdd
+	// opFindAndRemove: service name, account name.
+	opFindAndRemove
+	// opRemoveAndAdd: service name, account name, password.
+	opRemoveAndAdd
+	// opUpdate: service name, account name, password.
+	opUpdate
+	// opGetAllAccountNames: service name -> account names.
+	opGetAllAccountNames
+	// opLock: no fields.
+	opLock
+)
+
+// Statuses of responses. Only statusOK and statusError have fields:
+// those of the operation's response, and an error message.
+const (
+	statusOK = iota
+	statusItemNotFound
+	statusDuplicateItem
+	statusDenied
+	statusError
+)
+
+var errMalformedMessage = errors.New("agent: malformed message")
+
+func writeHello(w io.Writer, version uint16) error {
+	hello := binary.BigEndian.AppendUint16([]byte(protocolMagic), version)
+	_, err := w.Write(hello)
+	return err
+}
+
+func readHello(r io.Reader) (version uint16, err error) {
+	hello := make([]byte, len(protocolMagic)+2)
+	if _, err := io.ReadFull(r, hello); err != nil {
+		return 0, err
+	}
+	if string(hello[:len(protocolMagic)]) != protocolMagic {
+		return 0, errors.New("agent: peer doesn't speak the agent protocol")
+	}
+	return binary.BigEndian.Uint16(hello[len(protocolMagic):]), nil
+}
+
+func writeMessage(w io.Writer, kind byte, fields ...[]byte) error {
+	length := 1
+	for _, field := range fields {
+		length += 4 + len(field)
+	}
+	if length > maxMessageLength {
+		return fmt.Errorf("agent: message of %d bytes is too long", length)
+	}
+	var buf bytes.Buffer
+	buf.Grow(4 + length)
+	binary.Write(&buf, binary.BigEndian, uint32(length))
+	buf.WriteByte(kind)
+	for _, field := range fields {
+		binary.Write(&buf, binary.BigEndian, uint32(len(field)))
+		buf.Write(field)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func readMessage(r io.Reader) (kind byte, fields [][]byte, err error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return 0, nil, err
+	}
+	if length < 1 || length > maxMessageLength {
+		return 0, nil, errMalformedMessage
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	kind, message = message[0], message[1:]
+	for len(message) > 0 {
+		if len(message) < 4 || uint64(len(message)-4) < uint64(binary.BigEndian.Uint32(message)) {
+			return 0, nil, errMalformedMessage
+		}
+		n := binary.BigEndian.Uint32(message)
+		fields = append(fields, message[4:4+n])
+		message = message[4+n:]
+	}
+	return kind, fields, nil
+}
@@ -0,0 +1,128 @@
+package osxkeychain
+
+import (
+	"errors"
+	"math"
+	"unicode/utf8"
+)
+
+// GenericPasswordAttributes holds all the info for a generic password
+// in a keychain.
+//
+// All string fields must have size that fits in 32 bits. All string
+// fields except for Password must be encoded in UTF-8.
+//
+// TrustedApplications is a list of additional application (paths)
+// that should have access to the keychain item. The application
+// that creates the keychain item always has access, so this list
+// is for additional apps or executables.
+//
+// AccessControl, if non-zero, makes the user authenticate as the flags
+// require, for example with Touch ID, before the password can be read,
+// and Accessible says when the password can be read at all. Both are
+// set when the item is added, apply only to items in the
+// data-protection keychain, and can't be combined with
+// TrustedApplications. OperationPrompt is shown to the user when they
+// are asked to authenticate to read such an item.
+//
+// UseDataProtectionKeychain selects the data-protection keychain
+// rather than the legacy file-based one, and AccessGroup, which
+// implies it, names the keychain access group the item is shared in
+// by apps signed with that group in their entitlements. Setting
+// AccessControl, Accessible or OperationPrompt also implies it.
+//
+// Synchronizable says whether the item is synchronized through iCloud
+// Keychain when it is added, and which items match when looking one
+// up. Anything but SynchronizableDefault implies the data-protection
+// keychain.
+type GenericPasswordAttributes struct {
+	ServiceName         string
+	AccountName         string
+	Password            []byte
+	TrustedApplications []string
+
+	AccessControl   AccessControlFlags
+	Accessible      Accessible
+	OperationPrompt string
+
+	UseDataProtectionKeychain bool
+	AccessGroup               string
+
+	Synchronizable Synchronizable
+}
+
+func check32Bit(paramName string, paramValue []byte) error {
+	if uint64(len(paramValue)) > math.MaxUint32 {
+		return errors.New(paramName + " has size overflowing 32 bits")
+	}
+	return nil
+}
+
+func check32BitUTF8(paramName, paramValue string) error {
+	if uint64(len(paramValue)) > math.MaxUint32 {
+		return errors.New(paramName + " has size overflowing 32 bits")
+	}
+	if !utf8.ValidString(paramValue) {
+		return errors.New(paramName + " is not a valid UTF-8 string")
+	}
+	return nil
+}
+
+// CheckValidity returns an error if any of the attributes in the
+// given GenericPasswordAttributes are invalid. Otherwise, it returns
+// nil.
+func (attributes *GenericPasswordAttributes) CheckValidity() error {
+	if err := check32BitUTF8("ServiceName", attributes.ServiceName); err != nil {
+		return err
+	}
+	if err := check32BitUTF8("AccountName", attributes.AccountName); err != nil {
+		return err
+	}
+	if err := check32Bit("Password", attributes.Password); err != nil {
+		return err
+	}
+	for _, trustedApplication := range attributes.TrustedApplications {
+		if err := check32BitUTF8("TrustedApplications", trustedApplication); err != nil {
+			return err
+		}
+	}
+	if attributes.AccessControl != 0 {
+		if err := attributes.AccessControl.CheckValidity(); err != nil {
+			return err
+		}
+	}
+	if err := attributes.Accessible.CheckValidity(); err != nil {
+		return err
+	}
+	if (attributes.AccessControl != 0 || attributes.Accessible != AccessibleDefault) &&
+		len(attributes.TrustedApplications) > 0 {
+		return errors.New("AccessControl and Accessible can't be combined with TrustedApplications")
+	}
+	if err := check32BitUTF8("OperationPrompt", attributes.OperationPrompt); err != nil {
+		return err
+	}
+	if err := check32BitUTF8("AccessGroup", attributes.AccessGroup); err != nil {
+		return err
+	}
+	if (attributes.UseDataProtectionKeychain || attributes.AccessGroup != "") &&
+		len(attributes.TrustedApplications) > 0 {
+		return errors.New("UseDataProtectionKeychain and AccessGroup can't be combined with TrustedApplications")
+	}
+	if err := attributes.Synchronizable.CheckValidity(); err != nil {
+		return err
+	}
+	if attributes.Synchronizable != SynchronizableDefault && len(attributes.TrustedApplications) > 0 {
+		return errors.New("Synchronizable can't be combined with TrustedApplications")
+	}
+	return nil
+}
+
+// usesDataProtectionKeychain returns true if the item with the given
+// attributes is kept in the data-protection keychain rather than the
+// file-based one.
+func (attributes *GenericPasswordAttributes) usesDataProtectionKeychain() bool {
+	return attributes.UseDataProtectionKeychain || attributes.AccessGroup != "" ||
+		attributes.Synchronizable != SynchronizableDefault ||
+		attributes.AccessControl != 0 || attributes.Accessible != AccessibleDefault ||
+		attributes.OperationPrompt != ""
+}
@@ -0,0 +1,235 @@
+// Package audit records every use of a Store, so that it can be known
+// which program read or changed which item, and when. Records never
+// hold passwords.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// Caller is the process that used a Store.
+type Caller struct {
+	PID        int    `json:"pid"`
+	UID        int    `json:"uid"`
+	Executable string `json:"executable,omitempty"`
+}
+
+var (
+	currentProcessOnce sync.Once
+	currentProcess     Caller
+)
+
+// CurrentProcess returns the current process as a Caller.
+func CurrentProcess() *Caller {
+	currentProcessOnce.Do(func() {
+		currentProcess = Caller{PID: os.Getpid(), UID: os.Getuid()}
+		currentProcess.Executable, _ = os.Executable()
+	})
+	caller := currentProcess
+	return &caller
+}
+
+// Operations, as recorded in Records.
+const (
+	OpAdd                = "add"
+	OpFind               = "find"
+	OpFindAndRemove      = "find_and_remove"
+	OpRemoveAndAdd       = "remove_and_add"
+	OpUpdate             = "update"
+	OpGetAllAccountNames = "get_all_account_names"
+)
+
+// Record is an operation on a Store.
+type Record struct {
+	Time        time.Time `json:"time"`
+	Operation   string    `json:"operation"`
+	ServiceName string    `json:"service"`
+	AccountName string    `json:"account,omitempty"`
+	Caller
+	// Result is "ok" or "error".
+	Result string `json:"result"`
+	// Code is the OSStatus result code of the error, if it's a
+	// keychain error such as osxkeychain.ErrItemNotFound.
+	Code  int32  `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	// PrevHash and Hash are set by a Chain.
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// hash returns the hash of r, including PrevHash but not Hash.
+func (r *Record) hash() (string, error) {
+	unhashed := *r
+	unhashed.Hash = ""
+	data, err := json.Marshal(&unhashed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Sink keeps Records.
+type Sink interface {
+	Write(r *Record) error
+}
+
+// SinkFunc is a Sink calling a function with each Record.
+type SinkFunc func(r *Record) error
+
+// Write implements Sink.
+func (f SinkFunc) Write(r *Record) error {
+	return f(r)
+}
+
+// Chain is a Sink that links each Record to the one before by setting
+// its PrevHash to the Hash of that record, and its Hash to the SHA-256
+// hash of its JSON encoding, before writing it to Sink. Verify then
+// detects records that were modified, removed or inserted, except
+// after the last one; comparing the last hash with one kept elsewhere
+// detects those too.
+type Chain struct {
+	Sink Sink
+	// Last is the Hash of the last record written, or of the last
+	// record already in the log when the Chain is created. It's empty
+	// for a new log.
+	Last string
+
+	mu sync.Mutex
+}
+
+// Write implements Sink.
+func (c *Chain) Write(r *Record) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r.PrevHash = c.Last
+	hash, err := r.hash()
+	if err != nil {
+		return err
+	}
+	r.Hash = hash
+	if err := c.Sink.Write(r); err != nil {
+		return err
+	}
+	c.Last = hash
+	return nil
+}
+
+// Store is an osxkeychain.Store that writes a Record to Sink for every
+// call to the Store it wraps. If writing the record fails, the method
+// returns that error instead of its result, so that no password is
+// returned without being recorded.
+type Store struct {
+	Store osxkeychain.Store
+	Sink  Sink
+	// Caller is recorded as the caller of every operation. If nil, the
+	// current process is.
+	Caller *Caller
+}
+
+// AddGenericPassword implements osxkeychain.Store.
+func (s *Store) AddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	err := s.Store.AddGenericPassword(attributes)
+	return s.record(OpAdd, attributes.ServiceName, attributes.AccountName, err)
+}
+
+// FindGenericPassword implements osxkeychain.Store.
+func (s *Store) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	password, err := s.Store.FindGenericPassword(attributes)
+	if err := s.record(OpFind, attributes.ServiceName, attributes.AccountName, err); err != nil {
+		return nil, err
+	}
+	return password, nil
+}
+
+// FindAndRemoveGenericPassword implements osxkeychain.Store.
+func (s *Store) FindAndRemoveGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	err := s.Store.FindAndRemoveGenericPassword(attributes)
+	return s.record(OpFindAndRemove, attributes.ServiceName, attributes.AccountName, err)
+}
+
+// RemoveAndAddGenericPassword implements osxkeychain.Store.
+func (s *Store) RemoveAndAddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	err := s.Store.RemoveAndAddGenericPassword(attributes)
+	return s.record(OpRemoveAndAdd, attributes.ServiceName, attributes.AccountName, err)
+}
+
+// UpdateGenericPassword implements osxkeychain.Store.
+func (s *Store) UpdateGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	err := s.Store.UpdateGenericPassword(attributes)
+	return s.record(OpUpdate, attributes.ServiceName, attributes.AccountName, err)
+}
+
+// GetAllAccountNames implements osxkeychain.Store.
+func (s *Store) GetAllAccountNames(serviceName string) ([]string, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	accountNames, err := s.Store.GetAllAccountNames(serviceName)
+	if err := s.record(OpGetAllAccountNames, serviceName, "", err); err != nil {
+		return nil, err
+	}
+	return accountNames, nil
+}
+
+// check returns an error if an operation couldn't be carried out and
+// recorded.
+func (s *Store) check() error {
+	if s.Store == nil {
+		return errors.New("Store is nil")
+	}
+	if s.Sink == nil {
+		return errors.New("Sink is nil")
+	}
+	return nil
+}
+
+// record writes a Record of an operation that returned err, returning
+// err or the error writing it.
+func (s *Store) record(op, serviceName, accountName string, err error) error {
+	caller := s.Caller
+	if caller == nil {
+		caller = CurrentProcess()
+	}
+	r := &Record{
+		Time:        time.Now().UTC(),
+		Operation:   op,
+		ServiceName: serviceName,
+		AccountName: accountName,
+		Caller:      *caller,
+		Result:      "ok",
+	}
+	if err != nil {
+		r.Result, r.Error = "error", err.Error()
+		r.Code, _ = osxkeychain.ErrorCode(err)
+	}
+	if sinkErr := s.Sink.Write(r); sinkErr != nil {
+		return fmt.Errorf("audit: can't record the operation: %w", sinkErr)
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package audit_test
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/audit"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+// recorder is a Sink keeping Records in memory.
+type recorder struct {
+	records []audit.Record
+	err     error
+}
+
+func (r *recorder) Write(record *audit.Record) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.records = append(r.records, *record)
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, &audit.Store{Store: &storetest.MemStore{}, Sink: &recorder{}})
+}
+
+func TestRecords(t *testing.T) {
+	sink := &recorder{}
+	caller := &audit.Caller{PID: 42, UID: 501, Executable: "/usr/local/bin/deploy"}
+	store := &audit.Store{Store: &storetest.MemStore{}, Sink: sink, Caller: caller}
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("secret")}
+	store.AddGenericPassword(attributes)
+	store.FindGenericPassword(attributes)
+	store.GetAllAccountNames("svc")
+	store.FindAndRemoveGenericPassword(attributes)
+	store.UpdateGenericPassword(attributes)
+	store.RemoveAndAddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "\xff", AccountName: "acct"})
+
+	expected := []audit.Record{
+		{Operation: audit.OpAdd, ServiceName: "svc", AccountName: "acct", Caller: *caller, Result: "ok"},
+		{Operation: audit.OpFind, ServiceName: "svc", AccountName: "acct", Caller: *caller, Result: "ok"},
+		{Operation: audit.OpGetAllAccountNames, ServiceName: "svc", Caller: *caller, Result: "ok"},
+		{Operation: audit.OpFindAndRemove, ServiceName: "svc", AccountName: "acct", Caller: *caller, Result: "ok"},
+		{Operation: audit.OpUpdate, ServiceName: "svc", AccountName: "acct", Caller: *caller, Result: "error", Code: -25300, Error: osxkeychain.ErrItemNotFound.Error()},
+		{Operation: audit.OpRemoveAndAdd, ServiceName: "\xff", AccountName: "acct", Caller: *caller, Result: "error", Error: "ServiceName is not a valid UTF-8 string"},
+	}
+	for i := range sink.records {
+		if time.Since(sink.records[i].Time) > time.Minute || sink.records[i].Time.Location() != time.UTC {
+			t.Errorf("Expected a time in UTC, got %v", sink.records[i].Time)
+		}
+		sink.records[i].Time = time.Time{}
+	}
+	if !reflect.DeepEqual(sink.records, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, sink.records)
+	}
+
+	// By default, the current process is the caller.
+	store.Caller = nil
+	store.GetAllAccountNames("svc")
+	if last := sink.records[len(sink.records)-1].Caller; last.PID != os.Getpid() || last.UID != os.Getuid() || last.Executable == "" {
+		t.Errorf("Expected the current process, got %+v", last)
+	}
+}
+
+func TestSinkErrors(t *testing.T) {
+	sink := &recorder{err: errors.New("disk full")}
+	memStore := &storetest.MemStore{}
+	store := &audit.Store{Store: memStore, Sink: sink}
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("secret")}
+	memStore.AddGenericPassword(attributes)
+
+	// No password is returned without being recorded.
+	expected := "audit: can't record the operation: disk full"
+	if password, err := store.FindGenericPassword(attributes); err == nil || err.Error() != expected || password != nil {
+		t.Errorf("Expected \"%s\", got %q, %v", expected, password, err)
+	}
+	if accountNames, err := store.GetAllAccountNames("svc"); err == nil || err.Error() != expected || accountNames != nil {
+		t.Errorf("Expected \"%s\", got %v, %v", expected, accountNames, err)
+	}
+
+	// Nothing is done that can't be recorded.
+	store.Sink = nil
+	if err := store.FindAndRemoveGenericPassword(attributes); err == nil || err.Error() != "Sink is nil" {
+		t.Errorf("Expected \"Sink is nil\", got %v", err)
+	}
+	if items := memStore.Items(); len(items) != 1 {
+		t.Errorf("Expected the item to be kept, got %v", items)
+	}
+}
+
+func TestChain(t *testing.T) {
+	sink := &recorder{}
+	chain := &audit.Chain{Sink: audit.SinkFunc(func(r *audit.Record) error {
+		if r.Operation == "fail" {
+			return errors.New("disk full")
+		}
+		return sink.Write(r)
+	})}
+	for _, op := range []string{audit.OpAdd, "fail", audit.OpFind} {
+		chain.Write(&audit.Record{Operation: op})
+	}
+	// A record that wasn't written isn't linked to.
+	records := sink.records
+	if len(records) != 2 || records[0].PrevHash != "" || len(records[0].Hash) != 64 || records[1].PrevHash != records[0].Hash {
+		t.Errorf("Expected the second record to follow the first, got %+v", records)
+	}
+	if len(records) == 2 && chain.Last != records[1].Hash {
+		t.Errorf("Expected Last to be the hash of the last record written, got %q", chain.Last)
+	}
+}
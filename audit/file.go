@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// File is a Sink appending each Record to a file as a line of JSON,
+// and syncing it to disk before returning.
+type File struct {
+	file  *os.File
+	chain *Chain
+
+	mu sync.Mutex
+}
+
+// OpenFile opens the log at path for appending, creating it if needed.
+// If chained is set, records are linked as a Chain does, continuing
+// the chain of those already in the log, which must verify.
+func OpenFile(path string, chained bool) (*File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{file: file}
+	if chained {
+		_, last, err := Verify(file, "")
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("audit: %s: %w", path, err)
+		}
+		f.chain = &Chain{Sink: SinkFunc(f.write), Last: last}
+	}
+	return f, nil
+}
+
+// Write implements Sink.
+func (f *File) Write(r *Record) error {
+	if f.chain != nil {
+		return f.chain.Write(r)
+	}
+	return f.write(r)
+}
+
+func (f *File) write(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// One write, so that records written by other processes aren't
+	// interleaved with it.
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.file.Close()
+}
+
+// maxRecordLength bounds the lines Verify reads.
+const maxRecordLength = 1 << 20
+
+// Verify reads a chained log of records, one JSON object per line as
+// File writes them, checking that each links to the one before and is
+// unmodified. The first must link to prevHash, which is empty for a
+// log that doesn't continue another. It returns the number of records
+// and the hash of the last one, or prevHash if there are none.
+func Verify(r io.Reader, prevHash string) (records int, lastHash string, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordLength)
+	lastHash = prevHash
+	for scanner.Scan() {
+		records++
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return records, lastHash, fmt.Errorf("audit: line %d isn't a record: %v", records, err)
+		}
+		if record.Hash == "" {
+			return records, lastHash, fmt.Errorf("audit: record %d isn't chained", records)
+		}
+		if record.PrevHash != lastHash {
+			return records, lastHash, fmt.Errorf("audit: record %d doesn't follow the record before it", records)
+		}
+		hash, err := record.hash()
+		if err != nil {
+			return records, lastHash, err
+		}
+		// Fields the record doesn't have aren't hashed, so a line
+		// holding any is modified too.
+		data, err := json.Marshal(&record)
+		if err != nil {
+			return records, lastHash, err
+		}
+		if hash != record.Hash || !bytes.Equal(data, scanner.Bytes()) {
+			return records, lastHash, fmt.Errorf("audit: record %d has been modified", records)
+		}
+		lastHash = hash
+	}
+	return records, lastHash, scanner.Err()
+}
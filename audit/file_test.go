@@ -0,0 +1,142 @@
+package audit_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/audit"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+// writeLog writes a chained log of n records to path, returning its
+// lines.
+func writeLog(t *testing.T, path string, n int) []string {
+	file, err := audit.OpenFile(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &audit.Store{Store: &storetest.MemStore{}, Sink: file}
+	for i := 0; i < n; i++ {
+		store.GetAllAccountNames("svc")
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// hashOf returns the hash of the record on line.
+func hashOf(t *testing.T, line string) string {
+	var record audit.Record
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatal(err)
+	}
+	return record.Hash
+}
+
+func verify(path string) (int, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	return audit.Verify(file, "")
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	file, err := audit.OpenFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &audit.Store{Store: &storetest.MemStore{}, Sink: file}
+	store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("secret")})
+	file.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record audit.Record
+	if err := json.Unmarshal(data, &record); err != nil || strings.Count(string(data), "\n") != 1 {
+		t.Fatalf("Expected one line of JSON, got %s, %v", data, err)
+	}
+	if record.Operation != audit.OpAdd || record.ServiceName != "svc" || record.AccountName != "acct" || record.Result != "ok" {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), "hash") {
+		t.Errorf("Expected no password or hash in %s", data)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected only the user to have access, got %v, %v", info.Mode(), err)
+	}
+	if _, _, err := verify(path); err == nil || err.Error() != "audit: record 1 isn't chained" {
+		t.Errorf("Expected \"audit: record 1 isn't chained\", got %v", err)
+	}
+}
+
+func TestChainedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeLog(t, path, 2)
+	// Reopening the log continues its chain.
+	lines := writeLog(t, path, 2)
+	records, lastHash, err := verify(path)
+	if err != nil || records != 4 || lastHash != hashOf(t, lines[3]) {
+		t.Errorf("Expected 4 records ending with %s, got %d, %s, %v", lines[3], records, lastHash, err)
+	}
+
+	// A log continuing another starts from its last hash.
+	next := filepath.Join(filepath.Dir(path), "audit.log.1")
+	os.WriteFile(next, []byte(strings.Join(lines[2:], "\n")+"\n"), 0600)
+	file, _ := os.Open(next)
+	defer file.Close()
+	if records, _, err := audit.Verify(file, hashOf(t, lines[1])); err != nil || records != 2 {
+		t.Errorf("Expected 2 records, got %d, %v", records, err)
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(lines []string) []string
+		expected string
+	}{
+		{"modified", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"svc"`, `"other"`, 1)
+			return lines
+		}, "audit: record 2 has been modified"},
+		{"field added", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `{`, `{"note":"x",`, 1)
+			return lines
+		}, "audit: record 2 has been modified"},
+		{"removed", func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}, "audit: record 2 doesn't follow the record before it"},
+		{"reordered", func(lines []string) []string {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		}, "audit: record 1 doesn't follow the record before it"},
+		{"truncated", func(lines []string) []string {
+			lines[3] = lines[3][:len(lines[3])/2]
+			return lines
+		}, "audit: line 4 isn't a record: unexpected end of JSON input"},
+	}
+	for _, test := range tests {
+		tampered := test.modify(append([]string{}, lines...))
+		if err := os.WriteFile(path, []byte(strings.Join(tampered, "\n")+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := verify(path); err == nil || err.Error() != test.expected {
+			t.Errorf("%s: expected \"%s\", got %v", test.name, test.expected, err)
+		}
+		// A tampered log isn't appended to.
+		if _, err := audit.OpenFile(path, true); err == nil || err.Error() != "audit: "+path+": "+test.expected {
+			t.Errorf("%s: expected \"audit: %s: %s\", got %v", test.name, path, test.expected, err)
+		}
+	}
+}
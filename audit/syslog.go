@@ -0,0 +1,28 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// Syslog is a Sink sending each Record to syslog as JSON, at the
+// informational level, or the warning level if its Result isn't "ok".
+// The facility is that of Writer, which can be made with
+// syslog.New(syslog.LOG_AUTHPRIV, "myapp"), for example.
+type Syslog struct {
+	Writer *syslog.Writer
+}
+
+// Write implements Sink.
+func (s *Syslog) Write(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if r.Result != "ok" {
+		return s.Writer.Warning(string(data))
+	}
+	return s.Writer.Info(string(data))
+}
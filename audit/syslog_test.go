@@ -0,0 +1,49 @@
+//go:build !windows && !plan9
+
+package audit_test
+
+import (
+	"log/syslog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/audit"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+func TestSyslog(t *testing.T) {
+	// Stand in for syslogd on a socket of our own.
+	path := filepath.Join(t.TempDir(), "log")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	writer, err := syslog.Dial("unixgram", path, syslog.LOG_AUTHPRIV, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	store := &audit.Store{Store: &storetest.MemStore{}, Sink: &audit.Syslog{Writer: writer}}
+	store.GetAllAccountNames("svc")
+	store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct"})
+
+	// The priority is the facility times 8 plus the level.
+	for _, expected := range []struct{ priority, record string }{
+		{"<86>", `"operation":"get_all_account_names","service":"svc",`},
+		{"<84>", `"operation":"find","service":"svc","account":"acct",`},
+	} {
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message := string(buf[:n]); !strings.HasPrefix(message, expected.priority) || !strings.Contains(message, expected.record) {
+			t.Errorf("Expected a message with priority %s holding %s, got %s", expected.priority, expected.record, message)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package osxkeychain
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backend opens the Stores of one URL scheme, such as "file". Like
+// database/sql drivers, backends register themselves, usually in the
+// init function of their package, so a program only has to import
+// the packages of the backends it wants:
+//
+//	import _ "github.com/keybase/go-osxkeychain/kdbx"
+//
+//	store, err := osxkeychain.Open("file:///var/lib/app/secrets.kdbx?kdf=argon2id")
+type Backend struct {
+	// Open opens the Store u names. It should read every option it
+	// takes from options, and then return options.Err() if it isn't
+	// nil, before doing anything else.
+	Open func(u *url.URL, options *Options) (Store, error)
+
+	// Probe, if non-nil, returns the URL of the Store OpenDefault
+	// should open with the backend, or an error if the backend isn't
+	// available on this system. Backends without one are never
+	// chosen by OpenDefault.
+	Probe func() (string, error)
+}
+
+// DefaultPreference is the order in which OpenDefault tries the
+// backends of these schemes: the macOS keychain, the Secret Service
+// of a Linux desktop, the Linux kernel's keyring, and an encrypted
+// file. Schemes no backend is registered for, or whose backend has
+// no Probe, are skipped; a backend for any of them takes part once
+// its package registers it with a Probe.
+var DefaultPreference = []string{"keychain", "secretservice", "keyctl", "file"}
+
+// Registry maps URL schemes to the Backends that open them. The zero
+// Registry is empty and ready to use. The package-level functions use
+// a Registry that backends register themselves with; others are
+// mainly useful for tests.
+type Registry struct {
+	mu       sync.RWMutex
+	backends map[string]*Backend
+}
+
+var defaultRegistry Registry
+
+// Register makes backend open the Stores of the given scheme. It
+// panics if backend or its Open is nil, or if a backend is already
+// registered for the scheme.
+func (r *Registry) Register(scheme string, backend *Backend) {
+	if !validScheme(scheme) {
+		panic("osxkeychain: Register of invalid scheme " + strconv.Quote(scheme))
+	}
+	if backend == nil || backend.Open == nil {
+		panic("osxkeychain: Register backend is nil")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.backends[scheme]; ok {
+		panic("osxkeychain: Register called twice for scheme " + scheme)
+	}
+	if r.backends == nil {
+		r.backends = make(map[string]*Backend)
+	}
+	r.backends[scheme] = backend
+}
+
+// Schemes returns the sorted schemes backends are registered for.
+func (r *Registry) Schemes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	schemes := make([]string, 0, len(r.backends))
+	for scheme := range r.backends {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+func (r *Registry) backend(scheme string) *Backend {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.backends[scheme]
+}
+
+// Open opens the Store the given URL names, with the backend
+// registered for its scheme. The URL's query holds the backend's
+// options; an option the backend doesn't take is an error.
+func (r *Registry) Open(rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("store URL %q has no scheme", rawURL)
+	}
+	backend := r.backend(u.Scheme)
+	if backend == nil {
+		return nil, fmt.Errorf("unknown backend %q (forgotten import?)", u.Scheme)
+	}
+	options, err := ParseOptions(u.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	store, err := backend.Open(u, options)
+	if err != nil {
+		return nil, err
+	}
+	// In case the backend didn't check its options itself.
+	if err := options.Err(); err != nil {
+		if closer, ok := store.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil, err
+	}
+	return store, nil
+}
+
+// OpenDefault opens the Store of the first backend in
+// DefaultPreference that is registered and whose Probe finds it
+// available. If none is, the error says why each wasn't.
+func (r *Registry) OpenDefault() (Store, error) {
+	var reasons []string
+	for _, scheme := range DefaultPreference {
+		backend := r.backend(scheme)
+		if backend == nil || backend.Probe == nil {
+			continue
+		}
+		rawURL, err := backend.Probe()
+		if err != nil {
+			reasons = append(reasons, scheme+": "+err.Error())
+			continue
+		}
+		return r.Open(rawURL)
+	}
+	if len(reasons) == 0 {
+		return nil, errors.New("no backend available: none of " + strings.Join(DefaultPreference, ", ") + " is registered")
+	}
+	return nil, errors.New("no backend available: " + strings.Join(reasons, "; "))
+}
+
+// Register registers backend for the given scheme with the default
+// Registry. See Registry.Register.
+func Register(scheme string, backend *Backend) {
+	defaultRegistry.Register(scheme, backend)
+}
+
+// Schemes returns the sorted schemes registered with the default
+// Registry.
+func Schemes() []string {
+	return defaultRegistry.Schemes()
+}
+
+// Open opens the Store the given URL names, such as
+// "file:///var/lib/app/secrets.kdbx", with the backends registered
+// with the default Registry. See Registry.Open.
+func Open(rawURL string) (Store, error) {
+	return defaultRegistry.Open(rawURL)
+}
+
+// OpenDefault opens the best Store available on this system with the
+// backends registered with the default Registry. See
+// Registry.OpenDefault.
+func OpenDefault() (Store, error) {
+	return defaultRegistry.OpenDefault()
+}
+
+// Options are the options in the query of a store URL, which a
+// Backend reads by type. The first option missing a valid value is
+// remembered, and its error returned by Err, so a backend can read
+// all of them before checking.
+type Options struct {
+	values url.Values
+	read   map[string]bool
+	err    error
+}
+
+// ParseOptions parses the options in a URL query. An option given
+// more than once is an error.
+func ParseOptions(rawQuery string) (*Options, error) {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range values {
+		if len(v) > 1 {
+			return nil, fmt.Errorf("option %s is given more than once", name)
+		}
+	}
+	return &Options{values: values, read: make(map[string]bool)}, nil
+}
+
+// lookup returns the value of the named option, and whether it was
+// given, marking it as read.
+func (o *Options) lookup(name string) (string, bool) {
+	o.read[name] = true
+	v, ok := o.values[name]
+	if !ok {
+		return "", false
+	}
+	return v[0], true
+}
+
+func (o *Options) invalid(name, value, reason string) {
+	if o.err == nil {
+		o.err = fmt.Errorf("option %s=%q is invalid: %s", name, value, reason)
+	}
+}
+
+// String returns the value of the named option, or def if it isn't
+// given.
+func (o *Options) String(name, def string) string {
+	v, ok := o.lookup(name)
+	if !ok {
+		return def
+	}
+	return v
+}
+
+// Bool returns the value of the named option, as strconv.ParseBool
+// parses it, or def if it isn't given.
+func (o *Options) Bool(name string, def bool) bool {
+	v, ok := o.lookup(name)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		o.invalid(name, v, "not a boolean")
+		return def
+	}
+	return b
+}
+
+// Int returns the value of the named option, a decimal integer, or
+// def if it isn't given.
+func (o *Options) Int(name string, def int) int {
+	v, ok := o.lookup(name)
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		o.invalid(name, v, "not an integer")
+		return def
+	}
+	return i
+}
+
+// Duration returns the value of the named option, as
+// time.ParseDuration parses it, or def if it isn't given.
+func (o *Options) Duration(name string, def time.Duration) time.Duration {
+	v, ok := o.lookup(name)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		o.invalid(name, v, "not a duration")
+		return def
+	}
+	return d
+}
+
+// Choice returns the value of the named option, which must be one of
+// choices, or def if it isn't given.
+func (o *Options) Choice(name, def string, choices ...string) string {
+	v, ok := o.lookup(name)
+	if !ok {
+		return def
+	}
+	for _, choice := range choices {
+		if v == choice {
+			return v
+		}
+	}
+	o.invalid(name, v, "not one of "+strings.Join(choices, ", "))
+	return def
+}
+
+// Err returns the error of the first option read without a valid
+// value or, failing that, an error naming the options that weren't
+// read, which the backend doesn't take. Otherwise, it returns nil.
+func (o *Options) Err() error {
+	if o.err != nil {
+		return o.err
+	}
+	var unknown []string
+	for name := range o.values {
+		if !o.read[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	switch len(unknown) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("unknown option " + unknown[0])
+	}
+	sort.Strings(unknown)
+	return errors.New("unknown options " + strings.Join(unknown, ", "))
+}
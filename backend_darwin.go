@@ -0,0 +1,39 @@
+//go:build darwin && !ios && cgo
+// +build darwin,!ios,cgo
+
+package osxkeychain
+
+import (
+	"errors"
+	"net/url"
+)
+
+// The keychain backend opens DefaultKeychain with the URL "keychain:",
+// or a DataProtectionKeychain with the options data_protection=true
+// and, optionally, access_group=<group>.
+func init() {
+	Register("keychain", &Backend{
+		Open: openKeychain,
+		Probe: func() (string, error) {
+			return "keychain:", nil
+		},
+	})
+}
+
+func openKeychain(u *url.URL, options *Options) (Store, error) {
+	dataProtection := options.Bool("data_protection", false)
+	accessGroup := options.String("access_group", "")
+	if err := options.Err(); err != nil {
+		return nil, err
+	}
+	if u.Opaque != "" || u.Host != "" || u.Path != "" {
+		return nil, errors.New("keychain URL " + u.Redacted() + " names a keychain; only the default one can be opened")
+	}
+	if accessGroup != "" && !dataProtection {
+		return nil, errors.New("keychain option access_group requires data_protection=true")
+	}
+	if dataProtection {
+		return DataProtectionKeychain{AccessGroup: accessGroup}, nil
+	}
+	return DefaultKeychain, nil
+}
@@ -0,0 +1,200 @@
+package osxkeychain_test
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+// fakeStore is a MemStore recording the URL and options it was opened
+// with, and whether it was closed.
+type fakeStore struct {
+	storetest.MemStore
+	url     string
+	name    string
+	count   int
+	verbose bool
+	timeout time.Duration
+	mode    string
+	closed  bool
+}
+
+func (s *fakeStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+// fakeBackend returns a Backend opening fakeStores, whose Probe, if
+// probeErr isn't nil, fails with it.
+func fakeBackend(scheme string, probeErr error) *osxkeychain.Backend {
+	return &osxkeychain.Backend{
+		Open: func(u *url.URL, options *osxkeychain.Options) (osxkeychain.Store, error) {
+			s := &fakeStore{url: u.String()}
+			s.name = options.String("name", "")
+			s.count = options.Int("count", 1)
+			s.verbose = options.Bool("verbose", false)
+			s.timeout = options.Duration("timeout", time.Second)
+			s.mode = options.Choice("mode", "fast", "fast", "safe")
+			if err := options.Err(); err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+		Probe: func() (string, error) {
+			if probeErr != nil {
+				return "", probeErr
+			}
+			return scheme + ":default", nil
+		},
+	}
+}
+
+func TestOpen(t *testing.T) {
+	var r osxkeychain.Registry
+	r.Register("fake", fakeBackend("fake", nil))
+	if schemes := r.Schemes(); !reflect.DeepEqual(schemes, []string{"fake"}) {
+		t.Errorf("Expected [fake], got %v", schemes)
+	}
+
+	store, err := r.Open("fake:///path?name=a%20b&count=3&verbose=true&timeout=1m&mode=safe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &fakeStore{url: "fake:///path?name=a%20b&count=3&verbose=true&timeout=1m&mode=safe", name: "a b", count: 3, verbose: true, timeout: time.Minute, mode: "safe"}
+	if !reflect.DeepEqual(store, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, store)
+	}
+	store, err = r.Open("fake:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = &fakeStore{url: "fake:", count: 1, timeout: time.Second, mode: "fast"}
+	if !reflect.DeepEqual(store, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, store)
+	}
+	storetest.TestStore(t, store)
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"/path", `store URL "/path" has no scheme`},
+		{"other:///path", `unknown backend "other" (forgotten import?)`},
+		{"fake:?count=1&count=2", "option count is given more than once"},
+		{"fake:?count=many", `option count="many" is invalid: not an integer`},
+		{"fake:?verbose=yes", `option verbose="yes" is invalid: not a boolean`},
+		{"fake:?timeout=5", `option timeout="5" is invalid: not a duration`},
+		{"fake:?mode=slow", `option mode="slow" is invalid: not one of fast, safe`},
+		{"fake:?count=many&mode=slow", `option count="many" is invalid: not an integer`},
+		{"fake:?color=red", "unknown option color"},
+		{"fake:?size=1&color=red", "unknown options color, size"},
+	}
+	for _, test := range tests {
+		_, err := r.Open(test.url)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%s: expected \"%s\", got %v", test.url, test.expected, err)
+		}
+	}
+}
+
+func TestOpenUncheckedOptions(t *testing.T) {
+	var r osxkeychain.Registry
+	var opened *fakeStore
+	r.Register("careless", &osxkeychain.Backend{
+		Open: func(u *url.URL, options *osxkeychain.Options) (osxkeychain.Store, error) {
+			opened = &fakeStore{name: options.String("name", "")}
+			return opened, nil
+		},
+	})
+	expected := "unknown option color"
+	if _, err := r.Open("careless:?name=a&color=red"); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+	if !opened.closed {
+		t.Error("Expected the store to be closed")
+	}
+}
+
+func TestOpenDefault(t *testing.T) {
+	unavailable := errors.New("not running")
+	tests := []struct {
+		backends map[string]error
+		expected string
+		err      string
+	}{
+		{
+			backends: map[string]error{"keychain": nil, "secretservice": nil, "keyctl": nil, "file": nil},
+			expected: "keychain:default",
+		},
+		{
+			backends: map[string]error{"secretservice": nil, "keyctl": nil, "file": nil},
+			expected: "secretservice:default",
+		},
+		{
+			backends: map[string]error{"secretservice": unavailable, "keyctl": nil, "file": nil},
+			expected: "keyctl:default",
+		},
+		{
+			backends: map[string]error{"secretservice": unavailable, "file": nil, "other": nil},
+			expected: "file:default",
+		},
+		{
+			backends: map[string]error{"secretservice": unavailable, "keyctl": errors.New("no keyring"), "other": nil},
+			err:      "no backend available: secretservice: not running; keyctl: no keyring",
+		},
+		{
+			backends: map[string]error{"other": nil},
+			err:      "no backend available: none of keychain, secretservice, keyctl, file is registered",
+		},
+	}
+	for _, test := range tests {
+		var r osxkeychain.Registry
+		for scheme, probeErr := range test.backends {
+			r.Register(scheme, fakeBackend(scheme, probeErr))
+		}
+		store, err := r.OpenDefault()
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%v: expected \"%s\", got %v", test.backends, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.backends, err)
+			continue
+		}
+		if url := store.(*fakeStore).url; url != test.expected {
+			t.Errorf("%v: expected %s, got %s", test.backends, test.expected, url)
+		}
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	tests := []struct {
+		scheme  string
+		backend *osxkeychain.Backend
+	}{
+		{"fake", fakeBackend("fake", nil)},
+		{"Fake", fakeBackend("Fake", nil)},
+		{"", fakeBackend("", nil)},
+		{"other", nil},
+		{"other", &osxkeychain.Backend{}},
+	}
+	var r osxkeychain.Registry
+	r.Register("fake", fakeBackend("fake", nil))
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q: expected Register to panic", test.scheme)
+				}
+			}()
+			r.Register(test.scheme, test.backend)
+		}()
+	}
+}
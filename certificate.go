@@ -0,0 +1,125 @@
+package osxkeychain
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+)
+
+// CertificateItem is a certificate in a keychain, along with the
+// label it is shown under.
+type CertificateItem struct {
+	Label       string
+	Certificate *x509.Certificate
+}
+
+// CertificateQuery selects certificates. Each non-zero field must
+// match; the zero value matches every certificate.
+type CertificateQuery struct {
+	// Subject and Issuer match either the whole distinguished name, in
+	// the form returned by pkix.Name.String, or its common name.
+	Subject string
+	Issuer  string
+
+	SerialNumber *big.Int
+
+	// SHA1Fingerprint and SHA256Fingerprint match the hash of the
+	// certificate's DER encoding.
+	SHA1Fingerprint   []byte
+	SHA256Fingerprint []byte
+
+	Label string
+}
+
+// Matches returns true if the given certificate item matches every
+// field set in query.
+func (query *CertificateQuery) Matches(item *CertificateItem) bool {
+	cert := item.Certificate
+	if query.Subject != "" && query.Subject != cert.Subject.String() && query.Subject != cert.Subject.CommonName {
+		return false
+	}
+	if query.Issuer != "" && query.Issuer != cert.Issuer.String() && query.Issuer != cert.Issuer.CommonName {
+		return false
+	}
+	if query.SerialNumber != nil && (cert.SerialNumber == nil || query.SerialNumber.Cmp(cert.SerialNumber) != 0) {
+		return false
+	}
+	if query.SHA1Fingerprint != nil {
+		fingerprint := sha1.Sum(cert.Raw)
+		if !bytes.Equal(query.SHA1Fingerprint, fingerprint[:]) {
+			return false
+		}
+	}
+	if query.SHA256Fingerprint != nil {
+		fingerprint := sha256.Sum256(cert.Raw)
+		if !bytes.Equal(query.SHA256Fingerprint, fingerprint[:]) {
+			return false
+		}
+	}
+	if query.Label != "" && query.Label != item.Label {
+		return false
+	}
+	return true
+}
+
+// empty returns true if query has no criteria, and so matches every
+// certificate.
+func (query *CertificateQuery) empty() bool {
+	return query.Subject == "" && query.Issuer == "" && query.SerialNumber == nil &&
+		query.SHA1Fingerprint == nil && query.SHA256Fingerprint == nil && query.Label == ""
+}
+
+// FilterCertificates returns the items that match query, in order.
+func FilterCertificates(items []CertificateItem, query *CertificateQuery) []CertificateItem {
+	var matches []CertificateItem
+	for i := range items {
+		if query.Matches(&items[i]) {
+			matches = append(matches, items[i])
+		}
+	}
+	return matches
+}
+
+// ParseCertificates parses either a single DER-encoded certificate or
+// one or more PEM "CERTIFICATE" blocks. Other PEM blocks, such as
+// private keys, are skipped.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.Certificate{cert}, nil
+	}
+
+	var certs []*x509.Certificate
+	for ; block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no CERTIFICATE PEM block found")
+	}
+	return certs, nil
+}
+
+// EncodeCertificatesPEM returns the given certificates as
+// concatenated PEM "CERTIFICATE" blocks.
+func EncodeCertificatesPEM(certs []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,207 @@
+//go:build darwin && !ios && cgo
+// +build darwin,!ios,cgo
+
+package osxkeychain
+
+/*
+#include <stdlib.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+*/
+import "C"
+
+import (
+	"crypto/x509"
+	"unsafe"
+)
+
+var secClassCertificate = C.CFTypeRef(C.kSecClassCertificate)
+var secAttrLabel = C.CFTypeRef(C.kSecAttrLabel)
+var secValueRef = C.CFTypeRef(C.kSecValueRef)
+var secReturnRef = C.CFTypeRef(C.kSecReturnRef)
+
+// ImportCertificate adds the given certificate to the default
+// keychain under the given label, or under its subject's common name
+// if label is empty.
+func ImportCertificate(cert *x509.Certificate, label string) (err error) {
+	keychainExecutor.do(func() {
+		err = importCertificate(cert, label, nil)
+	})
+	return
+}
+
+// ImportCertificates parses data as with ParseCertificates and adds
+// every certificate found to the default keychain under the given
+// label. It stops at the first certificate that can't be added.
+func ImportCertificates(data []byte, label string) error {
+	certs, err := ParseCertificates(data)
+	if err != nil {
+		return err
+	}
+	for _, cert := range certs {
+		if err := ImportCertificate(cert, label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importCertificate adds cert to the default keychain. If
+// persistentRef is non-nil, it's set to a persistent reference to the
+// added item, which the caller must release.
+func importCertificate(cert *x509.Certificate, label string, persistentRef *C.CFDataRef) error {
+	if err := check32BitUTF8("label", label); err != nil {
+		return err
+	}
+
+	certData := bytesToCFData(cert.Raw)
+	defer C.CFRelease(C.CFTypeRef(certData))
+
+	certRef := C.SecCertificateCreateWithData(nil, certData)
+	if certRef == nil {
+		return ErrParam
+	}
+	defer C.CFRelease(C.CFTypeRef(certRef))
+
+	query := map[C.CFTypeRef]C.CFTypeRef{
+		secClass:    secClassCertificate,
+		secValueRef: C.CFTypeRef(certRef),
+	}
+
+	if label != "" {
+		labelString, err := _UTF8StringToCFString(label)
+		if err != nil {
+			return err
+		}
+		defer C.CFRelease(C.CFTypeRef(labelString))
+		query[secAttrLabel] = C.CFTypeRef(labelString)
+	}
+
+	var result *C.CFTypeRef
+	if persistentRef != nil {
+		query[secReturnPersistentRef] = C.CFTypeRef(C.kCFBooleanTrue)
+		result = (*C.CFTypeRef)(unsafe.Pointer(persistentRef))
+	}
+	queryDict := mapToCFDictionary(query)
+	defer C.CFRelease(C.CFTypeRef(queryDict))
+
+	errCode := C.SecItemAdd(queryDict, result)
+	return newKeychainError(errCode)
+}
+
+// FindCertificates returns the certificates in the keychain search
+// list that match query. Certificates that can't be parsed are
+// skipped.
+func FindCertificates(query *CertificateQuery) (items []CertificateItem, err error) {
+	keychainExecutor.do(func() {
+		err = findCertificates(query, func(item *CertificateItem, ref C.CFTypeRef) error {
+			items = append(items, *item)
+			return nil
+		})
+	})
+	return
+}
+
+// ExportCertificates returns the certificates that match query as
+// concatenated PEM blocks.
+func ExportCertificates(query *CertificateQuery) ([]byte, error) {
+	items, err := FindCertificates(query)
+	if err != nil {
+		return nil, err
+	}
+	certs := make([]*x509.Certificate, len(items))
+	for i := range items {
+		certs[i] = items[i].Certificate
+	}
+	return EncodeCertificatesPEM(certs), nil
+}
+
+// DeleteCertificates removes the certificates that match query from
+// their keychains, and returns how many were removed. It stops at the
+// first certificate that can't be removed. Since a query without
+// criteria would remove every certificate, a nil or zero query
+// returns ErrParam.
+func DeleteCertificates(query *CertificateQuery) (deleted int, err error) {
+	if query == nil || query.empty() {
+		return 0, ErrParam
+	}
+	keychainExecutor.do(func() {
+		err = findCertificates(query, func(item *CertificateItem, ref C.CFTypeRef) error {
+			deleteQuery := mapToCFDictionary(map[C.CFTypeRef]C.CFTypeRef{
+				secClass:    secClassCertificate,
+				secValueRef: ref,
+			})
+			defer C.CFRelease(C.CFTypeRef(deleteQuery))
+
+			if err := newKeychainError(C.SecItemDelete(deleteQuery)); err != nil {
+				return err
+			}
+			deleted++
+			return nil
+		})
+	})
+	return
+}
+
+// findCertificates calls fn with each certificate that matches query
+// and its item reference, which is only valid during the call.
+func findCertificates(query *CertificateQuery, fn func(item *CertificateItem, ref C.CFTypeRef) error) error {
+	search := map[C.CFTypeRef]C.CFTypeRef{
+		secClass:            secClassCertificate,
+		secMatchLimit:       secMatchLimitAll,
+		secReturnAttributes: C.CFTypeRef(C.kCFBooleanTrue),
+		secReturnData:       C.CFTypeRef(C.kCFBooleanTrue),
+		secReturnRef:        C.CFTypeRef(C.kCFBooleanTrue),
+	}
+
+	// Narrow the search natively where possible; everything is
+	// matched again in Go below.
+	if query.Label != "" {
+		labelString, err := _UTF8StringToCFString(query.Label)
+		if err != nil {
+			return err
+		}
+		defer C.CFRelease(C.CFTypeRef(labelString))
+		search[secAttrLabel] = C.CFTypeRef(labelString)
+	}
+
+	searchDict := mapToCFDictionary(search)
+	defer C.CFRelease(C.CFTypeRef(searchDict))
+
+	var resultsRef C.CFTypeRef
+	errCode := C.SecItemCopyMatching(searchDict, &resultsRef)
+	err := newKeychainError(errCode)
+	if err == ErrItemNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer C.CFRelease(resultsRef)
+
+	if C.CFGetTypeID(resultsRef) != C.CFArrayGetTypeID() {
+		return ErrInternalComponent
+	}
+
+	for _, result := range _CFArrayToArray(C.CFArrayRef(resultsRef)) {
+		m := _CFDictionaryToMap(C.CFDictionaryRef(result))
+		data, ok := m[secValueData]
+		if !ok {
+			continue
+		}
+		cert, err := x509.ParseCertificate(_CFDataToBytes(C.CFDataRef(data)))
+		if err != nil {
+			continue
+		}
+		item := CertificateItem{Certificate: cert}
+		if label, ok := m[secAttrLabel]; ok {
+			item.Label = _CFStringToUTF8String(C.CFStringRef(label))
+		}
+		if !query.Matches(&item) {
+			continue
+		}
+		if err := fn(&item, m[secValueRef]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+//go:build darwin && !ios && cgo
+// +build darwin,!ios,cgo
+
+package osxkeychain_test
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+func TestCertificateWithDefaultKeychain(t *testing.T) {
+	cert, _ := newTestCertificate(t, "osxkeychain_test certificate", 1, nil, nil)
+	fingerprint := sha256.Sum256(cert.Raw)
+	query := &osxkeychain.CertificateQuery{SHA256Fingerprint: fingerprint[:]}
+
+	if err := osxkeychain.ImportCertificate(cert, "osxkeychain_test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := osxkeychain.ImportCertificate(cert, "osxkeychain_test"); err != osxkeychain.ErrDuplicateItem {
+		t.Errorf("Expected ErrDuplicateItem, got %v", err)
+	}
+
+	items, err := osxkeychain.FindCertificates(query)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(items) != 1 || items[0].Label != "osxkeychain_test" || !items[0].Certificate.Equal(cert) {
+		t.Errorf("Expected the imported certificate, got %v", items)
+	}
+
+	exported, err := osxkeychain.ExportCertificates(query)
+	if err != nil {
+		t.Error(err)
+	}
+	certs, err := osxkeychain.ParseCertificates(exported)
+	if err != nil || len(certs) != 1 || !certs[0].Equal(cert) {
+		t.Errorf("Expected the imported certificate exported, got %v, %v", certs, err)
+	}
+
+	deleted, err := osxkeychain.DeleteCertificates(query)
+	if err != nil {
+		t.Error(err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 certificate deleted, got %d", deleted)
+	}
+	items, err = osxkeychain.FindCertificates(query)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(items) != 0 {
+		t.Errorf("Expected no certificates, got %v", items)
+	}
+}
+
+func TestDeleteCertificatesWithoutCriteria(t *testing.T) {
+	for _, query := range []*osxkeychain.CertificateQuery{nil, {}} {
+		if deleted, err := osxkeychain.DeleteCertificates(query); err != osxkeychain.ErrParam || deleted != 0 {
+			t.Errorf("%v: expected ErrParam, got %d, %v", query, deleted, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package osxkeychain_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// newTestCertificate returns a certificate for commonName signed by
+// parent, or self-signed if parent is nil, and its private key.
+func newTestCertificate(t *testing.T, commonName string, serial int64, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"osxkeychain test"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestCertificateQuery(t *testing.T) {
+	ca, caKey := newTestCertificate(t, "Test CA", 1, nil, nil)
+	leaf, _ := newTestCertificate(t, "client.example.com", 42, ca, caKey)
+	other, _ := newTestCertificate(t, "other.example.com", 43, ca, caKey)
+	items := []osxkeychain.CertificateItem{
+		{Label: "ca", Certificate: ca},
+		{Label: "client", Certificate: leaf},
+		{Label: "other", Certificate: other},
+	}
+
+	leafSHA1 := sha1.Sum(leaf.Raw)
+	leafSHA256 := sha256.Sum256(leaf.Raw)
+	tests := []struct {
+		name     string
+		query    osxkeychain.CertificateQuery
+		expected []string
+	}{
+		{"all", osxkeychain.CertificateQuery{}, []string{"ca", "client", "other"}},
+		{"subject common name", osxkeychain.CertificateQuery{Subject: "client.example.com"}, []string{"client"}},
+		{"subject DN", osxkeychain.CertificateQuery{Subject: "CN=client.example.com,O=osxkeychain test"}, []string{"client"}},
+		{"issuer", osxkeychain.CertificateQuery{Issuer: "Test CA"}, []string{"ca", "client", "other"}},
+		{"issuer and subject", osxkeychain.CertificateQuery{Issuer: "Test CA", Subject: "Test CA"}, []string{"ca"}},
+		{"serial", osxkeychain.CertificateQuery{SerialNumber: big.NewInt(43)}, []string{"other"}},
+		{"SHA-1", osxkeychain.CertificateQuery{SHA1Fingerprint: leafSHA1[:]}, []string{"client"}},
+		{"SHA-256", osxkeychain.CertificateQuery{SHA256Fingerprint: leafSHA256[:]}, []string{"client"}},
+		{"label", osxkeychain.CertificateQuery{Label: "other"}, []string{"other"}},
+		{"no match", osxkeychain.CertificateQuery{Subject: "client.example.com", SerialNumber: big.NewInt(43)}, nil},
+		{"wrong fingerprint", osxkeychain.CertificateQuery{SHA256Fingerprint: leafSHA1[:]}, nil},
+	}
+	for _, test := range tests {
+		matches := osxkeychain.FilterCertificates(items, &test.query)
+		var labels []string
+		for _, match := range matches {
+			labels = append(labels, match.Label)
+		}
+		if len(labels) != len(test.expected) {
+			t.Errorf("%s: Expected %v, got %v", test.name, test.expected, labels)
+			continue
+		}
+		for i := range labels {
+			if labels[i] != test.expected[i] {
+				t.Errorf("%s: Expected %v, got %v", test.name, test.expected, labels)
+				break
+			}
+		}
+	}
+}
+
+func TestParseCertificates(t *testing.T) {
+	ca, caKey := newTestCertificate(t, "Test CA", 1, nil, nil)
+	leaf, _ := newTestCertificate(t, "client.example.com", 42, ca, caKey)
+
+	certs, err := osxkeychain.ParseCertificates(leaf.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || !certs[0].Equal(leaf) {
+		t.Errorf("Expected the DER certificate back, got %v", certs)
+	}
+
+	// PEM, with a private key block in between to skip.
+	data := osxkeychain.EncodeCertificatesPEM([]*x509.Certificate{leaf})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("not a key")})...)
+	data = append(data, osxkeychain.EncodeCertificatesPEM([]*x509.Certificate{ca})...)
+	certs, err = osxkeychain.ParseCertificates(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || !certs[0].Equal(leaf) || !certs[1].Equal(ca) {
+		t.Errorf("Expected the PEM certificates back, got %v", certs)
+	}
+
+	keyOnly := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("not a key")})
+	expected := "no CERTIFICATE PEM block found"
+	if _, err := osxkeychain.ParseCertificates(keyOnly); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+
+	if _, err := osxkeychain.ParseCertificates([]byte("garbage")); err == nil {
+		t.Error("Expected an error parsing garbage")
+	}
+}
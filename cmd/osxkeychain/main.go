@@ -0,0 +1,152 @@
+//go:build unix
+
+// Command osxkeychain runs commands with secrets from the keychain.
+//
+// Usage:
+//
+//	osxkeychain exec [--env NAME=URI]... [--file NAME=URI]... -- command [args...]
+//	osxkeychain verify-audit-log [--prev HASH] file
+//
+// exec resolves each reference URI, such as keychain://db/prod, and
+// runs the command with the secret in the environment variable NAME
+// (--env), or on an inherited pipe whose /dev/fd path is in NAME
+// (--file). Signals are forwarded to the command, and osxkeychain
+// exits with the command's exit code. A reference's keychain parameter
+// names another store by its URL, such as file:///path/secrets.kdbx
+// with the password in OSXKEYCHAIN_FILE_PASSWORD; keychain files other
+// than the default one can't be opened.
+//
+// verify-audit-log checks that no record of a chained audit log
+// written by an audit.File was modified, removed or inserted, and
+// prints the number of records and the hash of the last one. --prev is
+// the hash of the last record of the log this one continues, if any.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/audit"
+	_ "github.com/keybase/go-osxkeychain/kdbx"
+)
+
+// stores holds the Stores references can be resolved from, by scheme.
+var stores = map[string]osxkeychain.Store{}
+
+// secretFlag collects repeated NAME=URI flags.
+type secretFlag struct {
+	secrets *[]osxkeychain.SecretEnv
+	asFile  bool
+}
+
+func (f secretFlag) String() string {
+	return ""
+}
+
+func (f secretFlag) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i <= 0 {
+		return errors.New("expected NAME=URI, got " + value)
+	}
+	*f.secrets = append(*f.secrets, osxkeychain.SecretEnv{
+		Name:   value[:i],
+		URI:    value[i+1:],
+		AsFile: f.asFile,
+	})
+	return nil
+}
+
+// openStores returns a function opening the stores that references'
+// keychain parameters name by URL, each once.
+func openStores() func(name string) (osxkeychain.Store, error) {
+	opened := map[string]osxkeychain.Store{}
+	return func(name string) (osxkeychain.Store, error) {
+		if store, ok := opened[name]; ok {
+			return store, nil
+		}
+		if u, err := url.Parse(name); err != nil || u.Scheme == "" {
+			return nil, errors.New("keychain " + name + " isn't a store URL, such as file:///path/secrets.kdbx; only the default keychain can be opened")
+		}
+		store, err := osxkeychain.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		opened[name] = store
+		return store, nil
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: osxkeychain exec [--env NAME=URI]... [--file NAME=URI]... -- command [args...]")
+	fmt.Fprintln(os.Stderr, "       osxkeychain verify-audit-log [--prev HASH] file")
+	os.Exit(2)
+}
+
+func runExec(args []string) int {
+	var secrets []osxkeychain.SecretEnv
+	flags := flag.NewFlagSet("exec", flag.ExitOnError)
+	flags.Usage = usage
+	flags.Var(secretFlag{secrets: &secrets}, "env", "set environment variable `NAME=URI` to the secret")
+	flags.Var(secretFlag{secrets: &secrets, asFile: true}, "file", "pass the secret `NAME=URI` on a pipe named by NAME")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		usage()
+	}
+
+	cmd := exec.Command(flags.Arg(0), flags.Args()[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	resolver := &osxkeychain.Resolver{Stores: stores, OpenKeychain: openStores()}
+	exitCode, err := osxkeychain.ExecWithSecrets(resolver, secrets, cmd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "osxkeychain:", err)
+		return 1
+	}
+	return exitCode
+}
+
+func runVerifyAuditLog(args []string) int {
+	flags := flag.NewFlagSet("verify-audit-log", flag.ExitOnError)
+	flags.Usage = usage
+	prevHash := flags.String("prev", "", "the `HASH` of the last record of the log this one continues")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "osxkeychain:", err)
+		return 1
+	}
+	defer file.Close()
+	records, lastHash, err := audit.Verify(file, *prevHash)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "osxkeychain:", err)
+		return 1
+	}
+	fmt.Printf("%d records, last hash %s\n", records, lastHash)
+	return 0
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "exec":
+		os.Exit(runExec(os.Args[2:]))
+	case "verify-audit-log":
+		os.Exit(runVerifyAuditLog(os.Args[2:]))
+	default:
+		usage()
+	}
+}
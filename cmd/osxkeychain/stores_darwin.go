@@ -0,0 +1,10 @@
+//go:build darwin && !ios && cgo
+// +build darwin,!ios,cgo
+
+package main
+
+import "github.com/keybase/go-osxkeychain"
+
+func init() {
+	stores[osxkeychain.ReferenceScheme] = osxkeychain.DefaultKeychain
+}
@@ -0,0 +1,237 @@
+//go:build darwin && !ios && cgo
+// +build darwin,!ios,cgo
+
+package osxkeychain
+
+/*
+#include <stdlib.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+*/
+import "C"
+
+var secUseDataProtectionKeychain = C.CFTypeRef(C.kSecUseDataProtectionKeychain)
+var secAttrAccessGroup = C.CFTypeRef(C.kSecAttrAccessGroup)
+var secReturnData = C.CFTypeRef(C.kSecReturnData)
+var secReturnPersistentRef = C.CFTypeRef(C.kSecReturnPersistentRef)
+var secValuePersistentRef = C.CFTypeRef(C.kSecValuePersistentRef)
+var secMatchLimitOne = C.CFTypeRef(C.kSecMatchLimitOne)
+var secAttrSynchronizable = C.CFTypeRef(C.kSecAttrSynchronizable)
+var secAttrSynchronizableAny = C.CFTypeRef(C.kSecAttrSynchronizableAny)
+
+var synchronizableValues = map[Synchronizable]C.CFTypeRef{
+	SynchronizableYes: C.CFTypeRef(C.kCFBooleanTrue),
+	SynchronizableNo:  C.CFTypeRef(C.kCFBooleanFalse),
+	SynchronizableAny: secAttrSynchronizableAny,
+}
+
+// addDataProtectionAttributes adds what selects the data-protection
+// keychain, the item's access group and whether it is synchronizable
+// to query, if the item with the given attributes is kept there, and
+// the item's access controls if forAdd is true. The returned function
+// releases what was added.
+func addDataProtectionAttributes(query map[C.CFTypeRef]C.CFTypeRef, attributes *GenericPasswordAttributes, forAdd bool) (release func(), err error) {
+	release = func() {}
+	if !attributes.usesDataProtectionKeychain() {
+		return
+	}
+	query[secUseDataProtectionKeychain] = C.CFTypeRef(C.kCFBooleanTrue)
+	if synchronizable, ok := synchronizableValues[attributes.Synchronizable]; ok {
+		query[secAttrSynchronizable] = synchronizable
+	}
+
+	var releases []func()
+	release = func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+
+	if attributes.AccessGroup != "" {
+		var accessGroupString C.CFStringRef
+		if accessGroupString, err = _UTF8StringToCFString(attributes.AccessGroup); err != nil {
+			return nil, err
+		}
+		releases = append(releases, func() { C.CFRelease(C.CFTypeRef(accessGroupString)) })
+		query[secAttrAccessGroup] = C.CFTypeRef(accessGroupString)
+	}
+
+	if forAdd {
+		var releaseAccessControl func()
+		if releaseAccessControl, err = addAccessControl(query, attributes); err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, releaseAccessControl)
+	}
+	return
+}
+
+// dataProtectionQuery returns a query matching the generic password
+// with the given attributes in the data-protection keychain. As with
+// SecKeychainFindGenericPassword, an empty name matches any. The
+// returned function releases the query's values.
+func dataProtectionQuery(attributes *GenericPasswordAttributes) (query map[C.CFTypeRef]C.CFTypeRef, release func(), err error) {
+	query = map[C.CFTypeRef]C.CFTypeRef{
+		secClass: secClassGenericPassword,
+	}
+	var releases []func()
+	release = func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+
+	if attributes.ServiceName != "" {
+		var serviceNameString C.CFStringRef
+		if serviceNameString, err = _UTF8StringToCFString(attributes.ServiceName); err != nil {
+			release()
+			return nil, nil, err
+		}
+		releases = append(releases, func() { C.CFRelease(C.CFTypeRef(serviceNameString)) })
+		query[secAttrService] = C.CFTypeRef(serviceNameString)
+	}
+
+	if attributes.AccountName != "" {
+		var accountNameString C.CFStringRef
+		if accountNameString, err = _UTF8StringToCFString(attributes.AccountName); err != nil {
+			release()
+			return nil, nil, err
+		}
+		releases = append(releases, func() { C.CFRelease(C.CFTypeRef(accountNameString)) })
+		query[secAttrAccount] = C.CFTypeRef(accountNameString)
+	}
+
+	var releaseDataProtection func()
+	if releaseDataProtection, err = addDataProtectionAttributes(query, attributes, false); err != nil {
+		release()
+		return nil, nil, err
+	}
+	releases = append(releases, releaseDataProtection)
+	return
+}
+
+// findProtectedGenericPassword is like findGenericPassword, but
+// searches the data-protection keychain, asking the user to
+// authenticate with attributes.OperationPrompt if the item requires
+// it.
+func findProtectedGenericPassword(attributes *GenericPasswordAttributes) ([]byte, error) {
+	query, release, err := dataProtectionQuery(attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	query[secMatchLimit] = secMatchLimitOne
+	query[secReturnData] = C.CFTypeRef(C.kCFBooleanTrue)
+
+	if attributes.OperationPrompt != "" {
+		promptString, err := _UTF8StringToCFString(attributes.OperationPrompt)
+		if err != nil {
+			return nil, err
+		}
+		defer C.CFRelease(C.CFTypeRef(promptString))
+		query[secUseOperationPrompt] = C.CFTypeRef(promptString)
+	}
+
+	queryDict := mapToCFDictionary(query)
+	defer C.CFRelease(C.CFTypeRef(queryDict))
+
+	var resultRef C.CFTypeRef
+	errCode := C.SecItemCopyMatching(queryDict, &resultRef)
+	if err := newKeychainError(errCode); err != nil {
+		return nil, err
+	}
+	defer C.CFRelease(resultRef)
+
+	if C.CFGetTypeID(resultRef) != C.CFDataGetTypeID() {
+		return nil, ErrInternalComponent
+	}
+	return _CFDataToBytes(C.CFDataRef(resultRef)), nil
+}
+
+// findProtectedItem returns a persistent reference to the first
+// generic password in the data-protection keychain with the given
+// attributes, as SecKeychainFindGenericPassword finds the first one
+// in the default keychain. SecItemDelete and SecItemUpdate act on
+// every item their query matches, so given a query with an empty
+// name they would remove or change all of the items it matches, not
+// just one. The returned CFDataRef must be released via CFRelease.
+func findProtectedItem(attributes *GenericPasswordAttributes) (C.CFDataRef, error) {
+	query, release, err := dataProtectionQuery(attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	query[secMatchLimit] = secMatchLimitOne
+	query[secReturnPersistentRef] = C.CFTypeRef(C.kCFBooleanTrue)
+
+	queryDict := mapToCFDictionary(query)
+	defer C.CFRelease(C.CFTypeRef(queryDict))
+
+	var resultRef C.CFTypeRef
+	errCode := C.SecItemCopyMatching(queryDict, &resultRef)
+	if err := newKeychainError(errCode); err != nil {
+		return nil, err
+	}
+	if C.CFGetTypeID(resultRef) != C.CFDataGetTypeID() {
+		C.CFRelease(resultRef)
+		return nil, ErrInternalComponent
+	}
+	return C.CFDataRef(resultRef), nil
+}
+
+// protectedItemQuery returns a query matching only the item ref
+// refers to. The returned CFDictionaryRef must be released via
+// CFRelease.
+func protectedItemQuery(ref C.CFDataRef) C.CFDictionaryRef {
+	return mapToCFDictionary(map[C.CFTypeRef]C.CFTypeRef{
+		secClass:                     secClassGenericPassword,
+		secValuePersistentRef:        C.CFTypeRef(ref),
+		secUseDataProtectionKeychain: C.CFTypeRef(C.kCFBooleanTrue),
+		secAttrSynchronizable:        secAttrSynchronizableAny,
+	})
+}
+
+// removeProtectedGenericPassword is like findAndRemoveGenericPassword
+// for the data-protection keychain: it removes only the first item
+// with the given attributes.
+func removeProtectedGenericPassword(attributes *GenericPasswordAttributes) error {
+	ref, err := findProtectedItem(attributes)
+	if err != nil {
+		return err
+	}
+	defer C.CFRelease(C.CFTypeRef(ref))
+
+	queryDict := protectedItemQuery(ref)
+	defer C.CFRelease(C.CFTypeRef(queryDict))
+
+	errCode := C.SecItemDelete(queryDict)
+	return newKeychainError(errCode)
+}
+
+// updateProtectedGenericPassword is like updateGenericPassword for
+// the data-protection keychain: it changes only the first item with
+// the given attributes.
+func updateProtectedGenericPassword(attributes *GenericPasswordAttributes) error {
+	ref, err := findProtectedItem(attributes)
+	if err != nil {
+		return err
+	}
+	defer C.CFRelease(C.CFTypeRef(ref))
+
+	queryDict := protectedItemQuery(ref)
+	defer C.CFRelease(C.CFTypeRef(queryDict))
+
+	dataBytes := bytesToCFData(attributes.Password)
+	defer C.CFRelease(C.CFTypeRef(dataBytes))
+
+	update := mapToCFDictionary(map[C.CFTypeRef]C.CFTypeRef{
+		secValueData: C.CFTypeRef(dataBytes),
+	})
+	defer C.CFRelease(C.CFTypeRef(update))
+
+	errCode := C.SecItemUpdate(queryDict, update)
+	return newKeychainError(errCode)
+}
@@ -0,0 +1,16 @@
+// Package osxkeychain provides access to the Mac OS X Keychain.
+//
+// # Thread safety
+//
+// All functions in this package are safe for concurrent use by
+// multiple goroutines. The legacy SecKeychain* APIs used alongside
+// the SecItem* APIs are not reliably safe for concurrent use, so
+// every call into the Security framework is run on a single
+// goroutine locked to its own OS thread, in the order the calls were
+// made. GetExecutorStats reports how many calls are queued and how
+// long they wait.
+//
+// Functions that make several Security framework calls, such as
+// RemoveAndAddGenericPassword, are not atomic: calls from other
+// goroutines may run in between.
+package osxkeychain
@@ -0,0 +1,80 @@
+package osxkeychain
+
+import "errors"
+
+// keychainError is an OSStatus result code from the Security
+// framework. The values are defined here rather than taken from the
+// framework headers so that code shared by all platforms, such as
+// Store implementations, can return and compare them.
+type keychainError int32
+
+// Error codes from https://developer.apple.com/library/mac/documentation/security/Reference/keychainservices/Reference/reference.html#//apple_ref/doc/uid/TP30000898-CH5g-CJBEABHG
+const (
+	ErrUnimplemented         keychainError = -4     // errSecUnimplemented
+	ErrParam                 keychainError = -50    // errSecParam
+	ErrAllocate              keychainError = -108   // errSecAllocate
+	ErrUserCanceled          keychainError = -128   // errSecUserCanceled
+	ErrPKCS12VerifyFailure   keychainError = -25264 // errSecPkcs12VerifyFailure
+	ErrNotAvailable          keychainError = -25291 // errSecNotAvailable
+	ErrReadOnly              keychainError = -25292 // errSecReadOnly
+	ErrAuthFailed            keychainError = -25293 // errSecAuthFailed
+	ErrNoSuchKeychain        keychainError = -25294 // errSecNoSuchKeychain
+	ErrInvalidKeychain       keychainError = -25295 // errSecInvalidKeychain
+	ErrDuplicateKeychain     keychainError = -25296 // errSecDuplicateKeychain
+	ErrDuplicateCallback     keychainError = -25297 // errSecDuplicateCallback
+	ErrInvalidCallback       keychainError = -25298 // errSecInvalidCallback
+	ErrDuplicateItem         keychainError = -25299 // errSecDuplicateItem
+	ErrItemNotFound          keychainError = -25300 // errSecItemNotFound
+	ErrBufferTooSmall        keychainError = -25301 // errSecBufferTooSmall
+	ErrDataTooLarge          keychainError = -25302 // errSecDataTooLarge
+	ErrNoSuchAttr            keychainError = -25303 // errSecNoSuchAttr
+	ErrInvalidItemRef        keychainError = -25304 // errSecInvalidItemRef
+	ErrInvalidSearchRef      keychainError = -25305 // errSecInvalidSearchRef
+	ErrNoSuchClass           keychainError = -25306 // errSecNoSuchClass
+	ErrNoDefaultKeychain     keychainError = -25307 // errSecNoDefaultKeychain
+	ErrInteractionNotAllowed keychainError = -25308 // errSecInteractionNotAllowed
+	ErrReadOnlyAttr          keychainError = -25309 // errSecReadOnlyAttr
+	ErrInternalComponent     keychainError = -2070  // errSecInternalComponent
+	ErrMissingEntitlement    keychainError = -34018 // errSecMissingEntitlement
+	// TODO: Fill out more of these?
+)
+
+// ErrorCode returns the OSStatus result code of err, and true, if err
+// is or wraps one of the errors above or another error from the
+// Security framework, and 0 and false otherwise.
+func ErrorCode(err error) (int32, bool) {
+	var ke keychainError
+	ok := errors.As(err, &ke)
+	return int32(ke), ok
+}
+
+// keychainErrorMessages holds the messages for the error codes above,
+// for platforms without SecCopyErrorMessageString.
+var keychainErrorMessages = map[keychainError]string{
+	ErrUnimplemented:         "Function or operation not implemented.",
+	ErrParam:                 "One or more parameters passed to a function were not valid.",
+	ErrAllocate:              "Failed to allocate memory.",
+	ErrUserCanceled:          "User canceled the operation.",
+	ErrPKCS12VerifyFailure:   "MAC verification failed during PKCS12 import (wrong password?).",
+	ErrNotAvailable:          "No keychain is available. You may need to restart your computer.",
+	ErrReadOnly:              "This keychain cannot be modified.",
+	ErrAuthFailed:            "The user name or passphrase you entered is not correct.",
+	ErrNoSuchKeychain:        "The specified keychain could not be found.",
+	ErrInvalidKeychain:       "The specified keychain is not a valid keychain file.",
+	ErrDuplicateKeychain:     "A keychain with the same name already exists.",
+	ErrDuplicateCallback:     "The specified callback function is already installed.",
+	ErrInvalidCallback:       "The specified callback function is not valid.",
+	ErrDuplicateItem:         "The specified item already exists in the keychain.",
+	ErrItemNotFound:          "The specified item could not be found in the keychain.",
+	ErrBufferTooSmall:        "There is not enough memory available to use the specified item.",
+	ErrDataTooLarge:          "This item contains information which is too large or in a format that cannot be displayed.",
+	ErrNoSuchAttr:            "The specified attribute does not exist.",
+	ErrInvalidItemRef:        "The specified item is no longer valid. It may have been deleted from the keychain.",
+	ErrInvalidSearchRef:      "Unable to search the current keychain.",
+	ErrNoSuchClass:           "The specified item does not appear to be a valid keychain item.",
+	ErrNoDefaultKeychain:     "A default keychain could not be found.",
+	ErrInteractionNotAllowed: "User interaction is not allowed.",
+	ErrReadOnlyAttr:          "The specified attribute could not be modified.",
+	ErrInternalComponent:     "An internal component failed.",
+	ErrMissingEntitlement:    "A required entitlement isn't present.",
+}
@@ -0,0 +1,13 @@
+//go:build !darwin || ios || !cgo
+// +build !darwin ios !cgo
+
+package osxkeychain
+
+import "fmt"
+
+func (ke keychainError) Error() string {
+	if message, ok := keychainErrorMessages[ke]; ok {
+		return message
+	}
+	return fmt.Sprintf("keychainError with unknown error code %d", int32(ke))
+}
@@ -0,0 +1,22 @@
+package osxkeychain_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+func TestErrorCode(t *testing.T) {
+	if code, ok := osxkeychain.ErrorCode(osxkeychain.ErrItemNotFound); !ok || code != -25300 {
+		t.Errorf("Expected -25300, got %d, %v", code, ok)
+	}
+	wrapped := fmt.Errorf("find svc: %w", osxkeychain.ErrItemNotFound)
+	if code, ok := osxkeychain.ErrorCode(wrapped); !ok || code != -25300 {
+		t.Errorf("Expected -25300 from a wrapped error, got %d, %v", code, ok)
+	}
+	if code, ok := osxkeychain.ErrorCode(errors.New("AccountName is empty")); ok || code != 0 {
+		t.Errorf("Expected no code, got %d, %v", code, ok)
+	}
+}
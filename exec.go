@@ -0,0 +1,203 @@
+//go:build unix
+
+package osxkeychain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// SecretEnv is a secret to pass to a child process through an
+// environment variable.
+type SecretEnv struct {
+	// Name is the name of the environment variable.
+	Name string
+	// URI is the reference URI of the secret; see Reference.
+	URI string
+	// If AsFile is true, the secret is written to a pipe inherited
+	// by the child, and the variable is set to the pipe's /dev/fd
+	// path instead of the secret itself, so that the secret never
+	// appears in the child's environment or on disk.
+	AsFile bool
+}
+
+// forwardedSignals are the signals ExecWithSecrets passes on to the
+// child.
+var forwardedSignals = []os.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGWINCH,
+}
+
+// terminalSignals are the forwarded signals a terminal sends to its
+// whole foreground process group, so that a child in the same group
+// gets them without ExecWithSecrets forwarding them.
+var terminalSignals = map[os.Signal]bool{
+	syscall.SIGINT:   true,
+	syscall.SIGQUIT:  true,
+	syscall.SIGWINCH: true,
+}
+
+// ownProcessGroup reports whether cmd runs in a process group of its
+// own.
+func ownProcessGroup(cmd *exec.Cmd) bool {
+	return cmd.SysProcAttr != nil && (cmd.SysProcAttr.Setpgid || cmd.SysProcAttr.Setsid)
+}
+
+func scrub(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func checkEnvName(name string) error {
+	if name == "" || strings.ContainsAny(name, "=\x00") {
+		return fmt.Errorf("invalid environment variable name %q", name)
+	}
+	return nil
+}
+
+// ExecWithSecrets resolves the given secrets with the resolver, passes
+// them to cmd as described by SecretEnv, and runs cmd until it exits.
+// Signals received while cmd runs are forwarded to it, except that
+// SIGINT, SIGQUIT and SIGWINCH are only forwarded if cmd runs in its
+// own process group, as SysProcAttr.Setpgid or Setsid make it:
+// otherwise it gets them from the terminal too. The returned
+// exit code is cmd's exit status, or 128 plus the signal number if it
+// was killed by a signal; err is only set if cmd couldn't be run.
+//
+// The resolved secrets are overwritten before ExecWithSecrets
+// returns. Secrets passed in the environment have to be copied into
+// strings, and os/exec makes further copies while starting cmd;
+// neither can be overwritten, so prefer AsFile where the child
+// supports it.
+func ExecWithSecrets(resolver *Resolver, secrets []SecretEnv, cmd *exec.Cmd) (exitCode int, err error) {
+	seen := make(map[string]bool)
+	values := make([][]byte, len(secrets))
+	defer func() {
+		for _, value := range values {
+			scrub(value)
+		}
+	}()
+	for i, secret := range secrets {
+		if err := checkEnvName(secret.Name); err != nil {
+			return -1, err
+		}
+		if seen[secret.Name] {
+			return -1, errors.New("environment variable " + secret.Name + " is given more than once")
+		}
+		seen[secret.Name] = true
+		if values[i], err = resolver.Resolve(secret.URI); err != nil {
+			return -1, errors.New(secret.Name + ": " + err.Error())
+		}
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	var childEnv []string
+	for _, kv := range env {
+		if !seen[strings.SplitN(kv, "=", 2)[0]] {
+			childEnv = append(childEnv, kv)
+		}
+	}
+
+	var readers, writers []*os.File
+	defer func() {
+		for _, f := range readers {
+			f.Close()
+		}
+		for _, f := range writers {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	for i, secret := range secrets {
+		if !secret.AsFile {
+			childEnv = append(childEnv, secret.Name+"="+string(values[i]))
+			writers = append(writers, nil)
+			continue
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			return -1, err
+		}
+		readers = append(readers, r)
+		writers = append(writers, w)
+		// ExtraFiles entry i becomes file descriptor 3+i.
+		fd := 3 + len(cmd.ExtraFiles)
+		cmd.ExtraFiles = append(cmd.ExtraFiles, r)
+		childEnv = append(childEnv, fmt.Sprintf("%s=/dev/fd/%d", secret.Name, fd))
+	}
+	cmd.Env = childEnv
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		return -1, err
+	}
+
+	// The child has its own copies of the read ends now.
+	for _, r := range readers {
+		r.Close()
+	}
+	readers = nil
+
+	// Write the file secrets concurrently, since a pipe may not be
+	// able to hold a whole secret until the child reads it.
+	var wg sync.WaitGroup
+	for i, w := range writers {
+		if w == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(w *os.File, value []byte) {
+			defer wg.Done()
+			// The child may exit without reading the secret,
+			// so write errors are ignored.
+			w.Write(value)
+			w.Close()
+		}(w, values[i])
+	}
+	writers = nil
+
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- cmd.Wait()
+	}()
+
+	for {
+		select {
+		case sig := <-signals:
+			if terminalSignals[sig] && !ownProcessGroup(cmd) {
+				continue
+			}
+			cmd.Process.Signal(sig)
+		case err := <-waitErr:
+			wg.Wait()
+			if err != nil {
+				if _, ok := err.(*exec.ExitError); !ok {
+					return -1, err
+				}
+			}
+			if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				return 128 + int(status.Signal()), nil
+			}
+			return cmd.ProcessState.ExitCode(), nil
+		}
+	}
+}
@@ -0,0 +1,199 @@
+//go:build unix
+
+package osxkeychain_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// TestExecHelperProcess isn't a real test: it's the child process run
+// by the tests below.
+func TestExecHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	switch os.Args[len(os.Args)-1] {
+	case "env":
+		fmt.Printf("%s|%s", os.Getenv("DB_PASSWORD"), os.Getenv("PATH"))
+	case "file":
+		path := os.Getenv("API_TOKEN")
+		secret, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		fmt.Printf("%s|%s", path, secret)
+	case "exit":
+		os.Exit(7)
+	case "signal":
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGUSR1)
+		fmt.Println("ready")
+		<-signals
+		os.Exit(42)
+	case "interrupt":
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT)
+		fmt.Println("ready")
+		select {
+		case <-signals:
+			os.Exit(42)
+		case <-time.After(500 * time.Millisecond):
+		}
+	case "kill":
+		syscall.Kill(os.Getpid(), syscall.SIGKILL)
+	}
+}
+
+func helperCommand(mode string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=TestExecHelperProcess", "--", mode)
+	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1", "PATH=/bin", "DB_PASSWORD=stale"}
+	return cmd
+}
+
+func runHelper(t *testing.T, secrets []osxkeychain.SecretEnv, mode string) (string, int) {
+	r := newTestResolver(t)
+	cmd := helperCommand(mode)
+	out, err := ioutil.TempFile("", "exec_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	cmd.Stdout = out
+
+	exitCode, err := osxkeychain.ExecWithSecrets(r, secrets, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output), exitCode
+}
+
+func TestExecWithSecretsEnv(t *testing.T) {
+	output, exitCode := runHelper(t, []osxkeychain.SecretEnv{{Name: "DB_PASSWORD", URI: "keychain://db/prod"}}, "env")
+	if exitCode != 0 {
+		t.Errorf("Expected exit code 0, got %d", exitCode)
+	}
+	if output != "s3cret & <more>|/bin" {
+		t.Errorf("Expected secret in environment, got %q", output)
+	}
+}
+
+func TestExecWithSecretsFile(t *testing.T) {
+	secrets := []osxkeychain.SecretEnv{
+		{Name: "DB_PASSWORD", URI: "keychain://db/prod"},
+		{Name: "API_TOKEN", URI: "keychain://api/token", AsFile: true},
+	}
+	output, exitCode := runHelper(t, secrets, "file")
+	if exitCode != 0 {
+		t.Errorf("Expected exit code 0, got %d", exitCode)
+	}
+	if output != "/dev/fd/3|tok" {
+		t.Errorf("Expected secret on /dev/fd/3, got %q", output)
+	}
+}
+
+func TestExecWithSecretsExitCode(t *testing.T) {
+	if _, exitCode := runHelper(t, nil, "exit"); exitCode != 7 {
+		t.Errorf("Expected exit code 7, got %d", exitCode)
+	}
+	if _, exitCode := runHelper(t, nil, "kill"); exitCode != 128+int(syscall.SIGKILL) {
+		t.Errorf("Expected exit code %d, got %d", 128+int(syscall.SIGKILL), exitCode)
+	}
+}
+
+func TestExecWithSecretsSignal(t *testing.T) {
+	r := newTestResolver(t)
+	cmd := helperCommand("signal")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		line, _ := bufio.NewReader(stdout).ReadString('\n')
+		if line != "ready\n" {
+			t.Errorf("Expected ready, got %q", line)
+			return
+		}
+		syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	}()
+
+	exitCode, err := osxkeychain.ExecWithSecrets(r, nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != 42 {
+		t.Errorf("Expected exit code 42, got %d", exitCode)
+	}
+}
+
+func TestExecWithSecretsInterrupt(t *testing.T) {
+	r := newTestResolver(t)
+	// A child in our process group would get a terminal's SIGINT
+	// itself, so it's only forwarded to one in its own group.
+	for _, setpgid := range []bool{false, true} {
+		cmd := helperCommand("interrupt")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: setpgid}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			line, _ := bufio.NewReader(stdout).ReadString('\n')
+			if line != "ready\n" {
+				t.Errorf("Expected ready, got %q", line)
+				return
+			}
+			syscall.Kill(os.Getpid(), syscall.SIGINT)
+		}()
+
+		exitCode, err := osxkeychain.ExecWithSecrets(r, nil, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := 0
+		if setpgid {
+			expected = 42
+		}
+		if exitCode != expected {
+			t.Errorf("Setpgid %v: expected exit code %d, got %d", setpgid, expected, exitCode)
+		}
+	}
+}
+
+func TestExecWithSecretsErrors(t *testing.T) {
+	r := newTestResolver(t)
+	tests := []struct {
+		secrets []osxkeychain.SecretEnv
+		err     string
+	}{
+		{[]osxkeychain.SecretEnv{{Name: "A=B", URI: "keychain://db/prod"}}, `invalid environment variable name "A=B"`},
+		{[]osxkeychain.SecretEnv{{Name: "A", URI: "keychain://db/prod"}, {Name: "A", URI: "keychain://api/token"}}, "environment variable A is given more than once"},
+		{[]osxkeychain.SecretEnv{{Name: "A", URI: "keychain://db/missing"}}, "A: " + osxkeychain.ErrItemNotFound.Error()},
+	}
+	for _, test := range tests {
+		exitCode, err := osxkeychain.ExecWithSecrets(r, test.secrets, helperCommand("exit"))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("Expected \"%s\", got %v", test.err, err)
+		}
+		if exitCode != -1 {
+			t.Errorf("Expected exit code -1, got %d", exitCode)
+		}
+	}
+}
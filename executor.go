@@ -0,0 +1,139 @@
+package osxkeychain
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ExecutorStats is a snapshot of the queue metrics of an executor.
+type ExecutorStats struct {
+	// Pending is the number of calls waiting for a worker.
+	Pending int
+	// Running is the number of calls currently being run.
+	Running int
+	// MaxPending is the largest value Pending has reached.
+	MaxPending int
+	// Submitted and Completed count the calls handed to the
+	// executor and the calls that have finished (including those
+	// that panicked).
+	Submitted uint64
+	Completed uint64
+	// TotalWait is the total time calls spent queued, and TotalRun
+	// is the total time they spent running.
+	TotalWait time.Duration
+	TotalRun  time.Duration
+}
+
+type executorCall struct {
+	fn        func()
+	submitted time.Time
+	panicVal  interface{}
+	done      chan struct{}
+}
+
+// executor runs functions on a fixed number of worker goroutines,
+// each locked to its own OS thread. With a single worker, all calls
+// are serialized on one thread, which is what the legacy SecKeychain*
+// APIs need.
+type executor struct {
+	workers int
+	once    sync.Once
+	calls   chan *executorCall
+
+	mu    sync.Mutex
+	stats ExecutorStats
+}
+
+// newExecutor returns an executor with the given number of workers.
+// The workers are started on first use.
+func newExecutor(workers int) *executor {
+	if workers < 1 {
+		workers = 1
+	}
+	return &executor{
+		workers: workers,
+		calls:   make(chan *executorCall),
+	}
+}
+
+func (e *executor) start() {
+	for i := 0; i < e.workers; i++ {
+		go e.work()
+	}
+}
+
+func (e *executor) work() {
+	// Never unlocked, so the thread is only ever used for calls
+	// run by this executor.
+	runtime.LockOSThread()
+	for call := range e.calls {
+		e.run(call)
+	}
+}
+
+func (e *executor) run(call *executorCall) {
+	start := time.Now()
+	e.mu.Lock()
+	e.stats.Pending--
+	e.stats.Running++
+	e.stats.TotalWait += start.Sub(call.submitted)
+	e.mu.Unlock()
+
+	defer func() {
+		call.panicVal = recover()
+		e.mu.Lock()
+		e.stats.Running--
+		e.stats.Completed++
+		e.stats.TotalRun += time.Since(start)
+		e.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.fn()
+}
+
+// do runs fn on one of the executor's workers and waits for it to
+// return. If fn panics, the panic is re-raised in the calling
+// goroutine. fn must not call do on the same executor, or it may
+// deadlock.
+func (e *executor) do(fn func()) {
+	e.once.Do(e.start)
+
+	call := &executorCall{
+		fn:        fn,
+		submitted: time.Now(),
+		done:      make(chan struct{}),
+	}
+
+	e.mu.Lock()
+	e.stats.Submitted++
+	e.stats.Pending++
+	if e.stats.Pending > e.stats.MaxPending {
+		e.stats.MaxPending = e.stats.Pending
+	}
+	e.mu.Unlock()
+
+	e.calls <- call
+	<-call.done
+
+	if call.panicVal != nil {
+		panic(call.panicVal)
+	}
+}
+
+// getStats returns a snapshot of the executor's queue metrics.
+func (e *executor) getStats() ExecutorStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stats
+}
+
+// keychainExecutor serializes all calls into the Security framework.
+var keychainExecutor = newExecutor(1)
+
+// GetExecutorStats returns a snapshot of the queue metrics for the
+// executor that serializes calls into the Security framework.
+func GetExecutorStats() ExecutorStats {
+	return keychainExecutor.getStats()
+}
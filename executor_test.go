@@ -0,0 +1,106 @@
+package osxkeychain
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeNativeBackend stands in for the Security framework: it records
+// how many calls are in flight at once.
+type fakeNativeBackend struct {
+	inFlight    int32
+	maxInFlight int32
+	calls       int32
+}
+
+func (b *fakeNativeBackend) call() {
+	n := atomic.AddInt32(&b.inFlight, 1)
+	for {
+		max := atomic.LoadInt32(&b.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt32(&b.maxInFlight, max, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	atomic.AddInt32(&b.calls, 1)
+	atomic.AddInt32(&b.inFlight, -1)
+}
+
+func runConcurrently(e *executor, b *fakeNativeBackend, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.do(b.call)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestExecutorSerializes(t *testing.T) {
+	e := newExecutor(1)
+	var b fakeNativeBackend
+	runConcurrently(e, &b, 50)
+
+	if b.calls != 50 {
+		t.Errorf("Expected 50 calls, got %d", b.calls)
+	}
+	if b.maxInFlight != 1 {
+		t.Errorf("Expected at most 1 call in flight, got %d", b.maxInFlight)
+	}
+
+	stats := e.getStats()
+	if stats.Submitted != 50 || stats.Completed != 50 {
+		t.Errorf("Expected 50 submitted and completed calls, got %+v", stats)
+	}
+	if stats.Pending != 0 || stats.Running != 0 {
+		t.Errorf("Expected an idle executor, got %+v", stats)
+	}
+	if stats.MaxPending < 2 {
+		t.Errorf("Expected calls to queue up, got %+v", stats)
+	}
+	if stats.TotalRun < 50*time.Millisecond {
+		t.Errorf("Expected a total run time of at least 50ms, got %s", stats.TotalRun)
+	}
+}
+
+func TestExecutorBoundsConcurrency(t *testing.T) {
+	e := newExecutor(3)
+	var b fakeNativeBackend
+	runConcurrently(e, &b, 50)
+
+	if b.calls != 50 {
+		t.Errorf("Expected 50 calls, got %d", b.calls)
+	}
+	if b.maxInFlight > 3 {
+		t.Errorf("Expected at most 3 calls in flight, got %d", b.maxInFlight)
+	}
+}
+
+func TestExecutorPanic(t *testing.T) {
+	e := newExecutor(1)
+
+	func() {
+		defer func() {
+			if r := recover(); r != "native call failed" {
+				t.Errorf("Expected panic to be re-raised, got %v", r)
+			}
+		}()
+		e.do(func() { panic("native call failed") })
+	}()
+
+	// The worker must survive the panic.
+	ran := false
+	e.do(func() { ran = true })
+	if !ran {
+		t.Error("Expected call after panic to run")
+	}
+
+	stats := e.getStats()
+	if stats.Completed != 2 || stats.Running != 0 {
+		t.Errorf("Expected 2 completed calls, got %+v", stats)
+	}
+}
@@ -0,0 +1,226 @@
+// Package instrument reports how long the operations of a Store take
+// and how they end to an Observer, so that they can be measured and
+// traced. The prominstrument and otelinstrument packages have
+// Observers for Prometheus and OpenTelemetry.
+//
+// Operations are reported with their service name but never with
+// their account name or password.
+//
+// The Security framework doesn't say whether an operation prompted
+// the user, so prompts are counted by the operations that can prompt,
+// those whose attributes have an OperationPrompt or AccessControl,
+// and by how they ended, as Outcome.Prompt reports.
+package instrument
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// Operations, as reported in Operation.Name.
+const (
+	OpAdd                = "add"
+	OpFind               = "find"
+	OpFindAndRemove      = "find_and_remove"
+	OpRemoveAndAdd       = "remove_and_add"
+	OpUpdate             = "update"
+	OpGetAllAccountNames = "get_all_account_names"
+)
+
+// Operation is a call to a Store method.
+type Operation struct {
+	// Context is the context of the Store, as set by WithContext.
+	Context     context.Context
+	Name        string
+	ServiceName string
+	// CanPrompt is whether the operation's attributes have an
+	// OperationPrompt or AccessControl, with which the keychain may
+	// prompt the user.
+	CanPrompt bool
+}
+
+// How operations that can prompt ended, as reported in Outcome.Prompt.
+const (
+	// PromptOK is an operation that succeeded, whether or not it
+	// prompted.
+	PromptOK = "ok"
+	// PromptCanceled is errSecUserCanceled, -128: the user canceled
+	// the prompt.
+	PromptCanceled = "canceled"
+	// PromptAuthFailed is errSecAuthFailed, -25293, from a keychain:
+	// the user didn't authenticate. Other stores, such as pkcs11store
+	// with a wrong PIN, return it without prompting, so for them it's
+	// a PromptError.
+	PromptAuthFailed = "auth_failed"
+	// PromptNotAllowed is errSecInteractionNotAllowed, -25308: the
+	// prompt couldn't be shown.
+	PromptNotAllowed = "not_allowed"
+	// PromptError is any other error.
+	PromptError = "error"
+)
+
+// Outcome is how an Operation ended.
+type Outcome struct {
+	Duration time.Duration
+	Err      error
+	// Code is the OSStatus result code of Err, if it's a keychain
+	// error, and 0 otherwise.
+	Code int32
+	// Prompt is how an operation that CanPrompt ended, one of the
+	// Prompt constants, and empty for other operations.
+	Prompt string
+	// AccountNames is the number of account names an
+	// OpGetAllAccountNames returned, and 0 for other operations.
+	AccountNames int
+}
+
+// isKeychain reports whether a Store is a keychain, whose
+// ErrAuthFailed is from a prompt. It's set on platforms with one.
+var isKeychain = func(store osxkeychain.Store) bool { return false }
+
+// prompt returns the Outcome.Prompt of an operation that can prompt,
+// which ended with err and its code, on store.
+func prompt(store osxkeychain.Store, err error, code int32) string {
+	switch {
+	case err == nil:
+		return PromptOK
+	case code == int32(osxkeychain.ErrUserCanceled):
+		return PromptCanceled
+	case code == int32(osxkeychain.ErrInteractionNotAllowed):
+		return PromptNotAllowed
+	case code == int32(osxkeychain.ErrAuthFailed) && isKeychain(store):
+		return PromptAuthFailed
+	}
+	return PromptError
+}
+
+// Observer is told about operations. Its methods may be called from
+// many goroutines at once.
+type Observer interface {
+	// Begin is called when an operation begins, and returns a function
+	// to call with its outcome once it has ended.
+	Begin(op *Operation) (end func(*Outcome))
+}
+
+type multiObserver []Observer
+
+// Multi returns an Observer that tells each of observers about every
+// operation.
+func Multi(observers ...Observer) Observer {
+	return multiObserver(observers)
+}
+
+func (m multiObserver) Begin(op *Operation) func(*Outcome) {
+	ends := make([]func(*Outcome), len(m))
+	for i, observer := range m {
+		ends[i] = observer.Begin(op)
+	}
+	return func(outcome *Outcome) {
+		// End in the reverse order, as nested spans would.
+		for i := len(ends) - 1; i >= 0; i-- {
+			ends[i](outcome)
+		}
+	}
+}
+
+// Store is an osxkeychain.Store that tells Observer about every call
+// to the Store it wraps.
+type Store struct {
+	Store    osxkeychain.Store
+	Observer Observer
+
+	ctx context.Context
+}
+
+// WithContext returns a copy of s whose operations are reported with
+// ctx as their Context, so that spans of them can be children of a
+// span in ctx.
+func (s *Store) WithContext(ctx context.Context) *Store {
+	return &Store{Store: s.Store, Observer: s.Observer, ctx: ctx}
+}
+
+// AddGenericPassword implements osxkeychain.Store.
+func (s *Store) AddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	return s.observe(OpAdd, attributes.ServiceName, canPrompt(attributes), func() (int, error) {
+		return 0, s.Store.AddGenericPassword(attributes)
+	})
+}
+
+// FindGenericPassword implements osxkeychain.Store.
+func (s *Store) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	var password []byte
+	err := s.observe(OpFind, attributes.ServiceName, canPrompt(attributes), func() (int, error) {
+		var err error
+		password, err = s.Store.FindGenericPassword(attributes)
+		return 0, err
+	})
+	return password, err
+}
+
+// FindAndRemoveGenericPassword implements osxkeychain.Store.
+func (s *Store) FindAndRemoveGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	return s.observe(OpFindAndRemove, attributes.ServiceName, canPrompt(attributes), func() (int, error) {
+		return 0, s.Store.FindAndRemoveGenericPassword(attributes)
+	})
+}
+
+// RemoveAndAddGenericPassword implements osxkeychain.Store.
+func (s *Store) RemoveAndAddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	return s.observe(OpRemoveAndAdd, attributes.ServiceName, canPrompt(attributes), func() (int, error) {
+		return 0, s.Store.RemoveAndAddGenericPassword(attributes)
+	})
+}
+
+// UpdateGenericPassword implements osxkeychain.Store.
+func (s *Store) UpdateGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	return s.observe(OpUpdate, attributes.ServiceName, canPrompt(attributes), func() (int, error) {
+		return 0, s.Store.UpdateGenericPassword(attributes)
+	})
+}
+
+// GetAllAccountNames implements osxkeychain.Store.
+func (s *Store) GetAllAccountNames(serviceName string) ([]string, error) {
+	var accountNames []string
+	err := s.observe(OpGetAllAccountNames, serviceName, false, func() (int, error) {
+		var err error
+		accountNames, err = s.Store.GetAllAccountNames(serviceName)
+		return len(accountNames), err
+	})
+	return accountNames, err
+}
+
+// canPrompt returns the Operation.CanPrompt of an operation with the
+// given attributes.
+func canPrompt(attributes *osxkeychain.GenericPasswordAttributes) bool {
+	return attributes.OperationPrompt != "" || attributes.AccessControl != 0
+}
+
+// observe calls f, which carries out the operation op and returns the
+// number of account names it returned, if any, telling Observer about
+// it.
+func (s *Store) observe(op, serviceName string, canPrompt bool, f func() (int, error)) error {
+	if s.Store == nil {
+		return errors.New("Store is nil")
+	}
+	if s.Observer == nil {
+		_, err := f()
+		return err
+	}
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	end := s.Observer.Begin(&Operation{Context: ctx, Name: op, ServiceName: serviceName, CanPrompt: canPrompt})
+	start := time.Now()
+	accountNames, err := f()
+	outcome := &Outcome{Duration: time.Since(start), Err: err, AccountNames: accountNames}
+	outcome.Code, _ = osxkeychain.ErrorCode(err)
+	if canPrompt {
+		outcome.Prompt = prompt(s.Store, err, outcome.Code)
+	}
+	end(outcome)
+	return err
+}
@@ -0,0 +1,144 @@
+package instrument_test
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/instrument"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+// recorder is an Observer recording the operations it's told about,
+// and, if log isn't nil, when it was told about them.
+type recorder struct {
+	name string
+	log  *[]string
+	mu   sync.Mutex
+	ops  []instrument.Operation
+	outs []instrument.Outcome
+}
+
+func (r *recorder) Begin(op *instrument.Operation) func(*instrument.Outcome) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, *op)
+	if r.log != nil {
+		*r.log = append(*r.log, "begin "+r.name)
+	}
+	return func(outcome *instrument.Outcome) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.outs = append(r.outs, *outcome)
+		if r.log != nil {
+			*r.log = append(*r.log, "end "+r.name)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, &instrument.Store{Store: &storetest.MemStore{}, Observer: &recorder{}})
+	storetest.TestStore(t, &instrument.Store{Store: &storetest.MemStore{}})
+}
+
+type contextKey struct{}
+
+func TestObserver(t *testing.T) {
+	observer := &recorder{}
+	store := &instrument.Store{Store: &storetest.MemStore{}, Observer: observer}
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("secret")}
+	store.AddGenericPassword(attributes)
+	store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "other"})
+	store.GetAllAccountNames("svc")
+	store.AddGenericPassword(attributes)
+	ctx := context.WithValue(context.Background(), contextKey{}, "request")
+	store.WithContext(ctx).FindGenericPassword(attributes)
+
+	expected := []string{instrument.OpAdd, instrument.OpAdd, instrument.OpGetAllAccountNames, instrument.OpAdd, instrument.OpFind}
+	var names []string
+	for _, op := range observer.ops {
+		if op.ServiceName != "svc" {
+			t.Errorf("Expected service svc, got %q", op.ServiceName)
+		}
+		names = append(names, op.Name)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+	if observer.ops[0].Context != context.Background() || observer.ops[4].Context != ctx {
+		t.Errorf("Expected the Store's context, got %v and %v", observer.ops[0].Context, observer.ops[4].Context)
+	}
+	if outcome := observer.outs[2]; outcome.Err != nil || outcome.AccountNames != 2 {
+		t.Errorf("Expected 2 account names, got %+v", outcome)
+	}
+	if outcome := observer.outs[3]; outcome.Err != osxkeychain.ErrDuplicateItem || outcome.Code != -25299 {
+		t.Errorf("Expected ErrDuplicateItem, got %+v", outcome)
+	}
+	for _, outcome := range observer.outs {
+		if outcome.Duration <= 0 {
+			t.Errorf("Expected a duration, got %+v", outcome)
+		}
+	}
+}
+
+func TestMulti(t *testing.T) {
+	var log []string
+	first, second := &recorder{name: "first", log: &log}, &recorder{name: "second", log: &log}
+	store := &instrument.Store{Store: &storetest.MemStore{}, Observer: instrument.Multi(first, second)}
+	store.GetAllAccountNames("svc")
+	expected := []string{"begin first", "begin second", "end second", "end first"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("Expected %v, got %v", expected, log)
+	}
+	if !reflect.DeepEqual(first.ops, second.ops) || !reflect.DeepEqual(first.outs, second.outs) {
+		t.Errorf("Expected both observers to be told the same, got %+v %+v and %+v %+v", first.ops, first.outs, second.ops, second.outs)
+	}
+}
+
+// failingStore is a MemStore whose FindGenericPassword fails with err.
+type failingStore struct {
+	storetest.MemStore
+	err error
+}
+
+func (s *failingStore) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	return nil, s.err
+}
+
+func TestPrompt(t *testing.T) {
+	tests := []struct {
+		attributes osxkeychain.GenericPasswordAttributes
+		err        error
+		canPrompt  bool
+		prompt     string
+	}{
+		{osxkeychain.GenericPasswordAttributes{ServiceName: "svc"}, nil, false, ""},
+		{osxkeychain.GenericPasswordAttributes{ServiceName: "svc"}, osxkeychain.ErrUserCanceled, false, ""},
+		{osxkeychain.GenericPasswordAttributes{ServiceName: "svc", OperationPrompt: "prompt"}, nil, true, instrument.PromptOK},
+		{osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccessControl: osxkeychain.AccessControlUserPresence}, nil, true, instrument.PromptOK},
+		{osxkeychain.GenericPasswordAttributes{ServiceName: "svc", OperationPrompt: "prompt"}, osxkeychain.ErrUserCanceled, true, instrument.PromptCanceled},
+		{osxkeychain.GenericPasswordAttributes{ServiceName: "svc", OperationPrompt: "prompt"}, osxkeychain.ErrInteractionNotAllowed, true, instrument.PromptNotAllowed},
+		// Only a keychain's ErrAuthFailed is from a prompt.
+		{osxkeychain.GenericPasswordAttributes{ServiceName: "svc", OperationPrompt: "prompt"}, osxkeychain.ErrAuthFailed, true, instrument.PromptError},
+		{osxkeychain.GenericPasswordAttributes{ServiceName: "svc", OperationPrompt: "prompt"}, osxkeychain.ErrItemNotFound, true, instrument.PromptError},
+	}
+	for _, test := range tests {
+		observer := &recorder{}
+		store := &instrument.Store{Store: &failingStore{err: test.err}, Observer: observer}
+		store.FindGenericPassword(&test.attributes)
+		if op := observer.ops[0]; op.CanPrompt != test.canPrompt {
+			t.Errorf("%+v, %v: expected CanPrompt %v", test.attributes, test.err, test.canPrompt)
+		}
+		if outcome := observer.outs[0]; outcome.Prompt != test.prompt {
+			t.Errorf("%+v, %v: expected prompt %q, got %q", test.attributes, test.err, test.prompt, outcome.Prompt)
+		}
+	}
+	observer := &recorder{}
+	store := &instrument.Store{Store: &storetest.MemStore{}, Observer: observer}
+	store.GetAllAccountNames("svc")
+	if observer.ops[0].CanPrompt || observer.outs[0].Prompt != "" {
+		t.Errorf("Expected GetAllAccountNames not to prompt, got %+v %+v", observer.ops[0], observer.outs[0])
+	}
+}
@@ -0,0 +1,16 @@
+//go:build darwin && !ios && cgo
+// +build darwin,!ios,cgo
+
+package instrument
+
+import "github.com/keybase/go-osxkeychain"
+
+func init() {
+	isKeychain = func(store osxkeychain.Store) bool {
+		switch store.(type) {
+		case osxkeychain.DataProtectionKeychain, *osxkeychain.DataProtectionKeychain:
+			return true
+		}
+		return store == osxkeychain.DefaultKeychain
+	}
+}
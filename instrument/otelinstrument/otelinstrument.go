@@ -0,0 +1,65 @@
+// Package otelinstrument has an instrument.Observer making an
+// OpenTelemetry span of each operation of a Store.
+package otelinstrument
+
+import (
+	"github.com/keybase/go-osxkeychain/instrument"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the Tracer of an Observer.
+const instrumentationName = "github.com/keybase/go-osxkeychain/instrument/otelinstrument"
+
+// Attributes of spans.
+const (
+	OperationKey    = attribute.Key("osxkeychain.operation")
+	ServiceKey      = attribute.Key("osxkeychain.service")
+	ErrorCodeKey    = attribute.Key("osxkeychain.error_code")
+	AccountCountKey = attribute.Key("osxkeychain.account_count")
+	PromptKey       = attribute.Key("osxkeychain.prompt")
+)
+
+// Observer is an instrument.Observer making a span named
+// "osxkeychain <operation>" of each operation, a child of any span in
+// the operation's Context. The span has the operation and service name
+// as attributes, and, for operations that failed, the OSStatus result
+// code of keychain errors. Operations that could prompt have how they
+// ended, one of the instrument.Prompt constants, as well. Since other errors may hold account names,
+// only keychain errors are recorded on the span.
+type Observer struct {
+	tracer trace.Tracer
+}
+
+// New returns an Observer using a Tracer from provider, such as
+// otel.GetTracerProvider().
+func New(provider trace.TracerProvider) *Observer {
+	return &Observer{tracer: provider.Tracer(instrumentationName)}
+}
+
+// Begin implements instrument.Observer.
+func (o *Observer) Begin(op *instrument.Operation) func(*instrument.Outcome) {
+	_, span := o.tracer.Start(op.Context, "osxkeychain "+op.Name, trace.WithAttributes(
+		OperationKey.String(op.Name),
+		ServiceKey.String(op.ServiceName),
+	))
+	return func(outcome *instrument.Outcome) {
+		if op.CanPrompt {
+			span.SetAttributes(PromptKey.String(outcome.Prompt))
+		}
+		switch {
+		case outcome.Err == nil:
+			if op.Name == instrument.OpGetAllAccountNames {
+				span.SetAttributes(AccountCountKey.Int(outcome.AccountNames))
+			}
+		case outcome.Code != 0:
+			span.SetAttributes(ErrorCodeKey.Int(int(outcome.Code)))
+			span.RecordError(outcome.Err)
+			span.SetStatus(codes.Error, outcome.Err.Error())
+		default:
+			span.SetStatus(codes.Error, "")
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,76 @@
+package otelinstrument_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/instrument"
+	"github.com/keybase/go-osxkeychain/instrument/otelinstrument"
+	"github.com/keybase/go-osxkeychain/storetest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestObserver(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	store := &instrument.Store{Store: &storetest.MemStore{}, Observer: otelinstrument.New(provider)}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	requestStore := store.WithContext(ctx)
+	requestStore.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("secret")})
+	requestStore.GetAllAccountNames("svc")
+	parent.End()
+	store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "missing", OperationPrompt: "prompt"})
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("Expected 4 spans, got %d", len(spans))
+	}
+	tests := []struct {
+		name       string
+		child      bool
+		attributes []attribute.KeyValue
+		status     codes.Code
+	}{
+		{"osxkeychain add", true, []attribute.KeyValue{
+			otelinstrument.OperationKey.String("add"),
+			otelinstrument.ServiceKey.String("svc"),
+		}, codes.Unset},
+		{"osxkeychain get_all_account_names", true, []attribute.KeyValue{
+			otelinstrument.OperationKey.String("get_all_account_names"),
+			otelinstrument.ServiceKey.String("svc"),
+			otelinstrument.AccountCountKey.Int(1),
+		}, codes.Unset},
+		{"request", false, nil, codes.Unset},
+		{"osxkeychain find", false, []attribute.KeyValue{
+			otelinstrument.OperationKey.String("find"),
+			otelinstrument.ServiceKey.String("svc"),
+			otelinstrument.ErrorCodeKey.Int(-25300),
+			otelinstrument.PromptKey.String("error"),
+		}, codes.Error},
+	}
+	for i, test := range tests {
+		span := spans[i]
+		if span.Name() != test.name {
+			t.Errorf("Expected span %q, got %q", test.name, span.Name())
+			continue
+		}
+		if isChild := span.Parent().SpanID() == parent.SpanContext().SpanID(); isChild != test.child {
+			t.Errorf("%s: expected child of the request to be %v", test.name, test.child)
+		}
+		got, want := attribute.NewSet(span.Attributes()...), attribute.NewSet(test.attributes...)
+		if test.attributes != nil && !got.Equals(&want) {
+			t.Errorf("%s: expected attributes %v, got %v", test.name, test.attributes, span.Attributes())
+		}
+		if span.Status().Code != test.status {
+			t.Errorf("%s: expected status %v, got %v", test.name, test.status, span.Status())
+		}
+	}
+	if status := spans[3].Status(); status.Description != osxkeychain.ErrItemNotFound.Error() {
+		t.Errorf("Expected the keychain error, got %q", status.Description)
+	}
+}
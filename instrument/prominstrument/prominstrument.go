@@ -0,0 +1,84 @@
+// Package prominstrument has an instrument.Observer exporting Prometheus
+// metrics of the operations of a Store.
+package prominstrument
+
+import (
+	"strconv"
+
+	"github.com/keybase/go-osxkeychain/instrument"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Observer is an instrument.Observer updating these metrics:
+//
+//   - osxkeychain_operation_duration_seconds, a histogram of how long
+//     operations took, by operation and result, "ok" or "error".
+//   - osxkeychain_operation_errors_total, a count of the operations
+//     that failed, by operation and code: the OSStatus result code of
+//     keychain errors, such as "-128" for a prompt the user canceled,
+//     and "other" for other errors.
+//   - osxkeychain_prompts_total, a count of the operations that could
+//     prompt, by operation and outcome, one of the instrument.Prompt
+//     constants.
+//   - osxkeychain_items, the number of items of each service, as last
+//     listed by GetAllAccountNames.
+type Observer struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	prompts  *prometheus.CounterVec
+	items    *prometheus.GaugeVec
+}
+
+// New returns an Observer whose metrics are registered with
+// registerer, such as prometheus.DefaultRegisterer.
+func New(registerer prometheus.Registerer) (*Observer, error) {
+	o := &Observer{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "osxkeychain_operation_duration_seconds",
+			Help: "How long keychain operations took.",
+			// From a millisecond, for an in-memory store, to about a
+			// minute, for a prompt.
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 9),
+		}, []string{"operation", "result"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "osxkeychain_operation_errors_total",
+			Help: "Keychain operations that failed, by OSStatus result code.",
+		}, []string{"operation", "code"}),
+		prompts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "osxkeychain_prompts_total",
+			Help: "Keychain operations that could prompt, by how they ended.",
+		}, []string{"operation", "outcome"}),
+		items: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "osxkeychain_items",
+			Help: "Items of each service, as last listed.",
+		}, []string{"service"}),
+	}
+	for _, collector := range []prometheus.Collector{o.duration, o.errors, o.prompts, o.items} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// Begin implements instrument.Observer.
+func (o *Observer) Begin(op *instrument.Operation) func(*instrument.Outcome) {
+	return func(outcome *instrument.Outcome) {
+		result := "ok"
+		if outcome.Err != nil {
+			result = "error"
+			code := "other"
+			if outcome.Code != 0 {
+				code = strconv.Itoa(int(outcome.Code))
+			}
+			o.errors.WithLabelValues(op.Name, code).Inc()
+		}
+		if op.CanPrompt {
+			o.prompts.WithLabelValues(op.Name, outcome.Prompt).Inc()
+		}
+		o.duration.WithLabelValues(op.Name, result).Observe(outcome.Duration.Seconds())
+		if op.Name == instrument.OpGetAllAccountNames && outcome.Err == nil {
+			o.items.WithLabelValues(op.ServiceName).Set(float64(outcome.AccountNames))
+		}
+	}
+}
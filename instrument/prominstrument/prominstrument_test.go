@@ -0,0 +1,97 @@
+package prominstrument_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/instrument"
+	"github.com/keybase/go-osxkeychain/instrument/prominstrument"
+	"github.com/keybase/go-osxkeychain/storetest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// brokenStore is a MemStore whose FindGenericPassword fails with an
+// error that isn't a keychain error.
+type brokenStore struct {
+	storetest.MemStore
+}
+
+func (s *brokenStore) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	return nil, errors.New("disk on fire")
+}
+
+func TestObserver(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	observer, err := prominstrument.New(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &instrument.Store{Store: &storetest.MemStore{}, Observer: observer}
+	for _, accountName := range []string{"a", "b", "a"} {
+		store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: accountName})
+	}
+	store.GetAllAccountNames("svc")
+	store.GetAllAccountNames("empty")
+	store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "c"})
+	store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "a", OperationPrompt: "prompt"})
+	store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "c", OperationPrompt: "prompt"})
+	store.Store = &brokenStore{}
+	store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "a"})
+
+	expected := `
+# HELP osxkeychain_items Items of each service, as last listed.
+# TYPE osxkeychain_items gauge
+osxkeychain_items{service="empty"} 0
+osxkeychain_items{service="svc"} 2
+# HELP osxkeychain_operation_errors_total Keychain operations that failed, by OSStatus result code.
+# TYPE osxkeychain_operation_errors_total counter
+osxkeychain_operation_errors_total{code="-25299",operation="add"} 1
+osxkeychain_operation_errors_total{code="-25300",operation="find"} 2
+osxkeychain_operation_errors_total{code="other",operation="find"} 1
+# HELP osxkeychain_prompts_total Keychain operations that could prompt, by how they ended.
+# TYPE osxkeychain_prompts_total counter
+osxkeychain_prompts_total{operation="find",outcome="error"} 1
+osxkeychain_prompts_total{operation="find",outcome="ok"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "osxkeychain_items", "osxkeychain_operation_errors_total", "osxkeychain_prompts_total"); err != nil {
+		t.Error(err)
+	}
+
+	counts := map[string]int{
+		`operation="add",result="ok"`:                   2,
+		`operation="add",result="error"`:                1,
+		`operation="get_all_account_names",result="ok"`: 2,
+		`operation="find",result="ok"`:                  1,
+		`operation="find",result="error"`:               3,
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "osxkeychain_operation_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			var labels []string
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, label.GetName()+`="`+label.GetValue()+`"`)
+			}
+			key := strings.Join(labels, ",")
+			if count := int(metric.GetHistogram().GetSampleCount()); count != counts[key] {
+				t.Errorf("%s: expected %d operations, got %d", key, counts[key], count)
+			}
+			delete(counts, key)
+		}
+	}
+	if len(counts) != 0 {
+		t.Errorf("Expected durations of %v", counts)
+	}
+
+	if _, err := prominstrument.New(registry); err == nil {
+		t.Error("Expected registering the metrics twice to fail")
+	}
+}
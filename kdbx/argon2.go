@@ -0,0 +1,268 @@
+package kdbx
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Argon2, as specified in RFC 9106. golang.org/x/crypto/argon2 only
+// provides Argon2i and Argon2id, but most KeePass databases use
+// Argon2d, so all three are implemented here.
+
+type argon2Type uint32
+
+const (
+	argon2d  argon2Type = 0
+	argon2i  argon2Type = 1
+	argon2id argon2Type = 2
+)
+
+const (
+	argon2Version    = 0x13
+	argon2SyncPoints = 4
+	argon2BlockWords = 128
+)
+
+type argon2Block [argon2BlockWords]uint64
+
+type argon2Params struct {
+	typ         argon2Type
+	iterations  uint32
+	memory      uint32 // in KiB, at least 8*parallelism
+	parallelism uint32 // at least 1
+	secret      []byte
+	data        []byte
+}
+
+// argon2Key returns the tag of the given length for password and salt.
+func argon2Key(p *argon2Params, password, salt []byte, tagLength uint32) []byte {
+	var h0 [blake2b.Size + 8]byte
+	p.initialHash(h0[:0], password, salt, tagLength)
+
+	lanes := p.parallelism
+	laneLength := p.memory / (argon2SyncPoints * lanes) * argon2SyncPoints
+	segmentLength := laneLength / argon2SyncPoints
+	memory := make([]argon2Block, laneLength*lanes)
+
+	var buf [8 * argon2BlockWords]byte
+	for lane := uint32(0); lane < lanes; lane++ {
+		for i := uint32(0); i < 2; i++ {
+			binary.LittleEndian.PutUint32(h0[blake2b.Size:], i)
+			binary.LittleEndian.PutUint32(h0[blake2b.Size+4:], lane)
+			blake2bLong(buf[:], h0[:])
+			block := &memory[lane*laneLength+i]
+			for j := range block {
+				block[j] = binary.LittleEndian.Uint64(buf[8*j:])
+			}
+		}
+	}
+
+	fill := func(pass, slice, lane uint32) {
+		dataIndependent := p.typ == argon2i || (p.typ == argon2id && pass == 0 && slice < argon2SyncPoints/2)
+		var address, input, zero argon2Block
+		if dataIndependent {
+			input[0] = uint64(pass)
+			input[1] = uint64(lane)
+			input[2] = uint64(slice)
+			input[3] = uint64(len(memory))
+			input[4] = uint64(p.iterations)
+			input[5] = uint64(p.typ)
+		}
+
+		start := uint32(0)
+		if pass == 0 && slice == 0 {
+			// The first two blocks of each lane are already filled.
+			start = 2
+		}
+		for index := start; index < segmentLength; index++ {
+			current := lane*laneLength + slice*segmentLength + index
+			previous := current - 1
+			if slice == 0 && index == 0 {
+				previous = lane*laneLength + laneLength - 1
+			}
+
+			var pseudoRandom uint64
+			if dataIndependent {
+				if index == start || index%argon2BlockWords == 0 {
+					input[6]++
+					argon2Compress(&address, &zero, &input, false)
+					argon2Compress(&address, &zero, &address, false)
+				}
+				pseudoRandom = address[index%argon2BlockWords]
+			} else {
+				pseudoRandom = memory[previous][0]
+			}
+
+			refLane := uint32(pseudoRandom>>32) % lanes
+			if pass == 0 && slice == 0 {
+				refLane = lane
+			}
+			// The reference set is every block already filled in
+			// the last three segments (all of them in the first
+			// pass) except the previous one, plus the blocks
+			// before the current one in the current segment if
+			// referencing the same lane.
+			var area, startPosition uint32
+			if pass == 0 {
+				area = slice * segmentLength
+			} else {
+				area = laneLength - segmentLength
+				startPosition = (slice + 1) % argon2SyncPoints * segmentLength
+			}
+			if refLane == lane {
+				area += index - 1
+			} else if index == 0 {
+				area--
+			}
+			j1 := pseudoRandom & 0xFFFFFFFF
+			relative := uint64(area) - 1 - (uint64(area) * (j1 * j1 >> 32) >> 32)
+			ref := refLane*laneLength + uint32((uint64(startPosition)+relative)%uint64(laneLength))
+
+			argon2Compress(&memory[current], &memory[previous], &memory[ref], pass > 0)
+		}
+	}
+	for pass := uint32(0); pass < p.iterations; pass++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			var wg sync.WaitGroup
+			for lane := uint32(0); lane < lanes; lane++ {
+				wg.Add(1)
+				go func(lane uint32) {
+					defer wg.Done()
+					fill(pass, slice, lane)
+				}(lane)
+			}
+			wg.Wait()
+		}
+	}
+
+	final := memory[laneLength-1]
+	for lane := uint32(1); lane < lanes; lane++ {
+		last := &memory[lane*laneLength+laneLength-1]
+		for i := range final {
+			final[i] ^= last[i]
+		}
+	}
+	for i, v := range final {
+		binary.LittleEndian.PutUint64(buf[8*i:], v)
+	}
+	tag := make([]byte, tagLength)
+	blake2bLong(tag, buf[:])
+	return tag
+}
+
+// initialHash appends H0 to b.
+func (p *argon2Params) initialHash(b, password, salt []byte, tagLength uint32) []byte {
+	h, _ := blake2b.New512(nil)
+	var word [4]byte
+	writeWord := func(v uint32) {
+		binary.LittleEndian.PutUint32(word[:], v)
+		h.Write(word[:])
+	}
+	writeWord(p.parallelism)
+	writeWord(tagLength)
+	writeWord(p.memory)
+	writeWord(p.iterations)
+	writeWord(argon2Version)
+	writeWord(uint32(p.typ))
+	for _, input := range [][]byte{password, salt, p.secret, p.data} {
+		writeWord(uint32(len(input)))
+		h.Write(input)
+	}
+	return h.Sum(b)
+}
+
+// blake2bLong is the variable-length hash function H' of RFC 9106,
+// which fills out.
+func blake2bLong(out, in []byte) {
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(out)))
+	if len(out) <= blake2b.Size {
+		h, _ := blake2b.New(len(out), nil)
+		h.Write(length[:])
+		h.Write(in)
+		h.Sum(out[:0])
+		return
+	}
+
+	h, _ := blake2b.New512(nil)
+	h.Write(length[:])
+	h.Write(in)
+	v := h.Sum(nil)
+	// Half of each intermediate hash is output until the rest fits
+	// in one last hash.
+	for len(out) > blake2b.Size {
+		copy(out, v[:32])
+		out = out[32:]
+		if len(out) > blake2b.Size {
+			sum := blake2b.Sum512(v)
+			v = sum[:]
+		}
+	}
+	h, _ = blake2b.New(len(out), nil)
+	h.Write(v)
+	h.Sum(out[:0])
+}
+
+// argon2Compress sets out to G(x, y), or XORs G(x, y) into it if
+// xor is set, as later passes do. out may be x or y.
+func argon2Compress(out, x, y *argon2Block, xor bool) {
+	var r, q argon2Block
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	q = r
+	var v [16]uint64
+	for row := 0; row < 8; row++ {
+		copy(v[:], q[16*row:16*row+16])
+		blamkaRound(&v)
+		copy(q[16*row:16*row+16], v[:])
+	}
+	for column := 0; column < 8; column++ {
+		for i := 0; i < 8; i++ {
+			v[2*i] = q[2*column+16*i]
+			v[2*i+1] = q[2*column+16*i+1]
+		}
+		blamkaRound(&v)
+		for i := 0; i < 8; i++ {
+			q[2*column+16*i] = v[2*i]
+			q[2*column+16*i+1] = v[2*i+1]
+		}
+	}
+	for i := range out {
+		if xor {
+			out[i] ^= q[i] ^ r[i]
+		} else {
+			out[i] = q[i] ^ r[i]
+		}
+	}
+}
+
+// blamkaRound is the permutation P, applied to eight 16-byte
+// registers.
+func blamkaRound(v *[16]uint64) {
+	blamkaG(v, 0, 4, 8, 12)
+	blamkaG(v, 1, 5, 9, 13)
+	blamkaG(v, 2, 6, 10, 14)
+	blamkaG(v, 3, 7, 11, 15)
+	blamkaG(v, 0, 5, 10, 15)
+	blamkaG(v, 1, 6, 11, 12)
+	blamkaG(v, 2, 7, 8, 13)
+	blamkaG(v, 3, 4, 9, 14)
+}
+
+func blamkaG(v *[16]uint64, a, b, c, d int) {
+	fBlaMka := func(x, y uint64) uint64 {
+		return x + y + 2*(x&0xFFFFFFFF)*(y&0xFFFFFFFF)
+	}
+	v[a] = fBlaMka(v[a], v[b])
+	v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+	v[c] = fBlaMka(v[c], v[d])
+	v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+	v[a] = fBlaMka(v[a], v[b])
+	v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+	v[c] = fBlaMka(v[c], v[d])
+	v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+}
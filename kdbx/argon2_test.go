@@ -0,0 +1,33 @@
+package kdbx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestArgon2RFC9106(t *testing.T) {
+	// The test vectors of RFC 9106, section 5.
+	tests := []struct {
+		typ      argon2Type
+		expected string
+	}{
+		{argon2d, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+		{argon2i, "c814d9d1dc7f37aa13f0d77f2494bda1c8de6b016dd388d29952a4c4672b6ce8"},
+		{argon2id, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+	}
+	for _, test := range tests {
+		p := &argon2Params{
+			typ:         test.typ,
+			iterations:  3,
+			memory:      32,
+			parallelism: 4,
+			secret:      bytes.Repeat([]byte{3}, 8),
+			data:        bytes.Repeat([]byte{4}, 12),
+		}
+		tag := argon2Key(p, bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16), 32)
+		if actual := hex.EncodeToString(tag); actual != test.expected {
+			t.Errorf("Argon2 type %d: Expected %s, got %s", test.typ, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package kdbx
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// PasswordEnv is the environment variable the file backend reads the
+// password of its file from, unless the URL's password_env option
+// names another.
+const PasswordEnv = "OSXKEYCHAIN_FILE_PASSWORD"
+
+// The file backend opens a FileStore with URLs such as
+//
+//	file:///var/lib/app/secrets.kdbx?kdf=argon2id
+//
+// taking these options:
+//
+//   - password_env, the environment variable holding the password,
+//     PasswordEnv by default.
+//   - lock_path, the FileStore's LockPath.
+//   - kdf, the KDF of a file the store creates: argon2 or argon2d,
+//     the default, or argon2id.
+//   - iterations, memory_mib and parallelism, the KDF's parameters
+//     other than the defaults, within ReadLimits.
+//
+// The file's directory is created if it doesn't exist. OpenDefault
+// opens secrets.kdbx in an osxkeychain directory of the user's
+// configuration directory, if PasswordEnv is set.
+func init() {
+	osxkeychain.Register("file", &osxkeychain.Backend{
+		Open:  openFile,
+		Probe: probeFile,
+	})
+}
+
+func openFile(u *url.URL, options *osxkeychain.Options) (osxkeychain.Store, error) {
+	passwordEnv := options.String("password_env", PasswordEnv)
+	lockPath := options.String("lock_path", "")
+	kdf := DefaultKDFParameters
+	switch options.Choice("kdf", "argon2", "argon2", "argon2d", "argon2id") {
+	case "argon2", "argon2d":
+		kdf.KDF = KDFArgon2d
+	case "argon2id":
+		kdf.KDF = KDFArgon2id
+	}
+	kdf.Iterations = uint64(options.Int("iterations", int(kdf.Iterations)))
+	kdf.Memory = uint64(options.Int("memory_mib", int(kdf.Memory>>20))) << 20
+	kdf.Parallelism = uint32(options.Int("parallelism", int(kdf.Parallelism)))
+	if err := options.Err(); err != nil {
+		return nil, err
+	}
+	if err := kdf.CheckValidity(); err != nil {
+		return nil, err
+	}
+	// Don't create a file the store couldn't read back.
+	if err := kdf.checkLimits(&ReadLimits); err != nil {
+		return nil, err
+	}
+	if u.Opaque != "" || u.Host != "" && u.Host != "localhost" || u.Path == "" {
+		return nil, errors.New("kdbx: file URL " + u.Redacted() + " isn't of the form file:///path")
+	}
+	password, ok := os.LookupEnv(passwordEnv)
+	if !ok {
+		return nil, errors.New("kdbx: " + passwordEnv + " isn't set")
+	}
+	path := filepath.FromSlash(u.Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{Path: path, Password: password, LockPath: lockPath, KDF: &kdf}, nil
+}
+
+func probeFile() (string, error) {
+	if _, ok := os.LookupEnv(PasswordEnv); !ok {
+		return "", errors.New(PasswordEnv + " isn't set")
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(dir, "osxkeychain", "secrets.kdbx"))}
+	return u.String(), nil
+}
@@ -0,0 +1,76 @@
+package kdbx
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+func TestFileBackend(t *testing.T) {
+	t.Setenv(PasswordEnv, "pw")
+	path := filepath.Join(t.TempDir(), "dir", "secrets.kdbx")
+	store, err := osxkeychain.Open("file://" + filepath.ToSlash(path) + "?kdf=argon2id&iterations=1&memory_mib=1&parallelism=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.TestStore(t, store)
+	store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct"})
+	expected := KDFParameters{KDF: KDFArgon2id, Iterations: 1, Memory: 1 << 20, Parallelism: 1}
+	kdf := readTestFile(t, path).KDF
+	kdf.Salt = nil
+	if !kdf.equal(&expected) {
+		t.Errorf("Expected %+v, got %+v", expected, kdf)
+	}
+
+	t.Setenv("OTHER_PASSWORD", "other")
+	store, err = osxkeychain.Open("file://localhost" + filepath.ToSlash(path) + "?password_env=OTHER_PASSWORD&lock_path=/tmp/lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := store.(*FileStore); s.Password != "other" || s.LockPath != "/tmp/lock" || s.KDF.KDF != KDFArgon2d {
+		t.Errorf("Expected the options' password, lock path and Argon2d, got %+v", s)
+	}
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"file:secrets.kdbx", "kdbx: file URL file:secrets.kdbx isn't of the form file:///path"},
+		{"file://host/secrets.kdbx", "kdbx: file URL file://host/secrets.kdbx isn't of the form file:///path"},
+		{"file:///secrets.kdbx?password_env=MISSING_PASSWORD", "kdbx: MISSING_PASSWORD isn't set"},
+		{"file:///secrets.kdbx?kdf=aes", `option kdf="aes" is invalid: not one of argon2, argon2d, argon2id`},
+		{"file:///secrets.kdbx?parallelism=0", "Parallelism must be between 1 and 2^24-1"},
+		{"file:///secrets.kdbx?memory_mib=2048", "Argon2 memory of 2147483648 bytes exceeds the limit of 1073741824"},
+		{"file:///secrets.kdbx?password=pw", "unknown option password"},
+	}
+	for _, test := range tests {
+		_, err := osxkeychain.Open(test.url)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%s: expected \"%s\", got %v", test.url, test.expected, err)
+		}
+	}
+}
+
+func TestProbeFile(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/config")
+	t.Setenv("HOME", "/home")
+	t.Setenv(PasswordEnv, "pw")
+	rawURL, err := probeFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rawURL, "file://") || !strings.HasSuffix(rawURL, "/osxkeychain/secrets.kdbx") {
+		t.Errorf("Expected a file in the configuration directory, got %s", rawURL)
+	}
+
+	t.Setenv(PasswordEnv, "")
+	os.Unsetenv(PasswordEnv)
+	expected := PasswordEnv + " isn't set"
+	if _, err := probeFile(); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
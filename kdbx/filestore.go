@@ -0,0 +1,318 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// ErrConcurrentModification is returned by FileStore methods that
+// change the store when the file kept being changed by another
+// program while they tried to write it.
+var ErrConcurrentModification = errors.New("kdbx: file keeps being modified by another program")
+
+// maxWriteAttempts is how many times a FileStore applies a change to
+// a file that keeps changing before giving up.
+const maxWriteAttempts = 5
+
+// FileStore is an osxkeychain.Store kept in a KDBX file, which
+// KeePassXC can open too. Each generic password is an entry, as
+// SetEntryAttributes sets it, so attributes other than the names and
+// password are ignored. New entries go in a group named after their
+// service, as with Export, but entries are found in any group except
+// the recycle bin. As in the keychain, an empty ServiceName or
+// AccountName matches any when finding, removing or updating an
+// item, and the first matching entry is used. If the file doesn't
+// exist, the store is empty, and the first change creates the file
+// as NewDatabase does.
+//
+// Every method reads the file again, so changes made by others are
+// seen; the key is only derived again if the password or the KDF
+// parameters changed. Methods that change the store hold a lock on
+// LockPath, which keeps FileStores in other processes from writing
+// at the same time. Other programs, such as KeePassXC, don't take the
+// lock: if one saves the file after a method read it, the method
+// applies its change again to the new contents rather than
+// overwriting them, failing as usual if it no longer applies, for
+// example with ErrDuplicateItem if the other program added the same
+// item. If the file keeps changing, the method gives up with
+// ErrConcurrentModification.
+type FileStore struct {
+	Path     string
+	Password string
+
+	// LockPath is the path of the file that is locked while the
+	// store is written. If empty, Path with ".lock" appended is
+	// used.
+	LockPath string
+
+	// KDF, if non-nil, holds the KDF parameters of the file the store
+	// creates if it doesn't exist, instead of DefaultKDFParameters.
+	// The parameters of an existing file are kept.
+	KDF *KDFParameters
+
+	mu          sync.Mutex
+	db          *Database
+	fingerprint [sha256.Size]byte
+	key         *transformedKey
+}
+
+// AddGenericPassword implements osxkeychain.Store.
+func (s *FileStore) AddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	return s.modify(func(db *Database) error {
+		if group, _ := db.findEntry(attributes.ServiceName, attributes.AccountName); group != nil {
+			return osxkeychain.ErrDuplicateItem
+		}
+		return db.addEntry(attributes)
+	})
+}
+
+// FindGenericPassword implements osxkeychain.Store.
+func (s *FileStore) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	if err := attributes.CheckValidity(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, _, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	group, i := db.matchEntry(attributes.ServiceName, attributes.AccountName, true)
+	if group == nil {
+		return nil, osxkeychain.ErrItemNotFound
+	}
+	return EntryAttributes(group.Entries[i]).Password, nil
+}
+
+// FindAndRemoveGenericPassword implements osxkeychain.Store.
+func (s *FileStore) FindAndRemoveGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	return s.modify(func(db *Database) error {
+		group, i := db.matchEntry(attributes.ServiceName, attributes.AccountName, true)
+		if group == nil {
+			return osxkeychain.ErrItemNotFound
+		}
+		db.removeEntry(group, i)
+		return nil
+	})
+}
+
+// RemoveAndAddGenericPassword implements osxkeychain.Store. Unlike
+// the keychain, it replaces the item in a single write of the file.
+func (s *FileStore) RemoveAndAddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	return s.modify(func(db *Database) error {
+		if group, i := db.matchEntry(attributes.ServiceName, attributes.AccountName, true); group != nil {
+			db.removeEntry(group, i)
+		}
+		return db.addEntry(attributes)
+	})
+}
+
+// UpdateGenericPassword implements osxkeychain.Store. Only the
+// entry's password changes, and its previous version is kept in its
+// History.
+func (s *FileStore) UpdateGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	return s.modify(func(db *Database) error {
+		group, i := db.matchEntry(attributes.ServiceName, attributes.AccountName, true)
+		if group == nil {
+			return osxkeychain.ErrItemNotFound
+		}
+		item := EntryAttributes(group.Entries[i])
+		item.Password = attributes.Password
+		return updateEntry(group.Entries[i], item)
+	})
+}
+
+// GetAllAccountNames implements osxkeychain.Store.
+func (s *FileStore) GetAllAccountNames(serviceName string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, _, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	accountNames := []string{}
+	db.forEachEntry(func(g *Group, i int) bool {
+		if attributes := EntryAttributes(g.Entries[i]); attributes.ServiceName == serviceName {
+			accountNames = append(accountNames, attributes.AccountName)
+		}
+		return true
+	})
+	return accountNames, nil
+}
+
+// Close implements io.Closer. It forgets the database last read from
+// the file and overwrites the key derived from Password, so the next
+// method reads the file and derives the key again.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []*transformedKey{s.key}
+	if s.db != nil {
+		keys = append(keys, s.db.key)
+	}
+	for _, k := range keys {
+		if k != nil {
+			clear(k.key)
+			k.composite = [sha256.Size]byte{}
+		}
+	}
+	s.db, s.fingerprint, s.key = nil, [sha256.Size]byte{}, nil
+	return nil
+}
+
+// load returns the database in the file, reading it only if it has
+// changed since it was last read or written, and the fingerprint of
+// the file, which is zero if the file doesn't exist.
+func (s *FileStore) load() (*Database, [sha256.Size]byte, error) {
+	var fingerprint [sha256.Size]byte
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		if s.db == nil || s.fingerprint != fingerprint {
+			s.db = NewDatabase(strings.TrimSuffix(filepath.Base(s.Path), filepath.Ext(s.Path)))
+			if s.KDF != nil {
+				s.db.KDF = *s.KDF
+			}
+			s.fingerprint = fingerprint
+		}
+		return s.db, fingerprint, nil
+	}
+	if err != nil {
+		return nil, fingerprint, err
+	}
+	fingerprint = sha256.Sum256(data)
+	if s.db != nil && s.fingerprint == fingerprint {
+		return s.db, fingerprint, nil
+	}
+	db, err := read(data, s.Password, s.key)
+	if err != nil {
+		return nil, fingerprint, err
+	}
+	s.db, s.fingerprint, s.key = db, fingerprint, db.key
+	return db, fingerprint, nil
+}
+
+// modify applies change to the database in the file and writes it
+// back, applying it again to the file's new contents if another
+// program changed the file in the meantime.
+func (s *FileStore) modify(change func(db *Database) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lockPath := s.LockPath
+	if lockPath == "" {
+		lockPath = s.Path + ".lock"
+	}
+	unlock, err := lockFile(lockPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		db, fingerprint, err := s.load()
+		if err != nil {
+			return err
+		}
+		// The change is made to the cached database, which is only
+		// kept if it's written.
+		s.db = nil
+		if err := change(db); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := db.Write(&buf, s.Password); err != nil {
+			return err
+		}
+		replaced, err := s.replace(buf.Bytes(), fingerprint)
+		if err != nil {
+			return err
+		}
+		if replaced {
+			s.db, s.fingerprint, s.key = db, sha256.Sum256(buf.Bytes()), db.key
+			return nil
+		}
+	}
+	return ErrConcurrentModification
+}
+
+// beforeReplace is called by replace just before it checks the
+// file, so tests can change it.
+var beforeReplace = func(path string) {}
+
+// replace atomically replaces the file with data, unless its
+// fingerprint is no longer the given one.
+func (s *FileStore) replace(data []byte, fingerprint [sha256.Size]byte) (replaced bool, err error) {
+	path := s.Path
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if !replaced {
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+
+	beforeReplace(path)
+	var current [sha256.Size]byte
+	existing, err := os.ReadFile(path)
+	if err == nil {
+		current = sha256.Sum256(existing)
+		if info, err := os.Stat(path); err == nil {
+			os.Chmod(f.Name(), info.Mode().Perm())
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	if current != fingerprint {
+		return false, nil
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// removeEntry removes an entry from its group, recording its deletion
+// so that KeePassXC doesn't bring it back when merging copies of the
+// database.
+func (db *Database) removeEntry(g *Group, i int) {
+	db.DeletedObjects = append(db.DeletedObjects, DeletedObject{
+		UUID: g.Entries[i].UUID,
+		Time: time.Now().UTC().Truncate(time.Second),
+	})
+	g.Entries = append(g.Entries[:i], g.Entries[i+1:]...)
+}
@@ -0,0 +1,232 @@
+package kdbx
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+var testKDF = KDFParameters{KDF: KDFArgon2id, Iterations: 1, Memory: 64 << 10, Parallelism: 1}
+
+// newTestFile writes an empty database protected by "pw" with a fast
+// KDF, and returns its path.
+func newTestFile(t *testing.T) string {
+	db := NewDatabase("Test")
+	db.KDF = testKDF
+	path := filepath.Join(t.TempDir(), "test.kdbx")
+	writeTestFile(t, path, db)
+	return path
+}
+
+func readTestFile(t *testing.T, path string) *Database {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Read(bytes.NewReader(data), "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func writeTestFile(t *testing.T, path string, db *Database) {
+	var buf bytes.Buffer
+	if err := db.Write(&buf, "pw"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	storetest.TestStore(t, &FileStore{Path: newTestFile(t), Password: "pw"})
+}
+
+func TestFileStoreIsReadable(t *testing.T) {
+	path := newTestFile(t)
+	store := &FileStore{Path: path, Password: "pw"}
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("one")}
+	store.AddGenericPassword(attributes)
+	attributes.Password = []byte("two")
+	store.UpdateGenericPassword(attributes)
+
+	db := readTestFile(t, path)
+	if len(db.Root.Groups) != 1 || db.Root.Groups[0].Name != "svc" || len(db.Root.Groups[0].Entries) != 1 {
+		t.Fatalf("Expected an entry in group svc, got %v", db.Root.Groups)
+	}
+	entry := db.Root.Groups[0].Entries[0]
+	if !reflect.DeepEqual(EntryAttributes(entry), attributes) || len(entry.History) != 1 {
+		t.Errorf("Unexpected entry %v", entry)
+	}
+
+	store.FindAndRemoveGenericPassword(attributes)
+	db = readTestFile(t, path)
+	if len(db.Root.Groups[0].Entries) != 0 || len(db.DeletedObjects) != 1 || db.DeletedObjects[0].UUID != entry.UUID {
+		t.Errorf("Expected the entry's deletion to be recorded, got %v", db.DeletedObjects)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the file's mode to be kept, got %v", info.Mode())
+	}
+}
+
+func TestFileStoreEmptyNames(t *testing.T) {
+	store := &FileStore{Path: newTestFile(t), Password: "pw"}
+	store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("one")})
+	store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "other", AccountName: "acct2", Password: []byte("two")})
+
+	// An empty account name matches any account of the service.
+	password, err := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "other"})
+	if err != nil || string(password) != "two" {
+		t.Errorf("Expected \"two\", got %q, %v", password, err)
+	}
+	if err := store.UpdateGenericPassword(&osxkeychain.GenericPasswordAttributes{AccountName: "acct", Password: []byte("three")}); err != nil {
+		t.Fatal(err)
+	}
+	password, err = store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct"})
+	if err != nil || string(password) != "three" {
+		t.Errorf("Expected \"three\", got %q, %v", password, err)
+	}
+
+	// Adding stays exact.
+	if err := store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct3"}); err != nil {
+		t.Errorf("Expected a new item, got %v", err)
+	}
+
+	// Only the first matching item is removed.
+	if err := store.FindAndRemoveGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc"}); err != nil {
+		t.Fatal(err)
+	}
+	if accountNames, _ := store.GetAllAccountNames("svc"); !reflect.DeepEqual(accountNames, []string{"acct3"}) {
+		t.Errorf("Expected [acct3], got %v", accountNames)
+	}
+	if _, err := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "missing"}); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("Expected %v, got %v", osxkeychain.ErrItemNotFound, err)
+	}
+}
+
+func TestFileStoreClose(t *testing.T) {
+	store := &FileStore{Path: newTestFile(t), Password: "pw"}
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("one")}
+	if err := store.AddGenericPassword(attributes); err != nil {
+		t.Fatal(err)
+	}
+	key := store.key.key
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if store.db != nil || store.key != nil || !bytes.Equal(key, make([]byte, len(key))) {
+		t.Errorf("Expected the database to be dropped and the key overwritten, got key %x", key)
+	}
+
+	// The store can still be used, deriving the key again.
+	if password, err := store.FindGenericPassword(attributes); err != nil || string(password) != "one" {
+		t.Errorf("Expected \"one\", got %q, %v", password, err)
+	}
+}
+
+func TestFileStoreCreatesFile(t *testing.T) {
+	defer func(kdf KDFParameters) { DefaultKDFParameters = kdf }(DefaultKDFParameters)
+	DefaultKDFParameters = testKDF
+
+	path := filepath.Join(t.TempDir(), "new.kdbx")
+	store := &FileStore{Path: path, Password: "pw"}
+	if accountNames, err := store.GetAllAccountNames("svc"); err != nil || len(accountNames) != 0 {
+		t.Errorf("Expected no accounts, got %v, %v", accountNames, err)
+	}
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "svc"}
+	if _, err := store.FindGenericPassword(attributes); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("Expected ErrItemNotFound, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected reading not to create the file, got %v", err)
+	}
+	if err := store.AddGenericPassword(attributes); err != nil {
+		t.Fatal(err)
+	}
+	if db := readTestFile(t, path); db.Name != "new" || len(db.Root.Groups) != 1 {
+		t.Errorf("Unexpected database %v", db)
+	}
+}
+
+func TestFileStoreSeesOtherWriters(t *testing.T) {
+	path := newTestFile(t)
+	a := &FileStore{Path: path, Password: "pw"}
+	b := &FileStore{Path: path, Password: "pw"}
+	a.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "a"})
+	b.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "b"})
+
+	// As KeePassXC would, in a group of its own.
+	db := readTestFile(t, path)
+	group := NewGroup("Personal")
+	entry := NewEntry()
+	SetEntryAttributes(entry, &osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "c"})
+	group.Entries = append(group.Entries, entry)
+	db.Root.Groups = append(db.Root.Groups, group)
+	writeTestFile(t, path, db)
+
+	accountNames, err := a.GetAllAccountNames("svc")
+	if expected := []string{"a", "b", "c"}; err != nil || !reflect.DeepEqual(accountNames, expected) {
+		t.Errorf("Expected %v, got %v, %v", expected, accountNames, err)
+	}
+	if _, err := (&FileStore{Path: path, Password: "wrong"}).GetAllAccountNames("svc"); err != ErrWrongPassword {
+		t.Errorf("Expected ErrWrongPassword, got %v", err)
+	}
+}
+
+func TestFileStoreConcurrentModification(t *testing.T) {
+	defer func(f func(string)) { beforeReplace = f }(beforeReplace)
+	path := newTestFile(t)
+	store := &FileStore{Path: path, Password: "pw"}
+
+	// addBehind adds an item to the file as a program that doesn't
+	// take the lock would, while store is writing it.
+	addBehind := func(accountName string) {
+		beforeReplace = func(string) {
+			beforeReplace = func(string) {}
+			db := readTestFile(t, path)
+			db.addEntry(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: accountName})
+			writeTestFile(t, path, db)
+		}
+	}
+
+	// A change that still applies is merged with the other one.
+	addBehind("theirs")
+	if err := store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "ours"}); err != nil {
+		t.Fatal(err)
+	}
+	accountNames, _ := store.GetAllAccountNames("svc")
+	if expected := []string{"theirs", "ours"}; !reflect.DeepEqual(accountNames, expected) {
+		t.Errorf("Expected %v, got %v", expected, accountNames)
+	}
+
+	// One that doesn't is aborted.
+	addBehind("both")
+	err := store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "both"})
+	if err != osxkeychain.ErrDuplicateItem {
+		t.Errorf("Expected ErrDuplicateItem, got %v", err)
+	}
+
+	writes := 0
+	beforeReplace = func(string) {
+		writes++
+		writeTestFile(t, path, readTestFile(t, path))
+	}
+	err = store.FindAndRemoveGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "ours"})
+	if err != ErrConcurrentModification || writes != maxWriteAttempts {
+		t.Errorf("Expected ErrConcurrentModification after %d attempts, got %v after %d", maxWriteAttempts, err, writes)
+	}
+	beforeReplace = func(string) {}
+	if _, err := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "ours"}); err != nil {
+		t.Errorf("Expected the item to be kept, got %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".test.kdbx.*")); len(matches) != 0 {
+		t.Errorf("Expected temporary files to be removed, got %v", matches)
+	}
+}
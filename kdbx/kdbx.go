@@ -0,0 +1,582 @@
+// Package kdbx reads and writes KeePass databases in the KDBX 4
+// format, as used by KeePass 2.35 and later and KeePassXC, so that
+// passwords can be carried between the keychain and other systems.
+//
+// A Database is read whole into memory with Read and written back
+// with its Write method. Only databases protected by a password
+// alone are supported, not ones that also need a key file or a
+// hardware key. Elements of the XML document that this package
+// doesn't model, such as icons, auto-type settings and attachments,
+// are kept and written back unchanged.
+//
+// Export and Import copy generic passwords between a Database and an
+// osxkeychain.Store.
+package kdbx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// Cipher is the algorithm a database is encrypted with.
+type Cipher int
+
+const (
+	CipherChaCha20 Cipher = iota + 1
+	CipherAES256
+)
+
+var cipherUUIDs = map[Cipher]UUID{
+	CipherChaCha20: {0xd6, 0x03, 0x8a, 0x2b, 0x8b, 0x6f, 0x4c, 0xb5, 0xa5, 0x24, 0x33, 0x9a, 0x31, 0xdb, 0xb5, 0x9a},
+	CipherAES256:   {0x31, 0xc1, 0xf2, 0xe6, 0xbf, 0x71, 0x43, 0x50, 0xbe, 0x58, 0x05, 0x21, 0x6a, 0xfc, 0x5a, 0xff},
+}
+
+func (c Cipher) String() string {
+	switch c {
+	case CipherChaCha20:
+		return "ChaCha20"
+	case CipherAES256:
+		return "AES-256"
+	}
+	return fmt.Sprintf("Cipher(%d)", int(c))
+}
+
+// CheckValidity returns an error if the given Cipher is not one of
+// the values above.
+func (c Cipher) CheckValidity() error {
+	if _, ok := cipherUUIDs[c]; !ok {
+		return fmt.Errorf("Cipher has unknown value %d", int(c))
+	}
+	return nil
+}
+
+// ErrWrongPassword is returned by Read when the password doesn't
+// open the database. Databases that also need a key file fail this
+// way too.
+var ErrWrongPassword = errors.New("kdbx: wrong password")
+
+var errTruncated = errors.New("kdbx: file is truncated")
+
+const (
+	signature1      = 0x9AA2D903
+	signature2      = 0xB54BFB67
+	versionMajor    = 4
+	hmacBlockLength = 1 << 20
+)
+
+// Outer header fields.
+const (
+	headerEnd              = 0
+	headerCipherID         = 2
+	headerCompressionFlags = 3
+	headerMasterSeed       = 4
+	headerEncryptionIV     = 7
+	headerKDFParameters    = 11
+	headerPublicCustomData = 12
+)
+
+// Inner header fields.
+const (
+	innerHeaderEnd          = 0
+	innerHeaderStreamID     = 1
+	innerHeaderStreamKey    = 2
+	innerHeaderBinary       = 3
+	innerStreamChaCha20     = 3
+	innerStreamKeyLength    = 64
+	compressionFlagsNone    = 0
+	compressionFlagsGzip    = 1
+	masterSeedLength        = 32
+	chaCha20NonceLength     = 12
+	aesInitializationLength = aes.BlockSize
+)
+
+// Database is a KeePass database.
+type Database struct {
+	// Cipher is the algorithm the database is encrypted with.
+	Cipher Cipher
+	// Compress is whether the database is gzipped before being
+	// encrypted.
+	Compress bool
+	// KDF holds the parameters of the function that turns the
+	// password into a key.
+	KDF KDFParameters
+
+	// Name is the name of the database, which KeePassXC shows
+	// instead of the file name.
+	Name string
+	// Root is the group that holds all others.
+	Root *Group
+	// DeletedObjects lists the groups and entries that have been
+	// deleted, so that copies of the database can be merged.
+	DeletedObjects []DeletedObject
+
+	meta             *node
+	binaries         [][]byte
+	publicCustomData []byte
+	key              *transformedKey
+}
+
+// NewDatabase returns an empty database with the given name, which
+// is encrypted with ChaCha20 under a key derived with Argon2d, as
+// KeePassXC does by default.
+func NewDatabase(name string) *Database {
+	return &Database{
+		Cipher:   CipherChaCha20,
+		Compress: true,
+		KDF:      DefaultKDFParameters,
+		Name:     name,
+		Root:     NewGroup("Root"),
+	}
+}
+
+// Read reads a KDBX 4 database protected by the given password. Its
+// KDF parameters must be within ReadLimits.
+func Read(r io.Reader, password string) (*Database, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return read(data, password, nil)
+}
+
+// read reads a database, reusing the key derived by a previous read
+// or write if the password and KDF parameters are the same.
+func read(data []byte, password string, cached *transformedKey) (*Database, error) {
+	if len(data) < 12 || binary.LittleEndian.Uint32(data) != signature1 || binary.LittleEndian.Uint32(data[4:]) != signature2 {
+		return nil, errors.New("kdbx: not a KeePass 2 database")
+	}
+	minor, major := binary.LittleEndian.Uint16(data[8:]), binary.LittleEndian.Uint16(data[10:])
+	if major != versionMajor {
+		return nil, fmt.Errorf("kdbx: KDBX version %d.%d is not supported", major, minor)
+	}
+
+	db := &Database{key: cached}
+	var masterSeed, iv []byte
+	compressionFlags := uint32(math.MaxUint32)
+	rest := data[12:]
+	for done := false; !done; {
+		if len(rest) < 5 {
+			return nil, errTruncated
+		}
+		id, length := rest[0], binary.LittleEndian.Uint32(rest[1:])
+		if uint64(len(rest)-5) < uint64(length) {
+			return nil, errTruncated
+		}
+		value := rest[5 : 5+length]
+		rest = rest[5+length:]
+		switch id {
+		case headerEnd:
+			done = true
+		case headerCipherID:
+			db.Cipher = 0
+			for c, uuid := range cipherUUIDs {
+				if bytes.Equal(value, uuid[:]) {
+					db.Cipher = c
+				}
+			}
+			if db.Cipher == 0 {
+				return nil, fmt.Errorf("kdbx: unknown cipher %x", value)
+			}
+		case headerCompressionFlags:
+			if length != 4 {
+				return nil, errors.New("kdbx: invalid compression flags")
+			}
+			compressionFlags = binary.LittleEndian.Uint32(value)
+		case headerMasterSeed:
+			masterSeed = value
+		case headerEncryptionIV:
+			iv = value
+		case headerKDFParameters:
+			if err := db.KDF.unmarshal(value); err != nil {
+				return nil, err
+			}
+		case headerPublicCustomData:
+			db.publicCustomData = value
+		default:
+			return nil, fmt.Errorf("kdbx: unknown header field %d", id)
+		}
+	}
+	header := data[:len(data)-len(rest)]
+
+	switch {
+	case db.Cipher == 0:
+		return nil, errors.New("kdbx: header has no cipher")
+	case compressionFlags != compressionFlagsNone && compressionFlags != compressionFlagsGzip:
+		return nil, fmt.Errorf("kdbx: unknown compression flags %d", compressionFlags)
+	case len(masterSeed) != masterSeedLength:
+		return nil, errors.New("kdbx: invalid master seed")
+	case len(iv) != db.Cipher.ivLength():
+		return nil, errors.New("kdbx: invalid encryption IV")
+	case db.KDF.KDF == 0:
+		return nil, errors.New("kdbx: header has no KDF parameters")
+	}
+	if err := db.KDF.CheckValidity(); err != nil {
+		return nil, fmt.Errorf("kdbx: %v", err)
+	}
+	if err := db.KDF.checkLimits(&ReadLimits); err != nil {
+		return nil, fmt.Errorf("kdbx: %v", err)
+	}
+	db.Compress = compressionFlags == compressionFlagsGzip
+
+	if len(rest) < 2*sha256.Size {
+		return nil, errTruncated
+	}
+	headerHash := sha256.Sum256(header)
+	if !bytes.Equal(headerHash[:], rest[:sha256.Size]) {
+		return nil, errors.New("kdbx: header is corrupt")
+	}
+	key, err := db.transformKey(password)
+	if err != nil {
+		return nil, err
+	}
+	hmacBase := hmacBaseKey(masterSeed, key)
+	if !hmac.Equal(headerHMAC(hmacBase, header), rest[sha256.Size:2*sha256.Size]) {
+		return nil, ErrWrongPassword
+	}
+
+	payload, err := readHMACBlocks(rest[2*sha256.Size:], hmacBase)
+	if err != nil {
+		return nil, err
+	}
+	payload, err = db.Cipher.decrypt(payload, encryptionKey(masterSeed, key), iv)
+	if err != nil {
+		return nil, err
+	}
+	if db.Compress {
+		zr, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("kdbx: %v", err)
+		}
+		if payload, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("kdbx: %v", err)
+		}
+	}
+
+	stream, document, err := db.readInnerHeader(payload)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseXML(document, stream)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.fromXML(root); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Write writes the database, encrypted with a key derived from the
+// given password. If db.KDF.Salt is empty, a random one is
+// generated and kept.
+func (db *Database) Write(w io.Writer, password string) error {
+	if err := db.Cipher.CheckValidity(); err != nil {
+		return err
+	}
+	if err := db.KDF.CheckValidity(); err != nil {
+		return err
+	}
+	if db.Root == nil {
+		return errors.New("Root is nil")
+	}
+	if len(db.KDF.Salt) == 0 {
+		db.KDF.Salt = make([]byte, generatedSaltLength)
+		if _, err := rand.Read(db.KDF.Salt); err != nil {
+			return err
+		}
+	}
+
+	masterSeed := make([]byte, masterSeedLength)
+	iv := make([]byte, db.Cipher.ivLength())
+	streamKey := make([]byte, innerStreamKeyLength)
+	for _, b := range [][]byte{masterSeed, iv, streamKey} {
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+	}
+	stream, err := newInnerStream(streamKey)
+	if err != nil {
+		return err
+	}
+
+	// Serialize the document first, as it may fail.
+	var payload bytes.Buffer
+	payload.Write(appendHeaderField(nil, innerHeaderStreamID, binary.LittleEndian.AppendUint32(nil, innerStreamChaCha20)))
+	payload.Write(appendHeaderField(nil, innerHeaderStreamKey, streamKey))
+	for _, binary := range db.binaries {
+		payload.Write(appendHeaderField(nil, innerHeaderBinary, binary))
+	}
+	payload.Write(appendHeaderField(nil, innerHeaderEnd, nil))
+	if err := writeXML(&payload, db.toXML(), stream); err != nil {
+		return err
+	}
+	plaintext := payload.Bytes()
+	if db.Compress {
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write(plaintext)
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		plaintext = compressed.Bytes()
+	}
+
+	key, err := db.transformKey(password)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := db.Cipher.encrypt(plaintext, encryptionKey(masterSeed, key), iv)
+	if err != nil {
+		return err
+	}
+
+	header := binary.LittleEndian.AppendUint32(nil, signature1)
+	header = binary.LittleEndian.AppendUint32(header, signature2)
+	header = binary.LittleEndian.AppendUint32(header, versionMajor<<16)
+	cipherUUID := cipherUUIDs[db.Cipher]
+	header = appendHeaderField(header, headerCipherID, cipherUUID[:])
+	compressionFlags := uint32(compressionFlagsNone)
+	if db.Compress {
+		compressionFlags = compressionFlagsGzip
+	}
+	header = appendHeaderField(header, headerCompressionFlags, binary.LittleEndian.AppendUint32(nil, compressionFlags))
+	header = appendHeaderField(header, headerMasterSeed, masterSeed)
+	header = appendHeaderField(header, headerEncryptionIV, iv)
+	header = appendHeaderField(header, headerKDFParameters, db.KDF.marshal())
+	if db.publicCustomData != nil {
+		header = appendHeaderField(header, headerPublicCustomData, db.publicCustomData)
+	}
+	header = appendHeaderField(header, headerEnd, []byte("\r\n\r\n"))
+
+	hmacBase := hmacBaseKey(masterSeed, key)
+	headerHash := sha256.Sum256(header)
+	out := append(header, headerHash[:]...)
+	out = append(out, headerHMAC(hmacBase, header)...)
+	out = appendHMACBlocks(out, ciphertext, hmacBase)
+	_, err = w.Write(out)
+	return err
+}
+
+func appendHeaderField(b []byte, id byte, value []byte) []byte {
+	b = append(b, id)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(value)))
+	return append(b, value...)
+}
+
+// readInnerHeader reads the inner header at the start of the
+// decrypted payload, returning the stream that protected values are
+// encrypted with and the XML document that follows.
+func (db *Database) readInnerHeader(payload []byte) (cipher.Stream, []byte, error) {
+	streamID := uint32(0)
+	var streamKey []byte
+	for {
+		if len(payload) < 5 {
+			return nil, nil, errTruncated
+		}
+		id, length := payload[0], binary.LittleEndian.Uint32(payload[1:])
+		if uint64(len(payload)-5) < uint64(length) {
+			return nil, nil, errTruncated
+		}
+		value := payload[5 : 5+length]
+		payload = payload[5+length:]
+		switch id {
+		case innerHeaderEnd:
+			if streamID != innerStreamChaCha20 {
+				return nil, nil, fmt.Errorf("kdbx: inner random stream %d is not supported", streamID)
+			}
+			stream, err := newInnerStream(streamKey)
+			return stream, payload, err
+		case innerHeaderStreamID:
+			if length != 4 {
+				return nil, nil, errors.New("kdbx: invalid inner random stream ID")
+			}
+			streamID = binary.LittleEndian.Uint32(value)
+		case innerHeaderStreamKey:
+			streamKey = value
+		case innerHeaderBinary:
+			if length == 0 {
+				return nil, nil, errors.New("kdbx: invalid binary")
+			}
+			db.binaries = append(db.binaries, value)
+		default:
+			return nil, nil, fmt.Errorf("kdbx: unknown inner header field %d", id)
+		}
+	}
+}
+
+// newInnerStream returns the ChaCha20 stream that protected values
+// are encrypted with.
+func newInnerStream(key []byte) (cipher.Stream, error) {
+	if len(key) == 0 {
+		return nil, errors.New("kdbx: inner random stream has no key")
+	}
+	hash := sha512.Sum512(key)
+	return chacha20.NewUnauthenticatedCipher(hash[:chacha20.KeySize], hash[chacha20.KeySize:chacha20.KeySize+chacha20.NonceSize])
+}
+
+func (c Cipher) ivLength() int {
+	if c == CipherChaCha20 {
+		return chaCha20NonceLength
+	}
+	return aesInitializationLength
+}
+
+func (c Cipher) encrypt(plaintext, key, iv []byte) ([]byte, error) {
+	if c == CipherChaCha20 {
+		stream, err := chacha20.NewUnauthenticatedCipher(key, iv)
+		if err != nil {
+			return nil, err
+		}
+		ciphertext := make([]byte, len(plaintext))
+		stream.XORKeyStream(ciphertext, plaintext)
+		return ciphertext, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	ciphertext := append(append([]byte(nil), plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	return ciphertext, nil
+}
+
+func (c Cipher) decrypt(ciphertext, key, iv []byte) ([]byte, error) {
+	if c == CipherChaCha20 {
+		return c.encrypt(ciphertext, key, iv)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("kdbx: encrypted payload has invalid length")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("kdbx: encrypted payload has invalid padding")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+func encryptionKey(masterSeed, transformedKey []byte) []byte {
+	h := sha256.New()
+	h.Write(masterSeed)
+	h.Write(transformedKey)
+	return h.Sum(nil)
+}
+
+func hmacBaseKey(masterSeed, transformedKey []byte) []byte {
+	h := sha512.New()
+	h.Write(masterSeed)
+	h.Write(transformedKey)
+	h.Write([]byte{1})
+	return h.Sum(nil)
+}
+
+// hmacKey returns the key of the HMAC of the block with the given
+// index.
+func hmacKey(base []byte, index uint64) []byte {
+	h := sha512.New()
+	h.Write(binary.LittleEndian.AppendUint64(nil, index))
+	h.Write(base)
+	return h.Sum(nil)
+}
+
+func headerHMAC(base, header []byte) []byte {
+	mac := hmac.New(sha256.New, hmacKey(base, math.MaxUint64))
+	mac.Write(header)
+	return mac.Sum(nil)
+}
+
+func blockHMAC(base []byte, index uint64, block []byte) []byte {
+	mac := hmac.New(sha256.New, hmacKey(base, index))
+	mac.Write(binary.LittleEndian.AppendUint64(nil, index))
+	mac.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(block))))
+	mac.Write(block)
+	return mac.Sum(nil)
+}
+
+// appendHMACBlocks appends data split into authenticated blocks, as
+// they follow the outer header, to b.
+func appendHMACBlocks(b, data, base []byte) []byte {
+	for index := uint64(0); ; index++ {
+		block := data
+		if len(block) > hmacBlockLength {
+			block = block[:hmacBlockLength]
+		}
+		data = data[len(block):]
+		b = append(b, blockHMAC(base, index, block)...)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(block)))
+		b = append(b, block...)
+		if len(block) == 0 {
+			return b
+		}
+	}
+}
+
+// readHMACBlocks returns the data in the authenticated blocks that
+// follow the outer header.
+func readHMACBlocks(b, base []byte) ([]byte, error) {
+	var data []byte
+	for index := uint64(0); ; index++ {
+		if len(b) < sha256.Size+4 {
+			return nil, errTruncated
+		}
+		mac, length := b[:sha256.Size], binary.LittleEndian.Uint32(b[sha256.Size:])
+		b = b[sha256.Size+4:]
+		if uint64(len(b)) < uint64(length) {
+			return nil, errTruncated
+		}
+		block := b[:length]
+		b = b[length:]
+		if !hmac.Equal(mac, blockHMAC(base, index, block)) {
+			return nil, fmt.Errorf("kdbx: block %d is corrupt", index)
+		}
+		if length == 0 {
+			return data, nil
+		}
+		data = append(data, block...)
+	}
+}
+
+// transformedKey caches the result of the KDF, which is meant to be
+// slow, for a password and the parameters it was derived with.
+type transformedKey struct {
+	composite [sha256.Size]byte
+	kdf       KDFParameters
+	key       []byte
+}
+
+func (db *Database) transformKey(password string) ([]byte, error) {
+	// The composite key hashes the hashes of the password, key
+	// file and hardware key, of which only the first is supported.
+	passwordHash := sha256.Sum256([]byte(password))
+	composite := sha256.Sum256(passwordHash[:])
+	if k := db.key; k != nil && k.composite == composite && k.kdf.equal(&db.KDF) {
+		return k.key, nil
+	}
+	key, err := db.KDF.transform(composite[:])
+	if err != nil {
+		return nil, err
+	}
+	kdf := db.KDF
+	kdf.Salt = append([]byte(nil), kdf.Salt...)
+	db.key = &transformedKey{composite: composite, kdf: kdf, key: key}
+	return key, nil
+}
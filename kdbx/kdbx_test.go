@@ -0,0 +1,279 @@
+package kdbx_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-osxkeychain/kdbx"
+)
+
+// fastKDF makes tests quick; real databases should use
+// kdbx.DefaultKDFParameters or stronger.
+var fastKDF = kdbx.KDFParameters{KDF: kdbx.KDFArgon2d, Iterations: 2, Memory: 64 << 10, Parallelism: 2}
+
+func newTestDatabase() *kdbx.Database {
+	db := kdbx.NewDatabase("Test")
+	db.KDF = fastKDF
+
+	group := kdbx.NewGroup("Servers")
+	entry := kdbx.NewEntry()
+	entry.Set(kdbx.TitleField, "db.example.com")
+	entry.Set(kdbx.UserNameField, "admin")
+	entry.Set(kdbx.PasswordField, "hunter2 & <friends>")
+	entry.Set(kdbx.NotesField, "line 1\nline 2\ttabbed")
+	entry.Fields = append(entry.Fields, kdbx.Field{Key: "TOTP Seed", Value: "JBSWY3DPEHPK3PXP", Protected: true})
+	entry.Set("Environment", "production")
+	entry.Times.Expires = true
+	entry.Times.ExpiryTime = time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	entry.Times.UsageCount = 3
+
+	old := kdbx.NewEntry()
+	old.UUID = entry.UUID
+	old.Set(kdbx.PasswordField, "hunter1")
+	entry.History = []*kdbx.Entry{old}
+
+	group.Entries = append(group.Entries, entry)
+	group.Groups = append(group.Groups, kdbx.NewGroup("Empty"))
+	db.Root.Groups = append(db.Root.Groups, group)
+	db.DeletedObjects = []kdbx.DeletedObject{{UUID: kdbx.NewUUID(), Time: time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)}}
+	return db
+}
+
+func writeAndRead(t *testing.T, db *kdbx.Database, password string) *kdbx.Database {
+	var buf bytes.Buffer
+	if err := db.Write(&buf, password); err != nil {
+		t.Fatal(err)
+	}
+	read, err := kdbx.Read(&buf, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return read
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		cipher   kdbx.Cipher
+		kdf      kdbx.KDF
+		compress bool
+	}{
+		{kdbx.CipherChaCha20, kdbx.KDFArgon2d, true},
+		{kdbx.CipherAES256, kdbx.KDFArgon2id, false},
+		{kdbx.CipherAES256, kdbx.KDFAES, true},
+		{kdbx.CipherChaCha20, kdbx.KDFAES, false},
+	}
+	for _, test := range tests {
+		db := newTestDatabase()
+		db.Cipher = test.cipher
+		if test.kdf == kdbx.KDFAES {
+			db.KDF = kdbx.KDFParameters{KDF: kdbx.KDFAES, Iterations: 1000}
+		} else {
+			db.KDF.KDF = test.kdf
+		}
+		db.Compress = test.compress
+		read := writeAndRead(t, db, "correct horse")
+
+		if read.Cipher != test.cipher || read.KDF.KDF != test.kdf || read.Compress != test.compress {
+			t.Errorf("Expected %v, %v and compression %v, got %v, %v and %v", test.cipher, test.kdf, test.compress, read.Cipher, read.KDF.KDF, read.Compress)
+		}
+		if !reflect.DeepEqual(read.KDF, db.KDF) {
+			t.Errorf("Expected KDF parameters %v, got %v", db.KDF, read.KDF)
+		}
+		if read.Name != "Test" || !reflect.DeepEqual(read.DeletedObjects, db.DeletedObjects) {
+			t.Errorf("Expected name %q and %v, got %q and %v", db.Name, db.DeletedObjects, read.Name, read.DeletedObjects)
+		}
+		if !reflect.DeepEqual(read.Root, db.Root) {
+			t.Errorf("%v/%v: Expected %v, got %v", test.cipher, test.kdf, db.Root, read.Root)
+		}
+	}
+}
+
+func TestRoundTripLargeDatabase(t *testing.T) {
+	// Enough incompressible data to need several HMAC blocks.
+	db := newTestDatabase()
+	db.Compress = false
+	var notes bytes.Buffer
+	for i := 0; notes.Len() < 3<<20; i++ {
+		notes.WriteString(time.Unix(int64(i)*7919, 0).UTC().String())
+	}
+	entry := kdbx.NewEntry()
+	entry.Set(kdbx.NotesField, notes.String())
+	db.Root.Entries = append(db.Root.Entries, entry)
+
+	read := writeAndRead(t, db, "")
+	if notes, _ := read.Root.Entries[0].Get(kdbx.NotesField); len(notes) < 3<<20 {
+		t.Errorf("Expected %d bytes of notes, got %d", 3<<20, len(notes))
+	}
+}
+
+func TestWriteKeepsSalt(t *testing.T) {
+	db := newTestDatabase()
+	writeAndRead(t, db, "pw")
+	if len(db.KDF.Salt) != 32 {
+		t.Fatalf("Expected Write to generate a 32-byte salt, got %x", db.KDF.Salt)
+	}
+	salt := db.KDF.Salt
+	if read := writeAndRead(t, db, "pw"); !bytes.Equal(read.KDF.Salt, salt) {
+		t.Errorf("Expected salt %x, got %x", salt, read.KDF.Salt)
+	}
+}
+
+// TestReadFixtures reads databases written by another implementation
+// of the format, as testdata/README.md describes.
+func TestReadFixtures(t *testing.T) {
+	tests := []struct {
+		name   string
+		cipher kdbx.Cipher
+		kdf    kdbx.KDF
+	}{
+		{"kdbx4-argon2d-chacha20.kdbx", kdbx.CipherChaCha20, kdbx.KDFArgon2d},
+		{"kdbx4-aeskdf-aes.kdbx", kdbx.CipherAES256, kdbx.KDFAES},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", test.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			db, err := kdbx.Read(f, "fixture")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if db.Cipher != test.cipher || db.KDF.KDF != test.kdf {
+				t.Errorf("Expected %v and %v, got %v and %v", test.cipher, test.kdf, db.Cipher, db.KDF.KDF)
+			}
+			if len(db.Root.Entries) != 1 {
+				t.Fatalf("Expected 1 entry, got %d", len(db.Root.Entries))
+			}
+			entry := db.Root.Entries[0]
+			for key, expected := range map[string]string{
+				kdbx.TitleField:    "api.example.com",
+				kdbx.UserNameField: "alice",
+				kdbx.PasswordField: "s3cret",
+			} {
+				if value, _ := entry.Get(key); value != expected {
+					t.Errorf("Expected %s %q, got %q", key, expected, value)
+				}
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newTestDatabase().Write(&buf, "right"); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if _, err := kdbx.Read(bytes.NewReader(data), "wrong"); err != kdbx.ErrWrongPassword {
+		t.Errorf("Expected %v, got %v", kdbx.ErrWrongPassword, err)
+	}
+
+	corrupt := func(offset int) []byte {
+		c := append([]byte(nil), data...)
+		c[offset] ^= 1
+		return c
+	}
+	version3 := append([]byte(nil), data...)
+	version3[10] = 3
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("not a database"), "kdbx: not a KeePass 2 database"},
+		{version3, "kdbx: KDBX version 3.0 is not supported"},
+		{data[:100], "kdbx: file is truncated"},
+		{data[:len(data)-1], "kdbx: file is truncated"},
+		{corrupt(50), "kdbx: header is corrupt"},
+		{corrupt(len(data) - 50), "kdbx: block 0 is corrupt"},
+	}
+	for _, test := range tests {
+		if _, err := kdbx.Read(bytes.NewReader(test.data), "right"); err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+}
+
+func TestReadLimits(t *testing.T) {
+	defer func(limits kdbx.KDFLimits) { kdbx.ReadLimits = limits }(kdbx.ReadLimits)
+	tests := []struct {
+		kdf      kdbx.KDFParameters
+		limits   kdbx.KDFLimits
+		expected string
+	}{
+		{fastKDF, kdbx.KDFLimits{Argon2Iterations: 1, Argon2Memory: 1 << 30, Argon2Parallelism: 64}, "kdbx: Argon2 iterations 2 exceed the limit of 1"},
+		{fastKDF, kdbx.KDFLimits{Argon2Iterations: 1000, Argon2Memory: 32 << 10, Argon2Parallelism: 64}, "kdbx: Argon2 memory of 65536 bytes exceeds the limit of 32768"},
+		{fastKDF, kdbx.KDFLimits{Argon2Iterations: 1000, Argon2Memory: 1 << 30, Argon2Parallelism: 1}, "kdbx: Argon2 parallelism 2 exceeds the limit of 1"},
+		{kdbx.KDFParameters{KDF: kdbx.KDFAES, Iterations: 1000}, kdbx.KDFLimits{AESRounds: 999}, "kdbx: AES-KDF rounds 1000 exceed the limit of 999"},
+	}
+	for _, test := range tests {
+		db := newTestDatabase()
+		db.KDF = test.kdf
+		var buf bytes.Buffer
+		if err := db.Write(&buf, "pw"); err != nil {
+			t.Fatal(err)
+		}
+		kdbx.ReadLimits = test.limits
+		if _, err := kdbx.Read(&buf, "pw"); err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	tests := []struct {
+		modify   func(db *kdbx.Database)
+		expected string
+	}{
+		{func(db *kdbx.Database) { db.Cipher = 7 }, "Cipher has unknown value 7"},
+		{func(db *kdbx.Database) { db.KDF.KDF = 0 }, "KDF has unknown value 0"},
+		{func(db *kdbx.Database) { db.KDF.Iterations = 0 }, "Iterations must be between 1 and 2^32-1 for Argon2"},
+		{func(db *kdbx.Database) { db.KDF.Parallelism = 0 }, "Parallelism must be between 1 and 2^24-1"},
+		{func(db *kdbx.Database) { db.KDF.Memory = 1000 }, "Memory must be a multiple of 1024 bytes, at least 8 KiB per lane and at most 4 TiB"},
+		{func(db *kdbx.Database) { db.KDF.Salt = []byte("salt") }, "Salt must be at least 8 bytes long for Argon2"},
+		{func(db *kdbx.Database) { db.KDF = kdbx.KDFParameters{KDF: kdbx.KDFAES, Salt: []byte("salt")} }, "Salt must be 32 bytes long for AES-KDF"},
+		{func(db *kdbx.Database) { db.Root = nil }, "Root is nil"},
+		{func(db *kdbx.Database) { db.Root.Name = "bell\a" }, "kdbx: <Name> has character U+0007, which XML can't represent"},
+		{func(db *kdbx.Database) { db.Root.Name = "\xff" }, "kdbx: <Name> is not valid UTF-8"},
+	}
+	for _, test := range tests {
+		db := newTestDatabase()
+		test.modify(db)
+		if err := db.Write(&bytes.Buffer{}, "pw"); err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+
+	// Protected values are stored in base64, so can hold anything.
+	db := newTestDatabase()
+	db.Root.Groups[0].Entries[0].Set(kdbx.PasswordField, "bell\a")
+	if err := db.Write(&bytes.Buffer{}, "pw"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEntryFields(t *testing.T) {
+	entry := kdbx.NewEntry()
+	if _, ok := entry.Get(kdbx.TitleField); ok {
+		t.Error("Expected a new entry to have no title")
+	}
+	entry.Set(kdbx.TitleField, "title")
+	entry.Set(kdbx.PasswordField, "secret")
+	entry.Set(kdbx.TitleField, "new title")
+	expected := []kdbx.Field{
+		{Key: kdbx.TitleField, Value: "new title"},
+		{Key: kdbx.PasswordField, Value: "secret", Protected: true},
+	}
+	if !reflect.DeepEqual(entry.Fields, expected) {
+		t.Errorf("Expected %v, got %v", expected, entry.Fields)
+	}
+	if len(entry.UUID.String()) != 32 || entry.UUID == kdbx.NewUUID() {
+		t.Errorf("Unexpected UUID %v", entry.UUID)
+	}
+}
@@ -0,0 +1,367 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// KDF is the function a database's key is derived from its password
+// with.
+type KDF int
+
+const (
+	KDFArgon2d KDF = iota + 1
+	KDFArgon2id
+	// KDFAES is the AES-KDF of KDBX 3 databases.
+	KDFAES
+)
+
+var kdfUUIDs = map[KDF]UUID{
+	KDFArgon2d:  {0xef, 0x63, 0x6d, 0xdf, 0x8c, 0x29, 0x44, 0x4b, 0x91, 0xf7, 0xa9, 0xa4, 0x03, 0xe3, 0x0a, 0x0c},
+	KDFArgon2id: {0x9e, 0x29, 0x8b, 0x19, 0x56, 0xdb, 0x47, 0x73, 0xb2, 0x3d, 0xfc, 0x3e, 0xc6, 0xf0, 0xa1, 0xe6},
+	KDFAES:      {0xc9, 0xd9, 0xf3, 0x9a, 0x62, 0x8a, 0x44, 0x60, 0xbf, 0x74, 0x0d, 0x08, 0xc1, 0x8a, 0x4f, 0xea},
+}
+
+func (k KDF) String() string {
+	switch k {
+	case KDFArgon2d:
+		return "Argon2d"
+	case KDFArgon2id:
+		return "Argon2id"
+	case KDFAES:
+		return "AES-KDF"
+	}
+	return fmt.Sprintf("KDF(%d)", int(k))
+}
+
+// KDFParameters are the parameters of a database's KDF.
+type KDFParameters struct {
+	KDF KDF
+	// Iterations is the number of passes Argon2 makes over its
+	// memory, or the number of rounds of AES-KDF.
+	Iterations uint64
+	// Memory is the number of bytes of memory Argon2 uses, a
+	// multiple of 1024. It's ignored by AES-KDF.
+	Memory uint64
+	// Parallelism is the number of lanes Argon2 fills in parallel.
+	// It's ignored by AES-KDF.
+	Parallelism uint32
+	// Salt is the salt of Argon2, or the seed of AES-KDF, which
+	// must be 32 bytes long. If empty, Database.Write generates one.
+	Salt []byte
+}
+
+// DefaultKDFParameters are the parameters of NewDatabase, which take
+// about a second to derive a key with.
+var DefaultKDFParameters = KDFParameters{
+	KDF:         KDFArgon2d,
+	Iterations:  5,
+	Memory:      64 << 20,
+	Parallelism: 2,
+}
+
+// KDFLimits bound the KDF parameters of the databases Read reads.
+type KDFLimits struct {
+	Argon2Iterations  uint64
+	Argon2Memory      uint64
+	Argon2Parallelism uint32
+	AESRounds         uint64
+}
+
+// ReadLimits are the KDFLimits of Read. A database's header isn't
+// authenticated until its key is derived, so without them a crafted
+// file could make Read allocate terabytes or run for days before
+// finding the password wrong. They're far above what KeePass and
+// KeePassXC choose, but far below what CheckValidity allows; a
+// program reading databases it trusts may raise them.
+var ReadLimits = KDFLimits{
+	Argon2Iterations:  1000,
+	Argon2Memory:      1 << 30,
+	Argon2Parallelism: 64,
+	AESRounds:         1 << 28,
+}
+
+const (
+	generatedSaltLength  = 32
+	argon2MinSaltLength  = 8
+	argon2MaxParallelism = 1<<24 - 1
+	aesKDFSeedLength     = 32
+)
+
+// CheckValidity returns an error if any of the given KDFParameters
+// are invalid. Otherwise, it returns nil.
+func (p *KDFParameters) CheckValidity() error {
+	switch p.KDF {
+	case KDFArgon2d, KDFArgon2id:
+		if p.Iterations < 1 || p.Iterations > math.MaxUint32 {
+			return errors.New("Iterations must be between 1 and 2^32-1 for Argon2")
+		}
+		if p.Parallelism < 1 || p.Parallelism > argon2MaxParallelism {
+			return errors.New("Parallelism must be between 1 and 2^24-1")
+		}
+		if p.Memory%1024 != 0 || p.Memory/1024 < 8*uint64(p.Parallelism) || p.Memory/1024 > math.MaxUint32 {
+			return errors.New("Memory must be a multiple of 1024 bytes, at least 8 KiB per lane and at most 4 TiB")
+		}
+		if len(p.Salt) != 0 && len(p.Salt) < argon2MinSaltLength {
+			return errors.New("Salt must be at least 8 bytes long for Argon2")
+		}
+	case KDFAES:
+		if len(p.Salt) != 0 && len(p.Salt) != aesKDFSeedLength {
+			return errors.New("Salt must be 32 bytes long for AES-KDF")
+		}
+	default:
+		return fmt.Errorf("KDF has unknown value %d", int(p.KDF))
+	}
+	return nil
+}
+
+// checkLimits returns an error if the given KDFParameters exceed
+// limits. Otherwise, it returns nil.
+func (p *KDFParameters) checkLimits(limits *KDFLimits) error {
+	switch p.KDF {
+	case KDFArgon2d, KDFArgon2id:
+		if p.Iterations > limits.Argon2Iterations {
+			return fmt.Errorf("Argon2 iterations %d exceed the limit of %d", p.Iterations, limits.Argon2Iterations)
+		}
+		if p.Memory > limits.Argon2Memory {
+			return fmt.Errorf("Argon2 memory of %d bytes exceeds the limit of %d", p.Memory, limits.Argon2Memory)
+		}
+		if p.Parallelism > limits.Argon2Parallelism {
+			return fmt.Errorf("Argon2 parallelism %d exceeds the limit of %d", p.Parallelism, limits.Argon2Parallelism)
+		}
+	case KDFAES:
+		if p.Iterations > limits.AESRounds {
+			return fmt.Errorf("AES-KDF rounds %d exceed the limit of %d", p.Iterations, limits.AESRounds)
+		}
+	}
+	return nil
+}
+
+func (p *KDFParameters) equal(q *KDFParameters) bool {
+	return p.KDF == q.KDF && p.Iterations == q.Iterations && p.Memory == q.Memory &&
+		p.Parallelism == q.Parallelism && bytes.Equal(p.Salt, q.Salt)
+}
+
+// transform derives the key of a database from its composite key.
+func (p *KDFParameters) transform(composite []byte) ([]byte, error) {
+	switch p.KDF {
+	case KDFArgon2d, KDFArgon2id:
+		typ := argon2d
+		if p.KDF == KDFArgon2id {
+			typ = argon2id
+		}
+		params := &argon2Params{
+			typ:         typ,
+			iterations:  uint32(p.Iterations),
+			memory:      uint32(p.Memory / 1024),
+			parallelism: p.Parallelism,
+		}
+		return argon2Key(params, composite, p.Salt, sha256.Size), nil
+	case KDFAES:
+		block, err := aes.NewCipher(p.Salt)
+		if err != nil {
+			return nil, err
+		}
+		key := append([]byte(nil), composite...)
+		for i := uint64(0); i < p.Iterations; i++ {
+			block.Encrypt(key[:aes.BlockSize], key[:aes.BlockSize])
+			block.Encrypt(key[aes.BlockSize:], key[aes.BlockSize:])
+		}
+		hash := sha256.Sum256(key)
+		return hash[:], nil
+	}
+	return nil, p.CheckValidity()
+}
+
+// Keys of the KDF parameters' variant dictionary.
+const (
+	kdfParameterUUID        = "$UUID"
+	kdfParameterSalt        = "S"
+	kdfParameterParallelism = "P"
+	kdfParameterMemory      = "M"
+	kdfParameterIterations  = "I"
+	kdfParameterVersion     = "V"
+	kdfParameterRounds      = "R"
+)
+
+func (p *KDFParameters) marshal() []byte {
+	uuid := kdfUUIDs[p.KDF]
+	if p.KDF == KDFAES {
+		return marshalVariantDictionary([]variant{
+			{kdfParameterUUID, uuid[:]},
+			{kdfParameterRounds, p.Iterations},
+			{kdfParameterSalt, p.Salt},
+		})
+	}
+	return marshalVariantDictionary([]variant{
+		{kdfParameterUUID, uuid[:]},
+		{kdfParameterSalt, p.Salt},
+		{kdfParameterParallelism, p.Parallelism},
+		{kdfParameterMemory, p.Memory},
+		{kdfParameterIterations, p.Iterations},
+		{kdfParameterVersion, uint32(argon2Version)},
+	})
+}
+
+func (p *KDFParameters) unmarshal(data []byte) error {
+	d, err := unmarshalVariantDictionary(data)
+	if err != nil {
+		return err
+	}
+	var ok bool
+	uuid, _ := d[kdfParameterUUID].([]byte)
+	for kdf, kdfUUID := range kdfUUIDs {
+		if bytes.Equal(uuid, kdfUUID[:]) {
+			p.KDF = kdf
+		}
+	}
+	switch p.KDF {
+	case KDFArgon2d, KDFArgon2id:
+		var version uint32
+		if version, ok = d[kdfParameterVersion].(uint32); ok && version != argon2Version {
+			return fmt.Errorf("kdbx: Argon2 version %#x is not supported", version)
+		}
+		if ok {
+			p.Salt, ok = d[kdfParameterSalt].([]byte)
+		}
+		if ok {
+			p.Parallelism, ok = d[kdfParameterParallelism].(uint32)
+		}
+		if ok {
+			p.Memory, ok = d[kdfParameterMemory].(uint64)
+		}
+		if ok {
+			p.Iterations, ok = d[kdfParameterIterations].(uint64)
+		}
+	case KDFAES:
+		if p.Iterations, ok = d[kdfParameterRounds].(uint64); ok {
+			p.Salt, ok = d[kdfParameterSalt].([]byte)
+		}
+	default:
+		return fmt.Errorf("kdbx: unknown KDF %x", uuid)
+	}
+	if !ok {
+		return fmt.Errorf("kdbx: %v parameters are missing or invalid", p.KDF)
+	}
+	return nil
+}
+
+// variant is an entry of a variant dictionary, whose value is a
+// uint32, uint64, bool, int32, int64, string or []byte.
+type variant struct {
+	key   string
+	value interface{}
+}
+
+// Types of variant dictionary values.
+const (
+	variantEnd       = 0x00
+	variantUint32    = 0x04
+	variantUint64    = 0x05
+	variantBool      = 0x08
+	variantInt32     = 0x0C
+	variantInt64     = 0x0D
+	variantString    = 0x18
+	variantByteArray = 0x42
+
+	variantDictionaryVersion      = 0x0100
+	variantDictionaryCriticalMask = 0xFF00
+)
+
+func marshalVariantDictionary(entries []variant) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, variantDictionaryVersion)
+	for _, entry := range entries {
+		var typ byte
+		var value []byte
+		switch v := entry.value.(type) {
+		case uint32:
+			typ, value = variantUint32, binary.LittleEndian.AppendUint32(nil, v)
+		case uint64:
+			typ, value = variantUint64, binary.LittleEndian.AppendUint64(nil, v)
+		case bool:
+			typ, value = variantBool, []byte{0}
+			if v {
+				value[0] = 1
+			}
+		case int32:
+			typ, value = variantInt32, binary.LittleEndian.AppendUint32(nil, uint32(v))
+		case int64:
+			typ, value = variantInt64, binary.LittleEndian.AppendUint64(nil, uint64(v))
+		case string:
+			typ, value = variantString, []byte(v)
+		case []byte:
+			typ, value = variantByteArray, v
+		default:
+			panic(fmt.Sprintf("kdbx: %T can't be stored in a variant dictionary", v))
+		}
+		b = append(b, typ)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(entry.key)))
+		b = append(b, entry.key...)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(value)))
+		b = append(b, value...)
+	}
+	return append(b, variantEnd)
+}
+
+func unmarshalVariantDictionary(b []byte) (map[string]interface{}, error) {
+	invalid := errors.New("kdbx: invalid variant dictionary")
+	if len(b) < 2 {
+		return nil, invalid
+	}
+	if version := binary.LittleEndian.Uint16(b); version&variantDictionaryCriticalMask > variantDictionaryVersion&variantDictionaryCriticalMask {
+		return nil, fmt.Errorf("kdbx: variant dictionary version %#x is not supported", version)
+	}
+	b = b[2:]
+	// next returns the next length-prefixed field.
+	next := func() ([]byte, bool) {
+		if len(b) < 4 || uint64(len(b)-4) < uint64(binary.LittleEndian.Uint32(b)) {
+			return nil, false
+		}
+		field := b[4 : 4+binary.LittleEndian.Uint32(b)]
+		b = b[4+len(field):]
+		return field, true
+	}
+
+	d := make(map[string]interface{})
+	for {
+		if len(b) < 1 {
+			return nil, invalid
+		}
+		typ := b[0]
+		b = b[1:]
+		if typ == variantEnd {
+			return d, nil
+		}
+		key, ok := next()
+		if !ok {
+			return nil, invalid
+		}
+		value, ok := next()
+		if !ok {
+			return nil, invalid
+		}
+		switch {
+		case typ == variantUint32 && len(value) == 4:
+			d[string(key)] = binary.LittleEndian.Uint32(value)
+		case typ == variantUint64 && len(value) == 8:
+			d[string(key)] = binary.LittleEndian.Uint64(value)
+		case typ == variantBool && len(value) == 1:
+			d[string(key)] = value[0] != 0
+		case typ == variantInt32 && len(value) == 4:
+			d[string(key)] = int32(binary.LittleEndian.Uint32(value))
+		case typ == variantInt64 && len(value) == 8:
+			d[string(key)] = int64(binary.LittleEndian.Uint64(value))
+		case typ == variantString:
+			d[string(key)] = string(value)
+		case typ == variantByteArray:
+			d[string(key)] = value
+		default:
+			return nil, invalid
+		}
+	}
+}
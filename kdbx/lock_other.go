@@ -0,0 +1,10 @@
+//go:build !unix
+
+package kdbx
+
+// lockFile does nothing on platforms without flock, so writers in
+// other processes are only caught by FileStore's check that the file
+// hasn't changed.
+func lockFile(path string) (unlock func(), err error) {
+	return func() {}, nil
+}
@@ -0,0 +1,434 @@
+#!/usr/bin/env python3
+"""Writes the KDBX 4 fixtures that TestReadFixtures reads.
+
+This is a second implementation of the format, independent of the Go
+package, so the fixtures catch mistakes that a database written and
+read by the same code would hide. It uses only the standard library:
+Argon2d, AES and ChaCha20 are implemented below and checked against the
+test vectors of RFC 9106, FIPS 197 and RFC 8439 before anything is
+written. Its output is deterministic, so running it again reproduces
+the committed files.
+
+    python3 mkfixtures.py
+"""
+
+import base64
+import gzip
+import hashlib
+import hmac
+import os
+import struct
+
+PASSWORD = b"fixture"
+
+CIPHER_AES256 = bytes.fromhex("31c1f2e6bf714350be5805216afc5aff")
+CIPHER_CHACHA20 = bytes.fromhex("d6038a2b8b6f4cb5a524339a31dbb59a")
+KDF_ARGON2D = bytes.fromhex("ef636ddf8c29444b91f7a9a403e30a0c")
+KDF_AES = bytes.fromhex("c9d9f39a628a4460bf740d08c18a4fea")
+
+MASK64 = (1 << 64) - 1
+
+
+# Argon2d, as RFC 9106 specifies it.
+
+def blake2b_long(data, length):
+    data = struct.pack("<I", length) + data
+    if length <= 64:
+        return hashlib.blake2b(data, digest_size=length).digest()
+    r = (length + 31) // 32 - 2
+    v = hashlib.blake2b(data).digest()
+    out = v[:32]
+    for _ in range(r - 1):
+        v = hashlib.blake2b(v).digest()
+        out += v[:32]
+    return out + hashlib.blake2b(v, digest_size=length - 32 * r).digest()
+
+
+def gb(v, a, b, c, d):
+    def fblamka(x, y):
+        return (x + y + 2 * (x & 0xFFFFFFFF) * (y & 0xFFFFFFFF)) & MASK64
+
+    def rotr(x, n):
+        return ((x >> n) | (x << (64 - n))) & MASK64
+
+    v[a] = fblamka(v[a], v[b])
+    v[d] = rotr(v[d] ^ v[a], 32)
+    v[c] = fblamka(v[c], v[d])
+    v[b] = rotr(v[b] ^ v[c], 24)
+    v[a] = fblamka(v[a], v[b])
+    v[d] = rotr(v[d] ^ v[a], 16)
+    v[c] = fblamka(v[c], v[d])
+    v[b] = rotr(v[b] ^ v[c], 63)
+
+
+def permute(v):
+    gb(v, 0, 4, 8, 12)
+    gb(v, 1, 5, 9, 13)
+    gb(v, 2, 6, 10, 14)
+    gb(v, 3, 7, 11, 15)
+    gb(v, 0, 5, 10, 15)
+    gb(v, 1, 6, 11, 12)
+    gb(v, 2, 7, 8, 13)
+    gb(v, 3, 4, 9, 14)
+
+
+def compress(x, y):
+    r = [a ^ b for a, b in zip(x, y)]
+    q = list(r)
+    for row in range(8):
+        v = q[16 * row:16 * row + 16]
+        permute(v)
+        q[16 * row:16 * row + 16] = v
+    for column in range(8):
+        indices = [16 * i + 2 * column + j for i in range(8) for j in range(2)]
+        v = [q[i] for i in indices]
+        permute(v)
+        for i, word in zip(indices, v):
+            q[i] = word
+    return [a ^ b for a, b in zip(q, r)]
+
+
+def to_block(data):
+    return list(struct.unpack("<128Q", data))
+
+
+def argon2d(password, salt, time_cost, memory_kib, lanes, length, secret=b"", data=b""):
+    h0 = hashlib.blake2b(
+        struct.pack("<6I", lanes, length, memory_kib, time_cost, 0x13, 0)
+        + struct.pack("<I", len(password)) + password
+        + struct.pack("<I", len(salt)) + salt
+        + struct.pack("<I", len(secret)) + secret
+        + struct.pack("<I", len(data)) + data
+    ).digest()
+    columns = (memory_kib // (4 * lanes)) * 4
+    segment = columns // 4
+    memory = [[None] * columns for _ in range(lanes)]
+    for lane in range(lanes):
+        for i in range(2):
+            memory[lane][i] = to_block(blake2b_long(h0 + struct.pack("<II", i, lane), 1024))
+    for t in range(time_cost):
+        for s in range(4):
+            for lane in range(lanes):
+                for index in range(segment):
+                    j = s * segment + index
+                    if t == 0 and j < 2:
+                        continue
+                    prev = memory[lane][j - 1 if j > 0 else columns - 1]
+                    j1, j2 = prev[0] & 0xFFFFFFFF, prev[0] >> 32
+                    ref_lane = lane if t == 0 and s == 0 else j2 % lanes
+                    same = ref_lane == lane
+                    if t == 0:
+                        area = s * segment + index - 1 if same else s * segment - (1 if index == 0 else 0)
+                        start = 0
+                    else:
+                        area = columns - segment + index - 1 if same else columns - segment - (1 if index == 0 else 0)
+                        start = ((s + 1) * segment) % columns
+                    x = (j1 * j1) >> 32
+                    y = (area * x) >> 32
+                    ref = memory[ref_lane][(start + area - 1 - y) % columns]
+                    block = compress(prev, ref)
+                    if t > 0:
+                        block = [a ^ b for a, b in zip(block, memory[lane][j])]
+                    memory[lane][j] = block
+    final = memory[0][columns - 1]
+    for lane in range(1, lanes):
+        final = [a ^ b for a, b in zip(final, memory[lane][columns - 1])]
+    return blake2b_long(struct.pack("<128Q", *final), length)
+
+
+# AES-256 encryption, as FIPS 197 specifies it.
+
+def _sbox():
+    sbox = [0] * 256
+    p = q = 1
+    while True:
+        p = p ^ ((p << 1) & 0xFF) ^ (0x1B if p & 0x80 else 0)
+        q ^= q << 1
+        q ^= q << 2
+        q ^= q << 4
+        q &= 0xFF
+        if q & 0x80:
+            q ^= 0x09
+        rotl = lambda x, n: ((x << n) | (x >> (8 - n))) & 0xFF
+        sbox[p] = q ^ rotl(q, 1) ^ rotl(q, 2) ^ rotl(q, 3) ^ rotl(q, 4) ^ 0x63
+        if p == 1:
+            break
+    sbox[0] = 0x63
+    return sbox
+
+
+SBOX = _sbox()
+
+
+def xtime(b):
+    return ((b << 1) ^ (0x1B if b & 0x80 else 0)) & 0xFF
+
+
+def aes256_expand(key):
+    words = [list(key[i:i + 4]) for i in range(0, 32, 4)]
+    rcon = 1
+    for i in range(8, 60):
+        w = list(words[i - 1])
+        if i % 8 == 0:
+            w = [SBOX[b] for b in w[1:] + w[:1]]
+            w[0] ^= rcon
+            rcon = xtime(rcon)
+        elif i % 8 == 4:
+            w = [SBOX[b] for b in w]
+        words.append([a ^ b for a, b in zip(words[i - 8], w)])
+    return [sum(words[4 * r:4 * r + 4], []) for r in range(15)]
+
+
+def aes_encrypt_block(round_keys, block):
+    s = [a ^ b for a, b in zip(block, round_keys[0])]
+    for r in range(1, 15):
+        s = [SBOX[b] for b in s]
+        s = [s[(i + 4 * (i % 4)) % 16] for i in range(16)]
+        if r < 14:
+            mixed = []
+            for c in range(4):
+                a = s[4 * c:4 * c + 4]
+                t = a[0] ^ a[1] ^ a[2] ^ a[3]
+                mixed += [a[i] ^ t ^ xtime(a[i] ^ a[(i + 1) % 4]) for i in range(4)]
+            s = mixed
+        s = [a ^ b for a, b in zip(s, round_keys[r])]
+    return bytes(s)
+
+
+def aes256_cbc_encrypt(key, iv, plaintext):
+    round_keys = aes256_expand(key)
+    padding = 16 - len(plaintext) % 16
+    plaintext += bytes([padding]) * padding
+    out, prev = b"", iv
+    for i in range(0, len(plaintext), 16):
+        prev = aes_encrypt_block(round_keys, bytes(a ^ b for a, b in zip(plaintext[i:i + 16], prev)))
+        out += prev
+    return out
+
+
+def aes_kdf(composite, seed, rounds):
+    round_keys = aes256_expand(seed)
+    halves = [composite[:16], composite[16:]]
+    for _ in range(rounds):
+        halves = [aes_encrypt_block(round_keys, h) for h in halves]
+    return hashlib.sha256(halves[0] + halves[1]).digest()
+
+
+# ChaCha20, as RFC 8439 specifies it.
+
+def chacha20_block(key, counter, nonce):
+    def rotl(x, n):
+        return ((x << n) | (x >> (32 - n))) & 0xFFFFFFFF
+
+    def quarter(v, a, b, c, d):
+        v[a] = (v[a] + v[b]) & 0xFFFFFFFF
+        v[d] = rotl(v[d] ^ v[a], 16)
+        v[c] = (v[c] + v[d]) & 0xFFFFFFFF
+        v[b] = rotl(v[b] ^ v[c], 12)
+        v[a] = (v[a] + v[b]) & 0xFFFFFFFF
+        v[d] = rotl(v[d] ^ v[a], 8)
+        v[c] = (v[c] + v[d]) & 0xFFFFFFFF
+        v[b] = rotl(v[b] ^ v[c], 7)
+
+    state = [0x61707865, 0x3320646E, 0x79622D32, 0x6B206574]
+    state += list(struct.unpack("<8I", key)) + [counter] + list(struct.unpack("<3I", nonce))
+    v = list(state)
+    for _ in range(10):
+        quarter(v, 0, 4, 8, 12)
+        quarter(v, 1, 5, 9, 13)
+        quarter(v, 2, 6, 10, 14)
+        quarter(v, 3, 7, 11, 15)
+        quarter(v, 0, 5, 10, 15)
+        quarter(v, 1, 6, 11, 12)
+        quarter(v, 2, 7, 8, 13)
+        quarter(v, 3, 4, 9, 14)
+    return struct.pack("<16I", *[(a + b) & 0xFFFFFFFF for a, b in zip(v, state)])
+
+
+class ChaCha20:
+    def __init__(self, key, nonce, counter=0):
+        self.key, self.nonce, self.counter = key, nonce, counter
+        self.buffer = b""
+
+    def xor(self, data):
+        while len(self.buffer) < len(data):
+            self.buffer += chacha20_block(self.key, self.counter, self.nonce)
+            self.counter += 1
+        keystream, self.buffer = self.buffer[:len(data)], self.buffer[len(data):]
+        return bytes(a ^ b for a, b in zip(data, keystream))
+
+
+def check_vectors():
+    argon2 = argon2d(b"\x01" * 32, b"\x02" * 16, 3, 32, 4, 32, b"\x03" * 8, b"\x04" * 12)
+    assert argon2.hex() == "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb", argon2.hex()
+    aes = aes_encrypt_block(aes256_expand(bytes(range(32))), bytes.fromhex("00112233445566778899aabbccddeeff"))
+    assert aes.hex() == "8ea2b7ca516745bfeafc49904b496089", aes.hex()
+    chacha = chacha20_block(bytes(range(32)), 1, bytes.fromhex("000000090000004a00000000"))
+    assert chacha[:16].hex() == "10f1e7e4d13b5915500fdd1fa32071c4", chacha.hex()
+
+
+# KDBX 4.
+
+def variant_dictionary(items):
+    out = struct.pack("<H", 0x0100)
+    for key, kind, value in items:
+        key = key.encode()
+        out += bytes([kind]) + struct.pack("<I", len(key)) + key + struct.pack("<I", len(value)) + value
+    return out + b"\x00"
+
+
+def field(id, value):
+    return bytes([id]) + struct.pack("<I", len(value)) + value
+
+
+def hmac_key(base, index):
+    return hashlib.sha512(struct.pack("<Q", index) + base).digest()
+
+
+def kdbx_time(year, month, day, hour, minute, second):
+    # KDBX 4 stores times as base64 seconds since 0001-01-01.
+    import datetime
+    delta = datetime.datetime(year, month, day, hour, minute, second) - datetime.datetime(1, 1, 1)
+    return base64.b64encode(struct.pack("<q", delta.days * 86400 + delta.seconds)).decode()
+
+
+def document(protect):
+    created = kdbx_time(2023, 5, 6, 7, 8, 9)
+    times = f"""<Times>
+					<LastModificationTime>{created}</LastModificationTime>
+					<CreationTime>{created}</CreationTime>
+					<LastAccessTime>{created}</LastAccessTime>
+					<ExpiryTime>{created}</ExpiryTime>
+					<Expires>False</Expires>
+					<UsageCount>0</UsageCount>
+					<LocationChanged>{created}</LocationChanged>
+				</Times>"""
+    return f"""<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<KeePassFile>
+	<Meta>
+		<Generator>mkfixtures.py</Generator>
+		<DatabaseName>Fixture</DatabaseName>
+		<DatabaseNameChanged>{created}</DatabaseNameChanged>
+		<DatabaseDescription/>
+		<MemoryProtection>
+			<ProtectTitle>False</ProtectTitle>
+			<ProtectUserName>False</ProtectUserName>
+			<ProtectPassword>True</ProtectPassword>
+			<ProtectURL>False</ProtectURL>
+			<ProtectNotes>False</ProtectNotes>
+		</MemoryProtection>
+		<RecycleBinEnabled>True</RecycleBinEnabled>
+		<RecycleBinUUID>AAAAAAAAAAAAAAAAAAAAAA==</RecycleBinUUID>
+		<HistoryMaxItems>10</HistoryMaxItems>
+		<HistoryMaxSize>6291456</HistoryMaxSize>
+		<CustomData/>
+	</Meta>
+	<Root>
+		<Group>
+			<UUID>{base64.b64encode(bytes(range(16))).decode()}</UUID>
+			<Name>Root</Name>
+			<Notes/>
+			<IconID>48</IconID>
+			{times.replace(chr(10) + chr(9), chr(10))}
+			<IsExpanded>True</IsExpanded>
+			<Entry>
+				<UUID>{base64.b64encode(bytes(range(16, 32))).decode()}</UUID>
+				<IconID>0</IconID>
+				{times}
+				<String>
+					<Key>Notes</Key>
+					<Value/>
+				</String>
+				<String>
+					<Key>Password</Key>
+					<Value Protected="True">{base64.b64encode(protect(b"s3cret")).decode()}</Value>
+				</String>
+				<String>
+					<Key>Title</Key>
+					<Value>api.example.com</Value>
+				</String>
+				<String>
+					<Key>URL</Key>
+					<Value/>
+				</String>
+				<String>
+					<Key>UserName</Key>
+					<Value>alice</Value>
+				</String>
+				<AutoType>
+					<Enabled>True</Enabled>
+					<DataTransferObfuscation>0</DataTransferObfuscation>
+				</AutoType>
+				<History/>
+			</Entry>
+		</Group>
+		<DeletedObjects/>
+	</Root>
+</KeePassFile>
+""".encode()
+
+
+def write(name, cipher, kdf, seed):
+    # The random values of a database are derived from the file name,
+    # so the output is the same every time.
+    def random(label, length):
+        return hashlib.sha512(f"{seed}/{label}".encode()).digest()[:length]
+
+    master_seed = random("master seed", 32)
+    salt = random("salt", 32)
+    stream_key = random("stream key", 64)
+    composite = hashlib.sha256(hashlib.sha256(PASSWORD).digest()).digest()
+    if kdf == KDF_ARGON2D:
+        parameters = variant_dictionary([
+            ("$UUID", 0x42, kdf),
+            ("I", 0x05, struct.pack("<Q", 2)),
+            ("M", 0x05, struct.pack("<Q", 64 * 1024)),
+            ("P", 0x04, struct.pack("<I", 1)),
+            ("S", 0x42, salt),
+            ("V", 0x04, struct.pack("<I", 0x13)),
+        ])
+        transformed = argon2d(composite, salt, 2, 64, 1, 32)
+    else:
+        parameters = variant_dictionary([
+            ("$UUID", 0x42, kdf),
+            ("R", 0x05, struct.pack("<Q", 1000)),
+            ("S", 0x42, salt),
+        ])
+        transformed = aes_kdf(composite, salt, 1000)
+    iv = random("iv", 12 if cipher == CIPHER_CHACHA20 else 16)
+
+    header = struct.pack("<III", 0x9AA2D903, 0xB54BFB67, 4 << 16)
+    header += field(2, cipher)
+    header += field(3, struct.pack("<I", 1))
+    header += field(4, master_seed)
+    header += field(7, iv)
+    header += field(11, parameters)
+    header += field(0, b"\r\n\r\n")
+
+    inner_hash = hashlib.sha512(stream_key).digest()
+    inner = ChaCha20(inner_hash[:32], inner_hash[32:44])
+    payload = field(1, struct.pack("<I", 3)) + field(2, stream_key) + field(0, b"")
+    payload += document(inner.xor)
+    payload = gzip.compress(payload, mtime=0)
+
+    key = hashlib.sha256(master_seed + transformed).digest()
+    if cipher == CIPHER_CHACHA20:
+        ciphertext = ChaCha20(key, iv).xor(payload)
+    else:
+        ciphertext = aes256_cbc_encrypt(key, iv, payload)
+
+    hmac_base = hashlib.sha512(master_seed + transformed + b"\x01").digest()
+    out = header + hashlib.sha256(header).digest()
+    out += hmac.new(hmac_key(hmac_base, 2**64 - 1), header, hashlib.sha256).digest()
+    for index, block in enumerate([ciphertext, b""]):
+        length = struct.pack("<I", len(block))
+        mac = hmac.new(hmac_key(hmac_base, index), struct.pack("<Q", index) + length + block, hashlib.sha256)
+        out += mac.digest() + length + block
+    with open(os.path.join(os.path.dirname(os.path.abspath(__file__)), name), "wb") as f:
+        f.write(out)
+
+
+if __name__ == "__main__":
+    check_vectors()
+    write("kdbx4-argon2d-chacha20.kdbx", CIPHER_CHACHA20, KDF_ARGON2D, "argon2d-chacha20")
+    write("kdbx4-aeskdf-aes.kdbx", CIPHER_AES256, KDF_AES, "aeskdf-aes")
@@ -0,0 +1,275 @@
+package kdbx
+
+import (
+	"bytes"
+	"encoding/base64"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// BinaryPasswordField is the key of the protected custom field that
+// holds, in base64, a password that KeePass can't hold as text, for
+// example because it isn't valid UTF-8. The entry's Password is left
+// empty.
+const BinaryPasswordField = "Binary Password"
+
+// EntryAttributes returns the generic password an entry holds: its
+// title is the service name, its UserName the account name and its
+// Password, or BinaryPasswordField if it has one, the password.
+func EntryAttributes(e *Entry) *osxkeychain.GenericPasswordAttributes {
+	serviceName, _ := e.Get(TitleField)
+	accountName, _ := e.Get(UserNameField)
+	password, _ := e.Get(PasswordField)
+	attributes := &osxkeychain.GenericPasswordAttributes{
+		ServiceName: serviceName,
+		AccountName: accountName,
+		Password:    []byte(password),
+	}
+	if encoded, ok := e.Get(BinaryPasswordField); ok {
+		if binary, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			attributes.Password = binary
+		}
+	}
+	return attributes
+}
+
+// SetEntryAttributes sets the title, UserName and Password of an
+// entry from the given attributes, as EntryAttributes reads them.
+// Other attributes, such as TrustedApplications, only mean something
+// in a keychain and are ignored.
+func SetEntryAttributes(e *Entry, attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	e.Set(TitleField, attributes.ServiceName)
+	e.Set(UserNameField, attributes.AccountName)
+	e.Remove(BinaryPasswordField)
+	if checkXMLText(PasswordField, string(attributes.Password)) == nil {
+		e.Set(PasswordField, string(attributes.Password))
+	} else {
+		e.Set(PasswordField, "")
+		e.Fields = append(e.Fields, Field{
+			Key:       BinaryPasswordField,
+			Value:     base64.StdEncoding.EncodeToString(attributes.Password),
+			Protected: true,
+		})
+	}
+	return nil
+}
+
+// Export copies the generic passwords with the given service names
+// from a Store to a database. Each service gets a group named after
+// it under the root group, holding an entry for each account, as
+// SetEntryAttributes sets it. An entry already in the database for
+// the same service and account, in any group, has its password
+// updated, with its previous version kept in its History.
+//
+// Export stops at the first item that fails, returning an
+// *osxkeychain.MigrationError along with the items exported so far,
+// whose Password is left empty. Since a Store takes an empty name as
+// matching any, an empty service name, or an empty account name of a
+// service with other accounts, fails with
+// osxkeychain.ErrMigrationEmptyName. The database isn't written: call
+// its Write method to save it.
+func Export(db *Database, store osxkeychain.Store, serviceNames []string) (exported []osxkeychain.GenericPasswordAttributes, err error) {
+	for _, serviceName := range serviceNames {
+		accountNames, err := store.GetAllAccountNames(serviceName)
+		if err != nil {
+			return exported, &osxkeychain.MigrationError{ServiceName: serviceName, Err: err}
+		}
+		for _, accountName := range accountNames {
+			attributes := osxkeychain.GenericPasswordAttributes{
+				ServiceName: serviceName,
+				AccountName: accountName,
+			}
+			if err := exportGenericPassword(db, store, attributes); err != nil {
+				return exported, &osxkeychain.MigrationError{ServiceName: serviceName, AccountName: accountName, Err: err}
+			}
+			exported = append(exported, attributes)
+		}
+	}
+	return exported, nil
+}
+
+func exportGenericPassword(db *Database, store osxkeychain.Store, attributes osxkeychain.GenericPasswordAttributes) error {
+	if err := checkEmptyNames(store, &attributes); err != nil {
+		return err
+	}
+	password, err := store.FindGenericPassword(&attributes)
+	if err != nil {
+		return err
+	}
+	item := attributes
+	item.Password = password
+
+	group, i := db.findEntry(attributes.ServiceName, attributes.AccountName)
+	if group == nil {
+		return db.addEntry(&item)
+	}
+	if bytes.Equal(EntryAttributes(group.Entries[i]).Password, password) {
+		return nil
+	}
+	return updateEntry(group.Entries[i], &item)
+}
+
+// Import copies the generic passwords held by the entries of a
+// database, as EntryAttributes reads them, to a Store. Entries in
+// every group are imported, except those in KeePassXC's recycle bin.
+// An item already in the Store with the same password is left alone;
+// one with a different password is replaced if overwrite is set.
+//
+// Import stops at the first entry that fails, returning an
+// *osxkeychain.MigrationError along with the items imported so far,
+// whose Password is left empty. If overwrite isn't set, the Err of
+// an item that exists with a different password is
+// osxkeychain.ErrMigrationMismatch. Since a Store takes an empty name
+// as matching any, an entry with an empty title, or with an empty user
+// name whose service has other accounts in the Store, fails with
+// osxkeychain.ErrMigrationEmptyName if it's already there.
+func Import(store osxkeychain.Store, db *Database, overwrite bool) (imported []osxkeychain.GenericPasswordAttributes, err error) {
+	db.forEachEntry(func(g *Group, i int) bool {
+		attributes := EntryAttributes(g.Entries[i])
+		if err = importGenericPassword(store, attributes, overwrite); err != nil {
+			err = &osxkeychain.MigrationError{ServiceName: attributes.ServiceName, AccountName: attributes.AccountName, Err: err}
+			return false
+		}
+		imported = append(imported, osxkeychain.GenericPasswordAttributes{
+			ServiceName: attributes.ServiceName,
+			AccountName: attributes.AccountName,
+		})
+		return true
+	})
+	return imported, err
+}
+
+func importGenericPassword(store osxkeychain.Store, attributes *osxkeychain.GenericPasswordAttributes, overwrite bool) error {
+	err := store.AddGenericPassword(attributes)
+	if err != osxkeychain.ErrDuplicateItem {
+		return err
+	}
+	if err := checkEmptyNames(store, attributes); err != nil {
+		return err
+	}
+	existing, err := store.FindGenericPassword(attributes)
+	if err != nil || bytes.Equal(existing, attributes.Password) {
+		return err
+	}
+	if !overwrite {
+		return osxkeychain.ErrMigrationMismatch
+	}
+	return store.RemoveAndAddGenericPassword(attributes)
+}
+
+// checkEmptyNames returns osxkeychain.ErrMigrationEmptyName if finding
+// an item with the names of attributes in store could match another
+// item: if the service name is empty, or if the account name is and
+// the service has other accounts.
+func checkEmptyNames(store osxkeychain.Store, attributes *osxkeychain.GenericPasswordAttributes) error {
+	if attributes.ServiceName == "" {
+		return osxkeychain.ErrMigrationEmptyName
+	}
+	if attributes.AccountName != "" {
+		return nil
+	}
+	accountNames, err := store.GetAllAccountNames(attributes.ServiceName)
+	if err != nil {
+		return err
+	}
+	for _, accountName := range accountNames {
+		if accountName != "" {
+			return osxkeychain.ErrMigrationEmptyName
+		}
+	}
+	return nil
+}
+
+// serviceGroup returns the group named after a service under the
+// root group, adding it if needed.
+func (db *Database) serviceGroup(serviceName string) *Group {
+	for _, g := range db.Root.Groups {
+		if g.Name == serviceName {
+			return g
+		}
+	}
+	g := NewGroup(serviceName)
+	db.Root.Groups = append(db.Root.Groups, g)
+	return g
+}
+
+// addEntry adds an entry for a generic password to the group named
+// after its service.
+func (db *Database) addEntry(attributes *osxkeychain.GenericPasswordAttributes) error {
+	entry := NewEntry()
+	if err := SetEntryAttributes(entry, attributes); err != nil {
+		return err
+	}
+	group := db.serviceGroup(attributes.ServiceName)
+	group.Entries = append(group.Entries, entry)
+	return nil
+}
+
+// updateEntry sets an entry's password, keeping its previous version
+// in its History.
+func updateEntry(entry *Entry, attributes *osxkeychain.GenericPasswordAttributes) error {
+	previous := entry.snapshot()
+	if err := SetEntryAttributes(entry, attributes); err != nil {
+		return err
+	}
+	entry.History = append(entry.History, previous)
+	entry.Times.Modified = time.Now().UTC().Truncate(time.Second)
+	return nil
+}
+
+// forEachEntry calls f with each entry's group and index in it,
+// depth first, until f returns false. Entries in KeePassXC's recycle
+// bin are skipped.
+func (db *Database) forEachEntry(f func(g *Group, i int) bool) {
+	var recycleBin UUID
+	if enabled := db.meta.child("RecycleBinEnabled"); enabled != nil && enabled.text == "True" {
+		recycleBin, _ = uuidFromXML(db.meta.child("RecycleBinUUID"))
+	}
+	var walk func(g *Group) bool
+	walk = func(g *Group) bool {
+		if g.UUID == recycleBin && g != db.Root {
+			return true
+		}
+		for i := range g.Entries {
+			if !f(g, i) {
+				return false
+			}
+		}
+		for _, subgroup := range g.Groups {
+			if !walk(subgroup) {
+				return false
+			}
+		}
+		return true
+	}
+	walk(db.Root)
+}
+
+// findEntry returns the group holding the entry for a generic
+// password, as EntryAttributes reads it, and its index in the group,
+// or a nil group if there's none.
+func (db *Database) findEntry(serviceName, accountName string) (group *Group, index int) {
+	return db.matchEntry(serviceName, accountName, false)
+}
+
+// matchEntry is findEntry, except that if wildcard is set, an empty
+// serviceName or accountName matches any, as in the keychain, and
+// the first matching entry is returned.
+func (db *Database) matchEntry(serviceName, accountName string, wildcard bool) (group *Group, index int) {
+	matches := func(name, query string) bool {
+		return name == query || wildcard && query == ""
+	}
+	db.forEachEntry(func(g *Group, i int) bool {
+		attributes := EntryAttributes(g.Entries[i])
+		if matches(attributes.ServiceName, serviceName) && matches(attributes.AccountName, accountName) {
+			group, index = g, i
+			return false
+		}
+		return true
+	})
+	return group, index
+}
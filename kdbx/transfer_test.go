@@ -0,0 +1,183 @@
+package kdbx_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/kdbx"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+func addItem(t *testing.T, store osxkeychain.Store, serviceName, accountName, password string) {
+	err := store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{
+		ServiceName: serviceName,
+		AccountName: accountName,
+		Password:    []byte(password),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	from := &storetest.MemStore{}
+	addItem(t, from, "api.example.com", "alice", "s3cret")
+	addItem(t, from, "api.example.com", "bob", "🔑 unicode")
+	addItem(t, from, "db.example.com", "binary", "\x00\xff")
+	addItem(t, from, "cache.example.com", "", "")
+	addItem(t, from, "unexported", "carol", "x")
+
+	db := kdbx.NewDatabase("Keychain")
+	db.KDF = fastKDF
+	exported, err := kdbx.Export(db, from, []string{"api.example.com", "db.example.com", "cache.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 4 || exported[0].Password != nil {
+		t.Errorf("Unexpected exported items %v", exported)
+	}
+	if len(db.Root.Groups) != 3 || db.Root.Groups[0].Name != "api.example.com" || len(db.Root.Groups[0].Entries) != 2 {
+		t.Fatalf("Expected a group per service, got %v", db.Root.Groups)
+	}
+
+	to := &storetest.MemStore{}
+	imported, err := kdbx.Import(to, writeAndRead(t, db, "pw"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imported, exported) {
+		t.Errorf("Expected %v, got %v", exported, imported)
+	}
+	expected := from.Items()[:4]
+	if items := to.Items(); !reflect.DeepEqual(items, expected) {
+		t.Errorf("Expected %v, got %v", expected, items)
+	}
+}
+
+func TestExportEmptyAccountName(t *testing.T) {
+	store := &storetest.MemStore{}
+	addItem(t, store, "db.example.com", "prod", "s3cret")
+	addItem(t, store, "db.example.com", "", "no account")
+
+	// Finding the item without an account could find prod instead.
+	db := kdbx.NewDatabase("")
+	_, err := kdbx.Export(db, store, []string{"db.example.com"})
+	var migrationErr *osxkeychain.MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.AccountName != "" || migrationErr.Err != osxkeychain.ErrMigrationEmptyName {
+		t.Errorf("Expected ErrMigrationEmptyName for the empty account, got %v", err)
+	}
+
+	// Nor can an entry without a user name be compared with the one
+	// in the Store, or replace it.
+	entry := kdbx.NewEntry()
+	kdbx.SetEntryAttributes(entry, &osxkeychain.GenericPasswordAttributes{ServiceName: "db.example.com", Password: []byte("from db")})
+	db.Root.Entries = append(db.Root.Entries, entry)
+	if _, err := kdbx.Import(store, db, true); !errors.As(err, &migrationErr) || migrationErr.Err != osxkeychain.ErrMigrationEmptyName {
+		t.Errorf("Expected ErrMigrationEmptyName for the entry, got %v", err)
+	}
+	password, _ := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "db.example.com", AccountName: "prod"})
+	if string(password) != "s3cret" {
+		t.Errorf("Expected prod to be left alone, got %q", password)
+	}
+}
+
+func TestExportUpdatesEntries(t *testing.T) {
+	store := &storetest.MemStore{}
+	addItem(t, store, "svc", "acct", "first")
+	db := kdbx.NewDatabase("")
+	if _, err := kdbx.Export(db, store, []string{"svc"}); err != nil {
+		t.Fatal(err)
+	}
+	entry := db.Root.Groups[0].Entries[0]
+	entry.Set("Custom", "kept")
+
+	// Exporting again without changes leaves the entry alone.
+	if _, err := kdbx.Export(db, store, []string{"svc"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.History) != 0 {
+		t.Errorf("Expected no history, got %v", entry.History)
+	}
+
+	store.UpdateGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("second")})
+	if _, err := kdbx.Export(db, store, []string{"svc"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Root.Groups) != 1 || len(db.Root.Groups[0].Entries) != 1 {
+		t.Fatalf("Expected the entry to be updated in place, got %v", db.Root.Groups)
+	}
+	if password, _ := entry.Get(kdbx.PasswordField); password != "second" {
+		t.Errorf("Expected the new password, got %q", password)
+	}
+	if custom, _ := entry.Get("Custom"); custom != "kept" {
+		t.Errorf("Expected custom fields to be kept, got %q", custom)
+	}
+	if len(entry.History) != 1 {
+		t.Fatalf("Expected one previous version, got %v", entry.History)
+	}
+	if password, _ := entry.History[0].Get(kdbx.PasswordField); password != "first" {
+		t.Errorf("Expected the old password in the history, got %q", password)
+	}
+}
+
+func TestBinaryPassword(t *testing.T) {
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "svc", Password: []byte("\x00\xff binary")}
+	entry := kdbx.NewEntry()
+	if err := kdbx.SetEntryAttributes(entry, attributes); err != nil {
+		t.Fatal(err)
+	}
+	expected := []kdbx.Field{
+		{Key: kdbx.TitleField, Value: "svc"},
+		{Key: kdbx.UserNameField},
+		{Key: kdbx.PasswordField, Protected: true},
+		{Key: kdbx.BinaryPasswordField, Value: "AP8gYmluYXJ5", Protected: true},
+	}
+	if !reflect.DeepEqual(entry.Fields, expected) {
+		t.Errorf("Expected %v, got %v", expected, entry.Fields)
+	}
+	if decoded := kdbx.EntryAttributes(entry); !reflect.DeepEqual(decoded, attributes) {
+		t.Errorf("Expected %v, got %v", attributes, decoded)
+	}
+
+	// Setting a text password removes the binary one.
+	attributes.Password = []byte("text")
+	kdbx.SetEntryAttributes(entry, attributes)
+	if _, ok := entry.Get(kdbx.BinaryPasswordField); ok || !reflect.DeepEqual(kdbx.EntryAttributes(entry), attributes) {
+		t.Errorf("Expected a text password, got %v", entry.Fields)
+	}
+}
+
+func TestImportExistingItems(t *testing.T) {
+	db := kdbx.NewDatabase("")
+	for _, names := range [][2]string{{"same", "from db"}, {"different", "from db"}} {
+		entry := kdbx.NewEntry()
+		kdbx.SetEntryAttributes(entry, &osxkeychain.GenericPasswordAttributes{
+			ServiceName: names[0],
+			Password:    []byte(names[1]),
+		})
+		db.Root.Entries = append(db.Root.Entries, entry)
+	}
+
+	store := &storetest.MemStore{}
+	addItem(t, store, "same", "", "from db")
+	addItem(t, store, "different", "", "from store")
+
+	imported, err := kdbx.Import(store, db, false)
+	var migrationErr *osxkeychain.MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.ServiceName != "different" || migrationErr.Err != osxkeychain.ErrMigrationMismatch {
+		t.Errorf("Expected a mismatch for different, got %v", err)
+	}
+	if len(imported) != 1 || imported[0].ServiceName != "same" {
+		t.Errorf("Unexpected imported items %v", imported)
+	}
+
+	if _, err := kdbx.Import(store, db, true); err != nil {
+		t.Fatal(err)
+	}
+	password, _ := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "different"})
+	if string(password) != "from db" {
+		t.Errorf("Expected the item to be overwritten, got %q", password)
+	}
+}
@@ -0,0 +1,552 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// UUID identifies a group or entry.
+type UUID [16]byte
+
+// NewUUID returns a random UUID.
+func NewUUID() UUID {
+	var uuid UUID
+	if _, err := rand.Read(uuid[:]); err != nil {
+		panic(err)
+	}
+	uuid[6] = uuid[6]&0x0F | 0x40
+	uuid[8] = uuid[8]&0x3F | 0x80
+	return uuid
+}
+
+// String returns the UUID in hexadecimal, as KeePassXC shows it.
+func (u UUID) String() string {
+	return hex.EncodeToString(u[:])
+}
+
+// Times records when a group or entry was changed.
+type Times struct {
+	Created  time.Time
+	Modified time.Time
+	Accessed time.Time
+	// LocationChanged is when the group or entry was last moved to
+	// another group.
+	LocationChanged time.Time
+	Expires         bool
+	ExpiryTime      time.Time
+	UsageCount      int64
+}
+
+func newTimes() Times {
+	now := time.Now().UTC().Truncate(time.Second)
+	return Times{Created: now, Modified: now, Accessed: now, LocationChanged: now}
+}
+
+// Group is a folder of entries and other groups.
+type Group struct {
+	UUID    UUID
+	Name    string
+	Times   Times
+	Entries []*Entry
+	Groups  []*Group
+
+	extra []*node
+}
+
+// NewGroup returns an empty group with the given name and a new UUID,
+// created now.
+func NewGroup(name string) *Group {
+	return &Group{UUID: NewUUID(), Name: name, Times: newTimes()}
+}
+
+// Keys of the fields every entry has.
+const (
+	TitleField    = "Title"
+	UserNameField = "UserName"
+	PasswordField = "Password"
+	URLField      = "URL"
+	NotesField    = "Notes"
+)
+
+// Field is a string field of an entry. Fields other than the five
+// above are custom fields.
+type Field struct {
+	Key   string
+	Value string
+	// Protected is whether the value is encrypted in the database
+	// file, and hidden by KeePassXC, as passwords are by default.
+	Protected bool
+}
+
+// Entry is a KeePass entry, usually holding a password.
+type Entry struct {
+	UUID   UUID
+	Times  Times
+	Fields []Field
+	// History holds previous versions of the entry, oldest first.
+	History []*Entry
+
+	extra []*node
+}
+
+// NewEntry returns an entry with a new UUID, created now.
+func NewEntry() *Entry {
+	return &Entry{UUID: NewUUID(), Times: newTimes()}
+}
+
+// Get returns the value of the field with the given key, and whether
+// the entry has one.
+func (e *Entry) Get(key string) (string, bool) {
+	for _, field := range e.Fields {
+		if field.Key == key {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set sets the value of the field with the given key, adding the
+// field if the entry doesn't have one. A new field is protected if
+// it's the PasswordField.
+func (e *Entry) Set(key, value string) {
+	for i := range e.Fields {
+		if e.Fields[i].Key == key {
+			e.Fields[i].Value = value
+			return
+		}
+	}
+	e.Fields = append(e.Fields, Field{Key: key, Value: value, Protected: key == PasswordField})
+}
+
+// Remove removes the field with the given key, if the entry has one.
+func (e *Entry) Remove(key string) {
+	for i := range e.Fields {
+		if e.Fields[i].Key == key {
+			e.Fields = append(e.Fields[:i], e.Fields[i+1:]...)
+			return
+		}
+	}
+}
+
+// snapshot returns a copy of the entry's current version, to be
+// added to its History.
+func (e *Entry) snapshot() *Entry {
+	return &Entry{UUID: e.UUID, Times: e.Times, Fields: append([]Field(nil), e.Fields...), extra: e.extra}
+}
+
+// DeletedObject records that the group or entry with the given UUID
+// was deleted.
+type DeletedObject struct {
+	UUID UUID
+	Time time.Time
+}
+
+// node is an element of the XML document. Text is the decrypted
+// value of an element with a Protected="True" attribute, and is
+// ignored for elements with children.
+type node struct {
+	name     string
+	attrs    []xml.Attr
+	text     string
+	children []*node
+}
+
+func (n *node) attr(name string) string {
+	for _, attr := range n.attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func (n *node) protected() bool {
+	return n.attr("Protected") == "True"
+}
+
+func (n *node) child(name string) *node {
+	if n == nil {
+		return nil
+	}
+	for _, child := range n.children {
+		if child.name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+func (n *node) add(name, text string) *node {
+	child := &node{name: name, text: text}
+	n.children = append(n.children, child)
+	return child
+}
+
+// parseXML parses the XML document of a database, decrypting
+// protected values with stream in the order they appear.
+func parseXML(document []byte, stream cipher.Stream) (*node, error) {
+	d := xml.NewDecoder(bytes.NewReader(document))
+	var root *node
+	var stack []*node
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("kdbx: %v", err)
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			n := &node{name: token.Name.Local, attrs: token.Copy().Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			} else {
+				return nil, errors.New("kdbx: XML document has several root elements")
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if n.protected() {
+				value, err := base64.StdEncoding.DecodeString(n.text)
+				if err != nil {
+					return nil, fmt.Errorf("kdbx: protected value of <%s> is invalid: %v", n.name, err)
+				}
+				stream.XORKeyStream(value, value)
+				n.text = string(value)
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(token)
+			}
+		}
+	}
+	if root == nil || root.name != "KeePassFile" {
+		return nil, errors.New("kdbx: XML document is not a KeePassFile")
+	}
+	return root, nil
+}
+
+// writeXML writes the XML document of a database, encrypting
+// protected values with stream in the order they appear.
+func writeXML(w *bytes.Buffer, root *node, stream cipher.Stream) error {
+	w.WriteString(`<?xml version="1.0" encoding="utf-8" standalone="yes"?>` + "\n")
+	return root.write(w, 0, stream)
+}
+
+func (n *node) write(w *bytes.Buffer, depth int, stream cipher.Stream) error {
+	indent := bytes.Repeat([]byte{'\t'}, depth)
+	w.Write(indent)
+	w.WriteString("<" + n.name)
+	for _, attr := range n.attrs {
+		w.WriteString(" " + attr.Name.Local + `="`)
+		xml.EscapeText(w, []byte(attr.Value))
+		w.WriteString(`"`)
+	}
+	if len(n.children) > 0 {
+		w.WriteString(">\n")
+		for _, child := range n.children {
+			if err := child.write(w, depth+1, stream); err != nil {
+				return err
+			}
+		}
+		w.Write(indent)
+	} else {
+		w.WriteString(">")
+		if n.protected() {
+			value := []byte(n.text)
+			stream.XORKeyStream(value, value)
+			w.WriteString(base64.StdEncoding.EncodeToString(value))
+		} else {
+			if err := checkXMLText(n.name, n.text); err != nil {
+				return err
+			}
+			xml.EscapeText(w, []byte(n.text))
+		}
+	}
+	w.WriteString("</" + n.name + ">\n")
+	return nil
+}
+
+// checkXMLText returns an error if s can't be represented in XML, as
+// xml.EscapeText would silently replace the offending characters.
+func checkXMLText(name, s string) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("kdbx: <%s> is not valid UTF-8", name)
+	}
+	for _, r := range s {
+		if !(r == '\t' || r == '\n' || r == '\r' || r >= 0x20 && r <= 0xD7FF || r >= 0xE000 && r <= 0xFFFD || r >= 0x10000 && r <= 0x10FFFF) {
+			return fmt.Errorf("kdbx: <%s> has character %U, which XML can't represent", name, r)
+		}
+	}
+	return nil
+}
+
+// fromXML sets the contents of db from the XML document.
+func (db *Database) fromXML(file *node) error {
+	db.meta = file.child("Meta")
+	if db.meta == nil {
+		db.meta = &node{name: "Meta"}
+	}
+	if name := db.meta.child("DatabaseName"); name != nil {
+		db.Name = name.text
+	}
+	root := file.child("Root")
+	if root == nil || root.child("Group") == nil {
+		return errors.New("kdbx: XML document has no root group")
+	}
+	var err error
+	if db.Root, err = groupFromXML(root.child("Group")); err != nil {
+		return err
+	}
+	if deleted := root.child("DeletedObjects"); deleted != nil {
+		for _, n := range deleted.children {
+			var object DeletedObject
+			if object.UUID, err = uuidFromXML(n.child("UUID")); err != nil {
+				return err
+			}
+			if object.Time, err = timeFromXML(n.child("DeletionTime")); err != nil {
+				return err
+			}
+			db.DeletedObjects = append(db.DeletedObjects, object)
+		}
+	}
+	return nil
+}
+
+// toXML returns the XML document of db.
+func (db *Database) toXML() *node {
+	meta := &node{name: "Meta"}
+	if db.meta != nil {
+		meta.children = append(meta.children, db.meta.children...)
+	} else {
+		meta.add("Generator", "osxkeychain")
+		protection := meta.add("MemoryProtection", "")
+		for _, field := range []string{TitleField, UserNameField, PasswordField, URLField, NotesField} {
+			value := "False"
+			if field == PasswordField {
+				value = "True"
+			}
+			protection.add("Protect"+field, value)
+		}
+	}
+	if name := meta.child("DatabaseName"); name != nil {
+		*name = node{name: "DatabaseName", text: db.Name}
+	} else {
+		meta.add("DatabaseName", db.Name)
+	}
+
+	file := &node{name: "KeePassFile", children: []*node{meta}}
+	root := file.add("Root", "")
+	root.children = append(root.children, db.Root.toXML())
+	deleted := root.add("DeletedObjects", "")
+	for _, object := range db.DeletedObjects {
+		n := deleted.add("DeletedObject", "")
+		n.add("UUID", uuidToXML(object.UUID))
+		n.add("DeletionTime", timeToXML(object.Time))
+	}
+	return file
+}
+
+func groupFromXML(n *node) (*Group, error) {
+	g := &Group{}
+	var err error
+	for _, child := range n.children {
+		switch child.name {
+		case "UUID":
+			g.UUID, err = uuidFromXML(child)
+		case "Name":
+			g.Name = child.text
+		case "Times":
+			g.Times, err = timesFromXML(child)
+		case "Entry":
+			var e *Entry
+			if e, err = entryFromXML(child); err == nil {
+				g.Entries = append(g.Entries, e)
+			}
+		case "Group":
+			var subgroup *Group
+			if subgroup, err = groupFromXML(child); err == nil {
+				g.Groups = append(g.Groups, subgroup)
+			}
+		default:
+			g.extra = append(g.extra, child)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+func (g *Group) toXML() *node {
+	n := &node{name: "Group"}
+	n.add("UUID", uuidToXML(g.UUID))
+	n.add("Name", g.Name)
+	n.children = append(n.children, g.Times.toXML())
+	n.children = append(n.children, g.extra...)
+	for _, e := range g.Entries {
+		n.children = append(n.children, e.toXML())
+	}
+	for _, subgroup := range g.Groups {
+		n.children = append(n.children, subgroup.toXML())
+	}
+	return n
+}
+
+func entryFromXML(n *node) (*Entry, error) {
+	e := &Entry{}
+	var err error
+	for _, child := range n.children {
+		switch child.name {
+		case "UUID":
+			e.UUID, err = uuidFromXML(child)
+		case "Times":
+			e.Times, err = timesFromXML(child)
+		case "String":
+			key, value := child.child("Key"), child.child("Value")
+			if key == nil || value == nil {
+				return nil, errors.New("kdbx: <String> needs a <Key> and a <Value>")
+			}
+			e.Fields = append(e.Fields, Field{Key: key.text, Value: value.text, Protected: value.protected()})
+		case "History":
+			for _, old := range child.children {
+				var previous *Entry
+				if previous, err = entryFromXML(old); err != nil {
+					break
+				}
+				e.History = append(e.History, previous)
+			}
+		default:
+			e.extra = append(e.extra, child)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *Entry) toXML() *node {
+	n := &node{name: "Entry"}
+	n.add("UUID", uuidToXML(e.UUID))
+	n.children = append(n.children, e.extra...)
+	n.children = append(n.children, e.Times.toXML())
+	for _, field := range e.Fields {
+		s := n.add("String", "")
+		s.add("Key", field.Key)
+		value := s.add("Value", field.Value)
+		if field.Protected {
+			value.attrs = []xml.Attr{{Name: xml.Name{Local: "Protected"}, Value: "True"}}
+		}
+	}
+	if len(e.History) > 0 {
+		history := n.add("History", "")
+		for _, previous := range e.History {
+			history.children = append(history.children, previous.toXML())
+		}
+	}
+	return n
+}
+
+func timesFromXML(n *node) (Times, error) {
+	var t Times
+	var err error
+	for _, child := range n.children {
+		switch child.name {
+		case "CreationTime":
+			t.Created, err = timeFromXML(child)
+		case "LastModificationTime":
+			t.Modified, err = timeFromXML(child)
+		case "LastAccessTime":
+			t.Accessed, err = timeFromXML(child)
+		case "LocationChanged":
+			t.LocationChanged, err = timeFromXML(child)
+		case "ExpiryTime":
+			t.ExpiryTime, err = timeFromXML(child)
+		case "Expires":
+			t.Expires = child.text == "True"
+		case "UsageCount":
+			t.UsageCount, err = strconv.ParseInt(child.text, 10, 64)
+		}
+		if err != nil {
+			return Times{}, fmt.Errorf("kdbx: invalid <%s> %q", child.name, child.text)
+		}
+	}
+	return t, nil
+}
+
+func (t *Times) toXML() *node {
+	n := &node{name: "Times"}
+	n.add("CreationTime", timeToXML(t.Created))
+	n.add("LastModificationTime", timeToXML(t.Modified))
+	n.add("LastAccessTime", timeToXML(t.Accessed))
+	n.add("ExpiryTime", timeToXML(t.ExpiryTime))
+	n.add("Expires", boolToXML(t.Expires))
+	n.add("UsageCount", strconv.FormatInt(t.UsageCount, 10))
+	n.add("LocationChanged", timeToXML(t.LocationChanged))
+	return n
+}
+
+func uuidFromXML(n *node) (UUID, error) {
+	var uuid UUID
+	if n == nil {
+		return uuid, errors.New("kdbx: <UUID> is missing")
+	}
+	b, err := base64.StdEncoding.DecodeString(n.text)
+	if err != nil || len(b) != len(uuid) {
+		return uuid, fmt.Errorf("kdbx: invalid <UUID> %q", n.text)
+	}
+	copy(uuid[:], b)
+	return uuid, nil
+}
+
+func uuidToXML(uuid UUID) string {
+	return base64.StdEncoding.EncodeToString(uuid[:])
+}
+
+// yearOneOffset is the number of seconds between the start of year 1,
+// from which KDBX 4 counts time, and the Unix epoch.
+const yearOneOffset = 62135596800
+
+// timeFromXML parses a time, which KDBX 4 stores as the base64 of the
+// number of seconds since year 1 but KDBX 3 stored as text.
+func timeFromXML(n *node) (time.Time, error) {
+	if n == nil {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, n.text); err == nil {
+		return t.UTC(), nil
+	}
+	b, err := base64.StdEncoding.DecodeString(n.text)
+	if err != nil || len(b) != 8 {
+		return time.Time{}, fmt.Errorf("kdbx: invalid <%s> %q", n.name, n.text)
+	}
+	return time.Unix(int64(binary.LittleEndian.Uint64(b))-yearOneOffset, 0).UTC(), nil
+}
+
+func timeToXML(t time.Time) string {
+	return base64.StdEncoding.EncodeToString(binary.LittleEndian.AppendUint64(nil, uint64(t.Unix()+yearOneOffset)))
+}
+
+func boolToXML(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
+}
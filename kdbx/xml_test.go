@@ -0,0 +1,142 @@
+package kdbx
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+// plainStream leaves protected values as they are, so that fixtures
+// can hold them in plaintext base64.
+type plainStream struct{}
+
+func (plainStream) XORKeyStream(dst, src []byte) {
+	copy(dst, src)
+}
+
+// readXMLFixture reads the database in an XML fixture written as
+// KeePassXC would, but without protecting its values.
+func readXMLFixture(t *testing.T, name string) *Database {
+	document, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := parseXML(document, plainStream{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := NewDatabase("")
+	if err := db.fromXML(root); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestReadXML(t *testing.T) {
+	db := readXMLFixture(t, "keepassxc.xml")
+	if db.Name != "Passwords" || db.Root.Name != "Root" || len(db.Root.Groups) != 2 || len(db.DeletedObjects) != 1 {
+		t.Fatalf("Unexpected database %v", db)
+	}
+	if created := time.Date(2019, time.October, 7, 12, 30, 45, 0, time.UTC); db.Root.Times.Created != created {
+		t.Errorf("Expected a KDBX 3 time of %v, got %v", created, db.Root.Times.Created)
+	}
+
+	entry := db.Root.Entries[0]
+	expected := []Field{
+		{Key: TitleField, Value: "api.example.com"},
+		{Key: UserNameField, Value: "alice"},
+		{Key: PasswordField, Value: "s3cret", Protected: true},
+		{Key: "Recovery Code", Value: "1234-5678", Protected: true},
+	}
+	if !reflect.DeepEqual(entry.Fields, expected) {
+		t.Errorf("Expected %v, got %v", expected, entry.Fields)
+	}
+	if created := time.Date(2023, time.May, 6, 7, 8, 9, 0, time.UTC); entry.Times.Created != created {
+		t.Errorf("Expected %v, got %v", created, entry.Times.Created)
+	}
+	if len(entry.History) != 1 || entry.History[0].Fields[0].Value != "old" {
+		t.Errorf("Unexpected history %v", entry.History)
+	}
+	var extra []string
+	for _, n := range entry.extra {
+		extra = append(extra, n.name)
+	}
+	if expected := []string{"IconID", "ForegroundColor", "Tags", "Binary", "AutoType"}; !reflect.DeepEqual(extra, expected) {
+		t.Errorf("Expected extra elements %v, got %v", expected, extra)
+	}
+}
+
+func TestWriteXMLKeepsUnknownElements(t *testing.T) {
+	db := readXMLFixture(t, "keepassxc.xml")
+	db.Name = "Renamed"
+
+	key := bytes.Repeat([]byte{7}, innerStreamKeyLength)
+	stream, _ := newInnerStream(key)
+	var buf bytes.Buffer
+	if err := writeXML(&buf, db.toXML(), stream); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("1234-5678")) || !bytes.Contains(buf.Bytes(), []byte("<Tags>work</Tags>")) {
+		t.Errorf("Expected protected values to be encrypted and others kept, got:\n%s", buf.Bytes())
+	}
+
+	stream, _ = newInnerStream(key)
+	root, err := parseXML(buf.Bytes(), stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := NewDatabase("")
+	if err := read.fromXML(root); err != nil {
+		t.Fatal(err)
+	}
+	if read.Name != "Renamed" || read.meta.child("RecycleBinUUID") == nil || read.meta.child("CustomData") == nil {
+		t.Errorf("Expected Meta to be kept, got %v", read.meta)
+	}
+	// Times are written in full, so compare the rest.
+	read.Root.Times, db.Root.Times = Times{}, Times{}
+	if !reflect.DeepEqual(read.Root, db.Root) {
+		t.Errorf("Expected %v, got %v", db.Root, read.Root)
+	}
+}
+
+func TestParseXMLErrors(t *testing.T) {
+	tests := []struct {
+		document string
+		expected string
+	}{
+		{"<KeePassFile><Root></Root></KeePassFile>", "kdbx: XML document has no root group"},
+		{"<Database/>", "kdbx: XML document is not a KeePassFile"},
+		{"<KeePassFile>", "kdbx: XML syntax error on line 1: unexpected EOF"},
+		{`<KeePassFile><Root><Group><UUID>AAAA</UUID></Group></Root></KeePassFile>`, `kdbx: invalid <UUID> "AAAA"`},
+		{`<KeePassFile><Root><Group><Times><UsageCount>x</UsageCount></Times></Group></Root></KeePassFile>`, `kdbx: invalid <UsageCount> "x"`},
+		{`<KeePassFile><Root><Group><Entry><String><Key>k</Key></String></Entry></Group></Root></KeePassFile>`, "kdbx: <String> needs a <Key> and a <Value>"},
+		{`<KeePassFile><Root><Group><Name Protected="True">!</Name></Group></Root></KeePassFile>`, "kdbx: protected value of <Name> is invalid: illegal base64 data at input byte 0"},
+	}
+	for _, test := range tests {
+		root, err := parseXML([]byte(test.document), plainStream{})
+		if err == nil {
+			err = NewDatabase("").fromXML(root)
+		}
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+}
+
+func TestImportSkipsRecycleBin(t *testing.T) {
+	db := readXMLFixture(t, "keepassxc.xml")
+	store := &storetest.MemStore{}
+	if _, err := Import(store, db, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := []osxkeychain.GenericPasswordAttributes{{ServiceName: "api.example.com", AccountName: "alice", Password: []byte("s3cret"), TrustedApplications: []string{}}}
+	if items := store.Items(); !reflect.DeepEqual(items, expected) {
+		t.Errorf("Expected %v, got %v", expected, items)
+	}
+}
@@ -0,0 +1,367 @@
+package osxkeychain
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// KeyType is the type of a key in a keychain.
+type KeyType int
+
+const (
+	KeyTypeRSA KeyType = iota + 1
+	KeyTypeECDSA
+)
+
+func (keyType KeyType) String() string {
+	switch keyType {
+	case KeyTypeRSA:
+		return "RSA"
+	case KeyTypeECDSA:
+		return "ECDSA"
+	}
+	return fmt.Sprintf("KeyType(%d)", int(keyType))
+}
+
+// KeyAlgorithm is a SecKeyAlgorithm: the operation, padding and hash a
+// key is used with.
+type KeyAlgorithm int
+
+const (
+	KeyAlgorithmRSASignatureDigestPKCS1v15Raw KeyAlgorithm = iota + 1
+	KeyAlgorithmRSASignatureDigestPKCS1v15SHA1
+	KeyAlgorithmRSASignatureDigestPKCS1v15SHA224
+	KeyAlgorithmRSASignatureDigestPKCS1v15SHA256
+	KeyAlgorithmRSASignatureDigestPKCS1v15SHA384
+	KeyAlgorithmRSASignatureDigestPKCS1v15SHA512
+	KeyAlgorithmRSASignatureDigestPSSSHA1
+	KeyAlgorithmRSASignatureDigestPSSSHA224
+	KeyAlgorithmRSASignatureDigestPSSSHA256
+	KeyAlgorithmRSASignatureDigestPSSSHA384
+	KeyAlgorithmRSASignatureDigestPSSSHA512
+	KeyAlgorithmECDSASignatureDigestX962
+	KeyAlgorithmECDSASignatureDigestX962SHA1
+	KeyAlgorithmECDSASignatureDigestX962SHA224
+	KeyAlgorithmECDSASignatureDigestX962SHA256
+	KeyAlgorithmECDSASignatureDigestX962SHA384
+	KeyAlgorithmECDSASignatureDigestX962SHA512
+	KeyAlgorithmRSAEncryptionPKCS1
+	KeyAlgorithmRSAEncryptionOAEPSHA1
+	KeyAlgorithmRSAEncryptionOAEPSHA224
+	KeyAlgorithmRSAEncryptionOAEPSHA256
+	KeyAlgorithmRSAEncryptionOAEPSHA384
+	KeyAlgorithmRSAEncryptionOAEPSHA512
+)
+
+var (
+	pkcs1v15SignatureAlgorithms = map[crypto.Hash]KeyAlgorithm{
+		0:              KeyAlgorithmRSASignatureDigestPKCS1v15Raw,
+		crypto.MD5SHA1: KeyAlgorithmRSASignatureDigestPKCS1v15Raw,
+		crypto.SHA1:    KeyAlgorithmRSASignatureDigestPKCS1v15SHA1,
+		crypto.SHA224:  KeyAlgorithmRSASignatureDigestPKCS1v15SHA224,
+		crypto.SHA256:  KeyAlgorithmRSASignatureDigestPKCS1v15SHA256,
+		crypto.SHA384:  KeyAlgorithmRSASignatureDigestPKCS1v15SHA384,
+		crypto.SHA512:  KeyAlgorithmRSASignatureDigestPKCS1v15SHA512,
+	}
+	pssSignatureAlgorithms = map[crypto.Hash]KeyAlgorithm{
+		crypto.SHA1:   KeyAlgorithmRSASignatureDigestPSSSHA1,
+		crypto.SHA224: KeyAlgorithmRSASignatureDigestPSSSHA224,
+		crypto.SHA256: KeyAlgorithmRSASignatureDigestPSSSHA256,
+		crypto.SHA384: KeyAlgorithmRSASignatureDigestPSSSHA384,
+		crypto.SHA512: KeyAlgorithmRSASignatureDigestPSSSHA512,
+	}
+	ecdsaSignatureAlgorithms = map[crypto.Hash]KeyAlgorithm{
+		0:             KeyAlgorithmECDSASignatureDigestX962,
+		crypto.SHA1:   KeyAlgorithmECDSASignatureDigestX962SHA1,
+		crypto.SHA224: KeyAlgorithmECDSASignatureDigestX962SHA224,
+		crypto.SHA256: KeyAlgorithmECDSASignatureDigestX962SHA256,
+		crypto.SHA384: KeyAlgorithmECDSASignatureDigestX962SHA384,
+		crypto.SHA512: KeyAlgorithmECDSASignatureDigestX962SHA512,
+	}
+	oaepEncryptionAlgorithms = map[crypto.Hash]KeyAlgorithm{
+		crypto.SHA1:   KeyAlgorithmRSAEncryptionOAEPSHA1,
+		crypto.SHA224: KeyAlgorithmRSAEncryptionOAEPSHA224,
+		crypto.SHA256: KeyAlgorithmRSAEncryptionOAEPSHA256,
+		crypto.SHA384: KeyAlgorithmRSAEncryptionOAEPSHA384,
+		crypto.SHA512: KeyAlgorithmRSAEncryptionOAEPSHA512,
+	}
+)
+
+// SignatureAlgorithm returns the algorithm that signs a digest with a
+// key of the given type as crypto.Signer's Sign does with opts.
+// *rsa.PSSOptions select PSS, whose salt must be as long as the hash,
+// as the Security framework makes it.
+func SignatureAlgorithm(keyType KeyType, opts crypto.SignerOpts) (KeyAlgorithm, error) {
+	hash := opts.HashFunc()
+	pssOpts, isPSS := opts.(*rsa.PSSOptions)
+	var algorithms map[crypto.Hash]KeyAlgorithm
+	switch {
+	case keyType == KeyTypeRSA && isPSS:
+		algorithms = pssSignatureAlgorithms
+	case keyType == KeyTypeRSA:
+		algorithms = pkcs1v15SignatureAlgorithms
+	case keyType == KeyTypeECDSA:
+		algorithms = ecdsaSignatureAlgorithms
+	default:
+		return 0, errors.New("unknown key type " + keyType.String())
+	}
+	algorithm, ok := algorithms[hash]
+	if !ok {
+		return 0, fmt.Errorf("hash %v is not supported for %s signatures", hash, keyType)
+	}
+	if isPSS && pssOpts.SaltLength != rsa.PSSSaltLengthEqualsHash && pssOpts.SaltLength != hash.Size() {
+		return 0, fmt.Errorf("PSS salt length %d is not supported", pssOpts.SaltLength)
+	}
+	return algorithm, nil
+}
+
+// DecryptionAlgorithm returns the algorithm that decrypts with a key
+// of the given type as crypto.Decrypter's Decrypt does with opts: nil
+// and *rsa.PKCS1v15DecryptOptions select PKCS #1 v1.5, and
+// *rsa.OAEPOptions without a label select OAEP.
+func DecryptionAlgorithm(keyType KeyType, opts crypto.DecrypterOpts) (KeyAlgorithm, error) {
+	if keyType != KeyTypeRSA {
+		return 0, errors.New(keyType.String() + " keys can't decrypt")
+	}
+	switch opts := opts.(type) {
+	case nil:
+		return KeyAlgorithmRSAEncryptionPKCS1, nil
+	case *rsa.PKCS1v15DecryptOptions:
+		if opts.SessionKeyLen > 0 {
+			return 0, errors.New("PKCS #1 v1.5 session key decryption is not supported")
+		}
+		return KeyAlgorithmRSAEncryptionPKCS1, nil
+	case *rsa.OAEPOptions:
+		if len(opts.Label) > 0 {
+			return 0, errors.New("OAEP labels are not supported")
+		}
+		if opts.MGFHash != 0 && opts.MGFHash != opts.Hash {
+			return 0, errors.New("OAEP MGF1 hash must match the hash")
+		}
+		algorithm, ok := oaepEncryptionAlgorithms[opts.Hash]
+		if !ok {
+			return 0, fmt.Errorf("hash %v is not supported for OAEP", opts.Hash)
+		}
+		return algorithm, nil
+	}
+	return 0, fmt.Errorf("decrypter options %T are not supported", opts)
+}
+
+// keyBackend performs operations with a private key held elsewhere,
+// such as in a keychain.
+type keyBackend interface {
+	createSignature(algorithm KeyAlgorithm, digest []byte) ([]byte, error)
+	decrypt(algorithm KeyAlgorithm, ciphertext []byte) ([]byte, error)
+	// copyExternalRepresentation returns the private key in the format
+	// parseExternalPrivateKey takes, if it may be exported.
+	copyExternalRepresentation() ([]byte, error)
+}
+
+// Key is a private key whose operations are performed by the keychain
+// holding it, so that it never leaves the keychain. It implements
+// crypto.Signer and, for RSA keys, crypto.Decrypter. The user may be
+// asked to allow each use.
+type Key struct {
+	keyType   KeyType
+	publicKey crypto.PublicKey
+	backend   keyBackend
+}
+
+// Type returns the type of the key.
+func (k *Key) Type() KeyType {
+	return k.keyType
+}
+
+// Public returns the public half of the key, an *rsa.PublicKey or
+// *ecdsa.PublicKey.
+func (k *Key) Public() crypto.PublicKey {
+	return k.publicKey
+}
+
+// Sign signs digest, which must be the output of opts.HashFunc(), as
+// with crypto.Signer. rand is ignored.
+func (k *Key) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	algorithm, err := SignatureAlgorithm(k.keyType, opts)
+	if err != nil {
+		return nil, err
+	}
+	if hash := opts.HashFunc(); hash != 0 && len(digest) != hash.Size() {
+		return nil, fmt.Errorf("digest has length %d, expected %d for %v", len(digest), hash.Size(), hash)
+	}
+	return k.backend.createSignature(algorithm, digest)
+}
+
+// Decrypt decrypts msg, as with crypto.Decrypter. rand is ignored.
+func (k *Key) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	algorithm, err := DecryptionAlgorithm(k.keyType, opts)
+	if err != nil {
+		return nil, err
+	}
+	return k.backend.decrypt(algorithm, msg)
+}
+
+// Identity is a certificate along with the private key for its public
+// key.
+type Identity struct {
+	Certificate *x509.Certificate
+	Key         *Key
+}
+
+// TLSCertificate returns the identity as a tls.Certificate, for
+// example to return from tls.Config.GetClientCertificate. The chain
+// is appended after the identity's certificate.
+func (identity *Identity) TLSCertificate(chain ...*x509.Certificate) tls.Certificate {
+	certificate := tls.Certificate{
+		Certificate: [][]byte{identity.Certificate.Raw},
+		PrivateKey:  identity.Key,
+		Leaf:        identity.Certificate,
+	}
+	for _, cert := range chain {
+		certificate.Certificate = append(certificate.Certificate, cert.Raw)
+	}
+	return certificate
+}
+
+// curveForSize returns the NIST curve whose field is the given number
+// of bytes.
+func curveForSize(size int) elliptic.Curve {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		if (curve.Params().BitSize+7)/8 == size {
+			return curve
+		}
+	}
+	return nil
+}
+
+// parseExternalPublicKey parses a public key in the format returned by
+// SecKeyCopyExternalRepresentation: PKCS #1 for RSA and an uncompressed
+// ANSI X9.63 point for ECDSA.
+func parseExternalPublicKey(keyType KeyType, data []byte) (crypto.PublicKey, error) {
+	switch keyType {
+	case KeyTypeRSA:
+		return x509.ParsePKCS1PublicKey(data)
+	case KeyTypeECDSA:
+		if len(data) < 1 || data[0] != 4 || len(data)%2 != 1 {
+			return nil, errors.New("ECDSA public key is not an uncompressed point")
+		}
+		size := len(data) / 2
+		curve := curveForSize(size)
+		if curve == nil {
+			return nil, fmt.Errorf("ECDSA public key has unsupported size %d", size)
+		}
+		x := new(big.Int).SetBytes(data[1 : 1+size])
+		y := new(big.Int).SetBytes(data[1+size:])
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("ECDSA public key is not on its curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("unknown key type " + keyType.String())
+}
+
+// parseExternalPublicKeyOfAnyType is like parseExternalPublicKey,
+// but tells the key's type from its encoding.
+func parseExternalPublicKeyOfAnyType(data []byte) (KeyType, crypto.PublicKey, error) {
+	keyType := KeyTypeRSA
+	if len(data) > 0 && data[0] == 4 {
+		keyType = KeyTypeECDSA
+	}
+	publicKey, err := parseExternalPublicKey(keyType, data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return keyType, publicKey, nil
+}
+
+// checkKeySize returns an error if the keychain can't generate keys
+// of the given type and size.
+func checkKeySize(keyType KeyType, bits int) error {
+	switch keyType {
+	case KeyTypeRSA:
+		if bits < 2048 || bits > 4096 || bits%8 != 0 {
+			return fmt.Errorf("RSA key size %d is not supported", bits)
+		}
+	case KeyTypeECDSA:
+		if bits != 256 && bits != 384 && bits != 521 {
+			return fmt.Errorf("ECDSA key size %d is not supported", bits)
+		}
+	default:
+		return errors.New("unknown key type " + keyType.String())
+	}
+	return nil
+}
+
+// marshalExternalPrivateKey returns the given private key in the
+// format SecKeyCreateWithData takes: PKCS #1 for RSA and an ANSI X9.63
+// public point followed by the private scalar for ECDSA.
+func marshalExternalPrivateKey(key crypto.PrivateKey) (KeyType, []byte, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return KeyTypeRSA, x509.MarshalPKCS1PrivateKey(key), nil
+	case *ecdsa.PrivateKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if curveForSize(size) == nil {
+			return 0, nil, errors.New("ECDSA curve " + key.Curve.Params().Name + " is not supported")
+		}
+		data := make([]byte, 1+3*size)
+		data[0] = 4
+		key.X.FillBytes(data[1 : 1+size])
+		key.Y.FillBytes(data[1+size : 1+2*size])
+		key.D.FillBytes(data[1+2*size:])
+		return KeyTypeECDSA, data, nil
+	}
+	return 0, nil, fmt.Errorf("private key type %T is not supported", key)
+}
+
+// parseExternalPrivateKey parses a private key in the format
+// marshalExternalPrivateKey returns.
+func parseExternalPrivateKey(keyType KeyType, data []byte) (crypto.PrivateKey, error) {
+	switch keyType {
+	case KeyTypeRSA:
+		return x509.ParsePKCS1PrivateKey(data)
+	case KeyTypeECDSA:
+		if len(data)%3 != 1 {
+			return nil, errors.New("ECDSA private key has the wrong length")
+		}
+		size := len(data) / 3
+		publicKey, err := parseExternalPublicKey(keyType, data[:1+2*size])
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PrivateKey{PublicKey: *publicKey.(*ecdsa.PublicKey), D: new(big.Int).SetBytes(data[1+2*size:])}
+		if x, y := key.Curve.ScalarBaseMult(key.D.Bytes()); x.Cmp(key.X) != 0 || y.Cmp(key.Y) != 0 {
+			return nil, errors.New("ECDSA private key doesn't match its public key")
+		}
+		return key, nil
+	}
+	return nil, errors.New("unknown key type " + keyType.String())
+}
+
+// parsePKCS8PrivateKey parses a PKCS #8 private key, DER or in a PEM
+// "PRIVATE KEY" block, of a type the keychain supports.
+func parsePKCS8PrivateKey(data []byte) (crypto.PrivateKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "PRIVATE KEY" {
+			return nil, errors.New("PEM block is " + block.Type + ", not PRIVATE KEY")
+		}
+		data = block.Bytes
+	}
+	key, err := x509.ParsePKCS8PrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("private key type %T is not supported", key)
+}
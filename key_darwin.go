@@ -0,0 +1,315 @@
+//go:build darwin && !ios && cgo
+// +build darwin,!ios,cgo
+
+package osxkeychain
+
+/*
+#include <stdlib.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+*/
+import "C"
+
+import (
+	"crypto/x509"
+	"runtime"
+	"unsafe"
+)
+
+var secClassKey = C.CFTypeRef(C.kSecClassKey)
+var secAttrKeyType = C.CFTypeRef(C.kSecAttrKeyType)
+var secAttrKeyClass = C.CFTypeRef(C.kSecAttrKeyClass)
+var secAttrKeySizeInBits = C.CFTypeRef(C.kSecAttrKeySizeInBits)
+var secAttrIsPermanent = C.CFTypeRef(C.kSecAttrIsPermanent)
+var secPrivateKeyAttrs = C.CFTypeRef(C.kSecPrivateKeyAttrs)
+
+var secKeyTypes = map[KeyType]C.CFTypeRef{
+	KeyTypeRSA:   C.CFTypeRef(C.kSecAttrKeyTypeRSA),
+	KeyTypeECDSA: C.CFTypeRef(C.kSecAttrKeyTypeECSECPrimeRandom),
+}
+
+var keyAlgorithms = map[KeyAlgorithm]C.SecKeyAlgorithm{
+	KeyAlgorithmRSASignatureDigestPKCS1v15Raw:    C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15Raw,
+	KeyAlgorithmRSASignatureDigestPKCS1v15SHA1:   C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA1,
+	KeyAlgorithmRSASignatureDigestPKCS1v15SHA224: C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA224,
+	KeyAlgorithmRSASignatureDigestPKCS1v15SHA256: C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA256,
+	KeyAlgorithmRSASignatureDigestPKCS1v15SHA384: C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA384,
+	KeyAlgorithmRSASignatureDigestPKCS1v15SHA512: C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA512,
+	KeyAlgorithmRSASignatureDigestPSSSHA1:        C.kSecKeyAlgorithmRSASignatureDigestPSSSHA1,
+	KeyAlgorithmRSASignatureDigestPSSSHA224:      C.kSecKeyAlgorithmRSASignatureDigestPSSSHA224,
+	KeyAlgorithmRSASignatureDigestPSSSHA256:      C.kSecKeyAlgorithmRSASignatureDigestPSSSHA256,
+	KeyAlgorithmRSASignatureDigestPSSSHA384:      C.kSecKeyAlgorithmRSASignatureDigestPSSSHA384,
+	KeyAlgorithmRSASignatureDigestPSSSHA512:      C.kSecKeyAlgorithmRSASignatureDigestPSSSHA512,
+	KeyAlgorithmECDSASignatureDigestX962:         C.kSecKeyAlgorithmECDSASignatureDigestX962,
+	KeyAlgorithmECDSASignatureDigestX962SHA1:     C.kSecKeyAlgorithmECDSASignatureDigestX962SHA1,
+	KeyAlgorithmECDSASignatureDigestX962SHA224:   C.kSecKeyAlgorithmECDSASignatureDigestX962SHA224,
+	KeyAlgorithmECDSASignatureDigestX962SHA256:   C.kSecKeyAlgorithmECDSASignatureDigestX962SHA256,
+	KeyAlgorithmECDSASignatureDigestX962SHA384:   C.kSecKeyAlgorithmECDSASignatureDigestX962SHA384,
+	KeyAlgorithmECDSASignatureDigestX962SHA512:   C.kSecKeyAlgorithmECDSASignatureDigestX962SHA512,
+	KeyAlgorithmRSAEncryptionPKCS1:               C.kSecKeyAlgorithmRSAEncryptionPKCS1,
+	KeyAlgorithmRSAEncryptionOAEPSHA1:            C.kSecKeyAlgorithmRSAEncryptionOAEPSHA1,
+	KeyAlgorithmRSAEncryptionOAEPSHA224:          C.kSecKeyAlgorithmRSAEncryptionOAEPSHA224,
+	KeyAlgorithmRSAEncryptionOAEPSHA256:          C.kSecKeyAlgorithmRSAEncryptionOAEPSHA256,
+	KeyAlgorithmRSAEncryptionOAEPSHA384:          C.kSecKeyAlgorithmRSAEncryptionOAEPSHA384,
+	KeyAlgorithmRSAEncryptionOAEPSHA512:          C.kSecKeyAlgorithmRSAEncryptionOAEPSHA512,
+}
+
+// secKey is the keyBackend of a SecKeyRef, which it releases when it
+// is garbage collected.
+type secKey struct {
+	ref C.SecKeyRef
+}
+
+func newSecKey(ref C.SecKeyRef) *secKey {
+	k := &secKey{ref: ref}
+	runtime.SetFinalizer(k, func(k *secKey) {
+		// Like every other call into the Security framework, the
+		// release is run by keychainExecutor, but from another
+		// goroutine, so finalizers don't wait for its queue.
+		ref := k.ref
+		go keychainExecutor.do(func() {
+			C.CFRelease(C.CFTypeRef(ref))
+		})
+	})
+	return k
+}
+
+func (k *secKey) createSignature(algorithm KeyAlgorithm, digest []byte) (signature []byte, err error) {
+	keychainExecutor.do(func() {
+		signature, err = k.transform(false, algorithm, digest)
+	})
+	return
+}
+
+func (k *secKey) decrypt(algorithm KeyAlgorithm, ciphertext []byte) (plaintext []byte, err error) {
+	keychainExecutor.do(func() {
+		plaintext, err = k.transform(true, algorithm, ciphertext)
+	})
+	return
+}
+
+func (k *secKey) copyExternalRepresentation() (data []byte, err error) {
+	keychainExecutor.do(func() {
+		defer runtime.KeepAlive(k)
+
+		var cfError C.CFErrorRef
+		external := C.SecKeyCopyExternalRepresentation(k.ref, &cfError)
+		if external == nil {
+			err = cfErrorToError(cfError)
+			return
+		}
+		defer C.CFRelease(C.CFTypeRef(external))
+		data = _CFDataToBytes(external)
+	})
+	return
+}
+
+// transform signs input with SecKeyCreateSignature, or decrypts it
+// with SecKeyCreateDecryptedData if decrypt is true.
+func (k *secKey) transform(decrypt bool, algorithm KeyAlgorithm, input []byte) ([]byte, error) {
+	defer runtime.KeepAlive(k)
+
+	inputData := bytesToCFData(input)
+	defer C.CFRelease(C.CFTypeRef(inputData))
+
+	var cfError C.CFErrorRef
+	var output C.CFDataRef
+	if decrypt {
+		output = C.SecKeyCreateDecryptedData(k.ref, keyAlgorithms[algorithm], inputData, &cfError)
+	} else {
+		output = C.SecKeyCreateSignature(k.ref, keyAlgorithms[algorithm], inputData, &cfError)
+	}
+	if output == nil {
+		return nil, cfErrorToError(cfError)
+	}
+	defer C.CFRelease(C.CFTypeRef(output))
+	return _CFDataToBytes(output), nil
+}
+
+// newKey returns a Key for the given private key, taking ownership of
+// ref.
+func newKey(ref C.SecKeyRef) (*Key, error) {
+	publicRef := C.SecKeyCopyPublicKey(ref)
+	if publicRef == nil {
+		C.CFRelease(C.CFTypeRef(ref))
+		return nil, ErrInternalComponent
+	}
+	defer C.CFRelease(C.CFTypeRef(publicRef))
+
+	var cfError C.CFErrorRef
+	publicData := C.SecKeyCopyExternalRepresentation(publicRef, &cfError)
+	if publicData == nil {
+		C.CFRelease(C.CFTypeRef(ref))
+		return nil, cfErrorToError(cfError)
+	}
+	defer C.CFRelease(C.CFTypeRef(publicData))
+
+	keyType, publicKey, err := parseExternalPublicKeyOfAnyType(_CFDataToBytes(publicData))
+	if err != nil {
+		C.CFRelease(C.CFTypeRef(ref))
+		return nil, err
+	}
+	return &Key{keyType: keyType, publicKey: publicKey, backend: newSecKey(ref)}, nil
+}
+
+// ImportPrivateKey adds a PKCS #8 RSA or ECDSA private key, DER or in
+// a PEM "PRIVATE KEY" block, to the default keychain under the given
+// label, and returns it.
+func ImportPrivateKey(data []byte, label string) (key *Key, err error) {
+	privateKey, err := parsePKCS8PrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	keyType, external, err := marshalExternalPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := check32BitUTF8("label", label); err != nil {
+		return nil, err
+	}
+	keychainExecutor.do(func() {
+		key, err = importPrivateKey(keyType, external, label, nil, nil)
+	})
+	return
+}
+
+// importPrivateKey adds the given private key to the default keychain,
+// letting trustedApplications use it without asking the user. If
+// persistentRef is non-nil, it's set to a persistent reference to the
+// added item, which the caller must release.
+func importPrivateKey(keyType KeyType, external []byte, label string, trustedApplications []string, persistentRef *C.CFDataRef) (*Key, error) {
+	externalData := bytesToCFData(external)
+	defer C.CFRelease(C.CFTypeRef(externalData))
+
+	params := mapToCFDictionary(map[C.CFTypeRef]C.CFTypeRef{
+		secAttrKeyType:  secKeyTypes[keyType],
+		secAttrKeyClass: C.CFTypeRef(C.kSecAttrKeyClassPrivate),
+	})
+	defer C.CFRelease(C.CFTypeRef(params))
+
+	var cfError C.CFErrorRef
+	ref := C.SecKeyCreateWithData(externalData, params, &cfError)
+	if ref == nil {
+		return nil, cfErrorToError(cfError)
+	}
+
+	query := map[C.CFTypeRef]C.CFTypeRef{
+		secClass:    secClassKey,
+		secValueRef: C.CFTypeRef(ref),
+	}
+	if label != "" {
+		labelString, err := _UTF8StringToCFString(label)
+		if err != nil {
+			C.CFRelease(C.CFTypeRef(ref))
+			return nil, err
+		}
+		defer C.CFRelease(C.CFTypeRef(labelString))
+		query[secAttrLabel] = C.CFTypeRef(labelString)
+	}
+	access, err := createAccess(label, trustedApplications)
+	if err != nil {
+		C.CFRelease(C.CFTypeRef(ref))
+		return nil, err
+	}
+	if access != nil {
+		defer C.CFRelease(C.CFTypeRef(access))
+		query[secAttrAccess] = C.CFTypeRef(access)
+	}
+	var result *C.CFTypeRef
+	if persistentRef != nil {
+		query[secReturnPersistentRef] = C.CFTypeRef(C.kCFBooleanTrue)
+		result = (*C.CFTypeRef)(unsafe.Pointer(persistentRef))
+	}
+	queryDict := mapToCFDictionary(query)
+	defer C.CFRelease(C.CFTypeRef(queryDict))
+
+	if err := newKeychainError(C.SecItemAdd(queryDict, result)); err != nil {
+		C.CFRelease(C.CFTypeRef(ref))
+		return nil, err
+	}
+	return newKey(ref)
+}
+
+// GenerateKey generates a private key of the given type and size in
+// bits in the default keychain, under the given label. RSA keys may be
+// 2048 to 4096 bits, and ECDSA keys 256, 384 or 521.
+func GenerateKey(keyType KeyType, bits int, label string) (key *Key, err error) {
+	if err := checkKeySize(keyType, bits); err != nil {
+		return nil, err
+	}
+	if err := check32BitUTF8("label", label); err != nil {
+		return nil, err
+	}
+	keychainExecutor.do(func() {
+		key, err = generateKey(keyType, bits, label)
+	})
+	return
+}
+
+func generateKey(keyType KeyType, bits int, label string) (*Key, error) {
+	labelString, err := _UTF8StringToCFString(label)
+	if err != nil {
+		return nil, err
+	}
+	defer C.CFRelease(C.CFTypeRef(labelString))
+
+	privateAttrs := mapToCFDictionary(map[C.CFTypeRef]C.CFTypeRef{
+		secAttrIsPermanent: C.CFTypeRef(C.kCFBooleanTrue),
+		secAttrLabel:       C.CFTypeRef(labelString),
+	})
+	defer C.CFRelease(C.CFTypeRef(privateAttrs))
+
+	n := C.int(bits)
+	size := C.CFNumberCreate(nil, C.kCFNumberIntType, unsafe.Pointer(&n))
+	defer C.CFRelease(C.CFTypeRef(size))
+
+	params := mapToCFDictionary(map[C.CFTypeRef]C.CFTypeRef{
+		secAttrKeyType:       secKeyTypes[keyType],
+		secAttrKeySizeInBits: C.CFTypeRef(size),
+		secPrivateKeyAttrs:   C.CFTypeRef(privateAttrs),
+	})
+	defer C.CFRelease(C.CFTypeRef(params))
+
+	var cfError C.CFErrorRef
+	ref := C.SecKeyCreateRandomKey(params, &cfError)
+	if ref == nil {
+		return nil, cfErrorToError(cfError)
+	}
+	return newKey(ref)
+}
+
+// FindIdentity returns the identity for the given certificate, whose
+// private key must be in the keychain search list.
+func FindIdentity(cert *x509.Certificate) (identity *Identity, err error) {
+	keychainExecutor.do(func() {
+		identity, err = findIdentity(cert)
+	})
+	return
+}
+
+func findIdentity(cert *x509.Certificate) (*Identity, error) {
+	certData := bytesToCFData(cert.Raw)
+	defer C.CFRelease(C.CFTypeRef(certData))
+
+	certRef := C.SecCertificateCreateWithData(nil, certData)
+	if certRef == nil {
+		return nil, ErrParam
+	}
+	defer C.CFRelease(C.CFTypeRef(certRef))
+
+	var identityRef C.SecIdentityRef
+	if err := newKeychainError(C.SecIdentityCreateWithCertificate(nil, certRef, &identityRef)); err != nil {
+		return nil, err
+	}
+	defer C.CFRelease(C.CFTypeRef(identityRef))
+
+	var keyRef C.SecKeyRef
+	if err := newKeychainError(C.SecIdentityCopyPrivateKey(identityRef, &keyRef)); err != nil {
+		return nil, err
+	}
+	key, err := newKey(keyRef)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Certificate: cert, Key: key}, nil
+}
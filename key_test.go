@@ -0,0 +1,391 @@
+package osxkeychain
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	_ "crypto/sha512"
+)
+
+// softwareKey stands in for a key in a keychain: it performs each
+// algorithm with the standard library.
+type softwareKey struct {
+	key crypto.Signer
+}
+
+func hashFor(algorithms map[crypto.Hash]KeyAlgorithm, algorithm KeyAlgorithm) (crypto.Hash, bool) {
+	for hash, a := range algorithms {
+		if a == algorithm {
+			return hash, true
+		}
+	}
+	return 0, false
+}
+
+func (k softwareKey) createSignature(algorithm KeyAlgorithm, digest []byte) ([]byte, error) {
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		if algorithm == KeyAlgorithmRSASignatureDigestPKCS1v15Raw {
+			return rsa.SignPKCS1v15(nil, key, 0, digest)
+		}
+		if hash, ok := hashFor(pkcs1v15SignatureAlgorithms, algorithm); ok {
+			return rsa.SignPKCS1v15(nil, key, hash, digest)
+		}
+		if hash, ok := hashFor(pssSignatureAlgorithms, algorithm); ok {
+			return rsa.SignPSS(rand.Reader, key, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PrivateKey:
+		if _, ok := hashFor(ecdsaSignatureAlgorithms, algorithm); ok {
+			return ecdsa.SignASN1(rand.Reader, key, digest)
+		}
+	}
+	return nil, fmt.Errorf("algorithm %d is not supported for %T", algorithm, k.key)
+}
+
+func (k softwareKey) decrypt(algorithm KeyAlgorithm, ciphertext []byte) ([]byte, error) {
+	key, ok := k.key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrParam
+	}
+	if algorithm == KeyAlgorithmRSAEncryptionPKCS1 {
+		return rsa.DecryptPKCS1v15(nil, key, ciphertext)
+	}
+	if hash, ok := hashFor(oaepEncryptionAlgorithms, algorithm); ok {
+		return rsa.DecryptOAEP(hash.New(), nil, key, ciphertext, nil)
+	}
+	return nil, ErrParam
+}
+
+func (k softwareKey) copyExternalRepresentation() ([]byte, error) {
+	_, data, err := marshalExternalPrivateKey(k.key)
+	return data, err
+}
+
+func newSoftwareKey(t *testing.T, keyType KeyType) (*Key, crypto.Signer) {
+	var signer crypto.Signer
+	var err error
+	if keyType == KeyTypeRSA {
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Key{keyType: keyType, publicKey: signer.Public(), backend: softwareKey{signer}}, signer
+}
+
+func TestSignatureAlgorithm(t *testing.T) {
+	tests := []struct {
+		keyType  KeyType
+		opts     crypto.SignerOpts
+		expected KeyAlgorithm
+	}{
+		{KeyTypeRSA, crypto.SHA256, KeyAlgorithmRSASignatureDigestPKCS1v15SHA256},
+		{KeyTypeRSA, crypto.SHA1, KeyAlgorithmRSASignatureDigestPKCS1v15SHA1},
+		{KeyTypeRSA, crypto.MD5SHA1, KeyAlgorithmRSASignatureDigestPKCS1v15Raw},
+		{KeyTypeRSA, crypto.Hash(0), KeyAlgorithmRSASignatureDigestPKCS1v15Raw},
+		{KeyTypeRSA, &rsa.PSSOptions{Hash: crypto.SHA384, SaltLength: rsa.PSSSaltLengthEqualsHash}, KeyAlgorithmRSASignatureDigestPSSSHA384},
+		{KeyTypeRSA, &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: 32}, KeyAlgorithmRSASignatureDigestPSSSHA256},
+		{KeyTypeECDSA, crypto.SHA512, KeyAlgorithmECDSASignatureDigestX962SHA512},
+		{KeyTypeECDSA, crypto.Hash(0), KeyAlgorithmECDSASignatureDigestX962},
+	}
+	for _, test := range tests {
+		algorithm, err := SignatureAlgorithm(test.keyType, test.opts)
+		if err != nil {
+			t.Errorf("%s %v: %v", test.keyType, test.opts, err)
+		} else if algorithm != test.expected {
+			t.Errorf("%s %v: Expected %d, got %d", test.keyType, test.opts, test.expected, algorithm)
+		}
+	}
+
+	invalid := []struct {
+		keyType  KeyType
+		opts     crypto.SignerOpts
+		expected string
+	}{
+		{KeyTypeRSA, crypto.MD5, "hash MD5 is not supported for RSA signatures"},
+		{KeyTypeRSA, &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthAuto}, "PSS salt length 0 is not supported"},
+		{KeyTypeRSA, &rsa.PSSOptions{Hash: crypto.MD5SHA1}, "hash MD5+SHA1 is not supported for RSA signatures"},
+		{KeyTypeECDSA, crypto.MD5SHA1, "hash MD5+SHA1 is not supported for ECDSA signatures"},
+		{KeyType(7), crypto.SHA256, "unknown key type KeyType(7)"},
+	}
+	for _, test := range invalid {
+		_, err := SignatureAlgorithm(test.keyType, test.opts)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+}
+
+func TestDecryptionAlgorithm(t *testing.T) {
+	tests := []struct {
+		opts     crypto.DecrypterOpts
+		expected KeyAlgorithm
+	}{
+		{nil, KeyAlgorithmRSAEncryptionPKCS1},
+		{&rsa.PKCS1v15DecryptOptions{}, KeyAlgorithmRSAEncryptionPKCS1},
+		{&rsa.OAEPOptions{Hash: crypto.SHA256}, KeyAlgorithmRSAEncryptionOAEPSHA256},
+		{&rsa.OAEPOptions{Hash: crypto.SHA1, MGFHash: crypto.SHA1}, KeyAlgorithmRSAEncryptionOAEPSHA1},
+	}
+	for _, test := range tests {
+		algorithm, err := DecryptionAlgorithm(KeyTypeRSA, test.opts)
+		if err != nil {
+			t.Errorf("%v: %v", test.opts, err)
+		} else if algorithm != test.expected {
+			t.Errorf("%v: Expected %d, got %d", test.opts, test.expected, algorithm)
+		}
+	}
+
+	invalid := []struct {
+		keyType  KeyType
+		opts     crypto.DecrypterOpts
+		expected string
+	}{
+		{KeyTypeECDSA, nil, "ECDSA keys can't decrypt"},
+		{KeyTypeRSA, &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label")}, "OAEP labels are not supported"},
+		{KeyTypeRSA, &rsa.OAEPOptions{Hash: crypto.SHA256, MGFHash: crypto.SHA1}, "OAEP MGF1 hash must match the hash"},
+		{KeyTypeRSA, &rsa.OAEPOptions{Hash: crypto.MD5}, "hash MD5 is not supported for OAEP"},
+		{KeyTypeRSA, &rsa.PKCS1v15DecryptOptions{SessionKeyLen: 16}, "PKCS #1 v1.5 session key decryption is not supported"},
+		{KeyTypeRSA, "options", "decrypter options string are not supported"},
+	}
+	for _, test := range invalid {
+		_, err := DecryptionAlgorithm(test.keyType, test.opts)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+}
+
+func TestKeySign(t *testing.T) {
+	digest := sha256.Sum256([]byte("message"))
+
+	rsaKey, _ := newSoftwareKey(t, KeyTypeRSA)
+	rsaPublic := rsaKey.Public().(*rsa.PublicKey)
+	signature, err := rsaKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsa.VerifyPKCS1v15(rsaPublic, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("PKCS #1 v1.5: %v", err)
+	}
+	pssOpts := &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthEqualsHash}
+	signature, err = rsaKey.Sign(rand.Reader, digest[:], pssOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsa.VerifyPSS(rsaPublic, crypto.SHA256, digest[:], signature, pssOpts); err != nil {
+		t.Errorf("PSS: %v", err)
+	}
+
+	ecdsaKey, _ := newSoftwareKey(t, KeyTypeECDSA)
+	signature, err = ecdsaKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(ecdsaKey.Public().(*ecdsa.PublicKey), digest[:], signature) {
+		t.Error("ECDSA signature doesn't verify")
+	}
+
+	expected := "digest has length 32, expected 48 for SHA-384"
+	if _, err := ecdsaKey.Sign(rand.Reader, digest[:], crypto.SHA384); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
+
+func TestKeyDecrypt(t *testing.T) {
+	key, _ := newSoftwareKey(t, KeyTypeRSA)
+	public := key.Public().(*rsa.PublicKey)
+
+	ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, public, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := key.Decrypt(rand.Reader, ciphertext, nil)
+	if err != nil || string(plaintext) != "secret" {
+		t.Errorf("PKCS #1 v1.5: Expected \"secret\", got %q, %v", plaintext, err)
+	}
+
+	ciphertext, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, public, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err = key.Decrypt(rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	if err != nil || string(plaintext) != "secret" {
+		t.Errorf("OAEP: Expected \"secret\", got %q, %v", plaintext, err)
+	}
+}
+
+// TestIdentityTLSClientCertificate checks that a Key works as the
+// private key of a TLS client certificate.
+func TestIdentityTLSClientCertificate(t *testing.T) {
+	for _, keyType := range []KeyType{KeyTypeRSA, KeyTypeECDSA} {
+		t.Run(keyType.String(), func(t *testing.T) {
+			key, _ := newSoftwareKey(t, keyType)
+			serverKey, _ := newSoftwareKey(t, KeyTypeECDSA)
+			serverSigner := serverKey.backend.(softwareKey).key
+
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: "osxkeychain test"},
+				NotBefore:    time.Now().Add(-time.Hour),
+				NotAfter:     time.Now().Add(time.Hour),
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+				DNSNames:     []string{"osxkeychain.test"},
+			}
+			clientDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientCert, _ := x509.ParseCertificate(clientDER)
+			serverDER, err := x509.CreateCertificate(rand.Reader, template, template, serverSigner.Public(), serverSigner)
+			if err != nil {
+				t.Fatal(err)
+			}
+			serverCert, _ := x509.ParseCertificate(serverDER)
+
+			identity := &Identity{Certificate: clientCert, Key: key}
+			clientPool := x509.NewCertPool()
+			clientPool.AddCert(clientCert)
+			serverPool := x509.NewCertPool()
+			serverPool.AddCert(serverCert)
+
+			clientConn, serverConn := net.Pipe()
+			server := tls.Server(serverConn, &tls.Config{
+				Certificates: []tls.Certificate{{Certificate: [][]byte{serverDER}, PrivateKey: serverSigner}},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    clientPool,
+			})
+			client := tls.Client(clientConn, &tls.Config{
+				ServerName: "osxkeychain.test",
+				RootCAs:    serverPool,
+				GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					certificate := identity.TLSCertificate()
+					return &certificate, nil
+				},
+			})
+			errs := make(chan error, 1)
+			go func() {
+				errs <- server.Handshake()
+			}()
+			defer clientConn.Close()
+			defer serverConn.Close()
+			if err := client.Handshake(); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestExternalKeyRepresentation(t *testing.T) {
+	for _, keyType := range []KeyType{KeyTypeRSA, KeyTypeECDSA} {
+		_, signer := newSoftwareKey(t, keyType)
+		marshaledType, data, err := marshalExternalPrivateKey(signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if marshaledType != keyType {
+			t.Errorf("Expected %s, got %s", keyType, marshaledType)
+		}
+
+		// The public representation is a prefix of the private one for
+		// ECDSA, and PKCS #1 for RSA.
+		var publicData []byte
+		if keyType == KeyTypeECDSA {
+			publicData = data[:1+2*32]
+		} else {
+			publicData = x509.MarshalPKCS1PublicKey(signer.Public().(*rsa.PublicKey))
+		}
+		parsedType, public, err := parseExternalPublicKeyOfAnyType(publicData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsedType != keyType {
+			t.Errorf("Expected %s, got %s", keyType, parsedType)
+		}
+		if !signer.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(public) {
+			t.Errorf("%s: public key doesn't round-trip", keyType)
+		}
+
+		private, err := parseExternalPrivateKey(keyType, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !signer.(interface{ Equal(crypto.PrivateKey) bool }).Equal(private) {
+			t.Errorf("%s: private key doesn't round-trip", keyType)
+		}
+	}
+
+	_, signer := newSoftwareKey(t, KeyTypeECDSA)
+	_, data, _ := marshalExternalPrivateKey(signer)
+	data[len(data)-1] ^= 1
+	if _, err := parseExternalPrivateKey(KeyTypeECDSA, data); err == nil {
+		t.Error("Expected an error for a scalar that doesn't match the point")
+	}
+
+	if _, _, err := parseExternalPublicKeyOfAnyType([]byte{4, 1, 2}); err == nil {
+		t.Error("Expected an error for a point of unknown size")
+	}
+	if _, _, err := parseExternalPublicKeyOfAnyType(append([]byte{4}, make([]byte, 64)...)); err == nil {
+		t.Error("Expected an error for a point not on the curve")
+	}
+}
+
+func TestCheckKeySize(t *testing.T) {
+	for _, test := range []struct {
+		keyType KeyType
+		bits    int
+		valid   bool
+	}{
+		{KeyTypeRSA, 2048, true},
+		{KeyTypeRSA, 4096, true},
+		{KeyTypeRSA, 1024, false},
+		{KeyTypeRSA, 2049, false},
+		{KeyTypeECDSA, 256, true},
+		{KeyTypeECDSA, 521, true},
+		{KeyTypeECDSA, 512, false},
+	} {
+		if err := checkKeySize(test.keyType, test.bits); (err == nil) != test.valid {
+			t.Errorf("%s %d: expected valid %t, got %v", test.keyType, test.bits, test.valid, err)
+		}
+	}
+}
+
+func TestParsePKCS8PrivateKey(t *testing.T) {
+	_, signer := newSoftwareKey(t, KeyTypeECDSA)
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{der, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})} {
+		key, err := parsePKCS8PrivateKey(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !signer.(*ecdsa.PrivateKey).Equal(key) {
+			t.Error("private key doesn't round-trip")
+		}
+	}
+
+	expected := "PEM block is EC PRIVATE KEY, not PRIVATE KEY"
+	_, err = parsePKCS8PrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
@@ -0,0 +1,101 @@
+package osxkeychain
+
+import (
+	"bytes"
+	"errors"
+)
+
+// MigrationError is returned by MigrateGenericPasswords when the item
+// with the given names couldn't be migrated. The item is left in the
+// source Store.
+type MigrationError struct {
+	ServiceName string
+	AccountName string
+	Err         error
+}
+
+func (e *MigrationError) Error() string {
+	return "migrating " + e.ServiceName + "/" + e.AccountName + ": " + e.Err.Error()
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// ErrMigrationMismatch is the Err of a MigrationError for an item
+// whose copy read back differently from the original, or which was
+// already in the destination with a different password.
+var ErrMigrationMismatch = errors.New("copy doesn't match the original")
+
+// ErrMigrationEmptyName is the Err of a MigrationError for an item
+// with an empty service or account name. Stores take an empty name
+// as matching any, so the item couldn't be told apart from the
+// others of its service, and migrating it could copy or remove the
+// wrong one.
+var ErrMigrationEmptyName = errors.New("empty names match other items")
+
+// MigrateGenericPasswords moves the generic passwords with the given
+// service names from one Store to another, for example from
+// DefaultKeychain to a DataProtectionKeychain. Each item is added to
+// the destination, read back and compared, and only then removed from
+// the source; an item already in the destination with the same
+// password is just removed from the source.
+//
+// Migration stops at the first item that fails, returning a
+// *MigrationError along with the items migrated so far, whose
+// Password is left empty. A copy that fails verification is removed
+// again. Items with an empty service or account name fail with
+// ErrMigrationEmptyName before anything is done to them.
+func MigrateGenericPasswords(from, to Store, serviceNames []string) (migrated []GenericPasswordAttributes, err error) {
+	for _, serviceName := range serviceNames {
+		if serviceName == "" {
+			return migrated, &MigrationError{Err: ErrMigrationEmptyName}
+		}
+		accountNames, err := from.GetAllAccountNames(serviceName)
+		if err != nil {
+			return migrated, &MigrationError{ServiceName: serviceName, Err: err}
+		}
+		for _, accountName := range accountNames {
+			if accountName == "" {
+				return migrated, &MigrationError{ServiceName: serviceName, Err: ErrMigrationEmptyName}
+			}
+			attributes := GenericPasswordAttributes{
+				ServiceName: serviceName,
+				AccountName: accountName,
+			}
+			if err := migrateGenericPassword(from, to, attributes); err != nil {
+				return migrated, &MigrationError{ServiceName: serviceName, AccountName: accountName, Err: err}
+			}
+			migrated = append(migrated, attributes)
+		}
+	}
+	return migrated, nil
+}
+
+func migrateGenericPassword(from, to Store, attributes GenericPasswordAttributes) error {
+	password, err := from.FindGenericPassword(&attributes)
+	if err != nil {
+		return err
+	}
+
+	item := attributes
+	item.Password = password
+	err = to.AddGenericPassword(&item)
+	added := err == nil
+	if err != nil && err != ErrDuplicateItem {
+		return err
+	}
+
+	copied, err := to.FindGenericPassword(&attributes)
+	if err == nil && !bytes.Equal(copied, password) {
+		err = ErrMigrationMismatch
+	}
+	if err != nil {
+		if added {
+			to.FindAndRemoveGenericPassword(&attributes)
+		}
+		return err
+	}
+
+	return from.FindAndRemoveGenericPassword(&attributes)
+}
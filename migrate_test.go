@@ -0,0 +1,204 @@
+package osxkeychain_test
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+// corruptingStore returns every password it finds with a byte
+// appended, as a destination whose copies don't verify.
+type corruptingStore struct {
+	storetest.MemStore
+}
+
+func (s *corruptingStore) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	password, err := s.MemStore.FindGenericPassword(attributes)
+	if err != nil {
+		return nil, err
+	}
+	return append(password, 0), nil
+}
+
+// addTestItems adds the given items in order of their names, so that
+// stores list them in a predictable order.
+func addTestItems(t *testing.T, store osxkeychain.Store, items map[[2]string]string) {
+	var names [][2]string
+	for n := range items {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i][0] < names[j][0] || names[i][0] == names[j][0] && names[i][1] < names[j][1]
+	})
+	for _, n := range names {
+		err := store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{
+			ServiceName: n[0],
+			AccountName: n[1],
+			Password:    []byte(items[n]),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkPassword(t *testing.T, store osxkeychain.Store, serviceName, accountName, expected string) {
+	password, err := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{
+		ServiceName: serviceName,
+		AccountName: accountName,
+	})
+	if err != nil {
+		t.Errorf("%s/%s: %v", serviceName, accountName, err)
+	} else if string(password) != expected {
+		t.Errorf("%s/%s: Expected %q, got %q", serviceName, accountName, expected, password)
+	}
+}
+
+func checkNotFound(t *testing.T, store osxkeychain.Store, serviceName, accountName string) {
+	_, err := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{
+		ServiceName: serviceName,
+		AccountName: accountName,
+	})
+	if err != osxkeychain.ErrItemNotFound {
+		t.Errorf("%s/%s: Expected ErrItemNotFound, got %v", serviceName, accountName, err)
+	}
+}
+
+func TestMigrateGenericPasswords(t *testing.T) {
+	var from, to storetest.MemStore
+	addTestItems(t, &from, map[[2]string]string{
+		{"db", "prod"}:    "s3cret",
+		{"db", "staging"}: "staging",
+		{"api", "token"}:  "tok",
+		{"other", "x"}:    "left alone",
+	})
+	// Already migrated, say by an earlier run that was interrupted.
+	addTestItems(t, &to, map[[2]string]string{
+		{"api", "token"}: "tok",
+	})
+
+	migrated, err := osxkeychain.MigrateGenericPasswords(&from, &to, []string{"db", "api", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []osxkeychain.GenericPasswordAttributes{
+		{ServiceName: "db", AccountName: "prod"},
+		{ServiceName: "db", AccountName: "staging"},
+		{ServiceName: "api", AccountName: "token"},
+	}
+	if !reflect.DeepEqual(migrated, expected) {
+		t.Errorf("Expected %v, got %v", expected, migrated)
+	}
+
+	checkPassword(t, &to, "db", "prod", "s3cret")
+	checkPassword(t, &to, "db", "staging", "staging")
+	checkPassword(t, &to, "api", "token", "tok")
+	checkNotFound(t, &from, "db", "prod")
+	checkNotFound(t, &from, "db", "staging")
+	checkNotFound(t, &from, "api", "token")
+	checkPassword(t, &from, "other", "x", "left alone")
+	checkNotFound(t, &to, "other", "x")
+}
+
+func TestMigrateGenericPasswordsConflict(t *testing.T) {
+	var from, to storetest.MemStore
+	addTestItems(t, &from, map[[2]string]string{
+		{"db", "prod"}: "new",
+	})
+	addTestItems(t, &to, map[[2]string]string{
+		{"db", "prod"}: "old",
+	})
+
+	migrated, err := osxkeychain.MigrateGenericPasswords(&from, &to, []string{"db"})
+	if len(migrated) != 0 {
+		t.Errorf("Expected nothing migrated, got %v", migrated)
+	}
+	var migrationErr *osxkeychain.MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.ServiceName != "db" || migrationErr.AccountName != "prod" ||
+		migrationErr.Err != osxkeychain.ErrMigrationMismatch {
+		t.Fatalf("Expected a mismatch migrating db/prod, got %v", err)
+	}
+	expected := "migrating db/prod: copy doesn't match the original"
+	if err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+
+	// Neither side is touched.
+	checkPassword(t, &from, "db", "prod", "new")
+	checkPassword(t, &to, "db", "prod", "old")
+}
+
+func TestMigrateGenericPasswordsVerifyFailure(t *testing.T) {
+	var from storetest.MemStore
+	to := &corruptingStore{}
+	addTestItems(t, &from, map[[2]string]string{
+		{"db", "prod"}: "s3cret",
+	})
+
+	_, err := osxkeychain.MigrateGenericPasswords(&from, to, []string{"db"})
+	if !errors.Is(err, osxkeychain.ErrMigrationMismatch) {
+		t.Fatalf("Expected ErrMigrationMismatch, got %v", err)
+	}
+
+	// The bad copy is removed and the original kept.
+	checkPassword(t, &from, "db", "prod", "s3cret")
+	if items := to.Items(); len(items) != 0 {
+		t.Errorf("Expected the copy to be removed, got %v", items)
+	}
+}
+
+func TestMigrateGenericPasswordsAddFailure(t *testing.T) {
+	var from, to storetest.MemStore
+	addTestItems(t, &from, map[[2]string]string{
+		{"db", "prod"}: "s3cret",
+	})
+
+	_, err := osxkeychain.MigrateGenericPasswords(&from, rejectingStore{&to}, []string{"db"})
+	if !errors.Is(err, osxkeychain.ErrNotAvailable) {
+		t.Fatalf("Expected ErrNotAvailable, got %v", err)
+	}
+	checkPassword(t, &from, "db", "prod", "s3cret")
+}
+
+// rejectingStore fails every addition with ErrNotAvailable.
+type rejectingStore struct {
+	*storetest.MemStore
+}
+
+func (rejectingStore) AddGenericPassword(*osxkeychain.GenericPasswordAttributes) error {
+	return osxkeychain.ErrNotAvailable
+}
+
+func TestMigrateGenericPasswordsEmptyAccountName(t *testing.T) {
+	var from storetest.MemStore
+	var to storetest.MemStore
+	addTestItems(t, &from, map[[2]string]string{
+		{"db", "prod"}: "s3cret",
+		{"db", ""}:     "no account",
+	})
+
+	migrated, err := osxkeychain.MigrateGenericPasswords(&from, &to, []string{"db"})
+	var migrationErr *osxkeychain.MigrationError
+	if !errors.As(err, &migrationErr) || migrationErr.ServiceName != "db" || migrationErr.Err != osxkeychain.ErrMigrationEmptyName {
+		t.Fatalf("Expected a MigrationError for db with ErrMigrationEmptyName, got %v", err)
+	}
+	if len(migrated) != 0 {
+		t.Errorf("Expected nothing migrated, got %v", migrated)
+	}
+	// Neither item was copied or removed.
+	checkPassword(t, &from, "db", "prod", "s3cret")
+	if items := from.Items(); len(items) != 2 {
+		t.Errorf("Expected both items to be left in the source, got %v", items)
+	}
+	if items := to.Items(); len(items) != 0 {
+		t.Errorf("Expected nothing in the destination, got %v", items)
+	}
+
+	if _, err := osxkeychain.MigrateGenericPasswords(&from, &to, []string{""}); !errors.As(err, &migrationErr) || migrationErr.Err != osxkeychain.ErrMigrationEmptyName {
+		t.Errorf("Expected ErrMigrationEmptyName for an empty service name, got %v", err)
+	}
+}
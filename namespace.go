@@ -0,0 +1,176 @@
+package osxkeychain
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// namespaceSeparator separates a namespace's name from the names it
+// prefixes.
+const namespaceSeparator = "::"
+
+// Namespace is a Store that keeps its items apart from those of other
+// namespaces sharing the same underlying Store. It prefixes every
+// ServiceName, and optionally every AccountName, with the name of the
+// namespace followed by "::", and strips the prefix again from the
+// account names it returns.
+//
+// Names passed to a Namespace must not contain "::", since such a
+// name could address an item in a nested namespace, nor start or end
+// with ':', since namespace "a" with service ":x" and namespace "a:"
+// with service "x" would both be "a:::x". Operations on such names
+// fail without reaching the underlying Store.
+//
+// An empty ServiceName, or an empty AccountName if account names are
+// prefixed, would only match the prefix itself in the underlying
+// Store rather than any item of the namespace, so finding, removing
+// or updating with one fails too.
+type Namespace struct {
+	store             Store
+	prefix            string
+	prefixAccountName bool
+}
+
+func checkNamespaceName(paramName, paramValue string) error {
+	if strings.Contains(paramValue, namespaceSeparator) {
+		return errors.New(paramName + " contains the namespace separator \"" + namespaceSeparator + "\"")
+	}
+	if strings.HasPrefix(paramValue, ":") || strings.HasSuffix(paramValue, ":") {
+		return errors.New(paramName + " starts or ends with ':'")
+	}
+	return nil
+}
+
+// NewNamespace returns a Namespace with the given name that keeps its
+// items in the given Store. If prefixAccountName is true, account
+// names are prefixed as well as service names, and account names
+// without the prefix are left out of GetAllAccountNames.
+func NewNamespace(store Store, name string, prefixAccountName bool) (*Namespace, error) {
+	if name == "" {
+		return nil, errors.New("namespace name is empty")
+	}
+	if !utf8.ValidString(name) {
+		return nil, errors.New("namespace name is not a valid UTF-8 string")
+	}
+	if err := checkNamespaceName("namespace name", name); err != nil {
+		return nil, err
+	}
+	return &Namespace{
+		store:             store,
+		prefix:            name + namespaceSeparator,
+		prefixAccountName: prefixAccountName,
+	}, nil
+}
+
+// Namespace returns a namespace with the given name nested within n,
+// sharing n's underlying Store.
+func (n *Namespace) Namespace(name string, prefixAccountName bool) (*Namespace, error) {
+	nested, err := NewNamespace(n.store, name, prefixAccountName)
+	if err != nil {
+		return nil, err
+	}
+	nested.prefix = n.prefix + nested.prefix
+	return nested, nil
+}
+
+// wrap returns a copy of attributes with the namespace's prefixes
+// applied.
+func (n *Namespace) wrap(attributes *GenericPasswordAttributes) (*GenericPasswordAttributes, error) {
+	if err := checkNamespaceName("ServiceName", attributes.ServiceName); err != nil {
+		return nil, err
+	}
+	wrapped := *attributes
+	wrapped.ServiceName = n.prefix + attributes.ServiceName
+	if n.prefixAccountName {
+		if err := checkNamespaceName("AccountName", attributes.AccountName); err != nil {
+			return nil, err
+		}
+		wrapped.AccountName = n.prefix + attributes.AccountName
+	}
+	return &wrapped, nil
+}
+
+// wrapMatch is wrap for finding, removing or updating, which fails
+// for empty names the prefix would keep from matching any.
+func (n *Namespace) wrapMatch(attributes *GenericPasswordAttributes) (*GenericPasswordAttributes, error) {
+	if attributes.ServiceName == "" {
+		return nil, errors.New("ServiceName is empty, which a Namespace can't match")
+	}
+	if n.prefixAccountName && attributes.AccountName == "" {
+		return nil, errors.New("AccountName is empty, which a Namespace that prefixes it can't match")
+	}
+	return n.wrap(attributes)
+}
+
+// AddGenericPassword adds a generic password with the given
+// attributes to the namespace.
+func (n *Namespace) AddGenericPassword(attributes *GenericPasswordAttributes) error {
+	wrapped, err := n.wrap(attributes)
+	if err != nil {
+		return err
+	}
+	return n.store.AddGenericPassword(wrapped)
+}
+
+// FindGenericPassword finds a generic password with the given
+// attributes in the namespace.
+func (n *Namespace) FindGenericPassword(attributes *GenericPasswordAttributes) ([]byte, error) {
+	wrapped, err := n.wrapMatch(attributes)
+	if err != nil {
+		return nil, err
+	}
+	return n.store.FindGenericPassword(wrapped)
+}
+
+// FindAndRemoveGenericPassword finds a generic password with the
+// given attributes in the namespace and removes it.
+func (n *Namespace) FindAndRemoveGenericPassword(attributes *GenericPasswordAttributes) error {
+	wrapped, err := n.wrapMatch(attributes)
+	if err != nil {
+		return err
+	}
+	return n.store.FindAndRemoveGenericPassword(wrapped)
+}
+
+// RemoveAndAddGenericPassword replaces a generic password with the
+// given attributes in the namespace.
+func (n *Namespace) RemoveAndAddGenericPassword(attributes *GenericPasswordAttributes) error {
+	wrapped, err := n.wrapMatch(attributes)
+	if err != nil {
+		return err
+	}
+	return n.store.RemoveAndAddGenericPassword(wrapped)
+}
+
+// UpdateGenericPassword updates the password of a generic password
+// with the given attributes in the namespace.
+func (n *Namespace) UpdateGenericPassword(attributes *GenericPasswordAttributes) error {
+	wrapped, err := n.wrapMatch(attributes)
+	if err != nil {
+		return err
+	}
+	return n.store.UpdateGenericPassword(wrapped)
+}
+
+// GetAllAccountNames returns a list of all account names for the
+// given service name in the namespace.
+func (n *Namespace) GetAllAccountNames(serviceName string) ([]string, error) {
+	if err := checkNamespaceName("ServiceName", serviceName); err != nil {
+		return nil, err
+	}
+	wrappedAccountNames, err := n.store.GetAllAccountNames(n.prefix + serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if !n.prefixAccountName {
+		return wrappedAccountNames, nil
+	}
+	accountNames := []string{}
+	for _, accountName := range wrappedAccountNames {
+		if strings.HasPrefix(accountName, n.prefix) {
+			accountNames = append(accountNames, strings.TrimPrefix(accountName, n.prefix))
+		}
+	}
+	return accountNames, nil
+}
@@ -0,0 +1,235 @@
+package osxkeychain_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+func TestNamespace(t *testing.T) {
+	store := &storetest.MemStore{}
+	app1, err := osxkeychain.NewNamespace(store, "app1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app2, err := osxkeychain.NewNamespace(store, "app2", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attributes := osxkeychain.GenericPasswordAttributes{
+		ServiceName: "service with unicode テスト",
+		AccountName: "account",
+		Password:    []byte("app1 password"),
+	}
+	if err := app1.AddGenericPassword(&attributes); err != nil {
+		t.Fatal(err)
+	}
+
+	// The caller's attributes must not be modified.
+	if attributes.ServiceName != "service with unicode テスト" {
+		t.Errorf("Expected attributes to be left alone, got service name %s", attributes.ServiceName)
+	}
+
+	// The same names in another namespace don't collide.
+	attributes.Password = []byte("app2 password")
+	if err := app2.AddGenericPassword(&attributes); err != nil {
+		t.Fatal(err)
+	}
+	if err := app1.AddGenericPassword(&attributes); err != osxkeychain.ErrDuplicateItem {
+		t.Errorf("Expected osxkeychain.ErrDuplicateItem, got %v", err)
+	}
+
+	password, err := app1.FindGenericPassword(&attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(password) != "app1 password" {
+		t.Errorf("Expected app1 password, got %q", password)
+	}
+
+	// The underlying store sees the prefixed names.
+	underlying := osxkeychain.GenericPasswordAttributes{
+		ServiceName: "app2::service with unicode テスト",
+		AccountName: "app2::account",
+	}
+	password, err = store.FindGenericPassword(&underlying)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(password) != "app2 password" {
+		t.Errorf("Expected app2 password, got %q", password)
+	}
+
+	if err := app2.RemoveAndAddGenericPassword(&attributes); err != nil {
+		t.Error(err)
+	}
+	attributes.Password = []byte("updated app2 password")
+	if err := app2.UpdateGenericPassword(&attributes); err != nil {
+		t.Error(err)
+	}
+	password, err = store.FindGenericPassword(&underlying)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(password) != "updated app2 password" {
+		t.Errorf("Expected updated app2 password, got %q", password)
+	}
+	if err := app2.FindAndRemoveGenericPassword(&attributes); err != nil {
+		t.Error(err)
+	}
+	if _, err := app2.FindGenericPassword(&attributes); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("Expected osxkeychain.ErrItemNotFound, got %v", err)
+	}
+	if _, err := app1.FindGenericPassword(&attributes); err != nil {
+		t.Errorf("Expected app1 item to survive, got %v", err)
+	}
+}
+
+func TestNamespaceGetAllAccountNames(t *testing.T) {
+	store := &storetest.MemStore{}
+	ns, err := osxkeychain.NewNamespace(store, "app", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, accountName := range []string{"a", "b"} {
+		err := ns.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "service", AccountName: accountName})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An item under the namespace's service name but created
+	// outside the namespace.
+	err = store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "app::service", AccountName: "outsider"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "service", AccountName: "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accountNames, err := ns.GetAllAccountNames("service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(accountNames, []string{"a", "b"}) {
+		t.Errorf("Expected [a b], got %v", accountNames)
+	}
+}
+
+func TestNamespaceEscape(t *testing.T) {
+	store := &storetest.MemStore{}
+	parent, err := osxkeychain.NewNamespace(store, "parent", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := parent.Namespace("child", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = child.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "service", AccountName: "account"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{
+		ServiceName: "parent::child::service",
+		AccountName: "parent::child::account",
+	}); err != nil {
+		t.Errorf("Expected nested prefix, got %v", err)
+	}
+
+	// The parent can't reach into the child.
+	escaping := osxkeychain.GenericPasswordAttributes{ServiceName: "child::service", AccountName: "child::account"}
+	errServiceName := "ServiceName contains the namespace separator \"::\""
+	if _, err := parent.FindGenericPassword(&escaping); err == nil || err.Error() != errServiceName {
+		t.Errorf("Expected \"%s\", got %v", errServiceName, err)
+	}
+	if _, err := parent.GetAllAccountNames(escaping.ServiceName); err == nil || err.Error() != errServiceName {
+		t.Errorf("Expected \"%s\", got %v", errServiceName, err)
+	}
+
+	escaping.ServiceName = "service"
+	errAccountName := "AccountName contains the namespace separator \"::\""
+	if err := parent.FindAndRemoveGenericPassword(&escaping); err == nil || err.Error() != errAccountName {
+		t.Errorf("Expected \"%s\", got %v", errAccountName, err)
+	}
+
+	for _, name := range []string{"", "a::b", "a:", ":a", "invalid UTF-8 \xc3\x28"} {
+		if _, err := osxkeychain.NewNamespace(store, name, false); err == nil {
+			t.Errorf("Expected error for namespace name %q", name)
+		}
+	}
+}
+
+func TestNamespaceNamesDontCollide(t *testing.T) {
+	store := &storetest.MemStore{}
+	a, err := osxkeychain.NewNamespace(store, "a", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := osxkeychain.NewNamespace(store, "a:", true); err == nil {
+		t.Error("Expected namespace \"a:\" to be refused")
+	}
+
+	// Namespace "a" can't reach "a:::x", which would be namespace
+	// "a:" with service "x".
+	expected := "ServiceName starts or ends with ':'"
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: ":x", AccountName: "acct"}
+	if err := a.AddGenericPassword(attributes); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+	attributes = &osxkeychain.GenericPasswordAttributes{ServiceName: "x:", AccountName: "acct"}
+	if _, err := a.FindGenericPassword(attributes); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+	expected = "AccountName starts or ends with ':'"
+	attributes = &osxkeychain.GenericPasswordAttributes{ServiceName: "x", AccountName: ":acct"}
+	if err := a.FindAndRemoveGenericPassword(attributes); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+	if len(store.Items()) != 0 {
+		t.Errorf("Expected nothing to reach the store, got %v", store.Items())
+	}
+}
+
+func TestNamespaceEmptyNames(t *testing.T) {
+	store := &storetest.MemStore{}
+	store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "outside", AccountName: "acct", Password: []byte("outside")})
+	app1, err := osxkeychain.NewNamespace(store, "app1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app2, err := osxkeychain.NewNamespace(store, "app2", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []*osxkeychain.Namespace{app1, app2} {
+		n.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("inside")})
+	}
+
+	// An empty AccountName matches any account of the service, unless
+	// account names are prefixed.
+	password, err := app1.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc"})
+	if err != nil || string(password) != "inside" {
+		t.Errorf("Expected \"inside\", got %q, %v", password, err)
+	}
+	expected := "AccountName is empty, which a Namespace that prefixes it can't match"
+	if _, err := app2.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc"}); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+
+	// An empty ServiceName could only match items outside the
+	// namespace.
+	expected = "ServiceName is empty, which a Namespace can't match"
+	if err := app1.FindAndRemoveGenericPassword(&osxkeychain.GenericPasswordAttributes{AccountName: "acct"}); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+	if len(store.Items()) != 3 {
+		t.Errorf("Expected every item to be kept, got %v", store.Items())
+	}
+}
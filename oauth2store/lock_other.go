@@ -0,0 +1,10 @@
+//go:build !unix
+
+package oauth2store
+
+// lockFile does nothing on platforms without flock, so only
+// goroutines sharing a TokenSource are kept from refreshing at the
+// same time.
+func lockFile(path string) (unlock func(), err error) {
+	return func() {}, nil
+}
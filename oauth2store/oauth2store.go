@@ -0,0 +1,155 @@
+// Package oauth2store keeps golang.org/x/oauth2 tokens in an
+// osxkeychain.Store, such as the default keychain, instead of in
+// plaintext files.
+package oauth2store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/keybase/go-osxkeychain"
+	"golang.org/x/oauth2"
+)
+
+// TokenStore keeps an oauth2.Token, encoded as JSON, in the password
+// of a generic password item.
+type TokenStore struct {
+	Store       osxkeychain.Store
+	ServiceName string
+	AccountName string
+
+	// LockPath is the path of the file that is locked while the
+	// token is refreshed, so that processes sharing the item don't
+	// refresh it at the same time. If empty, a file named after the
+	// item in the user's cache directory is used.
+	LockPath string
+}
+
+func (s *TokenStore) attributes(password []byte) *osxkeychain.GenericPasswordAttributes {
+	return &osxkeychain.GenericPasswordAttributes{
+		ServiceName: s.ServiceName,
+		AccountName: s.AccountName,
+		Password:    password,
+	}
+}
+
+// LoadToken returns the saved token. If no token has been saved, it
+// returns osxkeychain.ErrItemNotFound.
+func (s *TokenStore) LoadToken() (*oauth2.Token, error) {
+	data, err := s.Store.FindGenericPassword(s.attributes(nil))
+	if err != nil {
+		return nil, err
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// SaveToken saves the given token, updating the item in place if it
+// already exists.
+func (s *TokenStore) SaveToken(token *oauth2.Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	attributes := s.attributes(data)
+	err = s.Store.UpdateGenericPassword(attributes)
+	if err != osxkeychain.ErrItemNotFound {
+		return err
+	}
+	err = s.Store.AddGenericPassword(attributes)
+	if err == osxkeychain.ErrDuplicateItem {
+		// Another process added it in the meantime.
+		return s.Store.UpdateGenericPassword(attributes)
+	}
+	return err
+}
+
+func (s *TokenStore) lockPath() (string, error) {
+	if s.LockPath != "" {
+		return s.LockPath, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	dir = filepath.Join(dir, "oauth2store")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(s.ServiceName + "\x00" + s.AccountName))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".lock"), nil
+}
+
+type tokenSource struct {
+	ctx    context.Context
+	config *oauth2.Config
+	store  *TokenStore
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+// TokenSource returns an oauth2.TokenSource that returns the token
+// saved in store, refreshing it with config when it has expired and
+// saving the refreshed token back to store. A token must have been
+// saved with SaveToken first, for example after exchanging an
+// authorization code.
+//
+// While refreshing, the source holds a lock on store's LockPath, and
+// after acquiring it loads the token again, so that only one of
+// several processes sharing the item refreshes it.
+func TokenSource(ctx context.Context, config *oauth2.Config, store *TokenStore) oauth2.TokenSource {
+	return &tokenSource{
+		ctx:    ctx,
+		config: config,
+		store:  store,
+	}
+}
+
+func (ts *tokenSource) Token() (*oauth2.Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token.Valid() {
+		return ts.token, nil
+	}
+
+	path, err := ts.store.lockPath()
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	token, err := ts.store.LoadToken()
+	if err != nil {
+		return nil, err
+	}
+	if token.Valid() {
+		ts.token = token
+		return token, nil
+	}
+
+	// The config's token source refreshes token, since it has
+	// expired.
+	token, err = ts.config.TokenSource(ts.ctx, token).Token()
+	if err != nil {
+		return nil, err
+	}
+	if err := ts.store.SaveToken(token); err != nil {
+		return nil, err
+	}
+	ts.token = token
+	return token, nil
+}
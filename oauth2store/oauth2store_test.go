@@ -0,0 +1,180 @@
+package oauth2store
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/storetest"
+	"golang.org/x/oauth2"
+)
+
+// newTokenServer returns a token endpoint that hands out access tokens
+// "access-1", "access-2", ... for the refresh token "refresh".
+func newTokenServer(t *testing.T, refreshes *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != "refresh" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		n := atomic.AddInt32(refreshes, 1)
+		// Make concurrent refreshes likely to overlap.
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"access-%d","token_type":"bearer","expires_in":3600}`, n)
+	}))
+}
+
+func newTestConfig(server *httptest.Server) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{
+			TokenURL:  server.URL,
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+func newTestTokenStore(t *testing.T) *TokenStore {
+	return &TokenStore{
+		Store:       &storetest.MemStore{},
+		ServiceName: "oauth2store_test",
+		AccountName: "user@example.com",
+		LockPath:    filepath.Join(t.TempDir(), "token.lock"),
+	}
+}
+
+func TestTokenSourceValidToken(t *testing.T) {
+	var refreshes int32
+	server := newTokenServer(t, &refreshes)
+	defer server.Close()
+
+	store := newTestTokenStore(t)
+	saved := &oauth2.Token{AccessToken: "access-0", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+	if err := store.SaveToken(saved); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := TokenSource(context.Background(), newTestConfig(server), store).Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-0" {
+		t.Errorf("Expected access-0, got %s", token.AccessToken)
+	}
+	if refreshes != 0 {
+		t.Errorf("Expected no refreshes, got %d", refreshes)
+	}
+}
+
+func TestTokenSourceRefresh(t *testing.T) {
+	var refreshes int32
+	server := newTokenServer(t, &refreshes)
+	defer server.Close()
+
+	store := newTestTokenStore(t)
+	saved := &oauth2.Token{AccessToken: "access-0", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Hour)}
+	if err := store.SaveToken(saved); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := TokenSource(context.Background(), newTestConfig(server), store)
+	for i := 0; i < 2; i++ {
+		token, err := ts.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.AccessToken != "access-1" {
+			t.Errorf("Expected access-1, got %s", token.AccessToken)
+		}
+	}
+	if refreshes != 1 {
+		t.Errorf("Expected 1 refresh, got %d", refreshes)
+	}
+
+	// The refreshed token is written back, keeping the refresh token.
+	token, err := store.LoadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-1" || token.RefreshToken != "refresh" || !token.Valid() {
+		t.Errorf("Expected refreshed token to be saved, got %+v", token)
+	}
+	if items := store.Store.(*storetest.MemStore).Items(); len(items) != 1 {
+		t.Errorf("Expected 1 item, got %d", len(items))
+	}
+}
+
+// Token sources that don't share a cache, like separate processes,
+// must not refresh the same token more than once.
+func TestTokenSourceConcurrentRefresh(t *testing.T) {
+	var refreshes int32
+	server := newTokenServer(t, &refreshes)
+	defer server.Close()
+
+	store := newTestTokenStore(t)
+	saved := &oauth2.Token{AccessToken: "access-0", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Hour)}
+	if err := store.SaveToken(saved); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := TokenSource(context.Background(), newTestConfig(server), store).Token()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if token.AccessToken != "access-1" {
+				t.Errorf("Expected access-1, got %s", token.AccessToken)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if refreshes != 1 {
+		t.Errorf("Expected 1 refresh, got %d", refreshes)
+	}
+}
+
+func TestTokenSourceErrors(t *testing.T) {
+	var refreshes int32
+	server := newTokenServer(t, &refreshes)
+	defer server.Close()
+
+	store := newTestTokenStore(t)
+	ts := TokenSource(context.Background(), newTestConfig(server), store)
+	if _, err := ts.Token(); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("Expected ErrItemNotFound, got %v", err)
+	}
+
+	saved := &oauth2.Token{AccessToken: "access-0", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Hour)}
+	if err := store.SaveToken(saved); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Token(); err == nil {
+		t.Error("Expected error for rejected refresh token")
+	}
+
+	// A failed refresh leaves the saved token alone.
+	token, err := store.LoadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-0" {
+		t.Errorf("Expected access-0, got %s", token.AccessToken)
+	}
+}
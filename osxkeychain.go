@@ -1,4 +1,4 @@
-// +build darwin,!ios
+// +build darwin,!ios,cgo
 
 package osxkeychain
 
@@ -7,7 +7,7 @@ package osxkeychain
 // Also see https://developer.apple.com/library/ios/documentation/Security/Conceptual/keychainServConcepts/01introduction/introduction.html .
 
 /*
-#cgo CFLAGS: -mmacosx-version-min=10.6 -D__MAC_OS_X_VERSION_MAX_ALLOWED=1060
+#cgo CFLAGS: -mmacosx-version-min=10.15 -D__MAC_OS_X_VERSION_MAX_ALLOWED=101500
 #cgo LDFLAGS: -framework CoreFoundation -framework Security
 
 #include <stdlib.h>
@@ -19,94 +19,10 @@ import "C"
 import (
 	"errors"
 	"fmt"
-	"math"
 	"unicode/utf8"
 	"unsafe"
 )
 
-// GenericPasswordAttributes holds all the info for a generic password
-// in a keychain.
-//
-// All string fields must have size that fits in 32 bits. All string
-// fields except for Password must be encoded in UTF-8.
-//
-// TrustedApplications is a list of additional application (paths)
-// that should have access to the keychain item. The application
-// that creates the keychain item always has access, so this list
-// is for additional apps or executables.
-type GenericPasswordAttributes struct {
-	ServiceName         string
-	AccountName         string
-	Password            []byte
-	TrustedApplications []string
-}
-
-func check32Bit(paramName string, paramValue []byte) error {
-	if uint64(len(paramValue)) > math.MaxUint32 {
-		return errors.New(paramName + " has size overflowing 32 bits")
-	}
-	return nil
-}
-
-func check32BitUTF8(paramName, paramValue string) error {
-	if uint64(len(paramValue)) > math.MaxUint32 {
-		return errors.New(paramName + " has size overflowing 32 bits")
-	}
-	if !utf8.ValidString(paramValue) {
-		return errors.New(paramName + " is not a valid UTF-8 string")
-	}
-	return nil
-}
-
-// CheckValidity returns an error if any of the attributes in the
-// given GenericPasswordAttributes are invalid. Otherwise, it returns
-// nil.
-func (attributes *GenericPasswordAttributes) CheckValidity() error {
-	if err := check32BitUTF8("ServiceName", attributes.ServiceName); err != nil {
-		return err
-	}
-	if err := check32BitUTF8("AccountName", attributes.AccountName); err != nil {
-		return err
-	}
-	if err := check32Bit("Password", attributes.Password); err != nil {
-		return err
-	}
-	for _, trustedApplication := range attributes.TrustedApplications {
-		if err := check32BitUTF8("TrustedApplications", trustedApplication); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-type keychainError C.OSStatus
-
-// Error codes from https://developer.apple.com/library/mac/documentation/security/Reference/keychainservices/Reference/reference.html#//apple_ref/doc/uid/TP30000898-CH5g-CJBEABHG
-const (
-	ErrUnimplemented     keychainError = C.errSecUnimplemented
-	ErrParam             keychainError = C.errSecParam
-	ErrAllocate          keychainError = C.errSecAllocate
-	ErrNotAvailable      keychainError = C.errSecNotAvailable
-	ErrReadOnly          keychainError = C.errSecReadOnly
-	ErrAuthFailed        keychainError = C.errSecAuthFailed
-	ErrNoSuchKeychain    keychainError = C.errSecNoSuchKeychain
-	ErrInvalidKeychain   keychainError = C.errSecInvalidKeychain
-	ErrDuplicateKeychain keychainError = C.errSecDuplicateKeychain
-	ErrDuplicateCallback keychainError = C.errSecDuplicateCallback
-	ErrInvalidCallback   keychainError = C.errSecInvalidCallback
-	ErrDuplicateItem     keychainError = C.errSecDuplicateItem
-	ErrItemNotFound      keychainError = C.errSecItemNotFound
-	ErrBufferTooSmall    keychainError = C.errSecBufferTooSmall
-	ErrDataTooLarge      keychainError = C.errSecDataTooLarge
-	ErrNoSuchAttr        keychainError = C.errSecNoSuchAttr
-	ErrInvalidItemRef    keychainError = C.errSecInvalidItemRef
-	ErrInvalidSearchRef  keychainError = C.errSecInvalidSearchRef
-	ErrNoSuchClass       keychainError = C.errSecNoSuchClass
-	ErrNoDefaultKeychain keychainError = C.errSecNoDefaultKeychain
-	ErrReadOnlyAttr      keychainError = C.errSecReadOnlyAttr
-	// TODO: Fill out more of these?
-)
-
 func newKeychainError(errCode C.OSStatus) error {
 	if errCode == C.noErr {
 		return nil
@@ -143,7 +59,14 @@ func (ke keychainError) Error() string {
 // AddGenericPassword adds a generic password with the given
 // attributes to the default keychain.
 func AddGenericPassword(attributes *GenericPasswordAttributes) (err error) {
-	if err = attributes.CheckValidity(); err != nil {
+	keychainExecutor.do(func() {
+		err = addGenericPassword(attributes)
+	})
+	return
+}
+
+func addGenericPassword(attributes *GenericPasswordAttributes) (err error) {
+	if err = attributes.CheckAddValidity(); err != nil {
 		return
 	}
 
@@ -179,6 +102,12 @@ func AddGenericPassword(attributes *GenericPasswordAttributes) (err error) {
 		query[secAttrAccess] = C.CFTypeRef(access)
 	}
 
+	release, err := addDataProtectionAttributes(query, attributes, true)
+	if err != nil {
+		return
+	}
+	defer release()
+
 	queryDict := mapToCFDictionary(query)
 	defer C.CFRelease(C.CFTypeRef(queryDict))
 
@@ -191,11 +120,28 @@ func AddGenericPassword(attributes *GenericPasswordAttributes) (err error) {
 // FindGenericPassword finds a generic password with the given
 // attributes in the default keychain and returns the password field
 // if found. If not found, an error is returned.
-func FindGenericPassword(attributes *GenericPasswordAttributes) ([]byte, error) {
+//
+// Items added with AccessControl, Accessible or OperationPrompt set
+// are kept in the data-protection keychain, and are only found if one
+// of those fields, usually OperationPrompt, is set here too. The user
+// may be asked to authenticate, in which case ErrUserCanceled is
+// returned if they decline.
+func FindGenericPassword(attributes *GenericPasswordAttributes) (password []byte, err error) {
+	keychainExecutor.do(func() {
+		password, err = findGenericPassword(attributes)
+	})
+	return
+}
+
+func findGenericPassword(attributes *GenericPasswordAttributes) ([]byte, error) {
 	if err := attributes.CheckValidity(); err != nil {
 		return nil, err
 	}
 
+	if attributes.usesDataProtectionKeychain() {
+		return findProtectedGenericPassword(attributes)
+	}
+
 	serviceName := C.CString(attributes.ServiceName)
 	defer C.free(unsafe.Pointer(serviceName))
 
@@ -229,7 +175,22 @@ func FindGenericPassword(attributes *GenericPasswordAttributes) ([]byte, error)
 // FindAndRemoveGenericPassword finds a generic password with the
 // given attributes in the default keychain and removes it if
 // found. If not found, an error is returned.
-func FindAndRemoveGenericPassword(attributes *GenericPasswordAttributes) error {
+func FindAndRemoveGenericPassword(attributes *GenericPasswordAttributes) (err error) {
+	keychainExecutor.do(func() {
+		err = findAndRemoveGenericPassword(attributes)
+	})
+	return
+}
+
+func findAndRemoveGenericPassword(attributes *GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+
+	if attributes.usesDataProtectionKeychain() {
+		return removeProtectedGenericPassword(attributes)
+	}
+
 	itemRef, err := findGenericPasswordItem(attributes)
 	if err != nil {
 		return err
@@ -241,9 +202,56 @@ func FindAndRemoveGenericPassword(attributes *GenericPasswordAttributes) error {
 	return newKeychainError(errCode)
 }
 
+// UpdateGenericPassword finds a generic password with the given
+// attributes in the default keychain and replaces its password with
+// attributes.Password in place, keeping the item's access controls
+// and trust settings. TrustedApplications is ignored. If not found,
+// an error is returned.
+//
+// Since the item's access controls are kept, see the caveat on
+// RemoveAndAddGenericPassword: an app that created the item with an
+// access control list of its choosing can read what is written here.
+func UpdateGenericPassword(attributes *GenericPasswordAttributes) (err error) {
+	keychainExecutor.do(func() {
+		err = updateGenericPassword(attributes)
+	})
+	return
+}
+
+func updateGenericPassword(attributes *GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+
+	if attributes.usesDataProtectionKeychain() {
+		return updateProtectedGenericPassword(attributes)
+	}
+
+	itemRef, err := findGenericPasswordItem(attributes)
+	if err != nil {
+		return err
+	}
+
+	defer C.CFRelease(C.CFTypeRef(itemRef))
+
+	var password unsafe.Pointer
+	if len(attributes.Password) > 0 {
+		password = unsafe.Pointer(&attributes.Password[0])
+	}
+
+	errCode := C.SecKeychainItemModifyAttributesAndData(
+		itemRef,
+		nil, // leave attributes alone
+		C.UInt32(len(attributes.Password)),
+		password,
+	)
+	return newKeychainError(errCode)
+}
+
 // RemoveAndAddGenericPassword calls FindAndRemoveGenericPassword()
 // with the given attributes (ignoring ErrItemNotFound) and then calls
-// AddGenericPassword with the same attributes.
+// AddGenericPassword with the same attributes. The two calls are
+// serialized separately, so other calls may run in between.
 //
 // https://developer.apple.com/library/mac/documentation/Security/Reference/keychainservices/index.html says:
 //
@@ -400,21 +408,85 @@ func bytesToCFData(b []byte) C.CFDataRef {
 	return C.CFDataCreate(nil, p, C.CFIndex(len(b)))
 }
 
+func _CFDataToBytes(d C.CFDataRef) []byte {
+	return C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(d)), C.int(C.CFDataGetLength(d)))
+}
+
 // GetAllAccountNames returns a list of all account names for the
 // given service name in the default keychain.
 func GetAllAccountNames(serviceName string) (accountNames []string, err error) {
-	var serviceNameString C.CFStringRef
-	if serviceNameString, err = _UTF8StringToCFString(serviceName); err != nil {
+	keychainExecutor.do(func() {
+		accountNames, err = getAllAccountNames(&GenericPasswordAttributes{ServiceName: serviceName})
+	})
+	return
+}
+
+// getAllAccountNames returns the account names for
+// attributes.ServiceName in the keychain the attributes select.
+func getAllAccountNames(attributes *GenericPasswordAttributes) ([]string, error) {
+	items, err := listGenericPasswords(attributes, true)
+	if err != nil {
+		return nil, err
+	}
+	accountNames := []string{}
+	for _, item := range items {
+		accountNames = append(accountNames, item.AccountName)
+	}
+	return accountNames, nil
+}
+
+// ListGenericPasswords returns the generic passwords matching the
+// given attributes, without their passwords. An empty ServiceName or
+// AccountName matches any, and Synchronizable, UseDataProtectionKeychain
+// and AccessGroup select items as they do for FindGenericPassword; use
+// SynchronizableAny to list both local and synchronized items. Each
+// returned item's Synchronizable is SynchronizableYes or
+// SynchronizableNo, and its AccessGroup is set if it is in the
+// data-protection keychain.
+func ListGenericPasswords(attributes *GenericPasswordAttributes) (items []GenericPasswordAttributes, err error) {
+	if err = attributes.CheckValidity(); err != nil {
 		return
 	}
-	defer C.CFRelease(C.CFTypeRef(serviceNameString))
+	keychainExecutor.do(func() {
+		items, err = listGenericPasswords(attributes, false)
+	})
+	return
+}
 
+// listGenericPasswords implements ListGenericPasswords. If
+// matchEmptyServiceName is true, an empty ServiceName matches only
+// items with an empty service name, as GetAllAccountNames always has.
+func listGenericPasswords(attributes *GenericPasswordAttributes, matchEmptyServiceName bool) (items []GenericPasswordAttributes, err error) {
 	query := map[C.CFTypeRef]C.CFTypeRef{
 		secClass:            secClassGenericPassword,
-		secAttrService:      C.CFTypeRef(serviceNameString),
 		secMatchLimit:       secMatchLimitAll,
 		secReturnAttributes: C.CFTypeRef(C.kCFBooleanTrue),
 	}
+
+	if attributes.ServiceName != "" || matchEmptyServiceName {
+		var serviceNameString C.CFStringRef
+		if serviceNameString, err = _UTF8StringToCFString(attributes.ServiceName); err != nil {
+			return
+		}
+		defer C.CFRelease(C.CFTypeRef(serviceNameString))
+		query[secAttrService] = C.CFTypeRef(serviceNameString)
+	}
+
+	if attributes.AccountName != "" {
+		var accountNameString C.CFStringRef
+		if accountNameString, err = _UTF8StringToCFString(attributes.AccountName); err != nil {
+			return
+		}
+		defer C.CFRelease(C.CFTypeRef(accountNameString))
+		query[secAttrAccount] = C.CFTypeRef(accountNameString)
+	}
+
+	release, err := addDataProtectionAttributes(query, attributes, false)
+	if err != nil {
+		return
+	}
+	defer release()
+
 	queryDict := mapToCFDictionary(query)
 	defer C.CFRelease(C.CFTypeRef(queryDict))
 
@@ -422,7 +494,7 @@ func GetAllAccountNames(serviceName string) (accountNames []string, err error) {
 	errCode := C.SecItemCopyMatching(queryDict, &resultsRef)
 	err = newKeychainError(errCode)
 	if err == ErrItemNotFound {
-		return []string{}, nil
+		return []GenericPasswordAttributes{}, nil
 	} else if err != nil {
 		return nil, err
 	}
@@ -442,17 +514,40 @@ func GetAllAccountNames(serviceName string) (accountNames []string, err error) {
 	results := _CFArrayToArray(C.CFArrayRef(resultsRef))
 	for _, result := range results {
 		m := _CFDictionaryToMap(C.CFDictionaryRef(result))
-		resultServiceName := _CFStringToUTF8String(C.CFStringRef(m[secAttrService]))
-		if resultServiceName != serviceName {
-			err = fmt.Errorf("Expected service name %s, got %s", serviceName, resultServiceName)
+		item := GenericPasswordAttributes{
+			ServiceName:    _CFStringToUTF8String(C.CFStringRef(m[secAttrService])),
+			AccountName:    _CFStringToUTF8String(C.CFStringRef(m[secAttrAccount])),
+			Synchronizable: SynchronizableNo,
+		}
+		if attributes.ServiceName != "" && item.ServiceName != attributes.ServiceName {
+			err = fmt.Errorf("Expected service name %s, got %s", attributes.ServiceName, item.ServiceName)
 			return
 		}
-		accountName := _CFStringToUTF8String(C.CFStringRef(m[secAttrAccount]))
-		accountNames = append(accountNames, accountName)
+		if synchronizable, ok := m[secAttrSynchronizable]; ok && _CFBooleanToBool(synchronizable) {
+			item.Synchronizable = SynchronizableYes
+		}
+		if accessGroup, ok := m[secAttrAccessGroup]; ok {
+			item.AccessGroup = _CFStringToUTF8String(C.CFStringRef(accessGroup))
+		}
+		items = append(items, item)
 	}
 	return
 }
 
+// _CFBooleanToBool returns the value of a CFBoolean, or of a CFNumber
+// as which some attributes are returned.
+func _CFBooleanToBool(b C.CFTypeRef) bool {
+	switch C.CFGetTypeID(b) {
+	case C.CFBooleanGetTypeID():
+		return C.CFBooleanGetValue(C.CFBooleanRef(b)) != 0
+	case C.CFNumberGetTypeID():
+		var n C.int
+		C.CFNumberGetValue(C.CFNumberRef(b), C.kCFNumberIntType, unsafe.Pointer(&n))
+		return n != 0
+	}
+	return false
+}
+
 // The returned SecTrustedApplicationRef, if non-nil, must be released via CFRelease.
 func createTrustedApplication(trustedApplication string) (C.CFTypeRef, error) {
 	var trustedApplicationCStr *C.char
@@ -1,9 +1,11 @@
-// +build darwin,!ios
+// +build darwin,!ios,cgo
 
 package osxkeychain
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -66,12 +68,35 @@ func TestGenericPassword(t *testing.T) {
 		t.Errorf("FindGenericPassword expected %s, got %q", expectedPassword, password)
 	}
 
+	// Update the password in place.
+	updatedPassword := []byte("updated test password")
+	attributes.Password = updatedPassword
+	err = UpdateGenericPassword(&attributes)
+	if err != nil {
+		t.Error(err)
+	}
+
+	password, err = FindGenericPassword(&attributes)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(password) != string(updatedPassword) {
+		t.Errorf("FindGenericPassword expected %s, got %q", updatedPassword, password)
+	}
+
 	// Remove password.
 	err = FindAndRemoveGenericPassword(&attributes)
 	if err != nil {
 		t.Error(err)
 	}
 
+	// Try updating after removal.
+	err = UpdateGenericPassword(&attributes)
+	if err != ErrItemNotFound {
+		t.Errorf("expected ErrItemNotFound, got %s", err)
+	}
+
 	// Try removing again.
 	err = FindAndRemoveGenericPassword(&attributes)
 	if err != ErrItemNotFound {
@@ -184,6 +209,19 @@ func TestGetAllAccountNames(t *testing.T) {
 		}
 	}
 
+	items, err := ListGenericPasswords(&GenericPasswordAttributes{ServiceName: serviceName})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(items) != len(attributes) {
+		t.Fatalf("Expected %d items, got %d", len(attributes), len(items))
+	}
+	for i := 0; i < len(items); i++ {
+		if items[i].AccountName != attributes[i].AccountName || items[i].Synchronizable != SynchronizableNo {
+			t.Errorf("Expected local item %s, got %+v", attributes[i].AccountName, items[i])
+		}
+	}
+
 	for i := 0; i < len(attributes); i++ {
 		err = FindAndRemoveGenericPassword(&attributes[i])
 		if err != nil {
@@ -250,8 +288,117 @@ func TestGenericPasswordWithApplicationAccess(t *testing.T) {
 		t.Error(err)
 	}
 
+	hasTrustedApplication := func(acl ACL, path string) bool {
+		for _, entry := range acl {
+			for _, app := range entry.TrustedApplications {
+				if app == path {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	acl, err := GetGenericPasswordACL(&attributes)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !hasTrustedApplication(acl, "/Applications/Mail.app") {
+		t.Errorf("Expected /Applications/Mail.app in ACL, got %+v", acl)
+	}
+
+	err = AddTrustedApplication(DefaultKeychain.(ACLStore), &attributes, "/Applications/Notes.app")
+	if err != nil {
+		t.Error(err)
+	}
+
+	acl, err = GetGenericPasswordACL(&attributes)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !hasTrustedApplication(acl, "/Applications/Notes.app") {
+		t.Errorf("Expected /Applications/Notes.app in ACL, got %+v", acl)
+	}
+
 	err = FindAndRemoveGenericPassword(&attributes)
 	if err != nil {
 		t.Error(err)
 	}
 }
+
+func TestSetACLKeepsMovedApplications(t *testing.T) {
+	// A trusted application that is no longer on disk can't be
+	// looked up again, so changing the ACL must keep its reference.
+	data, err := os.ReadFile("/usr/bin/true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(t.TempDir(), "moved")
+	if err := os.WriteFile(moved, data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	attributes := GenericPasswordAttributes{
+		ServiceName:         "osxkeychain_test",
+		AccountName:         "moved application",
+		Password:            []byte("test"),
+		TrustedApplications: []string{moved},
+	}
+	if err := AddGenericPassword(&attributes); err != nil {
+		t.Fatal(err)
+	}
+	defer FindAndRemoveGenericPassword(&attributes)
+	if err := os.Remove(moved); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddTrustedApplication(DefaultKeychain.(ACLStore), &attributes, "/Applications/Notes.app"); err != nil {
+		t.Fatal(err)
+	}
+	acl, err := GetGenericPasswordACL(&attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, entry := range acl {
+		for _, app := range entry.TrustedApplications {
+			found[app] = true
+		}
+	}
+	if !found[moved] || !found["/Applications/Notes.app"] {
+		t.Errorf("Expected %s and /Applications/Notes.app in ACL, got %+v", moved, acl)
+	}
+}
+
+func TestNamespaceWithDefaultKeychain(t *testing.T) {
+	ns, err := NewNamespace(DefaultKeychain, "osxkeychain_test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attributes := GenericPasswordAttributes{
+		ServiceName: "namespaced service",
+		AccountName: "test account",
+		Password:    []byte("test"),
+	}
+
+	err = ns.AddGenericPassword(&attributes)
+	if err != nil {
+		t.Error(err)
+	}
+
+	accountNames, err := ns.GetAllAccountNames(attributes.ServiceName)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(accountNames) != 1 || accountNames[0] != attributes.AccountName {
+		t.Errorf("Expected [%s], got %v", attributes.AccountName, accountNames)
+	}
+
+	err = ns.FindAndRemoveGenericPassword(&attributes)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,221 @@
+package osxkeychain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"strings"
+)
+
+// PartitionID is an entry in the partition list of a keychain item.
+// Since macOS 10.12, an application whose code signature doesn't match
+// one of an item's partition IDs is asked for permission to use the
+// item even if it is in the item's ACL.
+type PartitionID string
+
+// Partition IDs without a value.
+const (
+	PartitionAppleTool PartitionID = "apple-tool:"
+	PartitionApple     PartitionID = "apple:"
+	PartitionUnsigned  PartitionID = "unsigned:"
+)
+
+const (
+	partitionTeamIDPrefix = "teamid:"
+	partitionCDHashPrefix = "cdhash:"
+)
+
+// TeamIDPartition returns the partition ID for applications signed by
+// the Apple Developer team with the given ID.
+func TeamIDPartition(teamID string) (PartitionID, error) {
+	return ParsePartitionID(partitionTeamIDPrefix + teamID)
+}
+
+// CDHashPartition returns the partition ID for the application with
+// the given code directory hash.
+func CDHashPartition(cdhash []byte) (PartitionID, error) {
+	return ParsePartitionID(partitionCDHashPrefix + hex.EncodeToString(cdhash))
+}
+
+func isTeamID(s string) bool {
+	if len(s) != 10 {
+		return false
+	}
+	for _, c := range s {
+		if !('A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParsePartitionID parses and validates a partition ID in one of the
+// forms "apple-tool:", "apple:", "unsigned:", "teamid:<team ID>" or
+// "cdhash:<hex>", where a team ID is ten upper-case letters and digits
+// and a code directory hash is 20 bytes.
+func ParsePartitionID(s string) (PartitionID, error) {
+	switch id := PartitionID(s); {
+	case id == PartitionAppleTool || id == PartitionApple || id == PartitionUnsigned:
+		return id, nil
+	case strings.HasPrefix(s, partitionTeamIDPrefix):
+		if !isTeamID(s[len(partitionTeamIDPrefix):]) {
+			return "", errors.New("partition ID " + s + " has an invalid team ID")
+		}
+		return id, nil
+	case strings.HasPrefix(s, partitionCDHashPrefix):
+		cdhash, err := hex.DecodeString(s[len(partitionCDHashPrefix):])
+		if err != nil || len(cdhash) != 20 {
+			return "", errors.New("partition ID " + s + " has an invalid code directory hash")
+		}
+		// Normalize to lower case.
+		return PartitionID(partitionCDHashPrefix + hex.EncodeToString(cdhash)), nil
+	default:
+		return "", errors.New("unknown partition ID " + s)
+	}
+}
+
+// ParsePartitionList parses a comma-separated list of partition IDs,
+// as taken by `security set-key-partition-list -S`.
+func ParsePartitionList(s string) ([]PartitionID, error) {
+	var ids []PartitionID
+	for _, field := range strings.Split(s, ",") {
+		id, err := ParsePartitionID(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// partitionPlist is the XML property list, of the form
+// {"Partitions": [...]}, that is kept hex-encoded in the description
+// of the ACL entry authorizing ACLAuthorizationPartitionID.
+type partitionPlist struct {
+	XMLName xml.Name `xml:"plist"`
+	Version string   `xml:"version,attr"`
+	Dict    struct {
+		Key   string `xml:"key"`
+		Array struct {
+			Strings []string `xml:"string"`
+		} `xml:"array"`
+	} `xml:"dict"`
+}
+
+const plistHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+`
+
+func encodePartitionDescription(ids []PartitionID) (string, error) {
+	var p partitionPlist
+	p.Version = "1.0"
+	p.Dict.Key = "Partitions"
+	for _, id := range ids {
+		p.Dict.Array.Strings = append(p.Dict.Array.Strings, string(id))
+	}
+	data, err := xml.MarshalIndent(&p, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	data = append([]byte(plistHeader), append(data, '\n')...)
+	return strings.ToUpper(hex.EncodeToString(data)), nil
+}
+
+func decodePartitionDescription(description string) ([]PartitionID, error) {
+	data, err := hex.DecodeString(description)
+	if err != nil {
+		return nil, errors.New("partition list is not hex-encoded")
+	}
+	var p partitionPlist
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.Dict.Key != "Partitions" {
+		return nil, errors.New("partition list has no Partitions key")
+	}
+	ids := []PartitionID{}
+	for _, s := range p.Dict.Array.Strings {
+		ids = append(ids, PartitionID(s))
+	}
+	return ids, nil
+}
+
+// PartitionIDs returns the partition list kept in acl, or nil if acl
+// has no partition list. The IDs are returned as stored, without
+// validation.
+func (acl ACL) PartitionIDs() ([]PartitionID, error) {
+	for i := range acl {
+		for _, a := range acl[i].Authorizations {
+			if a == ACLAuthorizationPartitionID {
+				return decodePartitionDescription(acl[i].Description)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// WithPartitionIDs returns a copy of acl with its partition list
+// replaced by ids, adding a partition list entry if there is none.
+func (acl ACL) WithPartitionIDs(ids []PartitionID) (ACL, error) {
+	for _, id := range ids {
+		if _, err := ParsePartitionID(string(id)); err != nil {
+			return nil, err
+		}
+	}
+	description, err := encodePartitionDescription(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	c := acl.copy()
+	for i := range c {
+		for _, a := range c[i].Authorizations {
+			if a == ACLAuthorizationPartitionID {
+				c[i].Description = description
+				return c, nil
+			}
+		}
+	}
+	return append(c, ACLEntry{
+		Authorizations: []ACLAuthorization{ACLAuthorizationPartitionID},
+		AnyApplication: true,
+		Description:    description,
+	}), nil
+}
+
+// PartitionStore is implemented by Stores whose items have partition
+// lists, such as DefaultKeychain.
+type PartitionStore interface {
+	ACLStore
+	// SetGenericPasswordACLWithPassword is like
+	// SetGenericPasswordACL, but authorizes the change with the
+	// keychain's password instead of asking the user, which changing
+	// the partition list requires.
+	SetGenericPasswordACLWithPassword(attributes *GenericPasswordAttributes, acl ACL, keychainPassword []byte) error
+}
+
+// GetPartitionIDs returns the partition list of the generic password
+// with the given attributes, or nil if it has none.
+func GetPartitionIDs(store ACLStore, attributes *GenericPasswordAttributes) ([]PartitionID, error) {
+	acl, err := store.GetGenericPasswordACL(attributes)
+	if err != nil {
+		return nil, err
+	}
+	return acl.PartitionIDs()
+}
+
+// SetPartitionIDs replaces the partition list of the generic password
+// with the given attributes, like `security set-key-partition-list`.
+// keychainPassword is the password of the keychain holding the item.
+func SetPartitionIDs(store PartitionStore, attributes *GenericPasswordAttributes, ids []PartitionID, keychainPassword []byte) error {
+	acl, err := store.GetGenericPasswordACL(attributes)
+	if err != nil {
+		return err
+	}
+	acl, err = acl.WithPartitionIDs(ids)
+	if err != nil {
+		return err
+	}
+	return store.SetGenericPasswordACLWithPassword(attributes, acl, keychainPassword)
+}
@@ -0,0 +1,180 @@
+package osxkeychain_test
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+func TestParsePartitionID(t *testing.T) {
+	valid := map[string]osxkeychain.PartitionID{
+		"apple-tool:":                        osxkeychain.PartitionAppleTool,
+		"apple:":                             osxkeychain.PartitionApple,
+		"unsigned:":                          osxkeychain.PartitionUnsigned,
+		"teamid:ABCDE12345":                  "teamid:ABCDE12345",
+		"cdhash:" + strings.Repeat("AB", 20): osxkeychain.PartitionID("cdhash:" + strings.Repeat("ab", 20)),
+	}
+	for s, expected := range valid {
+		id, err := osxkeychain.ParsePartitionID(s)
+		if err != nil {
+			t.Errorf("ParsePartitionID(%q): %v", s, err)
+		}
+		if id != expected {
+			t.Errorf("ParsePartitionID(%q): expected %s, got %s", s, expected, id)
+		}
+	}
+
+	invalid := map[string]string{
+		"":                                   "unknown partition ID ",
+		"apple-tool":                         "unknown partition ID apple-tool",
+		"apple:x":                            "unknown partition ID apple:x",
+		"teamid:abcde12345":                  "partition ID teamid:abcde12345 has an invalid team ID",
+		"teamid:ABCDE1234":                   "partition ID teamid:ABCDE1234 has an invalid team ID",
+		"cdhash:abcd":                        "partition ID cdhash:abcd has an invalid code directory hash",
+		"cdhash:" + strings.Repeat("zz", 20): "partition ID cdhash:" + strings.Repeat("zz", 20) + " has an invalid code directory hash",
+	}
+	for s, errString := range invalid {
+		if _, err := osxkeychain.ParsePartitionID(s); err == nil || err.Error() != errString {
+			t.Errorf("ParsePartitionID(%q): expected \"%s\", got %v", s, errString, err)
+		}
+	}
+
+	id, err := osxkeychain.TeamIDPartition("ABCDE12345")
+	if err != nil || id != "teamid:ABCDE12345" {
+		t.Errorf("TeamIDPartition: got %s, %v", id, err)
+	}
+	id, err = osxkeychain.CDHashPartition(make([]byte, 20))
+	if err != nil || id != osxkeychain.PartitionID("cdhash:"+strings.Repeat("00", 20)) {
+		t.Errorf("CDHashPartition: got %s, %v", id, err)
+	}
+	if _, err := osxkeychain.CDHashPartition(make([]byte, 32)); err == nil {
+		t.Error("Expected error for a 32-byte code directory hash")
+	}
+}
+
+func TestParsePartitionList(t *testing.T) {
+	ids, err := osxkeychain.ParsePartitionList("apple-tool:,apple:, teamid:ABCDE12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []osxkeychain.PartitionID{osxkeychain.PartitionAppleTool, osxkeychain.PartitionApple, "teamid:ABCDE12345"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected %q, got %q", expected, ids)
+	}
+
+	if _, err := osxkeychain.ParsePartitionList("apple-tool:,,apple:"); err == nil {
+		t.Error("Expected error for empty partition ID")
+	}
+}
+
+// applePartitionPlist is a partition list as written by
+// `security set-key-partition-list`.
+const applePartitionPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Partitions</key>
+	<array>
+		<string>apple-tool:</string>
+		<string>apple:</string>
+		<string>teamid:ABCDE12345</string>
+	</array>
+</dict>
+</plist>
+`
+
+func TestACLPartitionIDs(t *testing.T) {
+	acl := append(newTestACL(), osxkeychain.ACLEntry{
+		Authorizations: []osxkeychain.ACLAuthorization{osxkeychain.ACLAuthorizationPartitionID},
+		AnyApplication: true,
+		Description:    strings.ToUpper(hex.EncodeToString([]byte(applePartitionPlist))),
+	})
+
+	ids, err := acl.PartitionIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []osxkeychain.PartitionID{osxkeychain.PartitionAppleTool, osxkeychain.PartitionApple, "teamid:ABCDE12345"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected %q, got %q", expected, ids)
+	}
+
+	// Replace the existing list.
+	updated, err := acl.WithPartitionIDs([]osxkeychain.PartitionID{osxkeychain.PartitionAppleTool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != len(acl) {
+		t.Errorf("Expected %d entries, got %d", len(acl), len(updated))
+	}
+	ids, err = updated.PartitionIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []osxkeychain.PartitionID{osxkeychain.PartitionAppleTool}) {
+		t.Errorf("Expected [apple-tool:], got %q", ids)
+	}
+
+	// Add a list to an ACL without one, and clear it again.
+	ids, err = newTestACL().PartitionIDs()
+	if err != nil || ids != nil {
+		t.Errorf("Expected no partition list, got %q, %v", ids, err)
+	}
+	updated, err = newTestACL().WithPartitionIDs(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != len(newTestACL())+1 {
+		t.Errorf("Expected a new entry, got %+v", updated)
+	}
+	ids, err = updated.PartitionIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected %q, got %q", expected, ids)
+	}
+	updated, err = updated.WithPartitionIDs(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err = updated.PartitionIDs()
+	if err != nil || ids == nil || len(ids) != 0 {
+		t.Errorf("Expected an empty partition list, got %q, %v", ids, err)
+	}
+
+	if _, err := acl.WithPartitionIDs([]osxkeychain.PartitionID{"teamid:bad"}); err == nil {
+		t.Error("Expected error for invalid partition ID")
+	}
+
+	acl[len(acl)-1].Description = "not hex"
+	if _, err := acl.PartitionIDs(); err == nil {
+		t.Error("Expected error for invalid partition list")
+	}
+}
+
+func TestSetPartitionIDs(t *testing.T) {
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "service", AccountName: "account"}
+	store := &fakeACLStore{
+		acls:             map[string]osxkeychain.ACL{"service/account": newTestACL()},
+		keychainPassword: "keychain password",
+	}
+	ids := []osxkeychain.PartitionID{osxkeychain.PartitionAppleTool, "teamid:ABCDE12345"}
+
+	if err := osxkeychain.SetPartitionIDs(store, attributes, ids, []byte("wrong")); err != osxkeychain.ErrAuthFailed {
+		t.Errorf("Expected ErrAuthFailed, got %v", err)
+	}
+	if err := osxkeychain.SetPartitionIDs(store, attributes, ids, []byte("keychain password")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := osxkeychain.GetPartitionIDs(store, attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ids) {
+		t.Errorf("Expected %q, got %q", ids, got)
+	}
+}
@@ -0,0 +1,384 @@
+// Package passstore keeps generic passwords in a password store, the
+// directory of OpenPGP-encrypted files used by pass, the standard Unix
+// password manager. Entries are encrypted and decrypted in Go, so no
+// gpg binary is needed.
+package passstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/keybase/go-osxkeychain"
+)
+
+// ErrNoPrivateKey is returned when an entry can't be decrypted because
+// Keyring has no decrypted private key for any of its recipients.
+var ErrNoPrivateKey = errors.New("passstore: no private key in Keyring can decrypt the entry")
+
+// DefaultDir returns the directory pass uses: $PASSWORD_STORE_DIR, or
+// .password-store in the user's home directory.
+func DefaultDir() (string, error) {
+	if dir := os.Getenv("PASSWORD_STORE_DIR"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".password-store"), nil
+}
+
+// Store is an osxkeychain.Store kept in a password store. The password
+// of a generic password is in the file <account name>.gpg in the
+// directory of its service, whose name may hold slashes, as in
+// "web/example.com", to name a subdirectory. The file holds the
+// password followed by a newline, as pass insert writes it; only that
+// newline is dropped when reading, so an entry with more lines, such
+// as one edited with pass edit, is returned whole. Entries in the root
+// of the password store have an empty service name, but when finding,
+// removing or updating, an empty service name matches the entries of
+// every directory, as osxkeychain.Store describes.
+//
+// Entries are encrypted to the recipients listed in the .gpg-id file
+// of their directory or, if it has none, of the nearest directory
+// above it, by fingerprint, key ID, or part of a user ID such as an
+// email address. Their public keys must be in Keyring, as must the
+// private keys that decrypt entries, which must be decrypted, for
+// example with Entity.DecryptPrivateKeys. When the recipients change,
+// Init or Reencrypt re-encrypts the entries to them.
+//
+// Unlike pass, Store doesn't commit its changes to the git repository
+// the password store may be in.
+type Store struct {
+	// Dir is the directory of the password store. If empty, the one
+	// DefaultDir returns is used.
+	Dir     string
+	Keyring openpgp.EntityList
+
+	mu sync.Mutex
+}
+
+const (
+	gpgIDFile     = ".gpg-id"
+	entrySuffix   = ".gpg"
+	storeDirPerm  = 0700
+	entryFilePerm = 0600
+)
+
+// AddGenericPassword implements osxkeychain.Store.
+func (s *Store) AddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	path, err := s.entryPath(attributes)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeEntry(path, attributes.Password, false)
+}
+
+// FindGenericPassword implements osxkeychain.Store.
+func (s *Store) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	if err := attributes.CheckValidity(); err != nil {
+		return nil, err
+	}
+	path, err := s.matchEntry(attributes)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, osxkeychain.ErrItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := s.decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(plaintext, []byte("\n")), nil
+}
+
+// FindAndRemoveGenericPassword implements osxkeychain.Store. As with
+// pass rm, directories left empty are removed too.
+func (s *Store) FindAndRemoveGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	root, err := s.root()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path, err := s.matchEntry(attributes)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return osxkeychain.ErrItemNotFound
+	} else if err != nil {
+		return err
+	}
+	for dir := filepath.Dir(path); len(dir) > len(root); dir = filepath.Dir(dir) {
+		// Removing a directory fails if it isn't empty.
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// RemoveAndAddGenericPassword implements osxkeychain.Store. Unlike
+// the keychain, it replaces the entry in a single rename.
+func (s *Store) RemoveAndAddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	path, err := s.entryPath(attributes)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeEntry(path, attributes.Password, true)
+}
+
+// UpdateGenericPassword implements osxkeychain.Store. The entry is
+// encrypted to the current recipients of its directory.
+func (s *Store) UpdateGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path, err := s.matchEntry(attributes)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return osxkeychain.ErrItemNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeEntry(path, attributes.Password, true)
+}
+
+// GetAllAccountNames implements osxkeychain.Store. It lists the
+// entries in the service's directory, but not in its subdirectories,
+// which belong to other services.
+func (s *Store) GetAllAccountNames(serviceName string) ([]string, error) {
+	dir, err := s.serviceDir(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	accountNames := []string{}
+	for _, entry := range entries {
+		accountName := strings.TrimSuffix(entry.Name(), entrySuffix)
+		if !entry.IsDir() && accountName != entry.Name() && accountName != "" {
+			accountNames = append(accountNames, accountName)
+		}
+	}
+	return accountNames, nil
+}
+
+// root returns the cleaned directory of the password store.
+func (s *Store) root() (string, error) {
+	dir := s.Dir
+	if dir == "" {
+		var err error
+		if dir, err = DefaultDir(); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Clean(dir), nil
+}
+
+// serviceDir returns the directory of a service's entries. An empty
+// service name is the root of the password store.
+func (s *Store) serviceDir(serviceName string) (string, error) {
+	if strings.ContainsRune(serviceName, 0) {
+		return "", errors.New("ServiceName can't contain NUL")
+	}
+	if serviceName != "" {
+		for _, elem := range strings.Split(serviceName, "/") {
+			if elem == "" || elem == "." || elem == ".." {
+				return "", errors.New("ServiceName can't have empty, \".\" or \"..\" path elements")
+			}
+		}
+	}
+	root, err := s.root()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, filepath.FromSlash(serviceName)), nil
+}
+
+// entryPath returns the path of the file holding a generic password.
+func (s *Store) entryPath(attributes *osxkeychain.GenericPasswordAttributes) (string, error) {
+	dir, err := s.serviceDir(attributes.ServiceName)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case attributes.AccountName == "":
+		return "", errors.New("AccountName is empty")
+	case strings.ContainsRune(attributes.AccountName, '/'):
+		return "", errors.New("AccountName can't contain \"/\"")
+	case strings.ContainsRune(attributes.AccountName, 0):
+		return "", errors.New("AccountName can't contain NUL")
+	}
+	return filepath.Join(dir, attributes.AccountName+entrySuffix), nil
+}
+
+// matchEntry returns the path of the entry with the names of
+// attributes. As in the keychain, an empty name matches any: an empty
+// service name matches the entries of every directory, and an empty
+// account name every entry of the service's directory. The first
+// entry that matches, in lexical order, is used.
+func (s *Store) matchEntry(attributes *osxkeychain.GenericPasswordAttributes) (string, error) {
+	if attributes.ServiceName != "" && attributes.AccountName != "" {
+		return s.entryPath(attributes)
+	}
+	dir, err := s.serviceDir(attributes.ServiceName)
+	if err != nil {
+		return "", err
+	}
+	if attributes.AccountName != "" {
+		// Check the account name as entryPath does.
+		if _, err := s.entryPath(attributes); err != nil {
+			return "", err
+		}
+	}
+	var match string
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && os.IsNotExist(err) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if entry.IsDir() {
+			// Subdirectories belong to other services, and pass
+			// keeps its git repository and extensions there.
+			if path != dir && (attributes.ServiceName != "" || entry.Name() == ".git" || entry.Name() == ".extensions") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		accountName := strings.TrimSuffix(entry.Name(), entrySuffix)
+		if accountName == entry.Name() || accountName == "" || attributes.AccountName != "" && accountName != attributes.AccountName {
+			return nil
+		}
+		match = path
+		return filepath.SkipAll
+	})
+	if err != nil {
+		return "", err
+	}
+	if match == "" {
+		return "", osxkeychain.ErrItemNotFound
+	}
+	return match, nil
+}
+
+// writeEntry encrypts a password to the recipients of the directory
+// of the entry at path and writes it there, creating the directory if
+// needed. Unless overwrite is set, it returns
+// osxkeychain.ErrDuplicateItem if the entry already exists.
+func (s *Store) writeEntry(path string, password []byte, overwrite bool) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, storeDirPerm); err != nil {
+		return err
+	}
+	recipients, err := s.recipients(dir)
+	if err != nil {
+		return err
+	}
+	plaintext := append(append([]byte{}, password...), '\n')
+	ciphertext, err := encrypt(plaintext, recipients)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, ciphertext, overwrite)
+}
+
+// writeFile atomically writes data to the file at path, by writing it
+// to a temporary file in the same directory first. Unless overwrite
+// is set, it returns osxkeychain.ErrDuplicateItem if the file exists.
+func writeFile(path string, data []byte, overwrite bool) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	// Once renamed or linked into place, the temporary name is gone
+	// or redundant.
+	defer os.Remove(f.Name())
+	if err := f.Chmod(entryFilePerm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if overwrite {
+		return os.Rename(f.Name(), path)
+	}
+	// Unlike a rename, a link fails if the file exists.
+	if err := os.Link(f.Name(), path); os.IsExist(err) {
+		return osxkeychain.ErrDuplicateItem
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func encrypt(plaintext []byte, recipients []*openpgp.Entity) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := openpgp.Encrypt(&buf, recipients, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Store) decrypt(ciphertext []byte) ([]byte, error) {
+	md, err := openpgp.ReadMessage(bytes.NewReader(ciphertext), s.Keyring, nil, nil)
+	if err == pgperrors.ErrKeyIncorrect {
+		return nil, ErrNoPrivateKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The integrity of the message is only checked once it's all read.
+	return io.ReadAll(md.UnverifiedBody)
+}
@@ -0,0 +1,293 @@
+package passstore_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/passstore"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+// newKey generates an Ed25519 key with a Curve25519 encryption
+// subkey, which is much quicker than RSA.
+func newKey(t *testing.T, name, email string) *openpgp.Entity {
+	key, err := openpgp.NewEntity(name, "", email, &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func fingerprint(key *openpgp.Entity) string {
+	return strings.ToUpper(hex.EncodeToString(key.PrimaryKey.Fingerprint))
+}
+
+func writeGPGID(t *testing.T, dir string, recipients ...string) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".gpg-id"), []byte(strings.Join(recipients, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// decryptFile decrypts an entry with a single key, as gpg would.
+func decryptFile(t *testing.T, path string, key *openpgp.Entity) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	md, err := openpgp.ReadMessage(f, openpgp.EntityList{key}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(md.UnverifiedBody)
+}
+
+func TestStore(t *testing.T) {
+	key := newKey(t, "Test", "test@example.com")
+	dir := t.TempDir()
+	writeGPGID(t, dir, fingerprint(key))
+	storetest.TestStore(t, &passstore.Store{Dir: dir, Keyring: openpgp.EntityList{key}})
+
+	// Removing the last entries removed their directory.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != ".gpg-id" {
+		t.Errorf("Expected only .gpg-id to be left, got %v", entries)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	key := newKey(t, "Test", "test@example.com")
+	dir := t.TempDir()
+	writeGPGID(t, dir, "test@example.com")
+	store := &passstore.Store{Dir: dir, Keyring: openpgp.EntityList{key}}
+
+	attributes := &osxkeychain.GenericPasswordAttributes{
+		ServiceName: "web/example.com",
+		AccountName: "alice",
+		Password:    []byte("hunter2"),
+	}
+	if err := store.AddGenericPassword(attributes); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "web", "example.com", "alice.gpg")
+	if plaintext, err := decryptFile(t, path, key); err != nil || string(plaintext) != "hunter2\n" {
+		t.Errorf("Expected \"hunter2\\n\" in %s, got %q, %v", path, plaintext, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v, %v", info.Mode(), err)
+	}
+
+	// Entries edited with pass have more lines, which are kept.
+	if err := store.UpdateGenericPassword(&osxkeychain.GenericPasswordAttributes{
+		ServiceName: "web/example.com",
+		AccountName: "alice",
+		Password:    []byte("hunter3\nurl: https://example.com/\n"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if password, err := store.FindGenericPassword(attributes); err != nil || string(password) != "hunter3\nurl: https://example.com/\n" {
+		t.Errorf("Expected every line, got %q, %v", password, err)
+	}
+
+	// Only the service's own directory is listed.
+	for _, accountName := range []string{"bob", "carol"} {
+		if err := store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "web", AccountName: accountName}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "web", "notes.txt"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	accountNames, err := store.GetAllAccountNames("web")
+	sort.Strings(accountNames)
+	if err != nil || !reflect.DeepEqual(accountNames, []string{"bob", "carol"}) {
+		t.Errorf("Expected [bob carol], got %v, %v", accountNames, err)
+	}
+	accountNames, err = store.GetAllAccountNames("")
+	if err != nil || len(accountNames) != 0 {
+		t.Errorf("Expected no accounts at the root, got %v, %v", accountNames, err)
+	}
+}
+
+func TestMultipleRecipients(t *testing.T) {
+	alice := newKey(t, "Alice", "alice@example.com")
+	bob := newKey(t, "Bob", "bob@example.com")
+	carol := newKey(t, "Carol", "carol@example.com")
+	dir := t.TempDir()
+	// A comment, the long key ID of Bob's encryption subkey and a
+	// duplicate.
+	writeGPGID(t, dir, "# team", fingerprint(alice), "0x"+bob.Subkeys[0].PublicKey.KeyIdString(), "Alice")
+	// A subdirectory with its own recipients.
+	writeGPGID(t, filepath.Join(dir, "ops"), "carol@EXAMPLE.com")
+	store := &passstore.Store{Dir: dir, Keyring: openpgp.EntityList{alice, bob, carol}}
+
+	team := &osxkeychain.GenericPasswordAttributes{ServiceName: "shared", AccountName: "team", Password: []byte("team password")}
+	ops := &osxkeychain.GenericPasswordAttributes{ServiceName: "ops/db", AccountName: "root", Password: []byte("ops password")}
+	for _, attributes := range []*osxkeychain.GenericPasswordAttributes{team, ops} {
+		if err := store.AddGenericPassword(attributes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path      string
+		key       *openpgp.Entity
+		decrypted bool
+	}{
+		{"shared/team.gpg", alice, true},
+		{"shared/team.gpg", bob, true},
+		{"shared/team.gpg", carol, false},
+		{"ops/db/root.gpg", alice, false},
+		{"ops/db/root.gpg", carol, true},
+	}
+	for _, test := range tests {
+		if _, err := decryptFile(t, filepath.Join(dir, test.path), test.key); (err == nil) != test.decrypted {
+			t.Errorf("%s with %s's key: expected decrypted %v, got %v", test.path, test.key.PrimaryIdentity().Name, test.decrypted, err)
+		}
+	}
+
+	// Each recipient can read the entries with only their own key.
+	bobsStore := &passstore.Store{Dir: dir, Keyring: openpgp.EntityList{bob}}
+	if password, err := bobsStore.FindGenericPassword(team); err != nil || string(password) != "team password" {
+		t.Errorf("Expected %q, got %q, %v", "team password", password, err)
+	}
+	if _, err := bobsStore.FindGenericPassword(ops); err != passstore.ErrNoPrivateKey {
+		t.Errorf("Expected %v, got %v", passstore.ErrNoPrivateKey, err)
+	}
+}
+
+func TestInit(t *testing.T) {
+	alice := newKey(t, "Alice", "alice@example.com")
+	bob := newKey(t, "Bob", "bob@example.com")
+	dir := t.TempDir()
+	store := &passstore.Store{Dir: dir, Keyring: openpgp.EntityList{alice, bob}}
+
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "svc/sub", AccountName: "acct", Password: []byte("pw")}
+	expected := "passstore: " + dir + " has no .gpg-id file; call Init first"
+	if err := store.AddGenericPassword(attributes); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+	if err := store.Init("", []string{fingerprint(alice)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddGenericPassword(attributes); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "svc", "sub", "acct.gpg")
+
+	// Changing the recipients of a service re-encrypts its entries.
+	if err := store.Init("svc", []string{"bob@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "svc", ".gpg-id")); err != nil || string(data) != "bob@example.com\n" {
+		t.Errorf("Expected .gpg-id to list bob@example.com, got %q, %v", data, err)
+	}
+	if _, err := decryptFile(t, path, alice); err == nil {
+		t.Error("Expected Alice's key to no longer decrypt the entry")
+	}
+	if plaintext, err := decryptFile(t, path, bob); err != nil || string(plaintext) != "pw\n" {
+		t.Errorf("Expected Bob's key to decrypt the entry, got %q, %v", plaintext, err)
+	}
+
+	// Entries already encrypted to their recipients are left alone.
+	before, _ := os.ReadFile(path)
+	if err := store.Reencrypt(""); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+		t.Error("Expected Reencrypt to leave an up-to-date entry alone")
+	}
+
+	// Empty recipients fall back to those of the root.
+	if err := store.Init("svc", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "svc", ".gpg-id")); !os.IsNotExist(err) {
+		t.Errorf("Expected .gpg-id to be removed, got %v", err)
+	}
+	if _, err := decryptFile(t, path, alice); err != nil {
+		t.Errorf("Expected Alice's key to decrypt the entry, got %v", err)
+	}
+
+	// A .gpg-id edited by hand, for example by git pull, takes
+	// effect with Reencrypt, which needs a private key of each entry.
+	writeGPGID(t, dir, fingerprint(bob))
+	bobsStore := &passstore.Store{Dir: dir, Keyring: openpgp.EntityList{bob}}
+	err := bobsStore.Reencrypt("")
+	if !errors.Is(err, passstore.ErrNoPrivateKey) || !strings.Contains(err.Error(), path) {
+		t.Errorf("Expected %v for %s, got %v", passstore.ErrNoPrivateKey, path, err)
+	}
+	if err := store.Reencrypt("svc"); err != nil {
+		t.Fatal(err)
+	}
+	if password, err := bobsStore.FindGenericPassword(attributes); err != nil || string(password) != "pw" {
+		t.Errorf("Expected %q, got %q, %v", "pw", password, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	key := newKey(t, "Test", "test@example.com")
+	dir := t.TempDir()
+	writeGPGID(t, dir, fingerprint(key))
+	store := &passstore.Store{Dir: dir, Keyring: openpgp.EntityList{key}}
+
+	names := []struct {
+		serviceName, accountName string
+		expected                 string
+	}{
+		{"svc", "", "AccountName is empty"},
+		{"svc", "a/b", "AccountName can't contain \"/\""},
+		{"svc", "a\x00b", "AccountName can't contain NUL"},
+		{"../outside", "acct", "ServiceName can't have empty, \".\" or \"..\" path elements"},
+		{"/absolute", "acct", "ServiceName can't have empty, \".\" or \"..\" path elements"},
+		{"a//b", "acct", "ServiceName can't have empty, \".\" or \"..\" path elements"},
+		{"a\x00b", "acct", "ServiceName can't contain NUL"},
+	}
+	for _, test := range names {
+		attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: test.serviceName, AccountName: test.accountName}
+		if err := store.AddGenericPassword(attributes); err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+	if _, err := store.GetAllAccountNames("a/../.."); err == nil {
+		t.Error("Expected GetAllAccountNames to reject a path outside the store")
+	}
+
+	initErrors := []struct {
+		serviceName string
+		recipients  []string
+		expected    string
+	}{
+		{"", nil, "recipients can only be empty for a service"},
+		{"svc", []string{"nobody@example.com"}, "passstore: no key in Keyring for recipient \"nobody@example.com\""},
+		{"svc", []string{"test@example.com\nnobody"}, "recipient \"test@example.com\\nnobody\" isn't valid in a .gpg-id file"},
+	}
+	for _, test := range initErrors {
+		if err := store.Init(test.serviceName, test.recipients); err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+
+	writeGPGID(t, filepath.Join(dir, "svc"), "# nobody")
+	expected := "passstore: " + filepath.Join(dir, "svc", ".gpg-id") + " lists no recipients"
+	if err := store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct"}); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
@@ -0,0 +1,251 @@
+package passstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// Init sets the recipients of the entries in the directory of the
+// given service, or of the whole password store if the service name
+// is empty, writing them to the directory's .gpg-id file, and
+// re-encrypts the entries as Reencrypt does, like pass init. Empty
+// recipients remove a service's .gpg-id file, so that its entries are
+// encrypted to the recipients of the directory above instead.
+func (s *Store) Init(serviceName string, recipients []string) error {
+	dir, err := s.serviceDir(serviceName)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 && serviceName == "" {
+		return errors.New("recipients can only be empty for a service")
+	}
+	for _, recipient := range recipients {
+		if strings.TrimSpace(recipient) != recipient || recipient == "" || strings.ContainsAny(recipient, "\r\n") || strings.HasPrefix(recipient, "#") {
+			return fmt.Errorf("recipient %q isn't valid in a .gpg-id file", recipient)
+		}
+		// Checked before the file is written, so a typo doesn't leave
+		// the store unusable.
+		if s.findKey(recipient) == nil {
+			return fmt.Errorf("passstore: no key in Keyring for recipient %q", recipient)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gpgID := filepath.Join(dir, gpgIDFile)
+	if len(recipients) == 0 {
+		if err := os.Remove(gpgID); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(dir, storeDirPerm); err != nil {
+			return err
+		}
+		if err := writeFile(gpgID, []byte(strings.Join(recipients, "\n")+"\n"), true); err != nil {
+			return err
+		}
+	}
+	return s.reencrypt(dir)
+}
+
+// Reencrypt re-encrypts each entry in the directory of the given
+// service and its subdirectories, or in the whole password store if
+// the service name is empty, whose recipients aren't those its .gpg-id
+// file now lists, as pass does when they change. It needs the private
+// key of every entry it re-encrypts, and stops at the first it can't.
+func (s *Store) Reencrypt(serviceName string) error {
+	dir, err := s.serviceDir(serviceName)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reencrypt(dir)
+}
+
+func (s *Store) reencrypt(dir string) error {
+	recipients := make(map[string][]*openpgp.Entity)
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			// pass keeps its git repository and extensions there.
+			if path != dir && (entry.Name() == ".git" || entry.Name() == ".extensions") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(entry.Name(), entrySuffix) {
+			return nil
+		}
+		to, ok := recipients[filepath.Dir(path)]
+		if !ok {
+			if to, err = s.recipients(filepath.Dir(path)); err != nil {
+				return err
+			}
+			recipients[filepath.Dir(path)] = to
+		}
+		if err := s.reencryptFile(path, to); err != nil {
+			return fmt.Errorf("passstore: can't re-encrypt %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+func (s *Store) reencryptFile(path string, recipients []*openpgp.Entity) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	keyIDs, err := encryptedKeyIDs(data)
+	if err != nil {
+		return err
+	}
+	upToDate := len(keyIDs) == len(recipients)
+	for _, recipient := range recipients {
+		key, ok := recipient.EncryptionKey(time.Now())
+		upToDate = upToDate && ok && keyIDs[key.PublicKey.KeyId]
+	}
+	if upToDate {
+		return nil
+	}
+	plaintext, err := s.decrypt(data)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := encrypt(plaintext, recipients)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, ciphertext, true)
+}
+
+// encryptedKeyIDs returns the IDs of the keys an OpenPGP message is
+// encrypted to.
+func encryptedKeyIDs(message []byte) (map[uint64]bool, error) {
+	keyIDs := make(map[uint64]bool)
+	packets := packet.NewReader(bytes.NewReader(message))
+	for {
+		p, err := packets.Next()
+		if err != nil {
+			return nil, err
+		}
+		switch p := p.(type) {
+		case *packet.EncryptedKey:
+			keyIDs[p.KeyId] = true
+		case *packet.SymmetricKeyEncrypted:
+		default:
+			// The encrypted data follows the session keys.
+			return keyIDs, nil
+		}
+	}
+}
+
+// recipients returns the keys entries in dir are encrypted to, as
+// listed in the .gpg-id file of dir or of the nearest directory above
+// it in the password store.
+func (s *Store) recipients(dir string) ([]*openpgp.Entity, error) {
+	root, err := s.root()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		path := filepath.Join(dir, gpgIDFile)
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return s.parseGPGID(path, data)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(dir) <= len(root) {
+			return nil, fmt.Errorf("passstore: %s has no %s file; call Init first", root, gpgIDFile)
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// parseGPGID returns the keys of the recipients listed in a .gpg-id
+// file, one per line, skipping blank lines and comments.
+func (s *Store) parseGPGID(path string, data []byte) ([]*openpgp.Entity, error) {
+	var recipients []*openpgp.Entity
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key := s.findKey(line)
+		if key == nil {
+			return nil, fmt.Errorf("passstore: no key in Keyring for recipient %q in %s", line, path)
+		}
+		if !containsKey(recipients, key) {
+			recipients = append(recipients, key)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("passstore: %s lists no recipients", path)
+	}
+	return recipients, nil
+}
+
+// findKey returns the first key in Keyring that a recipient names, as
+// gpg -r would: a hexadecimal fingerprint or key ID, optionally
+// prefixed with 0x, matches the primary key or a subkey, and anything
+// else is matched, ignoring case, against the keys' user IDs.
+func (s *Store) findKey(recipient string) *openpgp.Entity {
+	id := strings.TrimSuffix(recipient, "!")
+	if len(id) > 2 && strings.EqualFold(id[:2], "0x") {
+		id = id[2:]
+	}
+	if _, err := hex.DecodeString(id); err == nil && (len(id) == 8 || len(id) == 16 || len(id) == 40) {
+		for _, entity := range s.Keyring {
+			if matchesKeyID(entity.PrimaryKey, id) {
+				return entity
+			}
+			for _, subkey := range entity.Subkeys {
+				if matchesKeyID(subkey.PublicKey, id) {
+					return entity
+				}
+			}
+		}
+		return nil
+	}
+	for _, entity := range s.Keyring {
+		for name := range entity.Identities {
+			if strings.Contains(strings.ToLower(name), strings.ToLower(recipient)) {
+				return entity
+			}
+		}
+	}
+	return nil
+}
+
+func containsKey(keys []*openpgp.Entity, key *openpgp.Entity) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesKeyID returns true if id is the fingerprint of key, or its
+// long or short key ID, which are the end of a version 4 key's
+// fingerprint.
+func matchesKeyID(key *packet.PublicKey, id string) bool {
+	return strings.HasSuffix(hex.EncodeToString(key.Fingerprint), strings.ToLower(id))
+}
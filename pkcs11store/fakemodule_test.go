@@ -0,0 +1,306 @@
+package pkcs11store_test
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// fakeToken is a token in a fakeModule's slot.
+type fakeToken struct {
+	label    string
+	pin      string
+	readOnly bool
+	loggedIn bool
+	objects  map[pkcs11.ObjectHandle]map[uint][]byte
+}
+
+type fakeSession struct {
+	slot    uint
+	finding bool
+	found   []pkcs11.ObjectHandle
+}
+
+// fakeModule is a PKCS#11 module with a token in each slot, enforcing
+// the rules of the standard that Store relies on.
+type fakeModule struct {
+	mu       sync.Mutex
+	tokens   []*fakeToken
+	sessions map[pkcs11.SessionHandle]*fakeSession
+	opened   int
+	next     uint
+}
+
+func newFakeModule(tokens ...*fakeToken) *fakeModule {
+	for _, token := range tokens {
+		token.objects = map[pkcs11.ObjectHandle]map[uint][]byte{}
+	}
+	return &fakeModule{tokens: tokens, sessions: map[pkcs11.SessionHandle]*fakeSession{}}
+}
+
+func ckr(code uint) error {
+	return pkcs11.Error(code)
+}
+
+func (m *fakeModule) session(sh pkcs11.SessionHandle) (*fakeSession, *fakeToken, error) {
+	session, ok := m.sessions[sh]
+	if !ok {
+		return nil, nil, ckr(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	}
+	return session, m.tokens[session.slot], nil
+}
+
+// object returns the attributes of an object the session can see.
+func (m *fakeModule) object(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle) (map[uint][]byte, *fakeToken, error) {
+	_, token, err := m.session(sh)
+	if err != nil {
+		return nil, nil, err
+	}
+	object, ok := token.objects[oh]
+	if !ok || !visible(token, object) {
+		return nil, nil, ckr(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	return object, token, nil
+}
+
+// visible returns whether object can be seen: private objects can only
+// be seen once logged in.
+func visible(token *fakeToken, object map[uint][]byte) bool {
+	return token.loggedIn || !bytes.Equal(object[pkcs11.CKA_PRIVATE], []byte{1})
+}
+
+// addObject adds an object as another program would.
+func (m *fakeModule) addObject(slot uint, template []*pkcs11.Attribute) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.next++
+	object := map[uint][]byte{}
+	for _, a := range template {
+		object[a.Type] = append([]byte{}, a.Value...)
+	}
+	m.tokens[slot].objects[pkcs11.ObjectHandle(m.next)] = object
+}
+
+// objects returns the attributes of the objects on the token in slot.
+func (m *fakeModule) objects(slot uint) []map[uint][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var handles []pkcs11.ObjectHandle
+	for handle := range m.tokens[slot].objects {
+		handles = append(handles, handle)
+	}
+	sort.Slice(handles, func(i, j int) bool { return handles[i] < handles[j] })
+	var objects []map[uint][]byte
+	for _, handle := range handles {
+		objects = append(objects, m.tokens[slot].objects[handle])
+	}
+	return objects
+}
+
+// closeAllSessions closes every session, as happens when a token is
+// removed and inserted again.
+func (m *fakeModule) closeAllSessions() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions = map[pkcs11.SessionHandle]*fakeSession{}
+	for _, token := range m.tokens {
+		token.loggedIn = false
+	}
+}
+
+func (m *fakeModule) GetSlotList(tokenPresent bool) ([]uint, error) {
+	var slots []uint
+	for i := range m.tokens {
+		slots = append(slots, uint(i))
+	}
+	return slots, nil
+}
+
+func (m *fakeModule) GetTokenInfo(slotID uint) (pkcs11.TokenInfo, error) {
+	if slotID >= uint(len(m.tokens)) {
+		return pkcs11.TokenInfo{}, ckr(pkcs11.CKR_SLOT_ID_INVALID)
+	}
+	return pkcs11.TokenInfo{Label: m.tokens[slotID].label}, nil
+}
+
+func (m *fakeModule) OpenSession(slotID uint, flags uint) (pkcs11.SessionHandle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if slotID >= uint(len(m.tokens)) {
+		return 0, ckr(pkcs11.CKR_SLOT_ID_INVALID)
+	}
+	if flags&pkcs11.CKF_SERIAL_SESSION == 0 {
+		return 0, ckr(pkcs11.CKR_SESSION_PARALLEL_NOT_SUPPORTED)
+	}
+	m.next++
+	m.opened++
+	m.sessions[pkcs11.SessionHandle(m.next)] = &fakeSession{slot: slotID}
+	return pkcs11.SessionHandle(m.next), nil
+}
+
+func (m *fakeModule) CloseSession(sh pkcs11.SessionHandle) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, token, err := m.session(sh)
+	if err != nil {
+		return err
+	}
+	delete(m.sessions, sh)
+	for _, other := range m.sessions {
+		if other.slot == session.slot {
+			return nil
+		}
+	}
+	// Closing the last session of a token logs out of it.
+	token.loggedIn = false
+	return nil
+}
+
+func (m *fakeModule) Login(sh pkcs11.SessionHandle, userType uint, pin string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, token, err := m.session(sh)
+	if err != nil {
+		return err
+	}
+	if token.loggedIn {
+		return ckr(pkcs11.CKR_USER_ALREADY_LOGGED_IN)
+	}
+	if userType != pkcs11.CKU_USER || pin != token.pin {
+		return ckr(pkcs11.CKR_PIN_INCORRECT)
+	}
+	token.loggedIn = true
+	return nil
+}
+
+func (m *fakeModule) CreateObject(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, token, err := m.session(sh)
+	if err != nil {
+		return 0, err
+	}
+	if token.readOnly {
+		return 0, ckr(pkcs11.CKR_TOKEN_WRITE_PROTECTED)
+	}
+	object := map[uint][]byte{}
+	for _, a := range temp {
+		object[a.Type] = append([]byte{}, a.Value...)
+	}
+	if !visible(token, object) {
+		return 0, ckr(pkcs11.CKR_USER_NOT_LOGGED_IN)
+	}
+	m.next++
+	token.objects[pkcs11.ObjectHandle(m.next)] = object
+	return pkcs11.ObjectHandle(m.next), nil
+}
+
+func (m *fakeModule) DestroyObject(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, token, err := m.object(sh, oh)
+	if err != nil {
+		return err
+	}
+	if token.readOnly {
+		return ckr(pkcs11.CKR_TOKEN_WRITE_PROTECTED)
+	}
+	delete(token.objects, oh)
+	return nil
+}
+
+func (m *fakeModule) GetAttributeValue(sh pkcs11.SessionHandle, o pkcs11.ObjectHandle, a []*pkcs11.Attribute) ([]*pkcs11.Attribute, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	object, _, err := m.object(sh, o)
+	if err != nil {
+		return nil, err
+	}
+	var values []*pkcs11.Attribute
+	for _, attribute := range a {
+		value, ok := object[attribute.Type]
+		if !ok {
+			return nil, ckr(pkcs11.CKR_ATTRIBUTE_TYPE_INVALID)
+		}
+		values = append(values, &pkcs11.Attribute{Type: attribute.Type, Value: append([]byte{}, value...)})
+	}
+	return values, nil
+}
+
+func (m *fakeModule) SetAttributeValue(sh pkcs11.SessionHandle, o pkcs11.ObjectHandle, a []*pkcs11.Attribute) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	object, token, err := m.object(sh, o)
+	if err != nil {
+		return err
+	}
+	if token.readOnly {
+		return ckr(pkcs11.CKR_TOKEN_WRITE_PROTECTED)
+	}
+	for _, attribute := range a {
+		object[attribute.Type] = append([]byte{}, attribute.Value...)
+	}
+	return nil
+}
+
+func (m *fakeModule) FindObjectsInit(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, token, err := m.session(sh)
+	if err != nil {
+		return err
+	}
+	if session.finding {
+		return ckr(pkcs11.CKR_OPERATION_ACTIVE)
+	}
+	session.finding, session.found = true, nil
+objects:
+	for handle, object := range token.objects {
+		if !visible(token, object) {
+			continue
+		}
+		for _, attribute := range temp {
+			if value, ok := object[attribute.Type]; !ok || !bytes.Equal(value, attribute.Value) {
+				continue objects
+			}
+		}
+		session.found = append(session.found, handle)
+	}
+	sort.Slice(session.found, func(i, j int) bool { return session.found[i] < session.found[j] })
+	return nil
+}
+
+func (m *fakeModule) FindObjects(sh pkcs11.SessionHandle, max int) ([]pkcs11.ObjectHandle, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, _, err := m.session(sh)
+	if err != nil {
+		return nil, false, err
+	}
+	if !session.finding {
+		return nil, false, ckr(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	n := len(session.found)
+	if n > max {
+		n = max
+	}
+	found := session.found[:n]
+	session.found = session.found[n:]
+	return found, false, nil
+}
+
+func (m *fakeModule) FindObjectsFinal(sh pkcs11.SessionHandle) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, _, err := m.session(sh)
+	if err != nil {
+		return err
+	}
+	if !session.finding {
+		return ckr(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	session.finding, session.found = false, nil
+	return nil
+}
@@ -0,0 +1,371 @@
+// Package pkcs11store keeps generic passwords on a PKCS#11 token, such
+// as a hardware security module or a YubiKey, through the module the
+// token's vendor provides.
+package pkcs11store
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/miekg/pkcs11"
+)
+
+// Module is the part of a PKCS#11 module a Store uses. *pkcs11.Ctx,
+// as OpenModule returns, implements it.
+type Module interface {
+	GetSlotList(tokenPresent bool) ([]uint, error)
+	GetTokenInfo(slotID uint) (pkcs11.TokenInfo, error)
+	OpenSession(slotID uint, flags uint) (pkcs11.SessionHandle, error)
+	CloseSession(sh pkcs11.SessionHandle) error
+	Login(sh pkcs11.SessionHandle, userType uint, pin string) error
+	CreateObject(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error)
+	DestroyObject(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle) error
+	GetAttributeValue(sh pkcs11.SessionHandle, o pkcs11.ObjectHandle, a []*pkcs11.Attribute) ([]*pkcs11.Attribute, error)
+	SetAttributeValue(sh pkcs11.SessionHandle, o pkcs11.ObjectHandle, a []*pkcs11.Attribute) error
+	FindObjectsInit(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) error
+	FindObjects(sh pkcs11.SessionHandle, max int) ([]pkcs11.ObjectHandle, bool, error)
+	FindObjectsFinal(sh pkcs11.SessionHandle) error
+}
+
+// OpenModule loads and initializes the PKCS#11 module at path, such as
+// /usr/lib/softhsm/libsofthsm2.so. The caller should call Finalize and
+// Destroy on it once done with it.
+func OpenModule(path string) (*pkcs11.Ctx, error) {
+	ctx := pkcs11.New(path)
+	if ctx == nil {
+		return nil, errors.New("pkcs11store: can't load the module at " + path)
+	}
+	if err := ctx.Initialize(); err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// Store is an osxkeychain.Store kept on a PKCS#11 token. Each generic
+// password is a private data object (CKO_DATA) whose CKA_APPLICATION
+// is the service name, CKA_LABEL the account name and CKA_VALUE the
+// password, as pkcs11-tool --write-object --type data creates.
+//
+// A Store logs in to the token as its user when first used, and keeps
+// one session open until Close is called, opening another if the
+// token closes it. Since a program is logged in to a token rather than
+// a session, Stores using the same token share the login.
+//
+// PKCS#11 can't create an object only if no other matches it, so
+// AddGenericPassword only returns ErrDuplicateItem for items added by
+// other programs before it checks.
+//
+// Errors are those of the module, as pkcs11.Error, except that an
+// incorrect or locked PIN is returned as osxkeychain.ErrAuthFailed
+// and a write-protected token as osxkeychain.ErrReadOnly.
+type Store struct {
+	Module Module
+	// TokenLabel is the label of the token to use. If empty, the token
+	// in the first slot that has one is used.
+	TokenLabel string
+	PIN        string
+
+	mu      sync.Mutex
+	session pkcs11.SessionHandle
+	open    bool
+}
+
+// dataClass is the attribute every object of a Store has.
+var dataClass = pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA)
+
+// AddGenericPassword implements osxkeychain.Store.
+func (s *Store) AddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	return s.withSession(func(sh pkcs11.SessionHandle) error {
+		objects, err := s.find(sh, attributes.ServiceName, &attributes.AccountName)
+		if err != nil {
+			return err
+		}
+		if len(objects) > 0 {
+			return osxkeychain.ErrDuplicateItem
+		}
+		return s.create(sh, attributes)
+	})
+}
+
+// FindGenericPassword implements osxkeychain.Store.
+func (s *Store) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	if err := attributes.CheckValidity(); err != nil {
+		return nil, err
+	}
+	var password []byte
+	err := s.withSession(func(sh pkcs11.SessionHandle) error {
+		objects, err := s.match(sh, attributes)
+		if err != nil {
+			return err
+		}
+		if len(objects) == 0 {
+			return osxkeychain.ErrItemNotFound
+		}
+		values, err := s.Module.GetAttributeValue(sh, objects[0], []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil)})
+		if err != nil {
+			return err
+		}
+		password = append([]byte{}, values[0].Value...)
+		return nil
+	})
+	return password, err
+}
+
+// FindAndRemoveGenericPassword implements osxkeychain.Store.
+func (s *Store) FindAndRemoveGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	return s.withSession(func(sh pkcs11.SessionHandle) error {
+		objects, err := s.match(sh, attributes)
+		if err != nil {
+			return err
+		}
+		if len(objects) == 0 {
+			return osxkeychain.ErrItemNotFound
+		}
+		return s.destroy(sh, objects)
+	})
+}
+
+// RemoveAndAddGenericPassword implements osxkeychain.Store.
+func (s *Store) RemoveAndAddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	return s.withSession(func(sh pkcs11.SessionHandle) error {
+		objects, err := s.match(sh, attributes)
+		if err != nil {
+			return err
+		}
+		if err := s.destroy(sh, objects); err != nil {
+			return err
+		}
+		return s.create(sh, attributes)
+	})
+}
+
+// UpdateGenericPassword implements osxkeychain.Store.
+func (s *Store) UpdateGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	return s.withSession(func(sh pkcs11.SessionHandle) error {
+		objects, err := s.match(sh, attributes)
+		if err != nil {
+			return err
+		}
+		if len(objects) == 0 {
+			return osxkeychain.ErrItemNotFound
+		}
+		return s.Module.SetAttributeValue(sh, objects[0], []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, attributes.Password)})
+	})
+}
+
+// GetAllAccountNames implements osxkeychain.Store.
+func (s *Store) GetAllAccountNames(serviceName string) ([]string, error) {
+	accountNames := []string{}
+	err := s.withSession(func(sh pkcs11.SessionHandle) error {
+		objects, err := s.find(sh, serviceName, nil)
+		if err != nil {
+			return err
+		}
+		accountNames = accountNames[:0]
+		for _, object := range objects {
+			values, err := s.Module.GetAttributeValue(sh, object, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil)})
+			if err != nil {
+				return err
+			}
+			accountNames = append(accountNames, string(values[0].Value))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accountNames, nil
+}
+
+// Close closes the Store's session, if it has one; the token logs the
+// program out once it has no session left. The Store opens another if
+// it's used again.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.open {
+		return nil
+	}
+	s.open = false
+	return s.Module.CloseSession(s.session)
+}
+
+// withSession calls f with the Store's session, opening it and logging
+// in if needed. If the token closed the session or logged it out, it
+// does so again and calls f once more.
+func (s *Store) withSession(f func(sh pkcs11.SessionHandle) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if !s.open {
+			if err := s.openSession(); err != nil {
+				return mapError(err)
+			}
+		}
+		err := f(s.session)
+		if attempt == 0 && isStaleSession(err) {
+			s.Module.CloseSession(s.session)
+			s.open = false
+			continue
+		}
+		return mapError(err)
+	}
+}
+
+func (s *Store) openSession() error {
+	if s.Module == nil {
+		return errors.New("Module is nil")
+	}
+	slot, err := s.slot()
+	if err != nil {
+		return err
+	}
+	sh, err := s.Module.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return err
+	}
+	if err := s.Module.Login(sh, pkcs11.CKU_USER, s.PIN); err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		s.Module.CloseSession(sh)
+		return err
+	}
+	s.session, s.open = sh, true
+	return nil
+}
+
+// slot returns the slot holding the token labelled TokenLabel.
+func (s *Store) slot() (uint, error) {
+	slots, err := s.Module.GetSlotList(true)
+	if err != nil {
+		return 0, err
+	}
+	for _, slot := range slots {
+		if s.TokenLabel == "" {
+			return slot, nil
+		}
+		info, err := s.Module.GetTokenInfo(slot)
+		if err != nil {
+			return 0, err
+		}
+		if info.Label == s.TokenLabel {
+			return slot, nil
+		}
+	}
+	if s.TokenLabel == "" {
+		return 0, errors.New("pkcs11store: no token is present")
+	}
+	return 0, errors.New("pkcs11store: no token labelled " + s.TokenLabel + " is present")
+}
+
+// match returns the data object with the names of attributes. As in
+// the keychain, an empty name matches any, and then only the first
+// object that matches is returned.
+func (s *Store) match(sh pkcs11.SessionHandle, attributes *osxkeychain.GenericPasswordAttributes) ([]pkcs11.ObjectHandle, error) {
+	if attributes.ServiceName != "" && attributes.AccountName != "" {
+		return s.find(sh, attributes.ServiceName, &attributes.AccountName)
+	}
+	template := []*pkcs11.Attribute{dataClass}
+	if attributes.ServiceName != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_APPLICATION, attributes.ServiceName))
+	}
+	if attributes.AccountName != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, attributes.AccountName))
+	}
+	if err := s.Module.FindObjectsInit(sh, template); err != nil {
+		return nil, err
+	}
+	objects, _, err := s.Module.FindObjects(sh, 1)
+	if err != nil {
+		s.Module.FindObjectsFinal(sh)
+		return nil, err
+	}
+	return objects, s.Module.FindObjectsFinal(sh)
+}
+
+// find returns the data objects of serviceName and, if it isn't nil,
+// *accountName.
+func (s *Store) find(sh pkcs11.SessionHandle, serviceName string, accountName *string) ([]pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{dataClass, pkcs11.NewAttribute(pkcs11.CKA_APPLICATION, serviceName)}
+	if accountName != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, *accountName))
+	}
+	if err := s.Module.FindObjectsInit(sh, template); err != nil {
+		return nil, err
+	}
+	var objects []pkcs11.ObjectHandle
+	for {
+		found, _, err := s.Module.FindObjects(sh, 64)
+		if err != nil {
+			s.Module.FindObjectsFinal(sh)
+			return nil, err
+		}
+		if len(found) == 0 {
+			break
+		}
+		objects = append(objects, found...)
+	}
+	return objects, s.Module.FindObjectsFinal(sh)
+}
+
+func (s *Store) create(sh pkcs11.SessionHandle, attributes *osxkeychain.GenericPasswordAttributes) error {
+	_, err := s.Module.CreateObject(sh, []*pkcs11.Attribute{
+		dataClass,
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_MODIFIABLE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_APPLICATION, attributes.ServiceName),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, attributes.AccountName),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, attributes.Password),
+	})
+	return err
+}
+
+func (s *Store) destroy(sh pkcs11.SessionHandle, objects []pkcs11.ObjectHandle) error {
+	for _, object := range objects {
+		if err := s.Module.DestroyObject(sh, object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isStaleSession returns whether err means the session must be opened
+// again, or logged in again, before it can be used.
+func isStaleSession(err error) bool {
+	switch err {
+	case pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID),
+		pkcs11.Error(pkcs11.CKR_SESSION_CLOSED),
+		pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN):
+		return true
+	}
+	return false
+}
+
+// mapError returns the osxkeychain error for the PKCS#11 error err,
+// if there is one, and err otherwise.
+func mapError(err error) error {
+	switch err {
+	case pkcs11.Error(pkcs11.CKR_PIN_INCORRECT),
+		pkcs11.Error(pkcs11.CKR_PIN_INVALID),
+		pkcs11.Error(pkcs11.CKR_PIN_LEN_RANGE),
+		pkcs11.Error(pkcs11.CKR_PIN_EXPIRED),
+		pkcs11.Error(pkcs11.CKR_PIN_LOCKED):
+		return osxkeychain.ErrAuthFailed
+	case pkcs11.Error(pkcs11.CKR_TOKEN_WRITE_PROTECTED):
+		return osxkeychain.ErrReadOnly
+	}
+	return err
+}
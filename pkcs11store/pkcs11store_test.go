@@ -0,0 +1,128 @@
+package pkcs11store_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/pkcs11store"
+	"github.com/keybase/go-osxkeychain/storetest"
+	"github.com/miekg/pkcs11"
+)
+
+func newTestStore() (*pkcs11store.Store, *fakeModule) {
+	module := newFakeModule(&fakeToken{label: "other", pin: "0000"}, &fakeToken{label: "secrets", pin: "1234"})
+	return &pkcs11store.Store{Module: module, TokenLabel: "secrets", PIN: "1234"}, module
+}
+
+func TestStore(t *testing.T) {
+	store, module := newTestStore()
+	storetest.TestStore(t, store)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(module.sessions) != 0 || module.tokens[1].loggedIn {
+		t.Errorf("Expected Close to close the session, and so log out, got %d sessions", len(module.sessions))
+	}
+	if module.opened != 1 {
+		t.Errorf("Expected one session to be used, got %d", module.opened)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	store, module := newTestStore()
+	if err := store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("pw")}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[uint][]byte{{
+		pkcs11.CKA_CLASS:       pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA).Value,
+		pkcs11.CKA_TOKEN:       {1},
+		pkcs11.CKA_PRIVATE:     {1},
+		pkcs11.CKA_MODIFIABLE:  {1},
+		pkcs11.CKA_APPLICATION: []byte("svc"),
+		pkcs11.CKA_LABEL:       []byte("acct"),
+		pkcs11.CKA_VALUE:       []byte("pw"),
+	}}
+	if objects := module.objects(1); !reflect.DeepEqual(objects, expected) {
+		t.Errorf("Expected %v, got %v", expected, objects)
+	}
+	if objects := module.objects(0); len(objects) != 0 {
+		t.Errorf("Expected the other token to be untouched, got %v", objects)
+	}
+
+	// Data objects written by other programs are items, but other
+	// objects with the same labels aren't.
+	module.addObject(1, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_APPLICATION, "svc"),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "tool"),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, []byte("from pkcs11-tool")),
+	})
+	module.addObject(1, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+		pkcs11.NewAttribute(pkcs11.CKA_APPLICATION, "svc"),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "certificate"),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, []byte("DER")),
+	})
+	if password, err := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "tool"}); err != nil || string(password) != "from pkcs11-tool" {
+		t.Errorf("Expected %q, got %q, %v", "from pkcs11-tool", password, err)
+	}
+	if _, err := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "certificate"}); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("Expected ErrItemNotFound, got %v", err)
+	}
+	if accountNames, err := store.GetAllAccountNames("svc"); err != nil || !reflect.DeepEqual(accountNames, []string{"acct", "tool"}) {
+		t.Errorf("Expected [acct tool], got %v, %v", accountNames, err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	store, module := newTestStore()
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("pw")}
+	if err := store.AddGenericPassword(attributes); err != nil {
+		t.Fatal(err)
+	}
+
+	// The token was removed and inserted again.
+	module.closeAllSessions()
+	if password, err := store.FindGenericPassword(attributes); err != nil || string(password) != "pw" {
+		t.Errorf("Expected %q, got %q, %v", "pw", password, err)
+	}
+	if module.opened != 2 || len(module.sessions) != 1 {
+		t.Errorf("Expected the session to be opened again, got %d opened and %d open", module.opened, len(module.sessions))
+	}
+
+	// Another store of the same program shares the login, which
+	// closing it doesn't end.
+	other := &pkcs11store.Store{Module: module, TokenLabel: "secrets", PIN: "1234"}
+	if _, err := other.GetAllAccountNames("svc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.FindGenericPassword(attributes); err != nil {
+		t.Errorf("Expected to still be logged in, got %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		modify   func(s *pkcs11store.Store, m *fakeModule)
+		expected string
+	}{
+		{func(s *pkcs11store.Store, m *fakeModule) { s.PIN = "4321" }, osxkeychain.ErrAuthFailed.Error()},
+		{func(s *pkcs11store.Store, m *fakeModule) { m.tokens[1].readOnly = true }, osxkeychain.ErrReadOnly.Error()},
+		{func(s *pkcs11store.Store, m *fakeModule) { s.TokenLabel = "missing" }, "pkcs11store: no token labelled missing is present"},
+		{func(s *pkcs11store.Store, m *fakeModule) { m.tokens, s.TokenLabel = nil, "" }, "pkcs11store: no token is present"},
+		{func(s *pkcs11store.Store, m *fakeModule) { s.Module = nil }, "Module is nil"},
+	}
+	for _, test := range tests {
+		store, module := newTestStore()
+		test.modify(store, module)
+		err := store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct"})
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+}
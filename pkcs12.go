@@ -0,0 +1,188 @@
+package osxkeychain
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// PKCS12Encoding is the set of algorithms a PKCS #12 bundle is
+// encrypted and authenticated with. Every encoding can be decoded.
+type PKCS12Encoding int
+
+const (
+	// PKCS12Modern encrypts with AES-256-CBC under keys derived with
+	// PBKDF2-HMAC-SHA-256, and authenticates with HMAC-SHA-256. It is
+	// what OpenSSL 3 writes by default.
+	PKCS12Modern PKCS12Encoding = iota
+	// PKCS12Legacy3DES encrypts with 3DES and authenticates with
+	// HMAC-SHA-1, for software that can't read PKCS12Modern, such as
+	// older releases of macOS and Windows.
+	PKCS12Legacy3DES
+	// PKCS12LegacyRC2 encrypts certificates with 40-bit RC2 and the
+	// private key with 3DES, as OpenSSL 1.x does by default. OpenSSL 3
+	// only reads it with its legacy provider.
+	PKCS12LegacyRC2
+)
+
+var pkcs12Encoders = map[PKCS12Encoding]*pkcs12.Encoder{
+	PKCS12Modern:     pkcs12.Modern2023,
+	PKCS12Legacy3DES: pkcs12.LegacyDES,
+	PKCS12LegacyRC2:  pkcs12.LegacyRC2,
+}
+
+// CheckValidity returns an error if encoding isn't one of the
+// encodings above.
+func (encoding PKCS12Encoding) CheckValidity() error {
+	if _, ok := pkcs12Encoders[encoding]; !ok {
+		return fmt.Errorf("PKCS12Encoding has unknown value %d", int(encoding))
+	}
+	return nil
+}
+
+// PKCS12Bundle is the contents of a PKCS #12 file holding an
+// identity: a private key, its certificate, and the certificates of
+// the issuers above it.
+type PKCS12Bundle struct {
+	// PrivateKey is an *rsa.PrivateKey or *ecdsa.PrivateKey.
+	PrivateKey     crypto.PrivateKey
+	Certificate    *x509.Certificate
+	CACertificates []*x509.Certificate
+}
+
+// DecodePKCS12 decodes a PKCS #12 file in any PKCS12Encoding. It
+// returns ErrPKCS12VerifyFailure if passphrase is wrong.
+func DecodePKCS12(data []byte, passphrase string) (*PKCS12Bundle, error) {
+	privateKey, cert, caCerts, err := pkcs12.DecodeChain(data, passphrase)
+	if err == pkcs12.ErrIncorrectPassword {
+		return nil, ErrPKCS12VerifyFailure
+	} else if err != nil {
+		return nil, err
+	}
+	bundle := &PKCS12Bundle{PrivateKey: privateKey, Certificate: cert, CACertificates: caCerts}
+	if err := bundle.CheckValidity(); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// EncodePKCS12 encodes bundle as a PKCS #12 file protected by
+// passphrase.
+func EncodePKCS12(bundle *PKCS12Bundle, passphrase string, encoding PKCS12Encoding) ([]byte, error) {
+	if err := bundle.CheckValidity(); err != nil {
+		return nil, err
+	}
+	if err := encoding.CheckValidity(); err != nil {
+		return nil, err
+	}
+	return pkcs12Encoders[encoding].Encode(bundle.PrivateKey, bundle.Certificate, bundle.CACertificates, passphrase)
+}
+
+// CheckValidity returns an error if the bundle's private key is of a
+// type the keychain doesn't support or isn't the one for its
+// certificate.
+func (bundle *PKCS12Bundle) CheckValidity() error {
+	if bundle.Certificate == nil {
+		return errors.New("PKCS12Bundle has no Certificate")
+	}
+	var publicKey crypto.PublicKey
+	switch key := bundle.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		publicKey = key.Public()
+	case *ecdsa.PrivateKey:
+		publicKey = key.Public()
+	default:
+		return fmt.Errorf("private key type %T is not supported", bundle.PrivateKey)
+	}
+	if !publicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(bundle.Certificate.PublicKey) {
+		return errors.New("PrivateKey doesn't match Certificate")
+	}
+	return nil
+}
+
+// PKCS12ImportOptions controls how the contents of a PKCS #12 file
+// are added to a keychain.
+type PKCS12ImportOptions struct {
+	// Label is the label the private key and its certificate are
+	// shown under. If empty, the certificate's subject common name is
+	// used. The certificates of the chain keep their own common names.
+	Label string
+
+	// TrustedApplications is a list of additional application
+	// (paths) that may use the private key without asking the user,
+	// as for GenericPasswordAttributes.
+	TrustedApplications []string
+}
+
+// CheckValidity returns an error if any of the options are invalid.
+func (options *PKCS12ImportOptions) CheckValidity() error {
+	if err := check32BitUTF8("Label", options.Label); err != nil {
+		return err
+	}
+	for _, trustedApplication := range options.TrustedApplications {
+		if err := check32BitUTF8("TrustedApplications", trustedApplication); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// label returns the label to import cert under.
+func (options *PKCS12ImportOptions) label(cert *x509.Certificate) string {
+	if options.Label != "" {
+		return options.Label
+	}
+	return cert.Subject.CommonName
+}
+
+// PKCS12Bundle returns the identity, with its private key exported
+// from the keychain, and chain as a bundle for EncodePKCS12. The
+// keychain refuses to export keys that were created as not
+// extractable, and may ask the user to allow it.
+func (identity *Identity) PKCS12Bundle(chain ...*x509.Certificate) (*PKCS12Bundle, error) {
+	data, err := identity.Key.backend.copyExternalRepresentation()
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := parseExternalPrivateKey(identity.Key.keyType, data)
+	if err != nil {
+		return nil, err
+	}
+	return &PKCS12Bundle{PrivateKey: privateKey, Certificate: identity.Certificate, CACertificates: chain}, nil
+}
+
+// maxChainLength bounds issuerChain, in case of cycles.
+const maxChainLength = 10
+
+// issuerChain returns the certificates that issued cert, from its
+// issuer up, using find to look up the certificates whose subject is
+// a given distinguished name. It stops at a self-signed certificate
+// or one whose issuer isn't found.
+func issuerChain(cert *x509.Certificate, find func(subject string) ([]*x509.Certificate, error)) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for len(chain) < maxChainLength && !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		candidates, err := find(cert.Issuer.String())
+		if err != nil {
+			return nil, err
+		}
+		var issuer *x509.Certificate
+		for _, candidate := range candidates {
+			if cert.CheckSignatureFrom(candidate) == nil {
+				issuer = candidate
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		chain = append(chain, issuer)
+		cert = issuer
+	}
+	return chain, nil
+}
@@ -0,0 +1,131 @@
+//go:build darwin && !ios && cgo
+// +build darwin,!ios,cgo
+
+package osxkeychain
+
+/*
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+*/
+import "C"
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+// ImportPKCS12 decodes a PKCS #12 file, as with DecodePKCS12, and adds
+// its private key and certificates to the default keychain. The
+// certificates of the chain are skipped if they're already there. If
+// any of them can't be added, the key and certificates added so far
+// are removed again, and an error removing them is returned along with
+// the first. options may be nil.
+func ImportPKCS12(data []byte, passphrase string, options *PKCS12ImportOptions) (*Identity, error) {
+	if options == nil {
+		options = &PKCS12ImportOptions{}
+	}
+	if err := options.CheckValidity(); err != nil {
+		return nil, err
+	}
+	bundle, err := DecodePKCS12(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	keyType, external, err := marshalExternalPrivateKey(bundle.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	label := options.label(bundle.Certificate)
+	var key *Key
+	keychainExecutor.do(func() {
+		key, err = importPKCS12(bundle, keyType, external, label, options.TrustedApplications)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Certificate: bundle.Certificate, Key: key}, nil
+}
+
+// addedItem is an item importPKCS12 added, to remove if a later one
+// can't be added.
+type addedItem struct {
+	class         C.CFTypeRef
+	persistentRef C.CFDataRef
+}
+
+// importPKCS12 adds the private key and certificates of bundle to the
+// default keychain, all or none of them.
+func importPKCS12(bundle *PKCS12Bundle, keyType KeyType, external []byte, label string, trustedApplications []string) (key *Key, err error) {
+	var added []addedItem
+	defer func() {
+		for i := len(added) - 1; i >= 0; i-- {
+			if err != nil {
+				if deleteErr := deleteItem(added[i].class, added[i].persistentRef); deleteErr != nil {
+					err = errors.Join(err, deleteErr)
+				}
+			}
+			C.CFRelease(C.CFTypeRef(added[i].persistentRef))
+		}
+	}()
+
+	var keyRef C.CFDataRef
+	key, err = importPrivateKey(keyType, external, label, trustedApplications, &keyRef)
+	if err != nil {
+		return nil, err
+	}
+	added = append(added, addedItem{secClassKey, keyRef})
+
+	certs := append([]*x509.Certificate{bundle.Certificate}, bundle.CACertificates...)
+	for i, cert := range certs {
+		certLabel := ""
+		if i == 0 {
+			certLabel = label
+		}
+		var certRef C.CFDataRef
+		if err := importCertificate(cert, certLabel, &certRef); err == ErrDuplicateItem {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		added = append(added, addedItem{secClassCertificate, certRef})
+	}
+	return key, nil
+}
+
+// deleteItem removes the item of the given class that persistentRef
+// refers to.
+func deleteItem(class C.CFTypeRef, persistentRef C.CFDataRef) error {
+	query := mapToCFDictionary(map[C.CFTypeRef]C.CFTypeRef{
+		secClass:              class,
+		secValuePersistentRef: C.CFTypeRef(persistentRef),
+	})
+	defer C.CFRelease(C.CFTypeRef(query))
+	return newKeychainError(C.SecItemDelete(query))
+}
+
+// ExportPKCS12 encodes identity as a PKCS #12 file in PKCS12Modern,
+// along with the chain of issuers of its certificate found in the
+// keychain search list. Use Identity.PKCS12Bundle and EncodePKCS12
+// for the legacy encodings.
+func ExportPKCS12(identity *Identity, passphrase string) ([]byte, error) {
+	chain, err := issuerChain(identity.Certificate, func(subject string) ([]*x509.Certificate, error) {
+		items, err := FindCertificates(&CertificateQuery{Subject: subject})
+		if err != nil {
+			return nil, err
+		}
+		certs := make([]*x509.Certificate, len(items))
+		for i := range items {
+			certs[i] = items[i].Certificate
+		}
+		return certs, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := identity.PKCS12Bundle(chain...)
+	if err != nil {
+		return nil, err
+	}
+	return EncodePKCS12(bundle, passphrase, PKCS12Modern)
+}
@@ -0,0 +1,196 @@
+package osxkeychain
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The fixtures in testdata were made with OpenSSL 3 from a leaf
+// certificate and key issued by "osxkeychain test CA":
+//
+//	openssl pkcs12 -export -inkey leaf.key -in leaf.pem -certfile ca.pem \
+//		[-keypbe PBE-SHA1-3DES -certpbe PBE-SHA1-3DES -macalg sha1 | -legacy] \
+//		-passout pass:correct-horse
+const fixturePassphrase = "correct-horse"
+
+func readFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodePKCS12(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		keyType  KeyType
+		leafName string
+	}{
+		{"rsa-aes256.p12", KeyTypeRSA, "osxkeychain test RSA"},
+		{"ecdsa-aes256.p12", KeyTypeECDSA, "osxkeychain test ECDSA"},
+		{"rsa-3des.p12", KeyTypeRSA, "osxkeychain test RSA"},
+		{"rsa-rc2.p12", KeyTypeRSA, "osxkeychain test RSA"},
+	}
+	for _, test := range tests {
+		bundle, err := DecodePKCS12(readFixture(t, test.fixture), fixturePassphrase)
+		if err != nil {
+			t.Errorf("%s: %v", test.fixture, err)
+			continue
+		}
+		if keyType, _, err := marshalExternalPrivateKey(bundle.PrivateKey); err != nil || keyType != test.keyType {
+			t.Errorf("%s: Expected a %s key, got %T", test.fixture, test.keyType, bundle.PrivateKey)
+		}
+		if bundle.Certificate.Subject.CommonName != test.leafName {
+			t.Errorf("%s: Expected certificate %q, got %q", test.fixture, test.leafName, bundle.Certificate.Subject.CommonName)
+		}
+		if len(bundle.CACertificates) != 1 {
+			t.Errorf("%s: Expected 1 CA certificate, got %d", test.fixture, len(bundle.CACertificates))
+			continue
+		}
+		if err := bundle.Certificate.CheckSignatureFrom(bundle.CACertificates[0]); err != nil {
+			t.Errorf("%s: %v", test.fixture, err)
+		}
+
+		if _, err := DecodePKCS12(readFixture(t, test.fixture), "wrong"); err != ErrPKCS12VerifyFailure {
+			t.Errorf("%s: Expected ErrPKCS12VerifyFailure, got %v", test.fixture, err)
+		}
+	}
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	original, err := DecodePKCS12(readFixture(t, "rsa-aes256.p12"), fixturePassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, encoding := range []PKCS12Encoding{PKCS12Modern, PKCS12Legacy3DES, PKCS12LegacyRC2} {
+		data, err := EncodePKCS12(original, "battery-staple", encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle, err := DecodePKCS12(data, "battery-staple")
+		if err != nil {
+			t.Fatalf("encoding %d: %v", encoding, err)
+		}
+		if !bundle.Certificate.Equal(original.Certificate) || len(bundle.CACertificates) != 1 || !bundle.CACertificates[0].Equal(original.CACertificates[0]) {
+			t.Errorf("encoding %d: certificates don't round-trip", encoding)
+		}
+		if !original.PrivateKey.(interface{ Equal(crypto.PrivateKey) bool }).Equal(bundle.PrivateKey) {
+			t.Errorf("encoding %d: private key doesn't round-trip", encoding)
+		}
+	}
+
+	expected := "PKCS12Encoding has unknown value 3"
+	if _, err := EncodePKCS12(original, "battery-staple", 3); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+
+	other, err := DecodePKCS12(readFixture(t, "ecdsa-aes256.p12"), fixturePassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatched := &PKCS12Bundle{PrivateKey: other.PrivateKey, Certificate: original.Certificate}
+	expected = "PrivateKey doesn't match Certificate"
+	if _, err := EncodePKCS12(mismatched, "battery-staple", PKCS12Modern); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
+
+func TestPKCS12ImportOptions(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "client.example.com"}}
+
+	options := &PKCS12ImportOptions{}
+	if label := options.label(cert); label != "client.example.com" {
+		t.Errorf("Expected the common name as the label, got %q", label)
+	}
+	options.Label = "My client certificate"
+	if label := options.label(cert); label != "My client certificate" {
+		t.Errorf("Expected the given label, got %q", label)
+	}
+
+	options.TrustedApplications = []string{"/Applications/Example.app", "\xff"}
+	expected := "TrustedApplications is not a valid UTF-8 string"
+	if err := options.CheckValidity(); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
+
+// newTestCA returns a certificate and key signed by parent, or
+// self-signed if parent is nil.
+func newTestCA(t *testing.T, name string, parent *x509.Certificate, parentKey *Key) (*x509.Certificate, *Key) {
+	key, signer := newSoftwareKey(t, KeyTypeECDSA)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, signer.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestIdentityPKCS12Bundle(t *testing.T) {
+	root, rootKey := newTestCA(t, "Root", nil, nil)
+	intermediate, intermediateKey := newTestCA(t, "Intermediate", root, rootKey)
+	// An unrelated certificate with the same subject, which mustn't be
+	// taken for the issuer.
+	impostor, _ := newTestCA(t, "Intermediate", nil, nil)
+	leaf, leafKey := newTestCA(t, "Leaf", intermediate, intermediateKey)
+
+	certs := []*x509.Certificate{impostor, intermediate, root}
+	chain, err := issuerChain(leaf, func(subject string) ([]*x509.Certificate, error) {
+		var found []*x509.Certificate
+		for _, cert := range certs {
+			if cert.Subject.String() == subject {
+				found = append(found, cert)
+			}
+		}
+		return found, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || !chain[0].Equal(intermediate) || !chain[1].Equal(root) {
+		t.Fatalf("Expected the intermediate and root, got %v", chain)
+	}
+
+	identity := &Identity{Certificate: leaf, Key: leafKey}
+	bundle, err := identity.PKCS12Bundle(chain...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncodePKCS12(bundle, "battery-staple", PKCS12Modern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodePKCS12(data, "battery-staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Certificate.Equal(leaf) || len(decoded.CACertificates) != 2 {
+		t.Errorf("Expected the leaf and its chain, got %v and %v", decoded.Certificate, decoded.CACertificates)
+	}
+	if !leafKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(decoded.Certificate.PublicKey) {
+		t.Error("Expected the identity's key")
+	}
+}
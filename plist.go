@@ -0,0 +1,336 @@
+package osxkeychain
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// Keys and values of item dictionaries: the values of the kSec*
+// constants used in keychain queries.
+const (
+	plistClass                = "class"      // kSecClass
+	plistClassGenericPassword = "genp"       // kSecClassGenericPassword
+	plistClassCertificate     = "cert"       // kSecClassCertificate
+	plistClassKey             = "keys"       // kSecClassKey
+	plistService              = "svce"       // kSecAttrService
+	plistAccount              = "acct"       // kSecAttrAccount
+	plistLabel                = "labl"       // kSecAttrLabel
+	plistValueData            = "v_Data"     // kSecValueData
+	plistAccess               = "acls"       // kSecAttrAccess
+	plistAccessible           = "pdmn"       // kSecAttrAccessible
+	plistAccessControl        = "accc"       // kSecAttrAccessControl
+	plistOperationPrompt      = "u_OpPrompt" // kSecUseOperationPrompt
+	plistDataProtection       = "nleg"       // kSecUseDataProtectionKeychain
+	plistAccessGroup          = "agrp"       // kSecAttrAccessGroup
+	plistSynchronizable       = "sync"       // kSecAttrSynchronizable
+	plistSynchronizableAny    = "syna"       // kSecAttrSynchronizableAny
+	plistSubject              = "subj"       // kSecAttrSubject
+	plistIssuer               = "issr"       // kSecAttrIssuer
+	plistSerialNumber         = "slnr"       // kSecAttrSerialNumber
+	plistKeyClass             = "kcls"       // kSecAttrKeyClass
+	plistKeyClassPrivate      = "1"          // kSecAttrKeyClassPrivate
+	plistKeyType              = "type"       // kSecAttrKeyType
+	plistKeySizeInBits        = "bsiz"       // kSecAttrKeySizeInBits
+)
+
+var plistAccessibleValues = map[Accessible]string{
+	AccessibleWhenUnlocked:                   "ak",   // kSecAttrAccessibleWhenUnlocked
+	AccessibleAfterFirstUnlock:               "ck",   // kSecAttrAccessibleAfterFirstUnlock
+	AccessibleWhenPasscodeSetThisDeviceOnly:  "akpu", // kSecAttrAccessibleWhenPasscodeSetThisDeviceOnly
+	AccessibleWhenUnlockedThisDeviceOnly:     "aku",  // kSecAttrAccessibleWhenUnlockedThisDeviceOnly
+	AccessibleAfterFirstUnlockThisDeviceOnly: "cku",  // kSecAttrAccessibleAfterFirstUnlockThisDeviceOnly
+}
+
+var plistKeyTypes = map[KeyType]string{
+	KeyTypeRSA:   "42", // kSecAttrKeyTypeRSA
+	KeyTypeECDSA: "73", // kSecAttrKeyTypeECSECPrimeRandom
+}
+
+// GenericPasswordToPlist returns a property list dictionary describing
+// the generic password with the given attributes, for plist.Marshal.
+// Fields are stored under the keys a keychain query would use for
+// them, such as "svce" for ServiceName, and are left out if zero.
+// TrustedApplications is stored as an array of paths under "acls",
+// and AccessControl as an integer under "accc".
+func GenericPasswordToPlist(attributes *GenericPasswordAttributes) (map[string]interface{}, error) {
+	if err := attributes.CheckValidity(); err != nil {
+		return nil, err
+	}
+	dict := map[string]interface{}{
+		plistClass:   plistClassGenericPassword,
+		plistService: attributes.ServiceName,
+		plistAccount: attributes.AccountName,
+	}
+	if attributes.Password != nil {
+		dict[plistValueData] = attributes.Password
+	}
+	if len(attributes.TrustedApplications) > 0 {
+		apps := make([]interface{}, len(attributes.TrustedApplications))
+		for i, app := range attributes.TrustedApplications {
+			apps[i] = app
+		}
+		dict[plistAccess] = apps
+	}
+	if attributes.AccessControl != 0 {
+		dict[plistAccessControl] = int64(attributes.AccessControl)
+	}
+	if attributes.Accessible != AccessibleDefault {
+		dict[plistAccessible] = plistAccessibleValues[attributes.Accessible]
+	}
+	if attributes.OperationPrompt != "" {
+		dict[plistOperationPrompt] = attributes.OperationPrompt
+	}
+	if attributes.UseDataProtectionKeychain {
+		dict[plistDataProtection] = true
+	}
+	if attributes.AccessGroup != "" {
+		dict[plistAccessGroup] = attributes.AccessGroup
+	}
+	switch attributes.Synchronizable {
+	case SynchronizableYes:
+		dict[plistSynchronizable] = true
+	case SynchronizableNo:
+		dict[plistSynchronizable] = false
+	case SynchronizableAny:
+		dict[plistSynchronizable] = plistSynchronizableAny
+	}
+	return dict, nil
+}
+
+// GenericPasswordFromPlist returns the attributes described by a
+// dictionary in the form GenericPasswordToPlist returns. Keys it
+// doesn't know, such as the creation date in a keychain dump, are
+// ignored.
+func GenericPasswordFromPlist(dict map[string]interface{}) (*GenericPasswordAttributes, error) {
+	r := plistReader{dict: dict}
+	r.checkClass(plistClassGenericPassword)
+	attributes := &GenericPasswordAttributes{
+		ServiceName:     r.string(plistService),
+		AccountName:     r.string(plistAccount),
+		Password:        r.data(plistValueData),
+		OperationPrompt: r.string(plistOperationPrompt),
+		AccessGroup:     r.string(plistAccessGroup),
+	}
+	if v, ok := dict[plistAccess]; ok {
+		a, ok := v.([]interface{})
+		if !ok {
+			r.typeError(plistAccess, v, "an array")
+		}
+		for _, app := range a {
+			s, ok := app.(string)
+			if !ok {
+				r.typeError(plistAccess, app, "an array of strings")
+				break
+			}
+			attributes.TrustedApplications = append(attributes.TrustedApplications, s)
+		}
+	}
+	if flags, ok := r.integer(plistAccessControl); ok {
+		attributes.AccessControl = AccessControlFlags(flags)
+	}
+	if s := r.string(plistAccessible); s != "" {
+		attributes.Accessible = -1
+		for accessible, value := range plistAccessibleValues {
+			if value == s {
+				attributes.Accessible = accessible
+			}
+		}
+		if attributes.Accessible == -1 && r.err == nil {
+			r.err = fmt.Errorf("%s has unknown value %q", plistAccessible, s)
+		}
+	}
+	attributes.UseDataProtectionKeychain = r.boolean(plistDataProtection)
+	switch v := dict[plistSynchronizable].(type) {
+	case nil:
+	case bool:
+		attributes.Synchronizable = SynchronizableNo
+		if v {
+			attributes.Synchronizable = SynchronizableYes
+		}
+	case string:
+		if v != plistSynchronizableAny && r.err == nil {
+			r.err = fmt.Errorf("%s has unknown value %q", plistSynchronizable, v)
+		}
+		attributes.Synchronizable = SynchronizableAny
+	case int64:
+		// Dumps of real keychains store it as 0 or 1.
+		attributes.Synchronizable = SynchronizableNo
+		if v != 0 {
+			attributes.Synchronizable = SynchronizableYes
+		}
+	default:
+		r.typeError(plistSynchronizable, v, "a boolean")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if err := attributes.CheckValidity(); err != nil {
+		return nil, err
+	}
+	return attributes, nil
+}
+
+// CertificateToPlist returns a property list dictionary describing the
+// given certificate item, with the certificate's DER encoding under
+// "v_Data". Its subject, issuer and serial number are included too,
+// but ignored by CertificateFromPlist.
+func CertificateToPlist(item *CertificateItem) map[string]interface{} {
+	cert := item.Certificate
+	dict := map[string]interface{}{
+		plistClass:        plistClassCertificate,
+		plistValueData:    cert.Raw,
+		plistSubject:      cert.RawSubject,
+		plistIssuer:       cert.RawIssuer,
+		plistSerialNumber: cert.SerialNumber.Bytes(),
+	}
+	if item.Label != "" {
+		dict[plistLabel] = item.Label
+	}
+	return dict
+}
+
+// CertificateFromPlist returns the certificate item described by a
+// dictionary in the form CertificateToPlist returns.
+func CertificateFromPlist(dict map[string]interface{}) (*CertificateItem, error) {
+	r := plistReader{dict: dict}
+	r.checkClass(plistClassCertificate)
+	label := r.string(plistLabel)
+	der := r.data(plistValueData)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if der == nil {
+		return nil, errors.New(plistValueData + " is missing")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateItem{Label: label, Certificate: cert}, nil
+}
+
+// PrivateKeyToPlist returns a property list dictionary describing an
+// RSA or ECDSA private key and its label, with the key under "v_Data"
+// in the format SecKeyCopyExternalRepresentation returns.
+func PrivateKeyToPlist(label string, key crypto.PrivateKey) (map[string]interface{}, error) {
+	keyType, external, err := marshalExternalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var bits int
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		bits = key.N.BitLen()
+	case *ecdsa.PrivateKey:
+		bits = key.Curve.Params().BitSize
+	}
+	dict := map[string]interface{}{
+		plistClass:         plistClassKey,
+		plistKeyClass:      plistKeyClassPrivate,
+		plistKeyType:       plistKeyTypes[keyType],
+		plistKeySizeInBits: int64(bits),
+		plistValueData:     external,
+	}
+	if label != "" {
+		dict[plistLabel] = label
+	}
+	return dict, nil
+}
+
+// PrivateKeyFromPlist returns the label and private key described by a
+// dictionary in the form PrivateKeyToPlist returns.
+func PrivateKeyFromPlist(dict map[string]interface{}) (label string, key crypto.PrivateKey, err error) {
+	r := plistReader{dict: dict}
+	r.checkClass(plistClassKey)
+	label = r.string(plistLabel)
+	if keyClass := r.string(plistKeyClass); keyClass != plistKeyClassPrivate && r.err == nil {
+		r.err = fmt.Errorf("%s is %q, not a private key", plistKeyClass, keyClass)
+	}
+	typeName := r.string(plistKeyType)
+	external := r.data(plistValueData)
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	for keyType, name := range plistKeyTypes {
+		if name == typeName {
+			key, err = parseExternalPrivateKey(keyType, external)
+			return label, key, err
+		}
+	}
+	return "", nil, fmt.Errorf("%s has unknown value %q", plistKeyType, typeName)
+}
+
+// plistReader reads typed values from an item dictionary, keeping the
+// first error.
+type plistReader struct {
+	dict map[string]interface{}
+	err  error
+}
+
+func (r *plistReader) typeError(key string, v interface{}, expected string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%s is %T, not %s", key, v, expected)
+	}
+}
+
+func (r *plistReader) checkClass(class string) {
+	if actual := r.string(plistClass); actual != class && r.err == nil {
+		r.err = fmt.Errorf("%s is %q, not %q", plistClass, actual, class)
+	}
+}
+
+func (r *plistReader) string(key string) string {
+	v, ok := r.dict[key]
+	if !ok {
+		return ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		r.typeError(key, v, "a string")
+	}
+	return s
+}
+
+// data returns the data under key, which may also be a string as
+// that's how some tools write passwords.
+func (r *plistReader) data(key string) []byte {
+	switch v := r.dict[key].(type) {
+	case nil:
+		return nil
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	default:
+		r.typeError(key, v, "data")
+		return nil
+	}
+}
+
+func (r *plistReader) boolean(key string) bool {
+	v, ok := r.dict[key]
+	if !ok {
+		return false
+	}
+	b, ok := v.(bool)
+	if !ok {
+		r.typeError(key, v, "a boolean")
+	}
+	return b
+}
+
+func (r *plistReader) integer(key string) (int64, bool) {
+	v, ok := r.dict[key]
+	if !ok {
+		return 0, false
+	}
+	i, ok := v.(int64)
+	if !ok {
+		r.typeError(key, v, "an integer")
+	}
+	return i, ok
+}
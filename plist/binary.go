@@ -0,0 +1,479 @@
+package plist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf16"
+)
+
+const binaryMagic = "bplist00"
+
+// trailerSize is the size of the trailer at the end of a binary
+// property list, which locates its offset table and top object.
+const trailerSize = 32
+
+// maxBinaryValues bounds the values decoded from a binary property
+// list, in which objects may be referenced any number of times.
+const maxBinaryValues = 1 << 20
+
+// Object markers, in the high nibble of an object's first byte.
+const (
+	markerSimple  = 0x0
+	markerInt     = 0x1
+	markerReal    = 0x2
+	markerDate    = 0x3
+	markerData    = 0x4
+	markerASCII   = 0x5
+	markerUTF16   = 0x6
+	markerUID     = 0x8
+	markerArray   = 0xA
+	markerSet     = 0xC
+	markerDict    = 0xD
+	simpleFalse   = 0x08
+	simpleTrue    = 0x09
+	longLengthLow = 0xF
+)
+
+// binaryEncoder flattens a value into the object table of a binary
+// property list. Equal strings are written once.
+type binaryEncoder struct {
+	objects [][]byte
+	strings map[string]uint64
+	// refs holds the references of each object's children, which are
+	// only written once the number of objects, and so the size of a
+	// reference, is known.
+	refs [][]uint64
+}
+
+func marshalBinary(v interface{}) ([]byte, error) {
+	e := &binaryEncoder{strings: map[string]uint64{}}
+	top, err := e.add(v)
+	if err != nil {
+		return nil, err
+	}
+
+	refSize := minBytes(uint64(len(e.objects)))
+	var buf bytes.Buffer
+	buf.WriteString(binaryMagic)
+	offsets := make([]uint64, len(e.objects))
+	for i, object := range e.objects {
+		offsets[i] = uint64(buf.Len())
+		buf.Write(object)
+		for _, ref := range e.refs[i] {
+			writeSized(&buf, ref, refSize)
+		}
+	}
+
+	offsetTableOffset := uint64(buf.Len())
+	offsetSize := minBytes(offsetTableOffset)
+	for _, offset := range offsets {
+		writeSized(&buf, offset, offsetSize)
+	}
+
+	var trailer [trailerSize]byte
+	trailer[6] = byte(offsetSize)
+	trailer[7] = byte(refSize)
+	binary.BigEndian.PutUint64(trailer[8:], uint64(len(e.objects)))
+	binary.BigEndian.PutUint64(trailer[16:], top)
+	binary.BigEndian.PutUint64(trailer[24:], offsetTableOffset)
+	buf.Write(trailer[:])
+	return buf.Bytes(), nil
+}
+
+// add appends v, and then its children, to the object table, and
+// returns its reference.
+func (e *binaryEncoder) add(v interface{}) (uint64, error) {
+	if s, ok := v.(string); ok {
+		if ref, ok := e.strings[s]; ok {
+			return ref, nil
+		}
+	}
+
+	ref := uint64(len(e.objects))
+	e.objects = append(e.objects, nil)
+	e.refs = append(e.refs, nil)
+
+	var object bytes.Buffer
+	var children []interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		writeMarker(&object, markerDict, uint64(len(v)))
+		keys := sortedKeys(v)
+		for _, key := range keys {
+			children = append(children, key)
+		}
+		for _, key := range keys {
+			children = append(children, v[key])
+		}
+	case []interface{}:
+		writeMarker(&object, markerArray, uint64(len(v)))
+		children = v
+	case string:
+		e.strings[v] = ref
+		if isASCII(v) {
+			writeMarker(&object, markerASCII, uint64(len(v)))
+			object.WriteString(v)
+		} else {
+			units := utf16.Encode([]rune(v))
+			writeMarker(&object, markerUTF16, uint64(len(units)))
+			for _, unit := range units {
+				binary.Write(&object, binary.BigEndian, unit)
+			}
+		}
+	case []byte:
+		writeMarker(&object, markerData, uint64(len(v)))
+		object.Write(v)
+	case time.Time:
+		object.WriteByte(markerDate<<4 | 3)
+		seconds := float64(v.Unix()-referenceDate.Unix()) + float64(v.Nanosecond())/1e9
+		binary.Write(&object, binary.BigEndian, seconds)
+	case int64:
+		writeInt(&object, v)
+	case uint64:
+		// Integers above math.MaxInt64 take 16 bytes.
+		object.WriteByte(markerInt<<4 | 4)
+		object.Write(make([]byte, 8))
+		binary.Write(&object, binary.BigEndian, v)
+	case float64:
+		object.WriteByte(markerReal<<4 | 3)
+		binary.Write(&object, binary.BigEndian, v)
+	case bool:
+		if v {
+			object.WriteByte(simpleTrue)
+		} else {
+			object.WriteByte(simpleFalse)
+		}
+	default:
+		return 0, fmt.Errorf("plist: %T has no property list equivalent", v)
+	}
+	e.objects[ref] = object.Bytes()
+
+	refs := make([]uint64, len(children))
+	for i, child := range children {
+		childRef, err := e.add(child)
+		if err != nil {
+			return 0, err
+		}
+		refs[i] = childRef
+	}
+	e.refs[ref] = refs
+	return ref, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// writeMarker writes an object marker with the given length, which
+// follows as an integer object if it doesn't fit in the low nibble.
+func writeMarker(buf *bytes.Buffer, marker byte, length uint64) {
+	if length < longLengthLow {
+		buf.WriteByte(marker<<4 | byte(length))
+		return
+	}
+	buf.WriteByte(marker<<4 | longLengthLow)
+	writeInt(buf, int64(length))
+}
+
+// writeInt writes an integer object in as few bytes as possible.
+// Negative integers always take 8 bytes.
+func writeInt(buf *bytes.Buffer, i int64) {
+	size := 8
+	if i >= 0 {
+		size = minBytes(uint64(i))
+		if size == 3 {
+			size = 4
+		} else if size > 4 {
+			size = 8
+		}
+	}
+	var sizeLog byte
+	for 1<<sizeLog < size {
+		sizeLog++
+	}
+	buf.WriteByte(markerInt<<4 | sizeLog)
+	writeSized(buf, uint64(i), size)
+}
+
+// minBytes returns the number of bytes needed for n, at least 1.
+func minBytes(n uint64) int {
+	size := 1
+	for n >>= 8; n != 0; n >>= 8 {
+		size++
+	}
+	return size
+}
+
+func writeSized(buf *bytes.Buffer, n uint64, size int) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	buf.Write(b[8-size:])
+}
+
+func readSized(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+// binaryDecoder decodes the objects of a binary property list.
+type binaryDecoder struct {
+	data       []byte
+	offsets    []uint64
+	refSize    int
+	decoding   []bool
+	numDecoded int
+}
+
+var errBinaryTruncated = errors.New("plist: binary property list is truncated")
+
+func unmarshalBinary(data []byte) (interface{}, error) {
+	if len(data) < len(binaryMagic)+trailerSize {
+		return nil, errBinaryTruncated
+	}
+	trailer := data[len(data)-trailerSize:]
+	offsetSize := int(trailer[6])
+	refSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:])
+	top := binary.BigEndian.Uint64(trailer[16:])
+	offsetTableOffset := binary.BigEndian.Uint64(trailer[24:])
+
+	if offsetSize < 1 || offsetSize > 8 || refSize < 1 || refSize > 8 {
+		return nil, errors.New("plist: binary property list has invalid integer sizes")
+	}
+	tableEnd := uint64(len(data) - trailerSize)
+	if numObjects == 0 || top >= numObjects || offsetTableOffset < uint64(len(binaryMagic)) ||
+		offsetTableOffset > tableEnd || numObjects > (tableEnd-offsetTableOffset)/uint64(offsetSize) {
+		return nil, errors.New("plist: binary property list has an invalid offset table")
+	}
+
+	d := &binaryDecoder{
+		data:     data[:offsetTableOffset],
+		offsets:  make([]uint64, numObjects),
+		refSize:  refSize,
+		decoding: make([]bool, numObjects),
+	}
+	for i := range d.offsets {
+		start := offsetTableOffset + uint64(i*offsetSize)
+		d.offsets[i] = readSized(data[start : start+uint64(offsetSize)])
+		if d.offsets[i] < uint64(len(binaryMagic)) || d.offsets[i] >= offsetTableOffset {
+			return nil, fmt.Errorf("plist: object %d has an invalid offset", i)
+		}
+	}
+	return d.decode(top)
+}
+
+// decode returns the value of the object with the given reference.
+func (d *binaryDecoder) decode(ref uint64) (interface{}, error) {
+	if ref >= uint64(len(d.offsets)) {
+		return nil, fmt.Errorf("plist: reference %d is out of range", ref)
+	}
+	if d.decoding[ref] {
+		return nil, errors.New("plist: binary property list contains a cycle")
+	}
+	if d.numDecoded++; d.numDecoded > maxBinaryValues {
+		return nil, errors.New("plist: binary property list has too many values")
+	}
+	d.decoding[ref] = true
+	defer func() { d.decoding[ref] = false }()
+
+	offset := d.offsets[ref]
+	marker := d.data[offset]
+	low := marker & 0xF
+	switch marker >> 4 {
+	case markerSimple:
+		switch marker {
+		case simpleFalse:
+			return false, nil
+		case simpleTrue:
+			return true, nil
+		}
+		return nil, fmt.Errorf("plist: unsupported object marker %#x", marker)
+	case markerInt:
+		b, err := d.read(offset+1, 1<<low)
+		if err != nil {
+			return nil, err
+		}
+		return decodeInt(b)
+	case markerReal:
+		b, err := d.read(offset+1, 1<<low)
+		if err != nil {
+			return nil, err
+		}
+		return decodeReal(b)
+	case markerDate:
+		if low != 3 {
+			return nil, fmt.Errorf("plist: unsupported object marker %#x", marker)
+		}
+		b, err := d.read(offset+1, 8)
+		if err != nil {
+			return nil, err
+		}
+		seconds := math.Float64frombits(binary.BigEndian.Uint64(b))
+		if !(math.Abs(seconds) < 1<<53) {
+			return nil, errors.New("plist: date is out of range")
+		}
+		whole := math.Floor(seconds)
+		return time.Unix(referenceDate.Unix()+int64(whole), int64((seconds-whole)*1e9)).UTC(), nil
+	case markerData, markerASCII:
+		start, length, err := d.readLength(offset)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.read(start, length)
+		if err != nil {
+			return nil, err
+		}
+		if marker>>4 == markerASCII {
+			return string(b), nil
+		}
+		return append([]byte{}, b...), nil
+	case markerUTF16:
+		start, length, err := d.readLength(offset)
+		if err != nil {
+			return nil, err
+		}
+		if length > uint64(len(d.data)) {
+			return nil, errBinaryTruncated
+		}
+		b, err := d.read(start, 2*length)
+		if err != nil {
+			return nil, err
+		}
+		units := make([]uint16, length)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(b[2*i:])
+		}
+		return string(utf16.Decode(units)), nil
+	case markerArray:
+		refs, err := d.readRefs(offset, 1)
+		if err != nil {
+			return nil, err
+		}
+		a := make([]interface{}, len(refs))
+		for i, ref := range refs {
+			if a[i], err = d.decode(ref); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	case markerDict:
+		refs, err := d.readRefs(offset, 2)
+		if err != nil {
+			return nil, err
+		}
+		n := len(refs) / 2
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := d.decode(refs[i])
+			if err != nil {
+				return nil, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("plist: dictionary key is %T, not a string", key)
+			}
+			if m[keyString], err = d.decode(refs[n+i]); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case markerUID:
+		return nil, errors.New("plist: UID values are not supported")
+	case markerSet:
+		return nil, errors.New("plist: set values are not supported")
+	}
+	return nil, fmt.Errorf("plist: unsupported object marker %#x", marker)
+}
+
+// read returns the size bytes at offset.
+func (d *binaryDecoder) read(offset, size uint64) ([]byte, error) {
+	if offset > uint64(len(d.data)) || size > uint64(len(d.data))-offset {
+		return nil, errBinaryTruncated
+	}
+	return d.data[offset : offset+size], nil
+}
+
+// readLength returns the length in the marker at offset, and the
+// offset of the contents that follow it.
+func (d *binaryDecoder) readLength(offset uint64) (start, length uint64, err error) {
+	marker := d.data[offset]
+	if marker&0xF != longLengthLow {
+		return offset + 1, uint64(marker & 0xF), nil
+	}
+	intMarker, err := d.read(offset+1, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if intMarker[0]>>4 != markerInt || intMarker[0]&0xF > 3 {
+		return 0, 0, errors.New("plist: invalid object length")
+	}
+	size := uint64(1) << (intMarker[0] & 0xF)
+	b, err := d.read(offset+2, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset + 2 + size, readSized(b), nil
+}
+
+// readRefs returns the references held by the array or dictionary at
+// offset, which has perEntry references per entry.
+func (d *binaryDecoder) readRefs(offset uint64, perEntry uint64) ([]uint64, error) {
+	start, length, err := d.readLength(offset)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(len(d.data)) {
+		return nil, errBinaryTruncated
+	}
+	count := length * perEntry
+	b, err := d.read(start, count*uint64(d.refSize))
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]uint64, count)
+	for i := range refs {
+		refs[i] = readSized(b[i*d.refSize : (i+1)*d.refSize])
+	}
+	return refs, nil
+}
+
+func decodeInt(b []byte) (interface{}, error) {
+	switch len(b) {
+	case 1, 2, 4:
+		return int64(readSized(b)), nil
+	case 8:
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case 16:
+		high, low := binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:])
+		if high == 0 {
+			return normalizeUint(low), nil
+		}
+		if high == math.MaxUint64 && int64(low) < 0 {
+			return int64(low), nil
+		}
+		return nil, errors.New("plist: integer is out of range")
+	}
+	return nil, fmt.Errorf("plist: unsupported integer size %d", len(b))
+}
+
+func decodeReal(b []byte) (interface{}, error) {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, fmt.Errorf("plist: unsupported real size %d", len(b))
+}
@@ -0,0 +1,175 @@
+// Package plist encodes and decodes Apple property lists, in both the
+// XML and the binary ("bplist00") formats.
+//
+// Property list values are represented by these Go types:
+//
+//	dictionary  map[string]interface{}
+//	array       []interface{}
+//	string      string
+//	data        []byte
+//	date        time.Time
+//	integer     int64, or uint64 for values above math.MaxInt64
+//	real        float64
+//	boolean     bool
+//
+// Marshal also takes the other integer types, float32, and slices and
+// maps of specific value types such as []string and map[string]string.
+package plist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Format is an encoding of property lists.
+type Format int
+
+const (
+	XMLFormat Format = iota + 1
+	BinaryFormat
+)
+
+func (format Format) String() string {
+	switch format {
+	case XMLFormat:
+		return "XML"
+	case BinaryFormat:
+		return "binary"
+	}
+	return fmt.Sprintf("Format(%d)", int(format))
+}
+
+// referenceDate is the epoch of property list dates.
+var referenceDate = time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// maxDepth bounds the nesting of decoded values.
+const maxDepth = 512
+
+// Marshal encodes v, which must be made of the types listed in the
+// package documentation, in the given format.
+func Marshal(v interface{}, format Format) ([]byte, error) {
+	value, err := normalize(v, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case XMLFormat:
+		return marshalXML(value)
+	case BinaryFormat:
+		return marshalBinary(value)
+	}
+	return nil, fmt.Errorf("plist: unknown format %v", format)
+}
+
+// Unmarshal decodes a property list in either format, and returns its
+// value and the format it was in.
+func Unmarshal(data []byte) (interface{}, Format, error) {
+	if bytes.HasPrefix(data, []byte(binaryMagic)) {
+		v, err := unmarshalBinary(data)
+		return v, BinaryFormat, err
+	}
+	v, err := unmarshalXML(data)
+	return v, XMLFormat, err
+}
+
+// normalize converts v to the types Unmarshal returns, or returns an
+// error if it holds a type that has no property list equivalent.
+func normalize(v interface{}, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("plist: value is nested too deeply")
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			normalized, err := normalize(value, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = normalized
+		}
+		return m, nil
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = value
+		}
+		return m, nil
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, value := range v {
+			normalized, err := normalize(value, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = normalized
+		}
+		return a, nil
+	case []string:
+		a := make([]interface{}, len(v))
+		for i, value := range v {
+			a[i] = value
+		}
+		return a, nil
+	case [][]byte:
+		a := make([]interface{}, len(v))
+		for i, value := range v {
+			a[i] = value
+		}
+		return a, nil
+	case string, bool, float64, time.Time:
+		return v, nil
+	case []byte:
+		if v == nil {
+			return []byte{}, nil
+		}
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		return normalizeUint(uint64(v)), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		return normalizeUint(v), nil
+	case nil:
+		return nil, errors.New("plist: nil has no property list equivalent")
+	}
+	return nil, fmt.Errorf("plist: %T has no property list equivalent", v)
+}
+
+func normalizeUint(v uint64) interface{} {
+	if v > math.MaxInt64 {
+		return v
+	}
+	return int64(v)
+}
+
+// sortedKeys returns the keys of m in order, so that encodings are
+// deterministic.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,258 @@
+package plist_test
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-osxkeychain/plist"
+)
+
+// The fixtures in testdata were written by Python's plistlib, whose
+// output matches CFPropertyList's.
+var fixtureValue = map[string]interface{}{
+	"string":   "hello <world> & more",
+	"unicode":  "café ☃ \U0001F511",
+	"data":     []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19},
+	"date":     time.Date(2019, time.October, 7, 12, 30, 45, 0, time.UTC),
+	"integer":  int64(42),
+	"negative": int64(-7),
+	"big":      int64(1 << 40),
+	"huge":     uint64(math.MaxUint64),
+	"real":     3.25,
+	"true":     true,
+	"false":    false,
+	"array":    []interface{}{"a", int64(1), []interface{}{}, map[string]interface{}{}},
+	"dict":     map[string]interface{}{"nested": map[string]interface{}{"deeper": "yes"}},
+	"empty":    "",
+	"long":     strings.Repeat("x", 40),
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestUnmarshalFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		format  plist.Format
+	}{
+		{"values.plist", plist.XMLFormat},
+		{"values.bplist", plist.BinaryFormat},
+	}
+	for _, test := range tests {
+		v, format, err := plist.Unmarshal(readFixture(t, test.fixture))
+		if err != nil {
+			t.Errorf("%s: %v", test.fixture, err)
+			continue
+		}
+		if format != test.format {
+			t.Errorf("%s: Expected %v format, got %v", test.fixture, test.format, format)
+		}
+		if !reflect.DeepEqual(v, fixtureValue) {
+			t.Errorf("%s: Expected %v, got %v", test.fixture, fixtureValue, v)
+		}
+	}
+}
+
+func TestMarshalXMLMatchesFixture(t *testing.T) {
+	data, err := plist.Marshal(fixtureValue, plist.XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := readFixture(t, "values.plist"); !bytes.Equal(data, expected) {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	many := make([]interface{}, 300)
+	for i := range many {
+		many[i] = int64(i * 1000)
+	}
+	values := []interface{}{
+		fixtureValue,
+		"",
+		"line\r\nbreaks\tand tabs",
+		strings.Repeat("é", 100),
+		bytes.Repeat([]byte{0xff}, 1000),
+		[]byte{},
+		int64(math.MinInt64),
+		int64(math.MaxInt64),
+		int64(255),
+		int64(256),
+		int64(1 << 24),
+		uint64(1 << 63),
+		0.1,
+		-1e300,
+		math.Inf(1),
+		math.Inf(-1),
+		time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2400, time.December, 31, 23, 59, 59, 0, time.UTC),
+		many,
+		map[string]interface{}{"": "empty key", "same": "same", "also same": "same"},
+	}
+	for _, format := range []plist.Format{plist.XMLFormat, plist.BinaryFormat} {
+		for _, value := range values {
+			data, err := plist.Marshal(value, format)
+			if err != nil {
+				t.Errorf("%v: %v", format, err)
+				continue
+			}
+			decoded, decodedFormat, err := plist.Unmarshal(data)
+			if err != nil {
+				t.Errorf("%v: %v", format, err)
+				continue
+			}
+			if decodedFormat != format {
+				t.Errorf("Expected %v format, got %v", format, decodedFormat)
+			}
+			if !reflect.DeepEqual(decoded, value) {
+				t.Errorf("%v: Expected %v, got %v", format, value, decoded)
+			}
+		}
+	}
+}
+
+func TestBinaryDatesKeepFractionalSeconds(t *testing.T) {
+	date := time.Date(2021, time.March, 4, 5, 6, 7, 250000000, time.UTC)
+	data, err := plist.Marshal(date, plist.BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := plist.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.(time.Time).Equal(date) {
+		t.Errorf("Expected %v, got %v", date, decoded)
+	}
+}
+
+func TestMarshalNormalizes(t *testing.T) {
+	value := map[string]interface{}{
+		"strings": []string{"a", "b"},
+		"map":     map[string]string{"k": "v"},
+		"int":     7,
+		"uint64":  uint64(7),
+		"float32": float32(0.5),
+	}
+	expected := map[string]interface{}{
+		"strings": []interface{}{"a", "b"},
+		"map":     map[string]interface{}{"k": "v"},
+		"int":     int64(7),
+		"uint64":  int64(7),
+		"float32": 0.5,
+	}
+	for _, format := range []plist.Format{plist.XMLFormat, plist.BinaryFormat} {
+		data, err := plist.Marshal(value, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, _, err := plist.Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("%v: Expected %v, got %v", format, expected, decoded)
+		}
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		format   plist.Format
+		expected string
+	}{
+		{nil, plist.XMLFormat, "plist: nil has no property list equivalent"},
+		{struct{}{}, plist.BinaryFormat, "plist: struct {} has no property list equivalent"},
+		{[]interface{}{"ok", nil}, plist.BinaryFormat, "plist: nil has no property list equivalent"},
+		{"bell\a", plist.XMLFormat, "plist: string has character U+0007, which XML can't represent"},
+		{"fine", plist.Format(3), "plist: unknown format Format(3)"},
+	}
+	for _, test := range tests {
+		if _, err := plist.Marshal(test.value, test.format); err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+	// Binary property lists can hold any string.
+	if _, err := plist.Marshal("bell\a", plist.BinaryFormat); err != nil {
+		t.Error(err)
+	}
+}
+
+// binaryPlist returns a binary property list of the given objects,
+// with one-byte offsets and references, whose top object is the
+// first.
+func binaryPlist(objects ...[]byte) []byte {
+	data := []byte("bplist00")
+	var offsets []byte
+	for _, object := range objects {
+		offsets = append(offsets, byte(len(data)))
+		data = append(data, object...)
+	}
+	offsetTableOffset := len(data)
+	data = append(data, offsets...)
+	trailer := make([]byte, 32)
+	trailer[6], trailer[7] = 1, 1
+	trailer[15] = byte(len(objects))
+	trailer[31] = byte(offsetTableOffset)
+	return append(data, trailer...)
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{binaryPlist([]byte{0x51, 'a'}), ""},
+		{[]byte("bplist00"), "plist: binary property list is truncated"},
+		{binaryPlist([]byte{0xA1, 0}), "plist: binary property list contains a cycle"},
+		{binaryPlist([]byte{0xA1, 1}), "plist: reference 1 is out of range"},
+		{binaryPlist([]byte{0x5F, 0x10, 100}), "plist: binary property list is truncated"},
+		{binaryPlist([]byte{0xD1, 1, 1}, []byte{0x10, 1}), "plist: dictionary key is int64, not a string"},
+		{binaryPlist([]byte{0x80, 1}), "plist: UID values are not supported"},
+		{binaryPlist([]byte{0x00}), "plist: unsupported object marker 0x0"},
+		{[]byte("<plist><dict><key>a</key></dict></plist>"), "plist: <key>a</key> has no value"},
+		{[]byte("<plist><dict><string>a</string></dict></plist>"), "plist: expected <key> in <dict>, got <string>"},
+		{[]byte("<plist><integer>x</integer></plist>"), "plist: invalid <integer> \"x\""},
+		{[]byte("<plist><color/></plist>"), "plist: unknown element <color>"},
+		{[]byte("<plist></plist>"), "plist: unexpected </plist>"},
+		{[]byte(""), "plist: no value in XML property list"},
+	}
+	for _, test := range tests {
+		_, _, err := plist.Unmarshal(test.data)
+		if test.expected == "" {
+			if err != nil {
+				t.Errorf("%q: %v", test.data, err)
+			}
+		} else if err == nil || err.Error() != test.expected {
+			t.Errorf("%q: Expected \"%s\", got %v", test.data, test.expected, err)
+		}
+	}
+}
+
+func TestUnmarshalSharedObjects(t *testing.T) {
+	// An array holding the same array twice, nested 30 deep, would
+	// expand to a billion values.
+	objects := [][]byte{}
+	for i := 0; i < 30; i++ {
+		objects = append(objects, []byte{0xA2, byte(i + 1), byte(i + 1)})
+	}
+	objects = append(objects, []byte{0x09})
+	_, _, err := plist.Unmarshal(binaryPlist(objects...))
+	expected := "plist: binary property list has too many values"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
@@ -0,0 +1,313 @@
+package plist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+// xmlDateFormat is the format of dates in XML property lists, which
+// are always in UTC and have no fractional seconds.
+const xmlDateFormat = "2006-01-02T15:04:05Z"
+
+// marshalXML encodes a normalized value the way CFPropertyList does,
+// indented with tabs.
+func marshalXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xmlHeader)
+	if err := writeXMLValue(&buf, v, 0); err != nil {
+		return nil, err
+	}
+	buf.WriteString("</plist>\n")
+	return buf.Bytes(), nil
+}
+
+func writeXMLValue(buf *bytes.Buffer, v interface{}, depth int) error {
+	indent := strings.Repeat("\t", depth)
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			buf.WriteString(indent + "<dict/>\n")
+			return nil
+		}
+		buf.WriteString(indent + "<dict>\n")
+		for _, key := range sortedKeys(v) {
+			if err := writeXMLElement(buf, depth+1, "key", key); err != nil {
+				return err
+			}
+			if err := writeXMLValue(buf, v[key], depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(indent + "</dict>\n")
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString(indent + "<array/>\n")
+			return nil
+		}
+		buf.WriteString(indent + "<array>\n")
+		for _, value := range v {
+			if err := writeXMLValue(buf, value, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(indent + "</array>\n")
+	case string:
+		return writeXMLElement(buf, depth, "string", v)
+	case []byte:
+		// Like CFPropertyList, wrap data into indented lines that fit in
+		// 76 columns, counting tabs as 8.
+		width := 76 - 8*depth
+		if width < 16 {
+			width = 16
+		}
+		lineSize := width / 4 * 3
+		buf.WriteString(indent + "<data>\n")
+		for len(v) > 0 {
+			n := lineSize
+			if n > len(v) {
+				n = len(v)
+			}
+			buf.WriteString(indent + base64.StdEncoding.EncodeToString(v[:n]) + "\n")
+			v = v[n:]
+		}
+		buf.WriteString(indent + "</data>\n")
+	case time.Time:
+		buf.WriteString(indent + "<date>" + v.UTC().Format(xmlDateFormat) + "</date>\n")
+	case int64:
+		buf.WriteString(indent + "<integer>" + strconv.FormatInt(v, 10) + "</integer>\n")
+	case uint64:
+		buf.WriteString(indent + "<integer>" + strconv.FormatUint(v, 10) + "</integer>\n")
+	case float64:
+		buf.WriteString(indent + "<real>" + formatXMLReal(v) + "</real>\n")
+	case bool:
+		if v {
+			buf.WriteString(indent + "<true/>\n")
+		} else {
+			buf.WriteString(indent + "<false/>\n")
+		}
+	default:
+		return fmt.Errorf("plist: %T has no property list equivalent", v)
+	}
+	return nil
+}
+
+// writeXMLElement writes an element holding text, which must only
+// have characters XML can represent.
+func writeXMLElement(buf *bytes.Buffer, depth int, name, text string) error {
+	for _, r := range text {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0xFFFE || r == 0xFFFF {
+			return fmt.Errorf("plist: %s has character %U, which XML can't represent", name, r)
+		}
+	}
+	buf.WriteString(strings.Repeat("\t", depth) + "<" + name + ">")
+	xmlEscaper.WriteString(buf, text)
+	buf.WriteString("</" + name + ">\n")
+	return nil
+}
+
+// xmlEscaper escapes text as CFPropertyList does, along with carriage
+// returns, which XML parsers would otherwise turn into newlines.
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#13;")
+
+func formatXMLReal(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+infinity"
+	case math.IsInf(f, -1):
+		return "-infinity"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func unmarshalXML(data []byte) (interface{}, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	start, err := nextXMLStart(d)
+	if err != nil {
+		return nil, err
+	}
+	if start.Name.Local == "plist" {
+		if start, err = nextXMLStart(d); err != nil {
+			return nil, err
+		}
+	}
+	return readXMLValue(d, start, 0)
+}
+
+// nextXMLStart returns the next start element, skipping the prolog,
+// comments and whitespace.
+func nextXMLStart(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			return xml.StartElement{}, errors.New("plist: no value in XML property list")
+		} else if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			return token, nil
+		case xml.EndElement:
+			return xml.StartElement{}, fmt.Errorf("plist: unexpected </%s>", token.Name.Local)
+		case xml.CharData:
+			if len(bytes.TrimSpace(token)) != 0 {
+				return xml.StartElement{}, errors.New("plist: unexpected text outside of an element")
+			}
+		}
+	}
+}
+
+// readXMLText returns the text of the element just started, which
+// may not have children.
+func readXMLText(d *xml.Decoder, start xml.StartElement) (string, error) {
+	var text strings.Builder
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return "", err
+		}
+		switch token := token.(type) {
+		case xml.CharData:
+			text.Write(token)
+		case xml.EndElement:
+			return text.String(), nil
+		case xml.StartElement:
+			return "", fmt.Errorf("plist: unexpected <%s> in <%s>", token.Name.Local, start.Name.Local)
+		}
+	}
+}
+
+// readXMLChildren calls fn with each child element of the element
+// just started, then consumes its end.
+func readXMLChildren(d *xml.Decoder, fn func(start xml.StartElement) error) error {
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if err := fn(token); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		case xml.CharData:
+			if len(bytes.TrimSpace(token)) != 0 {
+				return errors.New("plist: unexpected text between elements")
+			}
+		}
+	}
+}
+
+func readXMLValue(d *xml.Decoder, start xml.StartElement, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("plist: value is nested too deeply")
+	}
+	switch start.Name.Local {
+	case "dict":
+		m := map[string]interface{}{}
+		var key *string
+		err := readXMLChildren(d, func(start xml.StartElement) error {
+			if key == nil {
+				if start.Name.Local != "key" {
+					return fmt.Errorf("plist: expected <key> in <dict>, got <%s>", start.Name.Local)
+				}
+				text, err := readXMLText(d, start)
+				if err != nil {
+					return err
+				}
+				key = &text
+				return nil
+			}
+			value, err := readXMLValue(d, start, depth+1)
+			if err != nil {
+				return err
+			}
+			m[*key] = value
+			key = nil
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			return nil, fmt.Errorf("plist: <key>%s</key> has no value", *key)
+		}
+		return m, nil
+	case "array":
+		a := []interface{}{}
+		err := readXMLChildren(d, func(start xml.StartElement) error {
+			value, err := readXMLValue(d, start, depth+1)
+			if err != nil {
+				return err
+			}
+			a = append(a, value)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return a, nil
+	case "true", "false":
+		if text, err := readXMLText(d, start); err != nil {
+			return nil, err
+		} else if strings.TrimSpace(text) != "" {
+			return nil, fmt.Errorf("plist: unexpected text in <%s>", start.Name.Local)
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	text, err := readXMLText(d, start)
+	if err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "string":
+		return text, nil
+	case "data":
+		// Data is usually wrapped over several lines.
+		b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			return nil, fmt.Errorf("plist: invalid <data>: %v", err)
+		}
+		return b, nil
+	case "date":
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("plist: invalid <date>: %v", err)
+		}
+		return t.UTC(), nil
+	case "integer":
+		text = strings.TrimSpace(text)
+		if i, err := strconv.ParseInt(text, 0, 64); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(text, 0, 64); err == nil {
+			return u, nil
+		}
+		return nil, fmt.Errorf("plist: invalid <integer> %q", text)
+	case "real":
+		text = strings.TrimSpace(text)
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("plist: invalid <real> %q", text)
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("plist: unknown element <%s>", start.Name.Local)
+}
@@ -0,0 +1,188 @@
+package osxkeychain_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/plist"
+)
+
+// throughPlist marshals dict in the given format and unmarshals it
+// again, as when items are written to a file and read back.
+func throughPlist(t *testing.T, dict map[string]interface{}, format plist.Format) map[string]interface{} {
+	data, err := plist.Marshal(dict, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _, err := plist.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.(map[string]interface{})
+}
+
+func TestGenericPasswordPlist(t *testing.T) {
+	tests := []osxkeychain.GenericPasswordAttributes{
+		{ServiceName: "svc", AccountName: "acct", Password: []byte("secret")},
+		{ServiceName: "svc", AccountName: "acct", TrustedApplications: []string{"/usr/bin/git", "/usr/bin/ssh"}},
+		{
+			ServiceName:     "svc",
+			Password:        []byte{0, 0xff},
+			AccessControl:   osxkeychain.AccessControlUserPresence,
+			Accessible:      osxkeychain.AccessibleWhenUnlockedThisDeviceOnly,
+			OperationPrompt: "Unlock the token",
+			AccessGroup:     "TEAMID.com.example.shared",
+		},
+		{ServiceName: "svc", UseDataProtectionKeychain: true, Synchronizable: osxkeychain.SynchronizableYes},
+		{ServiceName: "svc", Synchronizable: osxkeychain.SynchronizableNo},
+		{ServiceName: "svc", Synchronizable: osxkeychain.SynchronizableAny},
+	}
+	for _, attributes := range tests {
+		dict, err := osxkeychain.GenericPasswordToPlist(&attributes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, format := range []plist.Format{plist.XMLFormat, plist.BinaryFormat} {
+			decoded, err := osxkeychain.GenericPasswordFromPlist(throughPlist(t, dict, format))
+			if err != nil {
+				t.Errorf("%v: %v", format, err)
+				continue
+			}
+			if !reflect.DeepEqual(*decoded, attributes) {
+				t.Errorf("%v: Expected %v, got %v", format, attributes, *decoded)
+			}
+		}
+	}
+
+	dict, _ := osxkeychain.GenericPasswordToPlist(&tests[2])
+	expected := map[string]interface{}{
+		"class":      "genp",
+		"svce":       "svc",
+		"acct":       "",
+		"v_Data":     []byte{0, 0xff},
+		"accc":       int64(osxkeychain.AccessControlUserPresence),
+		"pdmn":       "aku",
+		"u_OpPrompt": "Unlock the token",
+		"agrp":       "TEAMID.com.example.shared",
+	}
+	if !reflect.DeepEqual(dict, expected) {
+		t.Errorf("Expected %v, got %v", expected, dict)
+	}
+}
+
+func TestGenericPasswordFromKeychainDump(t *testing.T) {
+	// As found in a keychain, with attributes this package doesn't
+	// use and the password as a string.
+	dict := map[string]interface{}{
+		"class":  "genp",
+		"svce":   "svc",
+		"acct":   "acct",
+		"v_Data": "secret",
+		"sync":   int64(0),
+		"cdat":   time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		"labl":   "svc",
+	}
+	attributes, err := osxkeychain.GenericPasswordFromPlist(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := osxkeychain.GenericPasswordAttributes{
+		ServiceName:    "svc",
+		AccountName:    "acct",
+		Password:       []byte("secret"),
+		Synchronizable: osxkeychain.SynchronizableNo,
+	}
+	if !reflect.DeepEqual(*attributes, expected) {
+		t.Errorf("Expected %v, got %v", expected, *attributes)
+	}
+}
+
+func TestGenericPasswordFromPlistErrors(t *testing.T) {
+	tests := []struct {
+		dict     map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"svce": "svc"}, `class is "", not "genp"`},
+		{map[string]interface{}{"class": "cert"}, `class is "cert", not "genp"`},
+		{map[string]interface{}{"class": "genp", "svce": int64(1)}, "svce is int64, not a string"},
+		{map[string]interface{}{"class": "genp", "v_Data": true}, "v_Data is bool, not data"},
+		{map[string]interface{}{"class": "genp", "acls": []interface{}{int64(1)}}, "acls is int64, not an array of strings"},
+		{map[string]interface{}{"class": "genp", "pdmn": "dk"}, `pdmn has unknown value "dk"`},
+		{map[string]interface{}{"class": "genp", "nleg": "yes"}, "nleg is string, not a boolean"},
+		{map[string]interface{}{"class": "genp", "sync": "maybe"}, `sync has unknown value "maybe"`},
+		{
+			map[string]interface{}{"class": "genp", "agrp": "group", "acls": []interface{}{"/usr/bin/git"}},
+			"UseDataProtectionKeychain and AccessGroup can't be combined with TrustedApplications",
+		},
+	}
+	for _, test := range tests {
+		if _, err := osxkeychain.GenericPasswordFromPlist(test.dict); err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+}
+
+func TestCertificatePlist(t *testing.T) {
+	cert, _ := newTestCertificate(t, "plist.example.com", 42, nil, nil)
+	item := &osxkeychain.CertificateItem{Label: "Example", Certificate: cert}
+	dict := osxkeychain.CertificateToPlist(item)
+	if !reflect.DeepEqual(dict["subj"], cert.RawSubject) || !reflect.DeepEqual(dict["slnr"], []byte{42}) {
+		t.Errorf("Expected the subject and serial number, got %v", dict)
+	}
+	decoded, err := osxkeychain.CertificateFromPlist(throughPlist(t, dict, plist.BinaryFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Label != "Example" || !decoded.Certificate.Equal(cert) {
+		t.Errorf("Expected %v, got %v", item, decoded)
+	}
+
+	expected := "v_Data is missing"
+	if _, err := osxkeychain.CertificateFromPlist(map[string]interface{}{"class": "cert"}); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
+
+func TestPrivateKeyPlist(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ecdsaKey := newTestCertificate(t, "key", 1, nil, nil)
+
+	tests := []struct {
+		key     crypto.Signer
+		keyType string
+		bits    int64
+		name    string
+	}{
+		{rsaKey, "42", 2048, "RSA"},
+		{ecdsaKey, "73", 256, "ECDSA"},
+	}
+	for _, test := range tests {
+		dict, err := osxkeychain.PrivateKeyToPlist("My key", test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dict["type"] != test.keyType || dict["bsiz"] != test.bits || dict["kcls"] != "1" {
+			t.Errorf("%s: Expected type %s of %d bits, got %v", test.name, test.keyType, test.bits, dict)
+		}
+		label, key, err := osxkeychain.PrivateKeyFromPlist(throughPlist(t, dict, plist.XMLFormat))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if label != "My key" || !test.key.(interface{ Equal(crypto.PrivateKey) bool }).Equal(key) {
+			t.Errorf("%s: key doesn't round-trip", test.name)
+		}
+	}
+
+	expected := `kcls is "0", not a private key`
+	if _, _, err := osxkeychain.PrivateKeyFromPlist(map[string]interface{}{"class": "keys", "kcls": "0"}); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
@@ -0,0 +1,135 @@
+package osxkeychain
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// ReferenceScheme is the URI scheme of references to items in a
+// keychain.
+const ReferenceScheme = "keychain"
+
+// Fields that a Reference can select.
+const (
+	FieldPassword = "password"
+)
+
+// Reference identifies a secret kept in a Store, without containing
+// the secret itself. Its URI form is
+//
+//	keychain://<service>/<account>?field=password&keychain=/path
+//
+// where <service> and <account> are percent-encoded, so that they can
+// contain '/' and any other character. The query parameters are
+// optional: field defaults to "password", and keychain selects a
+// keychain other than the default one. Other schemes name other
+// Stores; see Resolver.
+type Reference struct {
+	Scheme      string
+	ServiceName string
+	AccountName string
+	Field       string
+	Keychain    string
+}
+
+func validScheme(scheme string) bool {
+	if scheme == "" {
+		return false
+	}
+	for i, c := range scheme {
+		switch {
+		case 'a' <= c && c <= 'z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ParseReference parses a reference URI, such as
+// "keychain://service/account". Like the package-level functions, it
+// returns an error if the service or account name is invalid.
+func ParseReference(uri string) (*Reference, error) {
+	i := strings.Index(uri, "://")
+	if i < 0 {
+		return nil, errors.New("reference " + uri + " is not of the form scheme://service/account")
+	}
+	ref := Reference{Scheme: uri[:i], Field: FieldPassword}
+	if !validScheme(ref.Scheme) {
+		return nil, errors.New("reference " + uri + " has an invalid scheme")
+	}
+
+	rest := uri[i+len("://"):]
+	var rawQuery string
+	if i := strings.IndexByte(rest, '?'); i >= 0 {
+		rest, rawQuery = rest[:i], rest[i+1:]
+	}
+	i = strings.IndexByte(rest, '/')
+	if i < 0 {
+		return nil, errors.New("reference " + uri + " has no account name")
+	}
+
+	var err error
+	if ref.ServiceName, err = url.PathUnescape(rest[:i]); err != nil {
+		return nil, err
+	}
+	if ref.AccountName, err = url.PathUnescape(rest[i+1:]); err != nil {
+		return nil, err
+	}
+	attributes := GenericPasswordAttributes{
+		ServiceName: ref.ServiceName,
+		AccountName: ref.AccountName,
+	}
+	if err = attributes.CheckValidity(); err != nil {
+		return nil, err
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range query {
+		if len(values) != 1 {
+			return nil, errors.New("reference " + uri + " has more than one " + key + " parameter")
+		}
+		switch key {
+		case "field":
+			ref.Field = values[0]
+		case "keychain":
+			ref.Keychain = values[0]
+		default:
+			return nil, errors.New("reference " + uri + " has unknown parameter " + key)
+		}
+	}
+	if ref.Field != FieldPassword {
+		return nil, errors.New("reference " + uri + " has unknown field " + ref.Field)
+	}
+
+	return &ref, nil
+}
+
+// String returns the URI form of the reference.
+func (ref *Reference) String() string {
+	s := ref.Scheme + "://" + url.PathEscape(ref.ServiceName) + "/" + url.PathEscape(ref.AccountName)
+	query := url.Values{}
+	if ref.Field != "" && ref.Field != FieldPassword {
+		query.Set("field", ref.Field)
+	}
+	if ref.Keychain != "" {
+		query.Set("keychain", ref.Keychain)
+	}
+	if len(query) > 0 {
+		s += "?" + query.Encode()
+	}
+	return s
+}
+
+// Attributes returns the attributes that identify the referenced item.
+func (ref *Reference) Attributes() *GenericPasswordAttributes {
+	return &GenericPasswordAttributes{
+		ServiceName: ref.ServiceName,
+		AccountName: ref.AccountName,
+	}
+}
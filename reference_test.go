@@ -0,0 +1,82 @@
+package osxkeychain_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		uri string
+		ref osxkeychain.Reference
+	}{
+		{
+			"keychain://db/prod",
+			osxkeychain.Reference{Scheme: "keychain", ServiceName: "db", AccountName: "prod", Field: "password"},
+		},
+		{
+			"keychain://https%3A%2F%2Fexample.com/user%2Fname%20with%20unicode%20%E3%83%86%E3%82%B9%E3%83%88?field=password&keychain=%2Ftmp%2Ftest.keychain",
+			osxkeychain.Reference{
+				Scheme:      "keychain",
+				ServiceName: "https://example.com",
+				AccountName: "user/name with unicode テスト",
+				Field:       "password",
+				Keychain:    "/tmp/test.keychain",
+			},
+		},
+		{
+			"keychain://service/",
+			osxkeychain.Reference{Scheme: "keychain", ServiceName: "service", Field: "password"},
+		},
+		{
+			"vault+kv2://secret%2Fapp/token",
+			osxkeychain.Reference{Scheme: "vault+kv2", ServiceName: "secret/app", AccountName: "token", Field: "password"},
+		},
+	}
+
+	for _, test := range tests {
+		ref, err := osxkeychain.ParseReference(test.uri)
+		if err != nil {
+			t.Errorf("osxkeychain.ParseReference(%q): %v", test.uri, err)
+			continue
+		}
+		if !reflect.DeepEqual(*ref, test.ref) {
+			t.Errorf("osxkeychain.ParseReference(%q): expected %+v, got %+v", test.uri, test.ref, *ref)
+		}
+
+		// Round-trip through String.
+		roundTripped, err := osxkeychain.ParseReference(ref.String())
+		if err != nil {
+			t.Errorf("osxkeychain.ParseReference(%q): %v", ref.String(), err)
+			continue
+		}
+		if !reflect.DeepEqual(roundTripped, ref) {
+			t.Errorf("Expected %q to round-trip, got %+v", ref.String(), *roundTripped)
+		}
+	}
+}
+
+func TestParseInvalidReference(t *testing.T) {
+	tests := []struct {
+		uri string
+		err string
+	}{
+		{"db/prod", "reference db/prod is not of the form scheme://service/account"},
+		{"Keychain://db/prod", "reference Keychain://db/prod has an invalid scheme"},
+		{"keychain://db", "reference keychain://db has no account name"},
+		{"keychain://db%C3%28/prod", "ServiceName is not a valid UTF-8 string"},
+		{"keychain://db/prod%C3%28", "AccountName is not a valid UTF-8 string"},
+		{"keychain://db/prod?field=comment", "reference keychain://db/prod?field=comment has unknown field comment"},
+		{"keychain://db/prod?label=x", "reference keychain://db/prod?label=x has unknown parameter label"},
+		{"keychain://db/prod?keychain=a&keychain=b", "reference keychain://db/prod?keychain=a&keychain=b has more than one keychain parameter"},
+	}
+
+	for _, test := range tests {
+		_, err := osxkeychain.ParseReference(test.uri)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("osxkeychain.ParseReference(%q): expected \"%s\", got %v", test.uri, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package remotestore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// ErrDenied is returned by Store methods when the server's ACL doesn't
+// allow the client to do what it asked.
+var ErrDenied = errors.New("remotestore: denied by the server's ACL")
+
+// Error is an error response from a Server, other than the ones Store
+// methods return as osxkeychain errors or ErrDenied.
+type Error struct {
+	StatusCode int
+	// Code is the kind of error, such as "invalid_request" or
+	// "internal".
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("remotestore: server returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Store is an osxkeychain.Store whose items are kept by a Server. Only
+// the names and password of items are sent; other attributes are
+// ignored.
+type Store struct {
+	// URL is the URL of the server, such as https://sidecar:8443.
+	URL string
+	// Client makes the requests, and must be set up to present a
+	// client certificate, through its Transport's TLSClientConfig,
+	// and to trust the server's. If nil, http.DefaultClient is used,
+	// which the server will refuse.
+	Client *http.Client
+}
+
+// AddGenericPassword implements osxkeychain.Store.
+func (s *Store) AddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	return s.call(opAdd, attributes, nil)
+}
+
+// FindGenericPassword implements osxkeychain.Store.
+func (s *Store) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	if err := attributes.CheckValidity(); err != nil {
+		return nil, err
+	}
+	var resp response
+	if err := s.call(opFind, attributes, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Password == nil {
+		resp.Password = []byte{}
+	}
+	return resp.Password, nil
+}
+
+// FindAndRemoveGenericPassword implements osxkeychain.Store.
+func (s *Store) FindAndRemoveGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	return s.call(opFindAndRemove, attributes, nil)
+}
+
+// RemoveAndAddGenericPassword implements osxkeychain.Store.
+func (s *Store) RemoveAndAddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	return s.call(opRemoveAndAdd, attributes, nil)
+}
+
+// UpdateGenericPassword implements osxkeychain.Store.
+func (s *Store) UpdateGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	return s.call(opUpdate, attributes, nil)
+}
+
+// GetAllAccountNames implements osxkeychain.Store.
+func (s *Store) GetAllAccountNames(serviceName string) ([]string, error) {
+	var resp response
+	if err := s.call(opGetAllAccountNames, &osxkeychain.GenericPasswordAttributes{ServiceName: serviceName}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.AccountNames == nil {
+		resp.AccountNames = []string{}
+	}
+	return resp.AccountNames, nil
+}
+
+// call sends a request for op to the server, decoding its response
+// into resp if it isn't nil.
+func (s *Store) call(op string, attributes *osxkeychain.GenericPasswordAttributes, resp *response) error {
+	if s.URL == "" {
+		return errors.New("URL is empty")
+	}
+	body, err := json.Marshal(&request{
+		ServiceName: attributes.ServiceName,
+		AccountName: attributes.AccountName,
+		Password:    attributes.Password,
+	})
+	if err != nil {
+		return err
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Post(strings.TrimSuffix(s.URL, "/")+"/v1/"+op, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		if err := json.Unmarshal(data, &errResp); err != nil {
+			return &Error{StatusCode: res.StatusCode, Message: http.StatusText(res.StatusCode)}
+		}
+		if errResp.Code == codeDenied {
+			return ErrDenied
+		}
+		for _, e := range keychainErrors {
+			if errResp.Code == e.code {
+				return e.err
+			}
+		}
+		return &Error{StatusCode: res.StatusCode, Code: errResp.Code, Message: errResp.Message}
+	}
+	if resp == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return fmt.Errorf("remotestore: invalid response from server: %v", err)
+	}
+	return nil
+}
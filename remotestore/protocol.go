@@ -0,0 +1,67 @@
+package remotestore
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// Each operation is a POST of a JSON request to /v1/<operation>. A
+// successful one returns 200 and a JSON response; a failed one returns
+// an errorResponse with one of the error codes below.
+const (
+	opFind               = "find"
+	opAdd                = "add"
+	opFindAndRemove      = "find_and_remove"
+	opRemoveAndAdd       = "remove_and_add"
+	opUpdate             = "update"
+	opGetAllAccountNames = "get_all_account_names"
+)
+
+// readOnlyOps are the operations a ReadOnly rule allows.
+var readOnlyOps = map[string]bool{opFind: true, opGetAllAccountNames: true}
+
+// maxRequestLength bounds the body of a request the server reads.
+const maxRequestLength = 1 << 20
+
+type request struct {
+	ServiceName string `json:"service"`
+	AccountName string `json:"account,omitempty"`
+	Password    []byte `json:"password,omitempty"`
+}
+
+type response struct {
+	Password     []byte   `json:"password,omitempty"`
+	AccountNames []string `json:"account_names,omitempty"`
+}
+
+type errorResponse struct {
+	Code    string `json:"error"`
+	Message string `json:"message,omitempty"`
+}
+
+// Error codes, which are also the Result of an Access that failed.
+const (
+	codeItemNotFound    = "item_not_found"
+	codeDuplicateItem   = "duplicate_item"
+	codeAuthFailed      = "auth_failed"
+	codeUnauthenticated = "unauthenticated"
+	codeDenied          = "denied"
+	codeInvalidRequest  = "invalid_request"
+	codeInternal        = "internal"
+)
+
+// keychainErrors are the errors a Store returns that are sent as their
+// own codes, so that the client can return the same error.
+var keychainErrors = []struct {
+	err        error
+	code       string
+	statusCode int
+}{
+	{osxkeychain.ErrItemNotFound, codeItemNotFound, http.StatusNotFound},
+	{osxkeychain.ErrDuplicateItem, codeDuplicateItem, http.StatusConflict},
+	{osxkeychain.ErrAuthFailed, codeAuthFailed, http.StatusServiceUnavailable},
+}
+
+var errInvalidRequest = errors.New("remotestore: invalid request")
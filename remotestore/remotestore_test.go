@@ -0,0 +1,343 @@
+package remotestore_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/remotestore"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+// authority is a certificate authority issuing certificates for tests.
+type authority struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pool        *x509.CertPool
+}
+
+func newAuthority(t *testing.T) *authority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return &authority{certificate, key, pool}
+}
+
+// issue returns a certificate for a server at 127.0.0.1 or, if client
+// is set, for a client, with the given common name.
+func (a *authority) issue(t *testing.T, commonName string, client bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if client {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		template.IPAddresses = nil
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.certificate, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// testServer is a Server running in the test, with the authority that
+// issued its certificate and trusted by it for clients.
+type testServer struct {
+	*httptest.Server
+	ca *authority
+}
+
+func startServer(t *testing.T, server *remotestore.Server) *testServer {
+	ca := newAuthority(t)
+	s := httptest.NewUnstartedServer(server)
+	s.TLS = remotestore.ServerTLSConfig(ca.issue(t, "server", false), ca.pool)
+	// Don't log the handshakes of clients the tests expect to fail.
+	s.Config.ErrorLog = log.New(io.Discard, "", 0)
+	s.StartTLS()
+	t.Cleanup(s.Close)
+	return &testServer{s, ca}
+}
+
+// client returns a Store for the server, authenticating with
+// certificates, if any.
+func (s *testServer) client(certificates ...tls.Certificate) *remotestore.Store {
+	return &remotestore.Store{
+		URL: s.URL,
+		Client: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      s.ca.pool,
+			Certificates: certificates,
+		}}},
+	}
+}
+
+func TestStore(t *testing.T) {
+	server := startServer(t, &remotestore.Server{
+		Store: &storetest.MemStore{},
+		ACL:   []remotestore.Rule{{Client: "worker"}},
+	})
+	storetest.TestStore(t, server.client(server.ca.issue(t, "worker", true)))
+}
+
+// wrappingStore wraps the errors of its Store, as stores built on
+// others do.
+type wrappingStore struct {
+	osxkeychain.Store
+}
+
+func (s wrappingStore) AddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := s.Store.AddGenericPassword(attributes); err != nil {
+		return fmt.Errorf("wrapped: %w", err)
+	}
+	return nil
+}
+
+func (s wrappingStore) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	password, err := s.Store.FindGenericPassword(attributes)
+	if err != nil {
+		return nil, fmt.Errorf("wrapped: %w", err)
+	}
+	return password, nil
+}
+
+func TestWrappedErrors(t *testing.T) {
+	server := startServer(t, &remotestore.Server{
+		Store: wrappingStore{&storetest.MemStore{}},
+		ACL:   []remotestore.Rule{{Client: "worker"}},
+	})
+	client := server.client(server.ca.issue(t, "worker", true))
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct"}
+	if _, err := client.FindGenericPassword(attributes); !errors.Is(err, osxkeychain.ErrItemNotFound) {
+		t.Errorf("Expected ErrItemNotFound, got %v", err)
+	}
+	if err := client.AddGenericPassword(attributes); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.AddGenericPassword(attributes); !errors.Is(err, osxkeychain.ErrDuplicateItem) {
+		t.Errorf("Expected ErrDuplicateItem, got %v", err)
+	}
+}
+
+func TestACL(t *testing.T) {
+	store := &storetest.MemStore{}
+	for _, serviceName := range []string{"allowed", "read only", "other"} {
+		store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: serviceName, AccountName: "acct", Password: []byte("pw")})
+	}
+	server := startServer(t, &remotestore.Server{
+		Store: store,
+		ACL: []remotestore.Rule{
+			{Client: "worker", ServiceNames: []string{"allowed"}},
+			{Client: "worker", ServiceNames: []string{"read only"}, ReadOnly: true},
+			{Client: "other", ServiceNames: []string{"other"}},
+		},
+	})
+	client := server.client(server.ca.issue(t, "worker", true))
+
+	find := func(a *osxkeychain.GenericPasswordAttributes) error {
+		_, err := client.FindGenericPassword(a)
+		return err
+	}
+	list := func(a *osxkeychain.GenericPasswordAttributes) error {
+		_, err := client.GetAllAccountNames(a.ServiceName)
+		return err
+	}
+	tests := []struct {
+		serviceName string
+		call        func(a *osxkeychain.GenericPasswordAttributes) error
+		expected    error
+	}{
+		{"allowed", find, nil},
+		{"allowed", client.UpdateGenericPassword, nil},
+		{"read only", find, nil},
+		{"read only", list, nil},
+		{"read only", client.UpdateGenericPassword, remotestore.ErrDenied},
+		{"read only", client.FindAndRemoveGenericPassword, remotestore.ErrDenied},
+		{"other", find, remotestore.ErrDenied},
+		{"unlisted", client.AddGenericPassword, remotestore.ErrDenied},
+	}
+	for _, test := range tests {
+		attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: test.serviceName, AccountName: "acct", Password: []byte("new")}
+		if err := test.call(attributes); err != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.serviceName, test.expected, err)
+		}
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	server := startServer(t, &remotestore.Server{
+		Store: &storetest.MemStore{},
+		ACL:   []remotestore.Rule{{}},
+	})
+	if _, err := server.client().GetAllAccountNames("svc"); err == nil {
+		t.Error("Expected a client without a certificate to be refused")
+	}
+	if _, err := server.client(newAuthority(t).issue(t, "worker", true)).GetAllAccountNames("svc"); err == nil {
+		t.Error("Expected a client with a certificate from another authority to be refused")
+	}
+
+	// A server that doesn't ask for client certificates refuses every
+	// request.
+	ca := newAuthority(t)
+	unauthenticated := httptest.NewUnstartedServer(&remotestore.Server{
+		Store: &storetest.MemStore{},
+		ACL:   []remotestore.Rule{{}},
+	})
+	unauthenticated.TLS = &tls.Config{Certificates: []tls.Certificate{ca.issue(t, "server", false)}}
+	unauthenticated.StartTLS()
+	defer unauthenticated.Close()
+	store := (&testServer{unauthenticated, ca}).client(ca.issue(t, "worker", true))
+	expected := "remotestore: server returned 401 Unauthorized: remotestore: no verified client certificate"
+	if _, err := store.GetAllAccountNames("svc"); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+
+	client := server.client(server.ca.issue(t, "worker", true)).Client
+	for _, test := range []struct {
+		method, path, body string
+		statusCode         int
+	}{
+		{http.MethodPost, "/v1/find", `{"service":`, http.StatusBadRequest},
+		{http.MethodPost, "/v1/frobnicate", `{"service":"svc"}`, http.StatusNotFound},
+		{http.MethodPost, "/find", `{"service":"svc"}`, http.StatusNotFound},
+		{http.MethodGet, "/v1/find", "", http.StatusMethodNotAllowed},
+	} {
+		req, err := http.NewRequest(test.method, server.URL+test.path, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		expected := `{"error":"invalid_request","message":"remotestore: invalid request"}`
+		if res.StatusCode != test.statusCode || strings.TrimSpace(string(body)) != expected {
+			t.Errorf("%s %s: expected %d %s, got %s %s", test.method, test.path, test.statusCode, expected, res.Status, body)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	var accessLog bytes.Buffer
+	audit := remotestore.AccessLog(&accessLog)
+	// failAudit is 1 to fail every record, and 2 to fail only those of
+	// results.
+	var failAudit int32
+	store := &storetest.MemStore{}
+	server := startServer(t, &remotestore.Server{
+		Store: store,
+		ACL:   []remotestore.Rule{{Client: "worker", ServiceNames: []string{"svc"}}},
+		Audit: func(access *remotestore.Access) error {
+			if fail := atomic.LoadInt32(&failAudit); fail == 1 || fail == 2 && access.Result != "started" {
+				return errors.New("disk full")
+			}
+			return audit(access)
+		},
+	})
+	client := server.client(server.ca.issue(t, "worker", true))
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("secret")}
+	client.AddGenericPassword(attributes)
+	client.FindGenericPassword(attributes)
+	client.GetAllAccountNames("other")
+	client.FindAndRemoveGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "missing"})
+
+	if strings.Contains(accessLog.String(), "secret") || strings.Contains(accessLog.String(), "c2VjcmV0") {
+		t.Errorf("Expected no password in the log, got %s", accessLog.String())
+	}
+	expected := []remotestore.Access{
+		{Client: "worker", Operation: "add", ServiceName: "svc", AccountName: "acct", Result: "started"},
+		{Client: "worker", Operation: "add", ServiceName: "svc", AccountName: "acct", Result: "ok"},
+		{Client: "worker", Operation: "find", ServiceName: "svc", AccountName: "acct", Result: "ok"},
+		{Client: "worker", Operation: "get_all_account_names", ServiceName: "other", Result: "denied", Error: "remotestore: denied by the server's ACL"},
+		{Client: "worker", Operation: "find_and_remove", ServiceName: "svc", AccountName: "missing", Result: "started"},
+		{Client: "worker", Operation: "find_and_remove", ServiceName: "svc", AccountName: "missing", Result: "item_not_found", Error: osxkeychain.ErrItemNotFound.Error()},
+	}
+	var actual []remotestore.Access
+	for _, line := range strings.Split(strings.TrimSuffix(accessLog.String(), "\n"), "\n") {
+		var access remotestore.Access
+		if err := json.Unmarshal([]byte(line), &access); err != nil {
+			t.Fatal(err)
+		}
+		if access.Time.IsZero() || !strings.HasPrefix(access.RemoteAddr, "127.0.0.1:") {
+			t.Errorf("Expected a time and remote address, got %+v", access)
+		}
+		access.Time, access.RemoteAddr = time.Time{}, ""
+		actual = append(actual, access)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, actual)
+	}
+
+	// No password is returned without being recorded.
+	atomic.StoreInt32(&failAudit, 1)
+	expectedErr := "remotestore: server returned 500 Internal Server Error: remotestore: writing the audit log failed"
+	if password, err := client.FindGenericPassword(attributes); err == nil || err.Error() != expectedErr || password != nil {
+		t.Errorf("Expected \"%s\", got %q, %v", expectedErr, password, err)
+	}
+
+	// No change is made without being recorded first.
+	if err := client.UpdateGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("unrecorded")}); err == nil || err.Error() != expectedErr {
+		t.Errorf("Expected \"%s\", got %v", expectedErr, err)
+	}
+	if items := store.Items(); len(items) != 1 || string(items[0].Password) != "secret" {
+		t.Errorf("Expected the item to be left alone, got %v", items)
+	}
+
+	// Once a change is made, the client learns that it was.
+	atomic.StoreInt32(&failAudit, 2)
+	if err := client.UpdateGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "svc", AccountName: "acct", Password: []byte("recorded")}); err != nil {
+		t.Errorf("Expected the update to succeed, got %v", err)
+	}
+	if items := store.Items(); len(items) != 1 || string(items[0].Password) != "recorded" {
+		t.Errorf("Expected the item to be updated, got %v", items)
+	}
+}
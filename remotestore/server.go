@@ -0,0 +1,236 @@
+// Package remotestore serves an osxkeychain.Store over HTTPS, so that
+// programs that can't reach a keychain, such as workers in containers,
+// can use one kept by a sidecar on a Mac. Clients authenticate with TLS
+// client certificates, and are allowed to use only the services the
+// server's ACL gives them.
+//
+// A Server is an http.Handler, to be served with a TLS configuration
+// such as ServerTLSConfig returns:
+//
+//	server := &http.Server{
+//		Addr:      ":8443",
+//		Handler:   &remotestore.Server{Store: osxkeychain.DefaultKeychain, ACL: acl},
+//		TLSConfig: remotestore.ServerTLSConfig(certificate, clientCAs),
+//	}
+//	err := server.ListenAndServeTLS("", "")
+//
+// and a Store is its client.
+package remotestore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// ServerTLSConfig returns a TLS configuration for a server presenting
+// certificate, which requires clients to present a certificate issued
+// by one of clientCAs.
+func ServerTLSConfig(certificate tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// Rule allows a client to use the server.
+type Rule struct {
+	// Client is the common name of the client's certificate. If empty,
+	// any client matches.
+	Client string
+	// ServiceNames are the services whose items the client can use. If
+	// empty, it can use any.
+	ServiceNames []string
+	// ReadOnly restricts the client to finding passwords and listing
+	// account names.
+	ReadOnly bool
+}
+
+func (r *Rule) allows(client, op, serviceName string) bool {
+	if r.Client != "" && r.Client != client {
+		return false
+	}
+	if r.ReadOnly && !readOnlyOps[op] {
+		return false
+	}
+	if len(r.ServiceNames) == 0 {
+		return true
+	}
+	for _, name := range r.ServiceNames {
+		if name == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+// Access is a request made to a Server, as passed to its Audit
+// function. It holds no password.
+type Access struct {
+	Time time.Time `json:"time"`
+	// Client is the common name of the client's certificate, or empty
+	// if it presented none.
+	Client      string `json:"client"`
+	RemoteAddr  string `json:"remote_addr"`
+	Operation   string `json:"operation"`
+	ServiceName string `json:"service"`
+	AccountName string `json:"account,omitempty"`
+	// Result is "ok" or the code of the error returned to the client,
+	// such as "item_not_found" or "denied", or "started" for the
+	// record written before a change is made.
+	Result string `json:"result"`
+	// Error is the message of the error, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// AccessLog returns an Audit function that writes each Access to w as
+// a line of JSON.
+func AccessLog(w io.Writer) func(*Access) error {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	return func(access *Access) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return encoder.Encode(access)
+	}
+}
+
+// Server serves Store to clients that authenticated with a verified
+// TLS client certificate. A client can only do what one of the rules
+// of ACL allows; with no rules, it can do nothing.
+type Server struct {
+	Store osxkeychain.Store
+	ACL   []Rule
+	// Audit, if not nil, is called with every request once it's been
+	// carried out. If it returns an error, the client gets an error
+	// rather than the response, so that no password is returned
+	// without being recorded.
+	//
+	// A request to add, remove or update an item is also recorded,
+	// with Result "started", before the change is made, and refused if
+	// that fails. Once the change is made, the client gets its result
+	// even if recording it fails, since it would otherwise retry a
+	// change that was made.
+	Audit func(*Access) error
+}
+
+// resultStarted is the Result of the record of a change written before
+// it's made.
+const resultStarted = "started"
+
+var errAuditFailed = errors.New("remotestore: writing the audit log failed")
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	access := &Access{
+		Time:       time.Now(),
+		RemoteAddr: r.RemoteAddr,
+		Operation:  strings.TrimPrefix(r.URL.Path, "/v1/"),
+	}
+	statusCode, resp, err := s.serve(r, access)
+	started := access.Result == resultStarted
+	access.Result = "ok"
+	if err != nil {
+		access.Result, access.Error = err.Code, err.Message
+	}
+	if s.Audit != nil {
+		if auditErr := s.Audit(access); auditErr != nil && !started {
+			statusCode, resp = http.StatusInternalServerError, &errorResponse{Code: codeInternal, Message: errAuditFailed.Error()}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serve carries out a request, filling in access, and returns the
+// status code and body of the response, and an *Error if it failed.
+// Before making a change, it records access with Result
+// resultStarted, and leaves Result set if that succeeded.
+func (s *Server) serve(r *http.Request, access *Access) (int, interface{}, *Error) {
+	fail := func(statusCode int, code string, err error) (int, interface{}, *Error) {
+		resp := &errorResponse{Code: code, Message: err.Error()}
+		return statusCode, resp, &Error{StatusCode: statusCode, Code: code, Message: resp.Message}
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return fail(http.StatusUnauthorized, codeUnauthenticated, errors.New("remotestore: no verified client certificate"))
+	}
+	access.Client = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if !strings.HasPrefix(r.URL.Path, "/v1/") {
+		return fail(http.StatusNotFound, codeInvalidRequest, errInvalidRequest)
+	}
+	if r.Method != http.MethodPost {
+		return fail(http.StatusMethodNotAllowed, codeInvalidRequest, errInvalidRequest)
+	}
+	var req request
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestLength)).Decode(&req); err != nil {
+		return fail(http.StatusBadRequest, codeInvalidRequest, errInvalidRequest)
+	}
+	access.ServiceName, access.AccountName = req.ServiceName, req.AccountName
+
+	op := access.Operation
+	switch op {
+	case opFind, opAdd, opFindAndRemove, opRemoveAndAdd, opUpdate, opGetAllAccountNames:
+	default:
+		return fail(http.StatusNotFound, codeInvalidRequest, errInvalidRequest)
+	}
+	if !s.allows(access.Client, op, req.ServiceName) {
+		return fail(http.StatusForbidden, codeDenied, ErrDenied)
+	}
+	if s.Store == nil {
+		return fail(http.StatusInternalServerError, codeInternal, errors.New("Store is nil"))
+	}
+	if s.Audit != nil && !readOnlyOps[op] {
+		access.Result = resultStarted
+		if err := s.Audit(access); err != nil {
+			access.Result = ""
+			return fail(http.StatusInternalServerError, codeInternal, errAuditFailed)
+		}
+	}
+
+	attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: req.ServiceName, AccountName: req.AccountName, Password: req.Password}
+	resp := &response{}
+	var err error
+	switch op {
+	case opFind:
+		resp.Password, err = s.Store.FindGenericPassword(attributes)
+	case opAdd:
+		err = s.Store.AddGenericPassword(attributes)
+	case opFindAndRemove:
+		err = s.Store.FindAndRemoveGenericPassword(attributes)
+	case opRemoveAndAdd:
+		err = s.Store.RemoveAndAddGenericPassword(attributes)
+	case opUpdate:
+		err = s.Store.UpdateGenericPassword(attributes)
+	case opGetAllAccountNames:
+		resp.AccountNames, err = s.Store.GetAllAccountNames(req.ServiceName)
+	}
+	if err != nil {
+		for _, e := range keychainErrors {
+			if errors.Is(err, e.err) {
+				return fail(e.statusCode, e.code, err)
+			}
+		}
+		return fail(http.StatusInternalServerError, codeInternal, err)
+	}
+	return http.StatusOK, resp, nil
+}
+
+func (s *Server) allows(client, op, serviceName string) bool {
+	for i := range s.ACL {
+		if s.ACL[i].allows(client, op, serviceName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package osxkeychain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Resolver fetches the secrets that references point to.
+type Resolver struct {
+	// Stores maps a reference scheme, such as ReferenceScheme, to
+	// the Store holding the items it refers to.
+	Stores map[string]Store
+
+	// OpenKeychain, if non-nil, returns the Store for references
+	// with a keychain parameter. Otherwise such references can't be
+	// resolved.
+	OpenKeychain func(path string) (Store, error)
+}
+
+func (r *Resolver) store(ref *Reference) (Store, error) {
+	if ref.Keychain != "" {
+		if ref.Scheme != ReferenceScheme {
+			return nil, errors.New("keychain parameter is only supported for " + ReferenceScheme + " references")
+		}
+		if r.OpenKeychain == nil {
+			return nil, errors.New("no keychain can be opened for " + ref.Keychain)
+		}
+		return r.OpenKeychain(ref.Keychain)
+	}
+	store, ok := r.Stores[ref.Scheme]
+	if !ok {
+		return nil, errors.New("no store for scheme " + ref.Scheme)
+	}
+	return store, nil
+}
+
+// ResolveReference returns the secret the given reference points to.
+func (r *Resolver) ResolveReference(ref *Reference) ([]byte, error) {
+	store, err := r.store(ref)
+	if err != nil {
+		return nil, err
+	}
+	switch ref.Field {
+	case "", FieldPassword:
+		return store.FindGenericPassword(ref.Attributes())
+	default:
+		return nil, errors.New("unknown field " + ref.Field)
+	}
+}
+
+// Resolve parses the given reference URI and returns the secret it
+// points to.
+func (r *Resolver) Resolve(uri string) ([]byte, error) {
+	ref, err := ParseReference(uri)
+	if err != nil {
+		return nil, err
+	}
+	return r.ResolveReference(ref)
+}
+
+// expandPattern matches "${scheme://...}". Other "${...}" sequences,
+// such as shell-style variables, are left alone.
+var expandPattern = regexp.MustCompile(`\$\{([a-z][a-z0-9+.-]*://[^}]*)\}`)
+
+// Expand replaces every "${scheme://service/account}" reference in s
+// with the secret it points to. If any reference can't be resolved,
+// it returns an error.
+func (r *Resolver) Expand(s string) (string, error) {
+	return r.expand(s, false)
+}
+
+// expand is Expand, but if checkUTF8 is true it also returns an error
+// for secrets that aren't valid UTF-8.
+func (r *Resolver) expand(s string, checkUTF8 bool) (string, error) {
+	var err error
+	expanded := expandPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if err != nil {
+			return match
+		}
+		uri := match[len("${") : len(match)-len("}")]
+		var secret []byte
+		secret, err = r.Resolve(uri)
+		if err == nil && checkUTF8 && !utf8.Valid(secret) {
+			err = errors.New("secret for " + uri + " is not a valid UTF-8 string")
+		}
+		return string(secret)
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+// ExpandEnv expands the references in the values of the given
+// environment, in the "key=value" form used by os.Environ.
+func (r *Resolver) ExpandEnv(env []string) ([]string, error) {
+	expanded := make([]string, len(env))
+	for i, kv := range env {
+		j := strings.IndexByte(kv, '=')
+		if j < 0 {
+			expanded[i] = kv
+			continue
+		}
+		key, value := kv[:j], kv[j+1:]
+		value, err := r.Expand(value)
+		if err != nil {
+			return nil, errors.New(key + ": " + err.Error())
+		}
+		expanded[i] = key + "=" + value
+	}
+	return expanded, nil
+}
+
+// ExpandValue expands the references in every string within v, which
+// may be a string or a map or slice as produced by encoding/json or
+// a YAML decoder, and returns the result. v itself is not modified.
+func (r *Resolver) ExpandValue(v interface{}) (interface{}, error) {
+	return r.expandValue(v, false)
+}
+
+func (r *Resolver) expandValue(v interface{}, checkUTF8 bool) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return r.expand(v, checkUTF8)
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			var err error
+			if expanded[key], err = r.expandValue(value, checkUTF8); err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+	case map[interface{}]interface{}:
+		expanded := make(map[interface{}]interface{}, len(v))
+		for key, value := range v {
+			var err error
+			if expanded[key], err = r.expandValue(value, checkUTF8); err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		for i, value := range v {
+			var err error
+			if expanded[i], err = r.expandValue(value, checkUTF8); err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+	default:
+		return v, nil
+	}
+}
+
+// ExpandJSON expands the references in the string values of the given
+// JSON document. Object keys are not expanded, and the document is
+// re-encoded, so its formatting and key order are not preserved.
+// Secrets substituted into the document must be valid UTF-8.
+func (r *Resolver) ExpandJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON document: trailing data")
+	}
+	expanded, err := r.expandValue(v, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(expanded); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,168 @@
+package osxkeychain_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+func newTestResolver(t *testing.T) *osxkeychain.Resolver {
+	keychain := &storetest.MemStore{}
+	other := &storetest.MemStore{}
+	for _, attributes := range []osxkeychain.GenericPasswordAttributes{
+		{ServiceName: "db", AccountName: "prod", Password: []byte("s3cret & <more>")},
+		{ServiceName: "api", AccountName: "token", Password: []byte("tok")},
+		{ServiceName: "binary", AccountName: "key", Password: []byte("\xc3\x28")},
+	} {
+		if err := keychain.AddGenericPassword(&attributes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := other.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: "db", AccountName: "prod", Password: []byte("other")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &osxkeychain.Resolver{
+		Stores: map[string]osxkeychain.Store{
+			osxkeychain.ReferenceScheme: keychain,
+			"other":                     other,
+		},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	r := newTestResolver(t)
+
+	secret, err := r.Resolve("keychain://db/prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret) != "s3cret & <more>" {
+		t.Errorf("Expected s3cret & <more>, got %q", secret)
+	}
+
+	secret, err = r.Resolve("other://db/prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret) != "other" {
+		t.Errorf("Expected other, got %q", secret)
+	}
+
+	if _, err := r.Resolve("keychain://db/dev"); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("Expected osxkeychain.ErrItemNotFound, got %v", err)
+	}
+
+	errScheme := "no store for scheme unknown"
+	if _, err := r.Resolve("unknown://db/prod"); err == nil || err.Error() != errScheme {
+		t.Errorf("Expected \"%s\", got %v", errScheme, err)
+	}
+
+	errKeychain := "no keychain can be opened for /tmp/test.keychain"
+	if _, err := r.Resolve("keychain://db/prod?keychain=/tmp/test.keychain"); err == nil || err.Error() != errKeychain {
+		t.Errorf("Expected \"%s\", got %v", errKeychain, err)
+	}
+
+	r.OpenKeychain = func(path string) (osxkeychain.Store, error) {
+		if path != "/tmp/test.keychain" {
+			return nil, errors.New("unexpected path " + path)
+		}
+		return r.Stores["other"], nil
+	}
+	secret, err = r.Resolve("keychain://db/prod?keychain=/tmp/test.keychain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret) != "other" {
+		t.Errorf("Expected other, got %q", secret)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	r := newTestResolver(t)
+
+	expanded, err := r.Expand("postgres://app:${keychain://db/prod}@db/${HOME}?token=${other://db/prod}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "postgres://app:s3cret & <more>@db/${HOME}?token=other"
+	if expanded != expected {
+		t.Errorf("Expected %s, got %s", expected, expanded)
+	}
+
+	if _, err := r.Expand("${keychain://db/dev}"); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("Expected osxkeychain.ErrItemNotFound, got %v", err)
+	}
+
+	env, err := r.ExpandEnv([]string{"DB_PASSWORD=${keychain://db/prod}", "PATH=/bin", "ODD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedEnv := []string{"DB_PASSWORD=s3cret & <more>", "PATH=/bin", "ODD"}
+	if !reflect.DeepEqual(env, expectedEnv) {
+		t.Errorf("Expected %q, got %q", expectedEnv, env)
+	}
+
+	errEnv := "API_TOKEN: The specified item could not be found in the keychain."
+	if _, err := r.ExpandEnv([]string{"API_TOKEN=${keychain://api/missing}"}); err == nil || err.Error() != errEnv {
+		t.Errorf("Expected \"%s\", got %v", errEnv, err)
+	}
+}
+
+func TestExpandValue(t *testing.T) {
+	r := newTestResolver(t)
+
+	// As decoded by a YAML decoder.
+	document := map[interface{}]interface{}{
+		"database": map[string]interface{}{
+			"password": "${keychain://db/prod}",
+			"port":     5432,
+		},
+		"tokens": []interface{}{"${keychain://api/token}", true},
+	}
+	expanded, err := r.ExpandValue(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[interface{}]interface{}{
+		"database": map[string]interface{}{
+			"password": "s3cret & <more>",
+			"port":     5432,
+		},
+		"tokens": []interface{}{"tok", true},
+	}
+	if !reflect.DeepEqual(expanded, expected) {
+		t.Errorf("Expected %v, got %v", expected, expanded)
+	}
+
+	// The original must be left alone.
+	if document["tokens"].([]interface{})[0] != "${keychain://api/token}" {
+		t.Errorf("Expected document to be left alone, got %v", document)
+	}
+}
+
+func TestExpandJSON(t *testing.T) {
+	r := newTestResolver(t)
+
+	expanded, err := r.ExpandJSON([]byte(`{"password": "${keychain://db/prod}", "port": 12345678901234567890, "${keychain://api/token}": ["${keychain://api/token}"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"${keychain://api/token}":["tok"],"password":"s3cret & <more>","port":12345678901234567890}`
+	if string(expanded) != expected {
+		t.Errorf("Expected %s, got %s", expected, expanded)
+	}
+
+	errUTF8 := "secret for keychain://binary/key is not a valid UTF-8 string"
+	if _, err := r.ExpandJSON([]byte(`{"key": "${keychain://binary/key}"}`)); err == nil || err.Error() != errUTF8 {
+		t.Errorf("Expected \"%s\", got %v", errUTF8, err)
+	}
+
+	if _, err := r.ExpandJSON([]byte(`{} {}`)); err == nil {
+		t.Error("Expected error for trailing data")
+	}
+}
@@ -0,0 +1,505 @@
+package osxkeychain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FourCC is a four-character code, such as the creator and type of a
+// keychain item.
+type FourCC uint32
+
+// String returns the code's four characters.
+func (code FourCC) String() string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(code))
+	return string(b[:])
+}
+
+// SecurityAttribute is an attribute of a keychain item as printed by
+// the security tool.
+type SecurityAttribute struct {
+	// Name is either a four-character code, such as "svce", or a
+	// numeric attribute ID printed in hex, such as "0x00000007".
+	Name string
+	// Type is the attribute's format, such as "blob", "uint32",
+	// "sint32" or "timedate".
+	Type string
+	// Value holds the attribute's bytes, which are big-endian for
+	// integers. It is nil if the value is <NULL>.
+	Value []byte
+}
+
+// SecurityItem is a keychain item as printed by the security tool's
+// dump-keychain and find-*-password commands.
+type SecurityItem struct {
+	Keychain string
+	Version  int
+	// Class is either a four-character code, such as "genp", or a
+	// numeric class ID printed in hex.
+	Class      string
+	Attributes []SecurityAttribute
+
+	// Password holds the item's data, from a "password:" line as
+	// printed by find-generic-password -g, or from a "data:" section
+	// as printed by dump-keychain -d if DataSection is true. It is nil
+	// if the output had neither.
+	Password    []byte
+	DataSection bool
+}
+
+// securityDateFormat is the format of timedate attributes, which are
+// followed by a NUL byte.
+const securityDateFormat = "20060102150405Z"
+
+// securityLabelAttributes maps classes to the attribute holding their
+// label, where it isn't "labl".
+var securityLabelAttributes = map[string]string{
+	"genp": "0x00000007",
+	"inet": "0x00000007",
+	// Public, private and symmetric keys.
+	"0x0000000F": "0x00000001",
+	"0x00000010": "0x00000001",
+	"0x00000011": "0x00000001",
+}
+
+// Attribute returns the value of the attribute with the given name,
+// and whether the item has it with a value other than <NULL>.
+func (item *SecurityItem) Attribute(name string) ([]byte, bool) {
+	for _, attribute := range item.Attributes {
+		if attribute.Name == name {
+			return attribute.Value, attribute.Value != nil
+		}
+	}
+	return nil, false
+}
+
+func (item *SecurityItem) stringAttribute(name string) string {
+	value, _ := item.Attribute(name)
+	return string(value)
+}
+
+// ServiceName returns the item's "svce" attribute.
+func (item *SecurityItem) ServiceName() string {
+	return item.stringAttribute("svce")
+}
+
+// AccountName returns the item's "acct" attribute.
+func (item *SecurityItem) AccountName() string {
+	return item.stringAttribute("acct")
+}
+
+// Label returns the item's label, which the security tool prints as a
+// numeric attribute for passwords and keys.
+func (item *SecurityItem) Label() string {
+	if name, ok := securityLabelAttributes[item.Class]; ok {
+		return item.stringAttribute(name)
+	}
+	return item.stringAttribute("labl")
+}
+
+func (item *SecurityItem) fourCCAttribute(name string) FourCC {
+	value, _ := item.Attribute(name)
+	if len(value) != 4 {
+		return 0
+	}
+	return FourCC(binary.BigEndian.Uint32(value))
+}
+
+// Creator returns the item's "crtr" attribute, or 0 if it has none.
+func (item *SecurityItem) Creator() FourCC {
+	return item.fourCCAttribute("crtr")
+}
+
+// Type returns the item's "type" attribute, or 0 if it has none.
+func (item *SecurityItem) Type() FourCC {
+	return item.fourCCAttribute("type")
+}
+
+func (item *SecurityItem) dateAttribute(name string) time.Time {
+	value, ok := item.Attribute(name)
+	if !ok {
+		return time.Time{}
+	}
+	t, _ := parseSecurityDate(value)
+	return t
+}
+
+// Created returns the item's "cdat" attribute, or the zero time if it
+// has none.
+func (item *SecurityItem) Created() time.Time {
+	return item.dateAttribute("cdat")
+}
+
+// Modified returns the item's "mdat" attribute, or the zero time if it
+// has none.
+func (item *SecurityItem) Modified() time.Time {
+	return item.dateAttribute("mdat")
+}
+
+// GenericPasswordAttributes returns the service and account names and
+// password of a generic password item.
+func (item *SecurityItem) GenericPasswordAttributes() (*GenericPasswordAttributes, error) {
+	if item.Class != "genp" {
+		return nil, fmt.Errorf("item has class %q, not \"genp\"", item.Class)
+	}
+	attributes := &GenericPasswordAttributes{
+		ServiceName: item.ServiceName(),
+		AccountName: item.AccountName(),
+		Password:    item.Password,
+	}
+	if err := attributes.CheckValidity(); err != nil {
+		return nil, err
+	}
+	return attributes, nil
+}
+
+func parseSecurityDate(value []byte) (time.Time, error) {
+	return time.Parse(securityDateFormat, string(bytes.TrimRight(value, "\x00")))
+}
+
+// FormatSecurityDate returns t as the value of a timedate attribute.
+func FormatSecurityDate(t time.Time) []byte {
+	return append([]byte(t.UTC().Format(securityDateFormat)), 0)
+}
+
+// ParseSecurityOutput parses the text printed by the security tool's
+// dump-keychain and find-*-password commands, with or without the
+// password or data, into the items it describes.
+func ParseSecurityOutput(r io.Reader) ([]SecurityItem, error) {
+	var items []SecurityItem
+	var item *SecurityItem
+	inAttributes, inData := false, false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24)
+	lineNumber := 0
+	fail := func(format string, args ...interface{}) ([]SecurityItem, error) {
+		return nil, fmt.Errorf("line %d: %s", lineNumber, fmt.Sprintf(format, args...))
+	}
+	// current returns the item being parsed, starting one if the
+	// output doesn't begin with a keychain line.
+	current := func() *SecurityItem {
+		if item == nil {
+			items = append(items, SecurityItem{})
+			item = &items[len(items)-1]
+		}
+		return item
+	}
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if inData {
+			value, err := parseSecurityValue(strings.TrimSpace(line))
+			if err != nil {
+				return fail("%v", err)
+			}
+			current().Password = value
+			inData = false
+			continue
+		}
+
+		if inAttributes && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			attribute, err := parseSecurityAttribute(strings.TrimSpace(line))
+			if err != nil {
+				return fail("%v", err)
+			}
+			current().Attributes = append(current().Attributes, attribute)
+			continue
+		}
+		inAttributes = false
+
+		key, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			return fail("expected a field, got %q", line)
+		}
+		rest = strings.TrimSpace(rest)
+		switch key {
+		case "keychain":
+			keychain, err := parseSecurityValue(rest)
+			if err != nil {
+				return fail("%v", err)
+			}
+			items = append(items, SecurityItem{Keychain: string(keychain)})
+			item = &items[len(items)-1]
+		case "version":
+			version, err := strconv.Atoi(rest)
+			if err != nil {
+				return fail("invalid version %q", rest)
+			}
+			current().Version = version
+		case "class":
+			class, err := parseSecurityName(rest)
+			if err != nil {
+				return fail("%v", err)
+			}
+			current().Class = class
+		case "attributes":
+			current()
+			inAttributes = true
+		case "password":
+			password := []byte{}
+			if rest != "" {
+				var err error
+				if password, err = parseSecurityValue(rest); err != nil {
+					return fail("%v", err)
+				}
+			}
+			current().Password = password
+		case "data":
+			current().DataSection = true
+			inData = true
+		default:
+			return fail("unknown field %q", key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if inData {
+		return fail("data: is missing its value")
+	}
+	return items, nil
+}
+
+// parseSecurityName parses a four-character code in quotes or a
+// numeric ID in hex.
+func parseSecurityName(s string) (string, error) {
+	if strings.HasPrefix(s, "0x") {
+		if _, err := strconv.ParseUint(s[2:], 16, 32); err != nil {
+			return "", fmt.Errorf("invalid ID %q", s)
+		}
+		return s, nil
+	}
+	name, rest, err := parseSecurityQuoted(s)
+	if err != nil {
+		return "", err
+	}
+	if rest != "" {
+		return "", fmt.Errorf("unexpected %q after name", rest)
+	}
+	return string(name), nil
+}
+
+// parseSecurityAttribute parses an attribute line, such as
+// `"svce"<blob>="name"` or `0x00000007 <blob>=<NULL>`.
+func parseSecurityAttribute(line string) (SecurityAttribute, error) {
+	typeStart := strings.Index(line, "<")
+	typeEnd := strings.Index(line, ">=")
+	if typeStart < 0 || typeEnd < typeStart {
+		return SecurityAttribute{}, fmt.Errorf("invalid attribute %q", line)
+	}
+	name, err := parseSecurityName(strings.TrimSpace(line[:typeStart]))
+	if err != nil {
+		return SecurityAttribute{}, err
+	}
+	attribute := SecurityAttribute{Name: name, Type: line[typeStart+1 : typeEnd]}
+	s := line[typeEnd+2:]
+	if s == "<NULL>" {
+		return attribute, nil
+	}
+
+	switch attribute.Type {
+	case "uint32", "sint32":
+		// These are printed as a four-character code if printable,
+		// and otherwise as a single hex number.
+		if strings.HasPrefix(s, "0x") {
+			n, err := strconv.ParseUint(s[2:], 16, 32)
+			if err != nil {
+				return SecurityAttribute{}, fmt.Errorf("invalid %s %q", attribute.Type, s)
+			}
+			attribute.Value = binary.BigEndian.AppendUint32(nil, uint32(n))
+			return attribute, nil
+		}
+	}
+	if attribute.Value, err = parseSecurityValue(s); err != nil {
+		return SecurityAttribute{}, err
+	}
+	if attribute.Type == "timedate" {
+		if _, err := parseSecurityDate(attribute.Value); err != nil {
+			return SecurityAttribute{}, fmt.Errorf("invalid timedate %q", attribute.Value)
+		}
+	}
+	return attribute, nil
+}
+
+// parseSecurityValue parses a value printed by the security tool:
+// a quoted string with octal escapes, hex digits prefixed by 0x, or
+// hex digits followed by two spaces and the same value quoted.
+func parseSecurityValue(s string) ([]byte, error) {
+	if s == "" {
+		return []byte{}, nil
+	}
+	if !strings.HasPrefix(s, "0x") {
+		value, rest, err := parseSecurityQuoted(s)
+		if err != nil {
+			return nil, err
+		}
+		if rest != "" {
+			return nil, fmt.Errorf("unexpected %q after value", rest)
+		}
+		return value, nil
+	}
+
+	digits, quoted, hasQuoted := strings.Cut(s[2:], "  ")
+	value, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex value %q", s)
+	}
+	if hasQuoted {
+		same, rest, err := parseSecurityQuoted(quoted)
+		if err != nil {
+			return nil, err
+		}
+		if rest != "" || !bytes.Equal(same, value) {
+			return nil, fmt.Errorf("hex and quoted values differ in %q", s)
+		}
+	}
+	return value, nil
+}
+
+// parseSecurityQuoted parses the quoted string at the start of s and
+// returns its bytes and the rest of s.
+func parseSecurityQuoted(s string) ([]byte, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return nil, "", fmt.Errorf("expected a quoted string, got %q", s)
+	}
+	value := []byte{}
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return value, s[i+1:], nil
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+				value = append(value, s[i+1])
+				i++
+				continue
+			}
+			if i+3 >= len(s) {
+				return nil, "", errors.New("truncated escape in " + strconv.Quote(s))
+			}
+			n, err := strconv.ParseUint(s[i+1:i+4], 8, 8)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid escape %q", s[i:i+4])
+			}
+			value = append(value, byte(n))
+			i += 3
+		default:
+			value = append(value, c)
+		}
+	}
+	return nil, "", errors.New("unterminated string " + strconv.Quote(s))
+}
+
+// FormatSecurityOutput writes items in the format ParseSecurityOutput
+// parses, as the security tool prints them.
+func FormatSecurityOutput(w io.Writer, items []SecurityItem) error {
+	var buf bytes.Buffer
+	for i := range items {
+		item := &items[i]
+		if item.Keychain != "" {
+			buf.WriteString("keychain: ")
+			formatSecurityValue(&buf, []byte(item.Keychain))
+			buf.WriteByte('\n')
+		}
+		if item.Version != 0 {
+			fmt.Fprintf(&buf, "version: %d\n", item.Version)
+		}
+		if item.Class != "" {
+			buf.WriteString("class: " + formatSecurityName(item.Class) + "\n")
+		}
+		buf.WriteString("attributes:\n")
+		for _, attribute := range item.Attributes {
+			name := formatSecurityName(attribute.Name)
+			if strings.HasPrefix(name, "0x") {
+				name += " "
+			}
+			buf.WriteString("    " + name + "<" + attribute.Type + ">=")
+			switch {
+			case attribute.Value == nil:
+				buf.WriteString("<NULL>")
+			case (attribute.Type == "uint32" || attribute.Type == "sint32") && len(attribute.Value) == 4 && !isSecurityPrintable(attribute.Value):
+				fmt.Fprintf(&buf, "0x%08X", binary.BigEndian.Uint32(attribute.Value))
+			default:
+				formatSecurityValue(&buf, attribute.Value)
+			}
+			buf.WriteByte('\n')
+		}
+		if item.Password != nil {
+			if item.DataSection {
+				buf.WriteString("data:\n")
+			} else {
+				buf.WriteString("password: ")
+			}
+			formatSecurityValue(&buf, item.Password)
+			buf.WriteByte('\n')
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func formatSecurityName(name string) string {
+	if strings.HasPrefix(name, "0x") {
+		return name
+	}
+	var buf bytes.Buffer
+	formatSecurityQuoted(&buf, []byte(name))
+	return buf.String()
+}
+
+func isSecurityPrintable(value []byte) bool {
+	for _, c := range value {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// formatSecurityValue writes value as the security tool does: quoted
+// if it's printable, and otherwise in hex, followed by the quoted form
+// if any of it is printable.
+func formatSecurityValue(buf *bytes.Buffer, value []byte) {
+	if isSecurityPrintable(value) {
+		formatSecurityQuoted(buf, value)
+		return
+	}
+	buf.WriteString("0x" + strings.ToUpper(hex.EncodeToString(value)))
+	for _, c := range value {
+		if c >= ' ' && c <= '~' {
+			buf.WriteString("  ")
+			formatSecurityQuoted(buf, value)
+			return
+		}
+	}
+}
+
+func formatSecurityQuoted(buf *bytes.Buffer, value []byte) {
+	buf.WriteByte('"')
+	for _, c := range value {
+		switch {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(buf, "\\%03o", c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+}
@@ -0,0 +1,172 @@
+package osxkeychain_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+func parseSecurityFixture(t *testing.T, name string) ([]osxkeychain.SecurityItem, []byte) {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := osxkeychain.ParseSecurityOutput(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var formatted bytes.Buffer
+	if err := osxkeychain.FormatSecurityOutput(&formatted, items); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(formatted.Bytes(), data) {
+		t.Errorf("%s: Expected the fixture back, got:\n%s", name, formatted.Bytes())
+	}
+	return items, data
+}
+
+func TestParseFindGenericPassword(t *testing.T) {
+	items, _ := parseSecurityFixture(t, "find-generic-password.txt")
+	if len(items) != 1 {
+		t.Fatalf("Expected 1 item, got %d", len(items))
+	}
+	item := &items[0]
+
+	if item.Keychain != "/Users/alice/Library/Keychains/login.keychain-db" || item.Version != 512 || item.Class != "genp" {
+		t.Errorf("Unexpected header %q %d %q", item.Keychain, item.Version, item.Class)
+	}
+	if item.ServiceName() != "api.example.com" || item.AccountName() != "alice" || item.Label() != "api.example.com" {
+		t.Errorf("Unexpected names %q %q %q", item.ServiceName(), item.AccountName(), item.Label())
+	}
+	if item.Creator().String() != "aapl" || item.Type() != 0 {
+		t.Errorf("Unexpected creator %q and type %d", item.Creator(), item.Type())
+	}
+	created := time.Date(2019, time.October, 7, 12, 30, 45, 0, time.UTC)
+	modified := time.Date(2021, time.March, 15, 8, 0, 0, 0, time.UTC)
+	if !item.Created().Equal(created) || !item.Modified().Equal(modified) {
+		t.Errorf("Unexpected dates %v and %v", item.Created(), item.Modified())
+	}
+	if comment, ok := item.Attribute("icmt"); !ok || string(comment) != "Deploy token" {
+		t.Errorf("Unexpected comment %q", comment)
+	}
+	if _, ok := item.Attribute("desc"); ok {
+		t.Error("Expected a <NULL> description not to be found")
+	}
+
+	attributes, err := item.GenericPasswordAttributes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := osxkeychain.GenericPasswordAttributes{
+		ServiceName: "api.example.com",
+		AccountName: "alice",
+		Password:    []byte(`s3cret "quoted" \ slash`),
+	}
+	if !reflect.DeepEqual(*attributes, expected) {
+		t.Errorf("Expected %v, got %v", expected, *attributes)
+	}
+}
+
+func TestParseDumpKeychain(t *testing.T) {
+	items, _ := parseSecurityFixture(t, "dump-keychain.txt")
+	if len(items) != 3 {
+		t.Fatalf("Expected 3 items, got %d", len(items))
+	}
+
+	wifi := &items[0]
+	if wifi.AccountName() != "Café Guest" || wifi.Label() != "Wi-Fi" || string(wifi.Password) != "correct horse battery staple" || !wifi.DataSection {
+		t.Errorf("Unexpected item %v", *wifi)
+	}
+	if wifi.Type() != 0xF || wifi.Creator() != 0 {
+		t.Errorf("Unexpected creator %d and type %d", wifi.Creator(), wifi.Type())
+	}
+	if invisible, _ := wifi.Attribute("invi"); !bytes.Equal(invisible, []byte{0, 0, 0, 1}) {
+		t.Errorf("Unexpected invi %v", invisible)
+	}
+
+	binary := &items[1]
+	if binary.AccountName() != "\x00\xff" || string(binary.Password) != "line 1\nline 2" || binary.Type().String() != "note" {
+		t.Errorf("Unexpected item %v", *binary)
+	}
+	if !binary.Modified().IsZero() {
+		t.Errorf("Expected no modification date, got %v", binary.Modified())
+	}
+
+	cert := &items[2]
+	if cert.Class != "cert" || cert.Label() != "Example Root CA" || cert.Password != nil {
+		t.Errorf("Unexpected item %v", *cert)
+	}
+	expected := `item has class "cert", not "genp"`
+	if _, err := cert.GenericPasswordAttributes(); err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
+
+func TestFormatSecurityOutput(t *testing.T) {
+	items := []osxkeychain.SecurityItem{{
+		Class: "genp",
+		Attributes: []osxkeychain.SecurityAttribute{
+			{Name: "0x00000007", Type: "blob", Value: []byte("label")},
+			{Name: "cdat", Type: "timedate", Value: osxkeychain.FormatSecurityDate(time.Date(2022, time.May, 1, 2, 3, 4, 0, time.UTC))},
+			{Name: "crtr", Type: "uint32", Value: []byte{0, 0, 1, 0}},
+			{Name: "svce", Type: "blob", Value: []byte{}},
+		},
+		Password: []byte{},
+	}}
+	var buf bytes.Buffer
+	if err := osxkeychain.FormatSecurityOutput(&buf, items); err != nil {
+		t.Fatal(err)
+	}
+	expected := `class: "genp"
+attributes:
+    0x00000007 <blob>="label"
+    "cdat"<timedate>=0x32303232303530313032303330345A00  "20220501020304Z\000"
+    "crtr"<uint32>=0x00000100
+    "svce"<blob>=""
+password: ""
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	parsed, err := osxkeychain.ParseSecurityOutput(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, items) {
+		t.Errorf("Expected %v, got %v", items, parsed)
+	}
+}
+
+func TestParseSecurityOutputErrors(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected string
+	}{
+		{"nonsense", `line 1: expected a field, got "nonsense"`},
+		{"flavor: sweet", `line 1: unknown field "flavor"`},
+		{"version: x", `line 1: invalid version "x"`},
+		{"class: genp", `line 1: expected a quoted string, got "genp"`},
+		{"attributes:\n    \"svce\"<blob>=\"unterminated", `line 2: unterminated string "\"unterminated"`},
+		{"attributes:\n    \"svce\"=\"x\"", `line 2: invalid attribute "\"svce\"=\"x\""`},
+		{"attributes:\n    \"svce\"<blob>=0xZZ", `line 2: invalid hex value "0xZZ"`},
+		{"attributes:\n    \"svce\"<blob>=0x41  \"B\"", `line 2: hex and quoted values differ in "0x41  \"B\""`},
+		{"attributes:\n    \"cdat\"<timedate>=\"yesterday\"", `line 2: invalid timedate "yesterday"`},
+		{"attributes:\n    \"type\"<uint32>=0xFFFFFFFFF", `line 2: invalid uint32 "0xFFFFFFFFF"`},
+		{"password: \"\\9\"", `line 1: truncated escape in "\"\\9\""`},
+		{"password: \"\\999\"", `line 1: invalid escape "\\999"`},
+		{"data:", "line 1: data: is missing its value"},
+	}
+	for _, test := range tests {
+		_, err := osxkeychain.ParseSecurityOutput(strings.NewReader(test.output))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package osxkeychain
+
+// Store is a place generic passwords can be kept. Its methods have
+// the same semantics as the package-level functions of the same name:
+// in particular, AddGenericPassword returns ErrDuplicateItem if the
+// item already exists, and the Find and Remove methods return
+// ErrItemNotFound if it doesn't.
+//
+// As in the keychain, an empty ServiceName or AccountName matches any
+// when finding, removing or updating an item, and only the first item
+// that matches is found, removed or updated; which one is first is up
+// to the implementation. RemoveAndAddGenericPassword removes the first
+// match and adds an item with the names as given. AddGenericPassword
+// and GetAllAccountNames use the names as given. An implementation
+// that can't match a name it was given empty returns an error rather
+// than matching it exactly.
+//
+// DefaultKeychain is the Store backed by the default keychain; other
+// implementations wrap it or keep items elsewhere.
+type Store interface {
+	AddGenericPassword(attributes *GenericPasswordAttributes) error
+	FindGenericPassword(attributes *GenericPasswordAttributes) ([]byte, error)
+	FindAndRemoveGenericPassword(attributes *GenericPasswordAttributes) error
+	RemoveAndAddGenericPassword(attributes *GenericPasswordAttributes) error
+	UpdateGenericPassword(attributes *GenericPasswordAttributes) error
+	GetAllAccountNames(serviceName string) ([]string, error)
+}
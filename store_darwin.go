@@ -0,0 +1,83 @@
+//go:build darwin && !ios && cgo
+// +build darwin,!ios,cgo
+
+package osxkeychain
+
+// DefaultKeychain is a Store backed by the default keychain, using
+// the package-level functions.
+var DefaultKeychain Store = defaultKeychain{}
+
+type defaultKeychain struct{}
+
+func (defaultKeychain) AddGenericPassword(attributes *GenericPasswordAttributes) error {
+	return AddGenericPassword(attributes)
+}
+
+func (defaultKeychain) FindGenericPassword(attributes *GenericPasswordAttributes) ([]byte, error) {
+	return FindGenericPassword(attributes)
+}
+
+func (defaultKeychain) FindAndRemoveGenericPassword(attributes *GenericPasswordAttributes) error {
+	return FindAndRemoveGenericPassword(attributes)
+}
+
+func (defaultKeychain) RemoveAndAddGenericPassword(attributes *GenericPasswordAttributes) error {
+	return RemoveAndAddGenericPassword(attributes)
+}
+
+func (defaultKeychain) UpdateGenericPassword(attributes *GenericPasswordAttributes) error {
+	return UpdateGenericPassword(attributes)
+}
+
+func (defaultKeychain) GetAllAccountNames(serviceName string) ([]string, error) {
+	return GetAllAccountNames(serviceName)
+}
+
+// DataProtectionKeychain is a Store backed by the data-protection
+// keychain, which unlike the default keychain is only available to
+// signed apps with a keychain-access-groups entitlement; others get
+// ErrMissingEntitlement. If AccessGroup is set, every item is kept in
+// that access group, to be shared with the other apps in it.
+type DataProtectionKeychain struct {
+	AccessGroup string
+}
+
+func (k DataProtectionKeychain) attributes(attributes *GenericPasswordAttributes) *GenericPasswordAttributes {
+	c := *attributes
+	c.UseDataProtectionKeychain = true
+	if k.AccessGroup != "" {
+		c.AccessGroup = k.AccessGroup
+	}
+	return &c
+}
+
+func (k DataProtectionKeychain) AddGenericPassword(attributes *GenericPasswordAttributes) error {
+	return AddGenericPassword(k.attributes(attributes))
+}
+
+func (k DataProtectionKeychain) FindGenericPassword(attributes *GenericPasswordAttributes) ([]byte, error) {
+	return FindGenericPassword(k.attributes(attributes))
+}
+
+func (k DataProtectionKeychain) FindAndRemoveGenericPassword(attributes *GenericPasswordAttributes) error {
+	return FindAndRemoveGenericPassword(k.attributes(attributes))
+}
+
+func (k DataProtectionKeychain) RemoveAndAddGenericPassword(attributes *GenericPasswordAttributes) error {
+	return RemoveAndAddGenericPassword(k.attributes(attributes))
+}
+
+func (k DataProtectionKeychain) UpdateGenericPassword(attributes *GenericPasswordAttributes) error {
+	return UpdateGenericPassword(k.attributes(attributes))
+}
+
+func (k DataProtectionKeychain) GetAllAccountNames(serviceName string) (accountNames []string, err error) {
+	attributes := k.attributes(&GenericPasswordAttributes{ServiceName: serviceName})
+	if err = attributes.CheckValidity(); err != nil {
+		return
+	}
+	keychainExecutor.do(func() {
+		accountNames, err = getAllAccountNames(attributes)
+	})
+	return
+}
@@ -0,0 +1,55 @@
+//go:build darwin && !ios && cgo
+// +build darwin,!ios,cgo
+
+package osxkeychain_test
+
+import (
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+func TestDefaultKeychainStore(t *testing.T) {
+	storetest.TestStore(t, osxkeychain.DefaultKeychain)
+}
+
+func TestDataProtectionKeychainEmptyNames(t *testing.T) {
+	store := osxkeychain.DataProtectionKeychain{}
+	serviceName := "osxkeychain_test empty names"
+	for _, accountName := range []string{"a", "b"} {
+		attributes := &osxkeychain.GenericPasswordAttributes{ServiceName: serviceName, AccountName: accountName, Password: []byte(accountName)}
+		if err := store.AddGenericPassword(attributes); err == osxkeychain.ErrMissingEntitlement {
+			t.Skip("The test binary isn't entitled to the data-protection keychain")
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		defer store.FindAndRemoveGenericPassword(attributes)
+	}
+
+	// An empty name matches any account, but, as in the default
+	// keychain, only the first matching item is changed or removed.
+	if err := store.UpdateGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: serviceName, Password: []byte("new")}); err != nil {
+		t.Fatal(err)
+	}
+	updated := 0
+	for _, accountName := range []string{"a", "b"} {
+		password, err := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: serviceName, AccountName: accountName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(password) == "new" {
+			updated++
+		}
+	}
+	if updated != 1 {
+		t.Errorf("Expected one item to be updated, got %d", updated)
+	}
+
+	if err := store.FindAndRemoveGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: serviceName}); err != nil {
+		t.Fatal(err)
+	}
+	if accountNames, err := store.GetAllAccountNames(serviceName); err != nil || len(accountNames) != 1 {
+		t.Errorf("Expected one item to be left, got %v, %v", accountNames, err)
+	}
+}
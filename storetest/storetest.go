@@ -0,0 +1,286 @@
+// Package storetest provides an in-memory osxkeychain.Store, and a
+// test that Store implementations behave like the default keychain.
+package storetest
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// MemStore is an in-memory Store with the same semantics as the
+// default keychain. The zero value is an empty store.
+type MemStore struct {
+	mu    sync.Mutex
+	items []osxkeychain.GenericPasswordAttributes
+}
+
+// find returns the index of the first item with the names of
+// attributes, where an empty name matches any unless exact is set, or
+// -1 if there's none.
+func (s *MemStore) find(attributes *osxkeychain.GenericPasswordAttributes, exact bool) int {
+	for i, item := range s.items {
+		if (item.ServiceName == attributes.ServiceName || !exact && attributes.ServiceName == "") &&
+			(item.AccountName == attributes.AccountName || !exact && attributes.AccountName == "") {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddGenericPassword implements osxkeychain.Store.
+func (s *MemStore) AddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.find(attributes, true) >= 0 {
+		return osxkeychain.ErrDuplicateItem
+	}
+	item := *attributes
+	item.Password = append([]byte{}, attributes.Password...)
+	item.TrustedApplications = append([]string{}, attributes.TrustedApplications...)
+	s.items = append(s.items, item)
+	return nil
+}
+
+// FindGenericPassword implements osxkeychain.Store.
+func (s *MemStore) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	if err := attributes.CheckValidity(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(attributes, false)
+	if i < 0 {
+		return nil, osxkeychain.ErrItemNotFound
+	}
+	return append([]byte{}, s.items[i].Password...), nil
+}
+
+// FindAndRemoveGenericPassword implements osxkeychain.Store.
+func (s *MemStore) FindAndRemoveGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(attributes, false)
+	if i < 0 {
+		return osxkeychain.ErrItemNotFound
+	}
+	s.items = append(s.items[:i], s.items[i+1:]...)
+	return nil
+}
+
+// RemoveAndAddGenericPassword implements osxkeychain.Store.
+func (s *MemStore) RemoveAndAddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	err := s.FindAndRemoveGenericPassword(attributes)
+	if err != nil && err != osxkeychain.ErrItemNotFound {
+		return err
+	}
+	return s.AddGenericPassword(attributes)
+}
+
+// UpdateGenericPassword implements osxkeychain.Store.
+func (s *MemStore) UpdateGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(attributes, false)
+	if i < 0 {
+		return osxkeychain.ErrItemNotFound
+	}
+	s.items[i].Password = append([]byte{}, attributes.Password...)
+	return nil
+}
+
+// GetAllAccountNames implements osxkeychain.Store.
+func (s *MemStore) GetAllAccountNames(serviceName string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accountNames := []string{}
+	for _, item := range s.items {
+		if item.ServiceName == serviceName {
+			accountNames = append(accountNames, item.AccountName)
+		}
+	}
+	return accountNames, nil
+}
+
+// Items returns a copy of every item in the store, in the order they
+// were added.
+func (s *MemStore) Items() []osxkeychain.GenericPasswordAttributes {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]osxkeychain.GenericPasswordAttributes, len(s.items))
+	for i, item := range s.items {
+		items[i] = item
+		items[i].Password = append([]byte{}, item.Password...)
+		items[i].TrustedApplications = append([]string{}, item.TrustedApplications...)
+	}
+	return items
+}
+
+// TestStore checks that store has the semantics of the default
+// keychain. It adds and removes items under service names starting
+// with "storetest", which must not be in use.
+func TestStore(t *testing.T, store osxkeychain.Store) {
+	attributes := osxkeychain.GenericPasswordAttributes{
+		ServiceName: "storetest service with unicode テスト",
+		AccountName: "test account with unicode テスト",
+	}
+
+	// Add with a blank password.
+	if err := store.AddGenericPassword(&attributes); err != nil {
+		t.Fatalf("AddGenericPassword: %v", err)
+	}
+	if err := store.AddGenericPassword(&attributes); err != osxkeychain.ErrDuplicateItem {
+		t.Errorf("AddGenericPassword: expected ErrDuplicateItem, got %v", err)
+	}
+	password, err := store.FindGenericPassword(&attributes)
+	if err != nil {
+		t.Errorf("FindGenericPassword: %v", err)
+	}
+	if len(password) != 0 {
+		t.Errorf("FindGenericPassword: expected empty password, got %q", password)
+	}
+
+	// Binary passwords must survive intact.
+	expectedPassword := []byte("long test password \000 with invalid UTF-8 \xc3\x28 and embedded nuls \000")
+	attributes.Password = expectedPassword
+	if err := store.RemoveAndAddGenericPassword(&attributes); err != nil {
+		t.Errorf("RemoveAndAddGenericPassword: %v", err)
+	}
+	password, err = store.FindGenericPassword(&attributes)
+	if err != nil {
+		t.Errorf("FindGenericPassword: %v", err)
+	}
+	if string(password) != string(expectedPassword) {
+		t.Errorf("FindGenericPassword: expected %q, got %q", expectedPassword, password)
+	}
+
+	attributes.Password = []byte("updated password")
+	if err := store.UpdateGenericPassword(&attributes); err != nil {
+		t.Errorf("UpdateGenericPassword: %v", err)
+	}
+	password, err = store.FindGenericPassword(&attributes)
+	if err != nil {
+		t.Errorf("FindGenericPassword: %v", err)
+	}
+	if string(password) != "updated password" {
+		t.Errorf("FindGenericPassword: expected updated password, got %q", password)
+	}
+
+	// A second account under the same service.
+	other := osxkeychain.GenericPasswordAttributes{
+		ServiceName: attributes.ServiceName,
+		AccountName: "other account",
+		Password:    []byte("other password"),
+	}
+	if err := store.AddGenericPassword(&other); err != nil {
+		t.Errorf("AddGenericPassword: %v", err)
+	}
+	accountNames, err := store.GetAllAccountNames(attributes.ServiceName)
+	if err != nil {
+		t.Errorf("GetAllAccountNames: %v", err)
+	}
+	sort.Strings(accountNames)
+	if len(accountNames) != 2 || accountNames[0] != other.AccountName || accountNames[1] != attributes.AccountName {
+		t.Errorf("GetAllAccountNames: expected [%s %s], got %q", other.AccountName, attributes.AccountName, accountNames)
+	}
+	accountNames, err = store.GetAllAccountNames("storetest service without items")
+	if err != nil {
+		t.Errorf("GetAllAccountNames: %v", err)
+	}
+	if len(accountNames) != 0 {
+		t.Errorf("GetAllAccountNames: expected no accounts, got %q", accountNames)
+	}
+
+	for _, a := range []*osxkeychain.GenericPasswordAttributes{&attributes, &other} {
+		if err := store.FindAndRemoveGenericPassword(a); err != nil {
+			t.Errorf("FindAndRemoveGenericPassword: %v", err)
+		}
+	}
+	if err := store.FindAndRemoveGenericPassword(&attributes); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("FindAndRemoveGenericPassword: expected ErrItemNotFound, got %v", err)
+	}
+	if _, err := store.FindGenericPassword(&attributes); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("FindGenericPassword: expected ErrItemNotFound, got %v", err)
+	}
+	if err := store.UpdateGenericPassword(&attributes); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("UpdateGenericPassword: expected ErrItemNotFound, got %v", err)
+	}
+
+	// Add path of RemoveAndAddGenericPassword.
+	if err := store.RemoveAndAddGenericPassword(&attributes); err != nil {
+		t.Errorf("RemoveAndAddGenericPassword: %v", err)
+	}
+	if err := store.FindAndRemoveGenericPassword(&attributes); err != nil {
+		t.Errorf("FindAndRemoveGenericPassword: %v", err)
+	}
+
+	// An empty name matches any when finding, updating or removing, and
+	// only the first match is used.
+	serviceName := "storetest service for empty names"
+	for _, accountName := range []string{"storetest account one", "storetest account two"} {
+		if err := store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: serviceName, AccountName: accountName, Password: []byte(accountName)}); err != nil {
+			t.Errorf("AddGenericPassword: %v", err)
+		}
+	}
+	password, err = store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: serviceName})
+	if err != nil {
+		t.Errorf("FindGenericPassword with an empty AccountName: %v", err)
+	} else if string(password) != "storetest account one" && string(password) != "storetest account two" {
+		t.Errorf("FindGenericPassword with an empty AccountName: expected either password, got %q", password)
+	}
+	if err := store.UpdateGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: serviceName, Password: []byte("updated")}); err != nil {
+		t.Errorf("UpdateGenericPassword with an empty AccountName: %v", err)
+	}
+	updated := 0
+	for _, accountName := range []string{"storetest account one", "storetest account two"} {
+		if password, _ := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: serviceName, AccountName: accountName}); string(password) == "updated" {
+			updated++
+		}
+	}
+	if updated != 1 {
+		t.Errorf("UpdateGenericPassword with an empty AccountName: expected 1 item updated, got %d", updated)
+	}
+	if err := store.FindAndRemoveGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: serviceName}); err != nil {
+		t.Errorf("FindAndRemoveGenericPassword with an empty AccountName: %v", err)
+	}
+	accountNames, err = store.GetAllAccountNames(serviceName)
+	if err != nil {
+		t.Errorf("GetAllAccountNames: %v", err)
+	}
+	if len(accountNames) != 1 {
+		t.Fatalf("FindAndRemoveGenericPassword with an empty AccountName: expected 1 item left, got %q", accountNames)
+	}
+	if _, err := store.FindGenericPassword(&osxkeychain.GenericPasswordAttributes{AccountName: accountNames[0]}); err != nil {
+		t.Errorf("FindGenericPassword with an empty ServiceName: %v", err)
+	}
+	if err := store.FindAndRemoveGenericPassword(&osxkeychain.GenericPasswordAttributes{AccountName: accountNames[0]}); err != nil {
+		t.Errorf("FindAndRemoveGenericPassword with an empty ServiceName: %v", err)
+	}
+	if err := store.FindAndRemoveGenericPassword(&osxkeychain.GenericPasswordAttributes{ServiceName: serviceName}); err != osxkeychain.ErrItemNotFound {
+		t.Errorf("FindAndRemoveGenericPassword with an empty AccountName: expected ErrItemNotFound, got %v", err)
+	}
+
+	// Invalid attributes are rejected.
+	invalid := osxkeychain.GenericPasswordAttributes{
+		ServiceName: "storetest with invalid UTF-8 \xc3\x28",
+		AccountName: "test account",
+	}
+	errServiceName := "ServiceName is not a valid UTF-8 string"
+	if err := store.AddGenericPassword(&invalid); err == nil || err.Error() != errServiceName {
+		t.Errorf("AddGenericPassword: expected \"%s\", got %v", errServiceName, err)
+	}
+	if _, err := store.FindGenericPassword(&invalid); err == nil || err.Error() != errServiceName {
+		t.Errorf("FindGenericPassword: expected \"%s\", got %v", errServiceName, err)
+	}
+}
@@ -0,0 +1,7 @@
+package storetest
+
+import "testing"
+
+func TestMemStore(t *testing.T) {
+	TestStore(t, &MemStore{})
+}
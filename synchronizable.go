@@ -0,0 +1,54 @@
+package osxkeychain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Synchronizable says whether an item is synchronized to the user's
+// other devices through iCloud Keychain.
+type Synchronizable int
+
+const (
+	// SynchronizableDefault adds items that aren't synchronized, and
+	// matches only those in queries.
+	SynchronizableDefault Synchronizable = iota
+	SynchronizableYes
+	SynchronizableNo
+	// SynchronizableAny matches both synchronized and local items.
+	// It can only be used in queries.
+	SynchronizableAny
+)
+
+// CheckValidity returns an error if synchronizable is not one of the
+// values above. Otherwise, it returns nil.
+func (synchronizable Synchronizable) CheckValidity() error {
+	if synchronizable < SynchronizableDefault || synchronizable > SynchronizableAny {
+		return fmt.Errorf("Synchronizable has unknown value %d", int(synchronizable))
+	}
+	return nil
+}
+
+// CheckAddValidity is like CheckValidity, but also returns an error if
+// the attributes can be used to look an item up but not to add one,
+// such as a synchronizable item that is only accessible on this
+// device.
+func (attributes *GenericPasswordAttributes) CheckAddValidity() error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	switch attributes.Synchronizable {
+	case SynchronizableAny:
+		return errors.New("SynchronizableAny can only be used in queries")
+	case SynchronizableYes:
+		switch attributes.Accessible {
+		case AccessibleWhenPasscodeSetThisDeviceOnly, AccessibleWhenUnlockedThisDeviceOnly,
+			AccessibleAfterFirstUnlockThisDeviceOnly:
+			return errors.New("Synchronizable items can't be accessible on this device only")
+		}
+		if attributes.AccessControl != 0 {
+			return errors.New("Synchronizable items can't have AccessControl")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package osxkeychain_test
+
+import (
+	"testing"
+
+	"github.com/keybase/go-osxkeychain"
+	"github.com/keybase/go-osxkeychain/storetest"
+)
+
+func TestSynchronizableCheckValidity(t *testing.T) {
+	for _, synchronizable := range []osxkeychain.Synchronizable{
+		osxkeychain.SynchronizableDefault,
+		osxkeychain.SynchronizableYes,
+		osxkeychain.SynchronizableNo,
+		osxkeychain.SynchronizableAny,
+	} {
+		attributes := osxkeychain.GenericPasswordAttributes{
+			ServiceName:    "osxkeychain_test",
+			AccountName:    "test",
+			Synchronizable: synchronizable,
+		}
+		if err := attributes.CheckValidity(); err != nil {
+			t.Errorf("%d: %v", synchronizable, err)
+		}
+	}
+
+	invalid := []struct {
+		attributes osxkeychain.GenericPasswordAttributes
+		expected   string
+	}{
+		{osxkeychain.GenericPasswordAttributes{Synchronizable: -1},
+			"Synchronizable has unknown value -1"},
+		{osxkeychain.GenericPasswordAttributes{Synchronizable: osxkeychain.SynchronizableYes, TrustedApplications: []string{"/usr/bin/security"}},
+			"Synchronizable can't be combined with TrustedApplications"},
+		{osxkeychain.GenericPasswordAttributes{Synchronizable: osxkeychain.SynchronizableAny, TrustedApplications: []string{"/usr/bin/security"}},
+			"Synchronizable can't be combined with TrustedApplications"},
+	}
+	for _, test := range invalid {
+		err := test.attributes.CheckValidity()
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+}
+
+func TestSynchronizableCheckAddValidity(t *testing.T) {
+	valid := []osxkeychain.GenericPasswordAttributes{
+		{Synchronizable: osxkeychain.SynchronizableYes},
+		{Synchronizable: osxkeychain.SynchronizableYes, Accessible: osxkeychain.AccessibleAfterFirstUnlock},
+		{Synchronizable: osxkeychain.SynchronizableNo, Accessible: osxkeychain.AccessibleWhenUnlockedThisDeviceOnly},
+		{Synchronizable: osxkeychain.SynchronizableNo, AccessControl: osxkeychain.AccessControlUserPresence},
+	}
+	for _, attributes := range valid {
+		if err := attributes.CheckAddValidity(); err != nil {
+			t.Errorf("%+v: %v", attributes, err)
+		}
+	}
+
+	invalid := []struct {
+		attributes osxkeychain.GenericPasswordAttributes
+		expected   string
+	}{
+		{osxkeychain.GenericPasswordAttributes{Synchronizable: osxkeychain.SynchronizableAny},
+			"SynchronizableAny can only be used in queries"},
+		{osxkeychain.GenericPasswordAttributes{Synchronizable: osxkeychain.SynchronizableYes, Accessible: osxkeychain.AccessibleWhenUnlockedThisDeviceOnly},
+			"Synchronizable items can't be accessible on this device only"},
+		{osxkeychain.GenericPasswordAttributes{Synchronizable: osxkeychain.SynchronizableYes, Accessible: osxkeychain.AccessibleWhenPasscodeSetThisDeviceOnly},
+			"Synchronizable items can't be accessible on this device only"},
+		{osxkeychain.GenericPasswordAttributes{Synchronizable: osxkeychain.SynchronizableYes, AccessControl: osxkeychain.AccessControlUserPresence},
+			"Synchronizable items can't have AccessControl"},
+		{osxkeychain.GenericPasswordAttributes{Synchronizable: osxkeychain.SynchronizableYes, TrustedApplications: []string{"/usr/bin/security"}},
+			"Synchronizable can't be combined with TrustedApplications"},
+	}
+	for _, test := range invalid {
+		err := test.attributes.CheckAddValidity()
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected \"%s\", got %v", test.expected, err)
+		}
+	}
+}
+
+func TestMemStoreRejectsSynchronizableAny(t *testing.T) {
+	var store storetest.MemStore
+	err := store.AddGenericPassword(&osxkeychain.GenericPasswordAttributes{
+		ServiceName:    "osxkeychain_test",
+		AccountName:    "test",
+		Synchronizable: osxkeychain.SynchronizableAny,
+	})
+	expected := "SynchronizableAny can only be used in queries"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected \"%s\", got %v", expected, err)
+	}
+}
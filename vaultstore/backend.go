@@ -0,0 +1,51 @@
+package vaultstore
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// The vault backend opens a Store with URLs such as
+//
+//	vault://vault.example.com:8200/secret?namespace=team
+//
+// naming the server and, optionally, the path the KV engine is
+// mounted at. It takes these options:
+//
+//   - namespace, the Store's Namespace.
+//   - token_env, the environment variable holding the token, VAULT_TOKEN
+//     by default.
+//   - tls, whether to connect with HTTPS, the default, or HTTP.
+func init() {
+	osxkeychain.Register("vault", &osxkeychain.Backend{Open: openStore})
+}
+
+func openStore(u *url.URL, options *osxkeychain.Options) (osxkeychain.Store, error) {
+	namespace := options.String("namespace", "")
+	tokenEnv := options.String("token_env", "VAULT_TOKEN")
+	useTLS := options.Bool("tls", true)
+	if err := options.Err(); err != nil {
+		return nil, err
+	}
+	if u.Opaque != "" || u.Host == "" || u.User != nil {
+		return nil, errors.New("vaultstore: URL " + u.Redacted() + " isn't of the form vault://host:port/mount")
+	}
+	token := os.Getenv(tokenEnv)
+	if token == "" {
+		return nil, errors.New("vaultstore: " + tokenEnv + " isn't set")
+	}
+	address := url.URL{Scheme: "https", Host: u.Host}
+	if !useTLS {
+		address.Scheme = "http"
+	}
+	return &Store{
+		Address:   address.String(),
+		Mount:     strings.Trim(u.Path, "/"),
+		Namespace: namespace,
+		Auth:      Token(token),
+	}, nil
+}
@@ -0,0 +1,187 @@
+package vaultstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Auth is a way of logging in to Vault: Token or AppRole.
+type Auth interface {
+	// login returns a token and how long it's valid for, or 0 if it
+	// doesn't expire.
+	login(s *Store) (token string, ttl time.Duration, err error)
+}
+
+// Token authenticates with a Vault token, such as the one in
+// VAULT_TOKEN or the one vault login saves.
+type Token string
+
+func (t Token) login(s *Store) (string, time.Duration, error) {
+	return string(t), 0, nil
+}
+
+// AppRole authenticates with a role ID and secret ID, logging in
+// again when the token it gets expires or is revoked.
+type AppRole struct {
+	RoleID   string
+	SecretID string
+	// Mount is the path the AppRole auth method is mounted at. If
+	// empty, "approle" is used.
+	Mount string
+}
+
+func (a *AppRole) login(s *Store) (string, time.Duration, error) {
+	mount := a.Mount
+	if mount == "" {
+		mount = "approle"
+	}
+	body := map[string]string{"role_id": a.RoleID, "secret_id": a.SecretID}
+	var resp response
+	if err := s.request(http.MethodPost, "auth/"+mount+"/login", nil, "", body, &resp); err != nil {
+		return "", 0, err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", 0, errors.New("vaultstore: AppRole login returned no token")
+	}
+	return resp.Auth.ClientToken, time.Duration(resp.Auth.LeaseDuration) * time.Second, nil
+}
+
+// Error is an error response from Vault.
+type Error struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vaultstore: Vault returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("vaultstore: Vault returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), strings.Join(e.Errors, "; "))
+}
+
+func isStatus(err error, statusCode int) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == statusCode
+}
+
+// response is the body of Vault's responses.
+type response struct {
+	Data json.RawMessage `json:"data"`
+	Auth *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// tokenRefreshMargin is how long before it expires a token is
+// replaced, so that it doesn't expire during a request.
+const tokenRefreshMargin = 30 * time.Second
+
+// token returns the token to make requests with, logging in if there
+// is none yet, it's about to expire, or refresh is set.
+func (s *Store) token(refresh bool) (string, error) {
+	s.tokenMu.Lock()
+	defer s.tokenMu.Unlock()
+	if s.cachedToken != "" && !refresh && (s.tokenExpiry.IsZero() || time.Now().Before(s.tokenExpiry)) {
+		return s.cachedToken, nil
+	}
+	if s.Auth == nil {
+		return "", errors.New("Auth is nil")
+	}
+	token, ttl, err := s.Auth.login(s)
+	if err != nil {
+		return "", err
+	}
+	s.cachedToken, s.tokenExpiry = token, time.Time{}
+	if ttl > 0 {
+		margin := tokenRefreshMargin
+		if margin > ttl/2 {
+			margin = ttl / 2
+		}
+		s.tokenExpiry = time.Now().Add(ttl - margin)
+	}
+	return token, nil
+}
+
+// do makes an authenticated request to Vault. If Vault denies it and
+// logging in again might help, it logs in again and retries once.
+func (s *Store) do(method, path string, query url.Values, body interface{}, resp *response) error {
+	token, err := s.token(false)
+	if err != nil {
+		return err
+	}
+	err = s.request(method, path, query, token, body, resp)
+	if _, static := s.Auth.(Token); static || !isStatus(err, http.StatusForbidden) {
+		return err
+	}
+	if token, err = s.token(true); err != nil {
+		return err
+	}
+	return s.request(method, path, query, token, body, resp)
+}
+
+// request makes a request to the Vault API at path, below /v1/, with
+// an optional JSON body, decoding the response into resp. Responses
+// with any status but 2xx return an *Error, but are decoded too, since
+// Vault sometimes sends data along with a 404.
+func (s *Store) request(method, path string, query url.Values, token string, body interface{}, resp *response) error {
+	if s.Address == "" {
+		return errors.New("Address is empty")
+	}
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	u := strings.TrimSuffix(s.Address, "/") + "/v1/" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if s.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.Namespace)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	*resp = response{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, resp); err != nil && res.StatusCode/100 == 2 {
+			return fmt.Errorf("vaultstore: invalid response from Vault: %v", err)
+		}
+	}
+	if res.StatusCode/100 != 2 {
+		return &Error{StatusCode: res.StatusCode, Errors: resp.Errors}
+	}
+	return nil
+}
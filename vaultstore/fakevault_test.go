@@ -0,0 +1,274 @@
+package vaultstore_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeVault implements the parts of the Vault HTTP API that Store
+// uses: AppRole login and a KV version 2 engine mounted at mount.
+type fakeVault struct {
+	mount     string
+	namespace string
+	roleID    string
+	secretID  string
+
+	mu      sync.Mutex
+	tokens  map[string]bool
+	logins  int
+	secrets map[string]*fakeSecret
+	// beforeWrite is called before a write to the data at path is
+	// checked, without mu held, so it can write too.
+	beforeWrite func(path string)
+}
+
+type fakeSecret struct {
+	created, updated time.Time
+	custom           map[string]string
+	versions         []*fakeVersion
+}
+
+type fakeVersion struct {
+	data      map[string]interface{}
+	created   time.Time
+	deleted   time.Time
+	destroyed bool
+}
+
+func newFakeVault(mount, namespace string) *fakeVault {
+	return &fakeVault{
+		mount:     mount,
+		namespace: namespace,
+		roleID:    "role",
+		secretID:  "secret",
+		tokens:    map[string]bool{"root": true},
+		secrets:   make(map[string]*fakeSecret),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeErrors(w http.ResponseWriter, status int, errors ...string) {
+	writeJSON(w, status, map[string]interface{}{"errors": append([]string{}, errors...)})
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Namespace") != f.namespace {
+		writeErrors(w, http.StatusNotFound, "no handler for route")
+		return
+	}
+	if r.URL.Path == "/v1/auth/approle/login" && r.Method == http.MethodPost {
+		f.login(w, r)
+		return
+	}
+	f.mu.Lock()
+	authorized := f.tokens[r.Header.Get("X-Vault-Token")]
+	f.mu.Unlock()
+	if !authorized {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	dataPrefix := "/v1/" + f.mount + "/data/"
+	metadataPrefix := "/v1/" + f.mount + "/metadata/"
+	switch {
+	case strings.HasPrefix(r.URL.Path, dataPrefix) && r.Method == http.MethodPost:
+		path := strings.TrimPrefix(r.URL.Path, dataPrefix)
+		if f.beforeWrite != nil {
+			f.beforeWrite(path)
+		}
+		f.write(w, r, path)
+	case strings.HasPrefix(r.URL.Path, dataPrefix) && r.Method == http.MethodGet:
+		f.read(w, r, strings.TrimPrefix(r.URL.Path, dataPrefix))
+	case strings.HasPrefix(r.URL.Path, metadataPrefix) && r.Method == http.MethodGet && r.URL.Query().Get("list") == "true":
+		f.list(w, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, metadataPrefix), "/"))
+	case strings.HasPrefix(r.URL.Path, metadataPrefix) && r.Method == http.MethodGet:
+		f.metadata(w, strings.TrimPrefix(r.URL.Path, metadataPrefix))
+	case strings.HasPrefix(r.URL.Path, metadataPrefix) && r.Method == http.MethodDelete:
+		f.mu.Lock()
+		delete(f.secrets, strings.TrimPrefix(r.URL.Path, metadataPrefix))
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeErrors(w, http.StatusNotFound, "no handler for route")
+	}
+}
+
+func (f *fakeVault) login(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RoleID   string `json:"role_id"`
+		SecretID string `json:"secret_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RoleID != f.roleID || body.SecretID != f.secretID {
+		writeErrors(w, http.StatusBadRequest, "invalid role or secret ID")
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logins++
+	token := fmt.Sprintf("approle-token-%d", f.logins)
+	f.tokens[token] = true
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"auth": map[string]interface{}{"client_token": token, "lease_duration": 3600, "renewable": true},
+	})
+}
+
+// revokeTokens revokes every token but the root token.
+func (f *fakeVault) revokeTokens() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = map[string]bool{"root": true}
+}
+
+func (f *fakeVault) write(w http.ResponseWriter, r *http.Request, path string) {
+	var body struct {
+		Options struct {
+			CAS *int `json:"cas"`
+		} `json:"options"`
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now().UTC()
+	secret := f.secrets[path]
+	current := 0
+	if secret != nil {
+		current = len(secret.versions)
+	}
+	if body.Options.CAS != nil && *body.Options.CAS != current {
+		writeErrors(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+		return
+	}
+	if secret == nil {
+		secret = &fakeSecret{created: now}
+		f.secrets[path] = secret
+	}
+	secret.updated = now
+	secret.versions = append(secret.versions, &fakeVersion{data: body.Data, created: now})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{"version": len(secret.versions), "created_time": now.Format(time.RFC3339Nano)},
+	})
+}
+
+func (f *fakeVault) read(w http.ResponseWriter, r *http.Request, path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secret := f.secrets[path]
+	if secret == nil {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+	number := len(secret.versions)
+	if v := r.URL.Query().Get("version"); v != "" {
+		number, _ = strconv.Atoi(v)
+	}
+	if number < 1 || number > len(secret.versions) {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+	version := secret.versions[number-1]
+	metadata := map[string]interface{}{
+		"version":      number,
+		"created_time": version.created.Format(time.RFC3339Nano),
+		"destroyed":    version.destroyed,
+	}
+	status, data := http.StatusOK, version.data
+	if !version.deleted.IsZero() || version.destroyed {
+		status, data = http.StatusNotFound, nil
+		metadata["deletion_time"] = version.deleted.Format(time.RFC3339Nano)
+	}
+	writeJSON(w, status, map[string]interface{}{"data": map[string]interface{}{"data": data, "metadata": metadata}})
+}
+
+func (f *fakeVault) metadata(w http.ResponseWriter, path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secret := f.secrets[path]
+	if secret == nil {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+	versions := make(map[string]interface{})
+	for i, version := range secret.versions {
+		deletionTime := ""
+		if !version.deleted.IsZero() {
+			deletionTime = version.deleted.Format(time.RFC3339Nano)
+		}
+		versions[strconv.Itoa(i+1)] = map[string]interface{}{
+			"created_time":  version.created.Format(time.RFC3339Nano),
+			"deletion_time": deletionTime,
+			"destroyed":     version.destroyed,
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+		"current_version": len(secret.versions),
+		"oldest_version":  0,
+		"created_time":    secret.created.Format(time.RFC3339Nano),
+		"updated_time":    secret.updated.Format(time.RFC3339Nano),
+		"custom_metadata": secret.custom,
+		"versions":        versions,
+	}})
+}
+
+func (f *fakeVault) list(w http.ResponseWriter, path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix := path + "/"
+	if path == "" {
+		prefix = ""
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	for p := range f.secrets {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(p, prefix)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+	sort.Strings(keys)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+}
+
+// deleteLatest deletes the latest version of a secret, as vault kv
+// delete does.
+func (f *fakeVault) deleteLatest(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secret := f.secrets[path]
+	secret.versions[len(secret.versions)-1].deleted = time.Now().UTC()
+}
+
+func (f *fakeVault) latestData(path string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secret := f.secrets[path]
+	if secret == nil {
+		return nil
+	}
+	return secret.versions[len(secret.versions)-1].data
+}
@@ -0,0 +1,513 @@
+// Package vaultstore keeps generic passwords in the KV version 2
+// secrets engine of HashiCorp Vault, so that a team can share them.
+package vaultstore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/keybase/go-osxkeychain"
+)
+
+// ErrConcurrentModification is returned by Store methods that change
+// a secret when other clients kept writing it at the same time.
+var ErrConcurrentModification = errors.New("vaultstore: secret keeps being modified by another client")
+
+// maxWriteAttempts is how many times a Store tries to write a secret
+// that keeps changing before giving up.
+const maxWriteAttempts = 5
+
+// Keys of the secret data holding a password.
+const (
+	PasswordKey = "password"
+	// BinaryPasswordKey holds, in base64, a password that isn't valid
+	// UTF-8, since JSON strings can't hold one.
+	BinaryPasswordKey = "password_base64"
+)
+
+// Store is an osxkeychain.Store kept in a KV version 2 secrets engine.
+// Each generic password is the secret at the path
+// <service name>/<account name>, so listing the path of a service
+// lists its accounts; the service name may hold slashes to nest it
+// further. The password is the secret's PasswordKey or, if it isn't
+// valid UTF-8, its BinaryPasswordKey. Secrets at the root of the mount
+// have an empty service name, but when finding, removing or updating,
+// an empty service name matches the secrets at every path, as
+// osxkeychain.Store describes.
+//
+// Writes use check-and-set, so AddGenericPassword returns
+// ErrDuplicateItem if another client added the item first, and
+// concurrent updates are both kept as versions rather than one
+// overwriting the other unseen. Every write makes a new version of
+// the secret; FindGenericPasswordVersion and Metadata return older
+// ones, and FindAndRemoveGenericPassword removes all of them, as
+// vault kv metadata delete does. A secret whose latest version was
+// deleted but whose metadata wasn't, as vault kv delete does, is
+// still listed by GetAllAccountNames, but can't be found.
+type Store struct {
+	// Address is the URL of the Vault server, such as
+	// https://vault.example.com:8200.
+	Address string
+	// Mount is the path the KV engine is mounted at. If empty,
+	// "secret" is used.
+	Mount string
+	// Namespace is the Vault Enterprise namespace to use, if any.
+	Namespace string
+	Auth      Auth
+	// Client makes the requests, and can be set up to trust the
+	// server's certificate authority. If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+
+	tokenMu     sync.Mutex
+	cachedToken string
+	tokenExpiry time.Time
+}
+
+// AddGenericPassword implements osxkeychain.Store.
+func (s *Store) AddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	return s.modify(attributes, func(latest *secret) (map[string]interface{}, error) {
+		if latest.Data != nil {
+			return nil, osxkeychain.ErrDuplicateItem
+		}
+		return setPassword(map[string]interface{}{}, attributes.Password), nil
+	})
+}
+
+// FindGenericPassword implements osxkeychain.Store. It returns the
+// password in the latest version of the secret.
+func (s *Store) FindGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) ([]byte, error) {
+	return s.FindGenericPasswordVersion(attributes, 0)
+}
+
+// FindGenericPasswordVersion returns the password in the given
+// version of the secret holding a generic password, or in its latest
+// version if version is 0. It returns osxkeychain.ErrItemNotFound if
+// the version doesn't exist or was deleted.
+func (s *Store) FindGenericPasswordVersion(attributes *osxkeychain.GenericPasswordAttributes, version int) ([]byte, error) {
+	if err := attributes.CheckValidity(); err != nil {
+		return nil, err
+	}
+	if version < 0 {
+		return nil, errors.New("version can't be negative")
+	}
+	attributes, err := s.match(attributes)
+	if err != nil {
+		return nil, err
+	}
+	path, err := s.path("data", attributes.ServiceName, attributes.AccountName)
+	if err != nil {
+		return nil, err
+	}
+	sec, err := s.read(path, version)
+	if err != nil {
+		return nil, err
+	}
+	if sec.Data == nil {
+		return nil, osxkeychain.ErrItemNotFound
+	}
+	return password(path, sec.Data)
+}
+
+// FindAndRemoveGenericPassword implements osxkeychain.Store. It
+// removes every version of the secret, along with its metadata.
+func (s *Store) FindAndRemoveGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	attributes, err := s.match(attributes)
+	if err != nil {
+		return err
+	}
+	if _, err := s.FindGenericPassword(attributes); err != nil {
+		return err
+	}
+	path, err := s.path("metadata", attributes.ServiceName, attributes.AccountName)
+	if err != nil {
+		return err
+	}
+	return s.do(http.MethodDelete, path, nil, nil, &response{})
+}
+
+// RemoveAndAddGenericPassword implements osxkeychain.Store. Unlike
+// the keychain, it writes a new version of the secret, keeping the
+// previous ones, and only holds the password.
+func (s *Store) RemoveAndAddGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckAddValidity(); err != nil {
+		return err
+	}
+	return s.modify(attributes, func(latest *secret) (map[string]interface{}, error) {
+		return setPassword(map[string]interface{}{}, attributes.Password), nil
+	})
+}
+
+// UpdateGenericPassword implements osxkeychain.Store. It writes a new
+// version of the secret, keeping any keys other than the password.
+func (s *Store) UpdateGenericPassword(attributes *osxkeychain.GenericPasswordAttributes) error {
+	if err := attributes.CheckValidity(); err != nil {
+		return err
+	}
+	attributes, err := s.match(attributes)
+	if err != nil {
+		return err
+	}
+	return s.modify(attributes, func(latest *secret) (map[string]interface{}, error) {
+		if latest.Data == nil {
+			return nil, osxkeychain.ErrItemNotFound
+		}
+		return setPassword(latest.Data, attributes.Password), nil
+	})
+}
+
+// GetAllAccountNames implements osxkeychain.Store. It lists the
+// secrets at the service's path, but not the paths below it, which
+// belong to other services.
+func (s *Store) GetAllAccountNames(serviceName string) ([]string, error) {
+	keys, err := s.list(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	accountNames := []string{}
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			accountNames = append(accountNames, key)
+		}
+	}
+	return accountNames, nil
+}
+
+// list returns the keys at the service's path, in lexical order. Those
+// of the paths below it end in a slash.
+func (s *Store) list(serviceName string) ([]string, error) {
+	path, err := s.path("metadata", serviceName, "")
+	if err != nil {
+		return nil, err
+	}
+	var resp response
+	err = s.do(http.MethodGet, path+"/", url.Values{"list": {"true"}}, nil, &resp)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.Unmarshal(resp.Data, &list); err != nil {
+		return nil, fmt.Errorf("vaultstore: invalid response from Vault: %v", err)
+	}
+	sort.Strings(list.Keys)
+	return list.Keys, nil
+}
+
+// match returns a copy of attributes naming the generic password they
+// match. As in the keychain, an empty name matches any: an empty
+// service name matches the secrets at every path, and an empty account
+// name every secret at the service's path. The first secret that
+// matches, in lexical order, and whose latest version wasn't deleted
+// is used.
+func (s *Store) match(attributes *osxkeychain.GenericPasswordAttributes) (*osxkeychain.GenericPasswordAttributes, error) {
+	if attributes.ServiceName != "" && attributes.AccountName != "" {
+		return attributes, nil
+	}
+	if attributes.AccountName != "" {
+		// Check the account name as path does.
+		if _, err := s.path("data", "", attributes.AccountName); err != nil {
+			return nil, err
+		}
+	}
+	serviceName, accountName, err := s.matchAt(attributes.ServiceName, attributes.AccountName, attributes.ServiceName == "")
+	if err != nil {
+		return nil, err
+	}
+	matched := *attributes
+	matched.ServiceName, matched.AccountName = serviceName, accountName
+	return &matched, nil
+}
+
+// matchAt returns the names of the first secret at the service's path,
+// or, if recurse is set, the paths below it, with the given account
+// name or, if it's empty, any.
+func (s *Store) matchAt(serviceName, accountName string, recurse bool) (string, string, error) {
+	keys, err := s.list(serviceName)
+	if err != nil {
+		return "", "", err
+	}
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") {
+			if !recurse {
+				continue
+			}
+			below := strings.TrimSuffix(key, "/")
+			if serviceName != "" {
+				below = serviceName + "/" + below
+			}
+			matchedService, matchedAccount, err := s.matchAt(below, accountName, true)
+			if err != osxkeychain.ErrItemNotFound {
+				return matchedService, matchedAccount, err
+			}
+			continue
+		}
+		if accountName != "" && key != accountName {
+			continue
+		}
+		path, err := s.path("data", serviceName, key)
+		if err != nil {
+			return "", "", err
+		}
+		latest, err := s.read(path, 0)
+		if err != nil {
+			return "", "", err
+		}
+		if latest.Data != nil {
+			return serviceName, key, nil
+		}
+	}
+	return "", "", osxkeychain.ErrItemNotFound
+}
+
+// Metadata describes the versions of the secret holding a generic
+// password.
+type Metadata struct {
+	CurrentVersion int
+	OldestVersion  int
+	CreatedTime    time.Time
+	UpdatedTime    time.Time
+	// CustomMetadata is set with vault kv metadata put.
+	CustomMetadata map[string]string
+	Versions       map[int]Version
+}
+
+// Version describes a version of a secret.
+type Version struct {
+	CreatedTime time.Time
+	// DeletionTime is when the version was or will be deleted, or
+	// zero if it won't be.
+	DeletionTime time.Time
+	Destroyed    bool
+}
+
+// Metadata returns the metadata of the secret holding a generic
+// password. It returns osxkeychain.ErrItemNotFound if there's no
+// such secret, even a deleted one.
+func (s *Store) Metadata(attributes *osxkeychain.GenericPasswordAttributes) (*Metadata, error) {
+	if err := attributes.CheckValidity(); err != nil {
+		return nil, err
+	}
+	path, err := s.path("metadata", attributes.ServiceName, attributes.AccountName)
+	if err != nil {
+		return nil, err
+	}
+	var resp response
+	err = s.do(http.MethodGet, path, nil, nil, &resp)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, osxkeychain.ErrItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		CurrentVersion int               `json:"current_version"`
+		OldestVersion  int               `json:"oldest_version"`
+		CreatedTime    string            `json:"created_time"`
+		UpdatedTime    string            `json:"updated_time"`
+		CustomMetadata map[string]string `json:"custom_metadata"`
+		Versions       map[string]struct {
+			CreatedTime  string `json:"created_time"`
+			DeletionTime string `json:"deletion_time"`
+			Destroyed    bool   `json:"destroyed"`
+		} `json:"versions"`
+	}
+	if err := json.Unmarshal(resp.Data, &raw); err != nil {
+		return nil, fmt.Errorf("vaultstore: invalid response from Vault: %v", err)
+	}
+	metadata := &Metadata{
+		CurrentVersion: raw.CurrentVersion,
+		OldestVersion:  raw.OldestVersion,
+		CustomMetadata: raw.CustomMetadata,
+		Versions:       make(map[int]Version),
+	}
+	if err := parseTime(raw.CreatedTime, &metadata.CreatedTime); err != nil {
+		return nil, err
+	}
+	if err := parseTime(raw.UpdatedTime, &metadata.UpdatedTime); err != nil {
+		return nil, err
+	}
+	for key, rawVersion := range raw.Versions {
+		number, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("vaultstore: invalid version %q in response from Vault", key)
+		}
+		version := Version{Destroyed: rawVersion.Destroyed}
+		if err := parseTime(rawVersion.CreatedTime, &version.CreatedTime); err != nil {
+			return nil, err
+		}
+		if err := parseTime(rawVersion.DeletionTime, &version.DeletionTime); err != nil {
+			return nil, err
+		}
+		metadata.Versions[number] = version
+	}
+	return metadata, nil
+}
+
+func parseTime(text string, t *time.Time) error {
+	if text == "" {
+		return nil
+	}
+	var err error
+	if *t, err = time.Parse(time.RFC3339Nano, text); err != nil {
+		return fmt.Errorf("vaultstore: invalid time %q in response from Vault", text)
+	}
+	return nil
+}
+
+// path returns the API path of the data or metadata of the secret
+// holding a generic password, or of the service's path if accountName
+// is empty.
+func (s *Store) path(kind, serviceName, accountName string) (string, error) {
+	mount := strings.Trim(s.Mount, "/")
+	if mount == "" {
+		mount = "secret"
+	}
+	elems := []string{mount, kind}
+	if serviceName != "" {
+		for _, elem := range strings.Split(serviceName, "/") {
+			if elem == "" || elem == "." || elem == ".." {
+				return "", errors.New("ServiceName can't have empty, \".\" or \"..\" path elements")
+			}
+			elems = append(elems, url.PathEscape(elem))
+		}
+	}
+	if kind == "data" || accountName != "" {
+		switch accountName {
+		case "":
+			return "", errors.New("AccountName is empty")
+		case ".", "..":
+			return "", errors.New("AccountName can't be \".\" or \"..\"")
+		}
+		if strings.ContainsRune(accountName, '/') {
+			return "", errors.New("AccountName can't contain \"/\"")
+		}
+		elems = append(elems, url.PathEscape(accountName))
+	}
+	return strings.Join(elems, "/"), nil
+}
+
+// secret is a version of a secret.
+type secret struct {
+	// Data is nil if the version doesn't exist or was deleted.
+	Data     map[string]interface{} `json:"data"`
+	Metadata struct {
+		Version int `json:"version"`
+	} `json:"metadata"`
+}
+
+// read returns a version of the secret at path, or its latest version
+// if version is 0. If the secret exists but that version was deleted,
+// its Metadata.Version is still set.
+func (s *Store) read(path string, version int) (*secret, error) {
+	var query url.Values
+	if version != 0 {
+		query = url.Values{"version": {strconv.Itoa(version)}}
+	}
+	var resp response
+	err := s.do(http.MethodGet, path, query, nil, &resp)
+	if err != nil && !isStatus(err, http.StatusNotFound) {
+		return nil, err
+	}
+	sec := &secret{}
+	if len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, sec); err != nil {
+			return nil, fmt.Errorf("vaultstore: invalid response from Vault: %v", err)
+		}
+	}
+	if err != nil {
+		sec.Data = nil
+	}
+	return sec, nil
+}
+
+// modify calls change with the latest version of the secret holding a
+// generic password, and writes the data it returns as a new version,
+// unless another client wrote one in the meantime, in which case it
+// starts again.
+func (s *Store) modify(attributes *osxkeychain.GenericPasswordAttributes, change func(latest *secret) (map[string]interface{}, error)) error {
+	path, err := s.path("data", attributes.ServiceName, attributes.AccountName)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		latest, err := s.read(path, 0)
+		if err != nil {
+			return err
+		}
+		data, err := change(latest)
+		if err != nil {
+			return err
+		}
+		body := map[string]interface{}{
+			"options": map[string]int{"cas": latest.Metadata.Version},
+			"data":    data,
+		}
+		err = s.do(http.MethodPost, path, nil, body, &response{})
+		if !isCheckAndSetMismatch(err) {
+			return err
+		}
+	}
+	return ErrConcurrentModification
+}
+
+func isCheckAndSetMismatch(err error) bool {
+	if !isStatus(err, http.StatusBadRequest) {
+		return false
+	}
+	for _, message := range err.(*Error).Errors {
+		if strings.Contains(message, "check-and-set") {
+			return true
+		}
+	}
+	return false
+}
+
+// password returns the password held in the data of the secret at
+// path.
+func password(path string, data map[string]interface{}) ([]byte, error) {
+	if encoded, ok := data[BinaryPasswordKey].(string); ok {
+		password, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("vaultstore: %s of %s isn't valid base64", BinaryPasswordKey, path)
+		}
+		return password, nil
+	}
+	if password, ok := data[PasswordKey].(string); ok {
+		return []byte(password), nil
+	}
+	return nil, fmt.Errorf("vaultstore: %s has no %s", path, PasswordKey)
+}
+
+// setPassword sets the password held in secret data, and returns the
+// data.
+func setPassword(data map[string]interface{}, password []byte) map[string]interface{} {
+	delete(data, PasswordKey)
+	delete(data, BinaryPasswordKey)
+	if utf8.Valid(password) {
+		data[PasswordKey] = string(password)
+	} else {
+		data[BinaryPasswordKey] = base64.StdEncoding.EncodeToString(password)
+	}
+	return data
+}